@@ -0,0 +1,333 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// QueryBuilder builds simple dialect correct SELECT statements for a
+// mapped table.  Create one with DbMap.Query() or Transaction.Query().
+// Conditions use "?" as placeholder, which is rewritten to the bind
+// variable of the dialect.
+//
+// Example:
+//
+//     var list []*Invoice
+//     _, err := dbmap.Query(Invoice{}).Where("PersonId = ?", 5).OrderBy("Created", true).Limit(10).Select(&list)
+//
+type QueryBuilder struct {
+	dbmap   *DbMap
+	exec    SqlExecutor
+	table   *TableMap
+	wheres  []string
+	args    []interface{}
+	orderBy []string
+	after   string
+	afterV  interface{}
+	limit   int64
+	offset  int64
+	index   string
+	sample  float64
+	err     error
+}
+
+// Query returns a QueryBuilder for the table mapped to the type of i.
+func (m *DbMap) Query(i interface{}) *QueryBuilder {
+	return newQueryBuilder(m, m, i)
+}
+
+// Query has the same behavior as DbMap.Query(), but runs in a transaction.
+func (t *Transaction) Query(i interface{}) *QueryBuilder {
+	return newQueryBuilder(t.dbmap, t, i)
+}
+
+func newQueryBuilder(m *DbMap, exec SqlExecutor, i interface{}) *QueryBuilder {
+	q := &QueryBuilder{dbmap: m, exec: exec, limit: -1}
+	t, err := toType(i)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	q.table, q.err = m.TableFor(t, false)
+	return q
+}
+
+// Where adds a condition to the query.  Multiple conditions are joined
+// with "and".  Each "?" in cond is bound to the next value in args.
+func (q *QueryBuilder) Where(cond string, args ...interface{}) *QueryBuilder {
+	if n := countPlaceholders(cond); n != len(args) {
+		q.err = fmt.Errorf("gorp: Where %q has %d placeholders but %d args", cond, n, len(args))
+		return q
+	}
+	q.wheres = append(q.wheres, cond)
+	q.args = append(q.args, args...)
+	return q
+}
+
+// WhereBool adds the condition that the column of field is value, written
+// as the boolean literal of the dialect, see Dialect.BoolLiteral().  field
+// may be a struct field name or a column name of the mapped table.
+func (q *QueryBuilder) WhereBool(field string, value bool) *QueryBuilder {
+	if q.table == nil {
+		return q
+	}
+	col := colMapOrNil(q.table, field)
+	if col == nil {
+		q.err = fmt.Errorf("gorp: WhereBool: no column %s in table %s", field, q.table.TableName)
+		return q
+	}
+	d := q.dbmap.Dialect
+	q.wheres = append(q.wheres, d.QuoteField(col.ColumnName)+" = "+d.BoolLiteral(value))
+	return q
+}
+
+// OrderBy adds a sort column to the query.  field may be a struct field
+// name or a column name of the mapped table.  If desc is true the rows are
+// sorted in descending order.
+func (q *QueryBuilder) OrderBy(field string, desc bool) *QueryBuilder {
+	if q.table == nil {
+		return q
+	}
+	col := colMapOrNil(q.table, field)
+	if col == nil {
+		q.err = fmt.Errorf("gorp: OrderBy: no column %s in table %s", field, q.table.TableName)
+		return q
+	}
+	s := q.dbmap.Dialect.QuoteField(col.ColumnName)
+	if desc {
+		s += " desc"
+	}
+	q.orderBy = append(q.orderBy, s)
+	return q
+}
+
+// OrderByRandom adds a random sort to the query, see Dialect.RandomFunc(),
+// e.g. to select random rows together with Limit.  Random values are
+// computed for every row, so it is slow on large tables, see Sample.
+func (q *QueryBuilder) OrderByRandom() *QueryBuilder {
+	q.orderBy = append(q.orderBy, q.dbmap.Dialect.RandomFunc())
+	return q
+}
+
+// After pages through the rows with keyset pagination: only rows whose
+// column of field is greater than value are returned, sorted by that column
+// before any other OrderBy column.  Pass the last value of the previous
+// page together with Limit, which unlike Offset does not read the skipped
+// rows.  A later call replaces the column and value.  field may be a struct
+// field name or a column name of the mapped table.
+func (q *QueryBuilder) After(field string, value interface{}) *QueryBuilder {
+	if q.table == nil {
+		return q
+	}
+	col := colMapOrNil(q.table, field)
+	if col == nil {
+		q.err = fmt.Errorf("gorp: After: no column %s in table %s", field, q.table.TableName)
+		return q
+	}
+	q.after = q.dbmap.Dialect.QuoteField(col.ColumnName)
+	q.afterV = value
+	return q
+}
+
+// UseIndex hints the database to use the named index of the table, see
+// Dialect.IndexHint().  A later call replaces the index.  Dialects without
+// index hints ignore it.
+func (q *QueryBuilder) UseIndex(name string) *QueryBuilder {
+	q.index = name
+	return q
+}
+
+// Sample returns only about percent of the rows of the table, see
+// Dialect.SampleClause().  percent must be greater than 0 and at most 100.
+// Dialects without a sampling clause, like sqlite and mysql, return the
+// rows in random order instead of the OrderBy columns, so Limit must be
+// set to the size of the sample.
+func (q *QueryBuilder) Sample(percent float64) *QueryBuilder {
+	if percent <= 0 || percent > 100 {
+		q.err = fmt.Errorf("gorp: Sample: percent %g is not in (0, 100]", percent)
+		return q
+	}
+	q.sample = percent
+	return q
+}
+
+// Limit sets the maximum number of rows returned.
+func (q *QueryBuilder) Limit(n int64) *QueryBuilder {
+	q.limit = n
+	return q
+}
+
+// Offset sets the number of rows skipped before rows are returned.
+func (q *QueryBuilder) Offset(n int64) *QueryBuilder {
+	q.offset = n
+	return q
+}
+
+// SQL returns the generated query and its bind arguments without running it.
+func (q *QueryBuilder) SQL() (string, []interface{}, error) {
+	return q.buildSQL(false)
+}
+
+// CountSQL returns the query counting the rows matching the conditions and
+// its bind arguments without running it.  Order, limit, offset and the
+// condition of After are left out.
+func (q *QueryBuilder) CountSQL() (string, []interface{}, error) {
+	return q.buildSQL(true)
+}
+
+// buildSQL returns the query selecting the columns of the table, or the
+// count of rows if count is true
+func (q *QueryBuilder) buildSQL(count bool) (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	d := q.dbmap.Dialect
+
+	var hint SelectClause
+	if q.index != "" {
+		hint = d.IndexHint(q.table.TableName, q.index)
+	}
+	var sample SelectClause
+	if q.sample > 0 {
+		sample = d.SampleClause(q.sample)
+	}
+	randomOrder := sample.Placement == OrderBy
+
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	if hint.Placement == AfterSelect {
+		s.WriteString(hint.Sql)
+		s.WriteString(" ")
+	}
+	if count {
+		s.WriteString("count(*)")
+	} else {
+		x := 0
+		for _, col := range q.table.Columns {
+			if !col.Transient {
+				if x > 0 {
+					s.WriteString(",")
+				}
+				s.WriteString(d.QuoteField(col.ColumnName))
+				x++
+			}
+		}
+	}
+	s.WriteString(" from ")
+	s.WriteString(d.QuotedTableForQuery(q.table.SchemaName, q.table.TableName))
+	if sample.Placement == AfterTable {
+		s.WriteString(sample.Sql)
+	}
+	if hint.Placement == AfterTable {
+		s.WriteString(hint.Sql)
+	}
+
+	wheres, args, orderBy := q.wheres, q.args, q.orderBy
+	if q.after != "" && !count {
+		wheres = append(wheres[:len(wheres):len(wheres)], q.after+" > ?")
+		args = append(args[:len(args):len(args)], q.afterV)
+		orderBy = append([]string{q.after}, orderBy...)
+	}
+
+	n := 0
+	for i, cond := range wheres {
+		if i == 0 {
+			s.WriteString(" where ")
+		} else {
+			s.WriteString(" and ")
+		}
+		s.WriteString("(")
+		s.WriteString(bindPlaceholders(d, cond, &n))
+		s.WriteString(")")
+	}
+
+	if !count {
+		if randomOrder {
+			s.WriteString(" order by ")
+			s.WriteString(sample.Sql)
+		} else if len(orderBy) > 0 {
+			s.WriteString(" order by ")
+			s.WriteString(strings.Join(orderBy, ", "))
+		}
+		s.WriteString(limitClause(d, q.limit, q.offset, randomOrder || len(orderBy) > 0))
+	}
+	s.WriteString(d.QuerySuffix())
+
+	return s.String(), args, nil
+}
+
+// Select runs the generated query.  i is handled like the holder passed
+// to DbMap.Select().
+func (q *QueryBuilder) Select(i interface{}) ([]interface{}, error) {
+	query, args, err := q.SQL()
+	if err != nil {
+		return nil, err
+	}
+	return hookedselect(q.dbmap, q.exec, i, query, args...)
+}
+
+// Count returns the number of rows matching the conditions of the query,
+// ignoring its order, limit and offset, e.g. the total for a paginated
+// list.
+func (q *QueryBuilder) Count() (int64, error) {
+	query, args, err := q.CountSQL()
+	if err != nil {
+		return 0, err
+	}
+	return q.exec.SelectInt(query, args...)
+}
+
+// limitClause returns the dialect specific clause to limit a result set,
+// see LimitDialect.  A negative limit and a zero offset return an empty
+// string.
+func limitClause(d Dialect, limit int64, offset int64, hasOrder bool) string {
+	if limit < 0 && offset == 0 {
+		return ""
+	}
+	if ld, ok := d.(LimitDialect); ok {
+		return ld.LimitClause(limit, offset, hasOrder)
+	}
+	if limit < 0 {
+		// sqlite and mysql need a limit when an offset is given
+		limit = 999999999999999999
+	}
+	s := fmt.Sprintf(" limit %d", limit)
+	if offset > 0 {
+		s += fmt.Sprintf(" offset %d", offset)
+	}
+	return s
+}
+
+// countPlaceholders returns the number of "?" outside of quoted strings
+func countPlaceholders(cond string) int {
+	n := 0
+	bindPlaceholders(nil, cond, &n)
+	return n
+}
+
+// bindPlaceholders replaces each "?" outside of quoted strings in cond
+// with the dialect's bind variable, numbered from *n on.  *n is advanced
+// by the number of placeholders replaced.  A nil dialect only counts.
+func bindPlaceholders(d Dialect, cond string, n *int) string {
+	s := bytes.Buffer{}
+	var quote rune
+	for _, c := range cond {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			if d != nil {
+				s.WriteString(d.BindVar(*n))
+			}
+			*n++
+			continue
+		}
+		s.WriteRune(c)
+	}
+	return s.String()
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // The Dialect interface encapsulates behaviors that differ across
@@ -28,8 +30,15 @@ type Dialect interface {
 	// remove reference to those columns in the INSERT statement.
 	AutoIncrBindValue() string
 
+	// string to append to an insert statement to return the value
+	// of an autoincrement column, e.g. " returning id"
 	AutoIncrInsertSuffix(col *ColumnMap) string
 
+	// Returns true if the driver returns the key of an autoincrement
+	// insert with sql.Result.LastInsertId(), otherwise it is read from the
+	// row returned by the AutoIncrInsertSuffix of the statement
+	SupportsLastInsertId() bool
+
 	// string to append to "create table" statement for vendor specific
 	// table attributes
 	CreateTableSuffix() string
@@ -55,6 +64,28 @@ type Dialect interface {
 	// table - The table name
 	QuotedTableForQuery(schema string, table string) string
 
+	// Returns the hint to use the index on table in a select, placed
+	// AfterSelect for an optimizer comment or AfterTable.  Dialects without
+	// index hints return an empty SelectClause.
+	IndexHint(table string, index string) SelectClause
+
+	// Returns the clause sampling about percent of the rows of a table in
+	// a select.  A clause placed as OrderBy replaces the order of the query
+	// and needs a limit to return a sample.
+	SampleClause(percent float64) SelectClause
+
+	// Returns the expression of a random value to order rows by, e.g.
+	// "random()"
+	RandomFunc() string
+
+	// Returns the maximum number of bind parameters of one statement, batch
+	// operations are split into several statements to stay below it
+	MaxBindParams() int
+
+	// Returns the literal of a boolean constant in sql, e.g. "true" or "1"
+	// for true, matching the column type of bool fields
+	BoolLiteral(b bool) string
+
 	// Existance clause for table creation / deletion
 	IfSchemaNotExists(command, schema string) string
 	IfTableExists(command, schema, table string) string
@@ -63,27 +94,34 @@ type Dialect interface {
 	// Sql to check if an index exists
 	IfIndexExists(table, index, schema string) string
 
-	// Returns the sql to drop an index
+	// Returns the sql to drop an index, named by BuildIndexName like the
+	// index created by TableMap.SqlForCreateIndex
 	DropIndex(table *TableMap, index string) string
 
 	// Handles building up of a schema.database string that is compatible with
 	// the given dialect
 	// table - The table that <index> is created on
 	// index - The index name
+	// The result is quoted with QuoteField when the index is created or
+	// dropped.
 	BuildIndexName(table string, index string) string
 }
 
 // IntegerAutoIncrInserter is implemented by dialects that can perform
 // inserts with automatically incremented integer primary keys.  If
 // the dialect can handle automatic assignment of more than just
-// integers, see TargetedAutoIncrInserter.
+// integers, see TargetedAutoIncrInserter.  It is only used if
+// SupportsLastInsertId returns true.  exec of both inserters wraps the
+// DbMap or Transaction of the insert, so that the ArgRedactor gets the
+// column names of params.
 type IntegerAutoIncrInserter interface {
 	InsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error)
 }
 
 // TargetedAutoIncrInserter is implemented by dialects that can
 // perform automatic assignment of any primary key type (i.e. strings
-// for uuids, integers for serials, etc).
+// for uuids, integers for serials, etc).  It is only used if
+// SupportsLastInsertId returns false.
 type TargetedAutoIncrInserter interface {
 	// InsertAutoIncrToTarget runs an insert operation and assigns the
 	// automatically generated primary key directly to the passed in
@@ -92,6 +130,205 @@ type TargetedAutoIncrInserter interface {
 	InsertAutoIncrToTarget(exec SqlExecutor, insertSql string, target interface{}, params ...interface{}) error
 }
 
+// AutoIncrOutputDialect is implemented by dialects whose AutoIncrInsertSuffix
+// is an OUTPUT clause, which has to be placed between the column list and the
+// VALUES clause of the insert statement instead of at its end.
+type AutoIncrOutputDialect interface {
+	AutoIncrOutputBeforeValues() bool
+}
+
+// ReturningDialect is implemented by dialects which can return columns of
+// the inserted row from the insert statement.  See DbMap.InsertReturning.
+// If the dialect implements AutoIncrOutputDialect, the clause is placed
+// before the VALUES clause.
+type ReturningDialect interface {
+	// ReturningClause returns the clause which returns the columns cols
+	ReturningClause(cols []string) string
+}
+
+// SequenceDialect is implemented by dialects which can fill a column from a
+// named sequence on insert.  See ColumnMap.Sequence.
+type SequenceDialect interface {
+	// NextValSql returns the sql expression for the next value of sequence.
+	// The sequence name, optionally qualified by its schema, is quoted like
+	// a table name.
+	NextValSql(sequence string) string
+}
+
+// BitDialect is implemented by dialects with bit string columns, to which
+// fields with the "type:bit" tag option are mapped.  See BitConverter.
+type BitDialect interface {
+	// BitSqlType returns the type of a column of size bits
+	BitSqlType(size int) string
+	// BitString returns true if bit values are passed as strings of 0 and 1
+	// instead of big endian bytes
+	BitString() bool
+}
+
+// TransactionalDDLDialect is implemented by dialects which can roll back
+// DDL statements.  If TransactionalDDL returns true, CreateTables and
+// DropTables run in a transaction unless DbMap.SetDDLTransaction says
+// otherwise.
+type TransactionalDDLDialect interface {
+	TransactionalDDL() bool
+}
+
+// TableCommentDialect is implemented by dialects which can attach a comment
+// to a table.  See TableMap.SetComment.
+type TableCommentDialect interface {
+	// TableCommentSuffix returns the clause appended to create table, or ""
+	// if the comment is set by a separate statement
+	TableCommentSuffix(comment string) string
+	// TableCommentSql returns the statement setting the comment of the
+	// table, or "" if the comment is part of the create table statement
+	TableCommentSql(schema string, table string, comment string) string
+}
+
+// quoteLiteral returns s as a single quoted sql string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// splitSequence returns the schema and the name of a sequence name like
+// "schema.sequence"
+func splitSequence(sequence string) (string, string) {
+	if i := strings.LastIndex(sequence, "."); i >= 0 {
+		return sequence[:i], sequence[i+1:]
+	}
+	return "", sequence
+}
+
+// RetryDialect is implemented by dialects which recognize the errors of
+// transactions which failed because of concurrent transactions, like
+// serialization failures and deadlocks, and may succeed if run again.  See
+// DbMap.WithTransactionRetry.
+type RetryDialect interface {
+	IsRetryable(err error) bool
+}
+
+// UndefinedTableDialect is implemented by dialects which recognize the
+// error of a statement on a table which does not exist.  See
+// DbMap.VerifySchema.
+type UndefinedTableDialect interface {
+	IsUndefinedTable(err error) bool
+}
+
+// ClausePlacement tells where a SelectClause is placed in a select
+type ClausePlacement int
+
+const (
+	// AfterTable appends the clause to the table in the from clause
+	AfterTable ClausePlacement = iota
+	// AfterSelect places the clause after the select keyword, like an
+	// optimizer hint comment
+	AfterSelect
+	// OrderBy makes the clause the expression of the order by clause
+	OrderBy
+	// AfterWhere appends the clause to the where clause, like a locking
+	// clause
+	AfterWhere
+)
+
+// SelectClause is a dialect specific clause of a select and its placement
+type SelectClause struct {
+	Sql       string
+	Placement ClausePlacement
+}
+
+// LockDialect is implemented by dialects which can lock the rows read by a
+// select until the end of the transaction.  See UpdateWithOld.
+type LockDialect interface {
+	// ForUpdateClause returns the clause locking the selected rows against
+	// updates of other transactions, placed AfterTable or AfterWhere
+	ForUpdateClause() SelectClause
+}
+
+// LimitDialect is implemented by dialects whose clause limiting the rows of
+// a select is not "limit n offset m".  See SelectStrLimit1.
+type LimitDialect interface {
+	// LimitClause returns the clause appended to a select to skip offset
+	// rows and return at most limit rows, all of them for a negative
+	// limit.  hasOrder tells if the select ends in an order by clause.
+	LimitClause(limit int64, offset int64, hasOrder bool) string
+}
+
+// standardOffsetFetch returns the offset and fetch clause of sql:2008
+func standardOffsetFetch(limit int64, offset int64) string {
+	s := fmt.Sprintf(" offset %d rows", offset)
+	if limit >= 0 {
+		s += fmt.Sprintf(" fetch next %d rows only", limit)
+	}
+	return s
+}
+
+// SavepointDialect is implemented by dialects whose savepoint statements
+// differ from the standard "savepoint", "rollback to savepoint" and
+// "release savepoint".  See Transaction.Savepoint.
+type SavepointDialect interface {
+	SavepointSql(name string) string
+	RollbackToSavepointSql(name string) string
+	// ReleaseSavepointSql returns "" if the database has no statement to
+	// release a savepoint before the end of the transaction
+	ReleaseSavepointSql(name string) string
+}
+
+// CopyInDialect is implemented by dialects which can bulk load rows with
+// the COPY protocol of their driver.  See DbMap.CopyIn.
+type CopyInDialect interface {
+	// CopyInSql returns the statement which, prepared in a transaction,
+	// loads the arguments of each execution as a row of the columns
+	CopyInSql(schema string, table string, columns []string) string
+}
+
+// IndexIncludeDialect is implemented by dialects which support covering
+// indexes.  See IndexMap.Include.
+type IndexIncludeDialect interface {
+	// IndexIncludeSql returns the clause appended to a create index
+	// statement to include the non key columns
+	IndexIncludeSql(columns []string) string
+}
+
+// ConcurrentIndexDialect is implemented by dialects which can build an
+// index without blocking writes to the table.  See IndexMap.Concurrently.
+type ConcurrentIndexDialect interface {
+	// ConcurrentIndex returns command, "create index" or "create unique
+	// index", changed to build the index concurrently
+	ConcurrentIndex(command string) string
+}
+
+// UpsertDialect is implemented by dialects which can update the row an
+// insert conflicts with.  See DbMap.Upsert.
+type UpsertDialect interface {
+	// OnConflictClause returns the clause appended to the values of an
+	// insert which sets the columns update of the row conflicting on the
+	// unique index of columns, partial with predicate where if not empty.
+	// If updateWhere is not empty, only rows matching it are updated.
+	OnConflictClause(columns []string, where string, update []string, updateWhere string) string
+}
+
+// IndexNotExistsDialect is implemented by dialects which can create an
+// index only if it does not exist yet.  CreateIndexesIfNotExists uses it
+// instead of looking the index up with IfIndexExists.
+type IndexNotExistsDialect interface {
+	// IfIndexNotExists guards command, "create index" or "create unique
+	// index", to run only if the index named by BuildIndexName is missing
+	IfIndexNotExists(command, schema, table, index string) string
+}
+
+// standardIndexInclude returns " include (columns)" with quoted columns
+func standardIndexInclude(d Dialect, columns []string) string {
+	return " include (" + quotedList(d, columns) + ")"
+}
+
+// quotedList returns the quoted columns separated by commas
+func quotedList(d Dialect, columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = d.QuoteField(col)
+	}
+	return strings.Join(quoted, ", ")
+}
+
 func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error) {
 	res, err := exec.Exec(insertSql, params...)
 	if err != nil {
@@ -100,12 +337,117 @@ func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interf
 	return res.LastInsertId()
 }
 
+// standardInsertAutoIncrToTarget runs an insert which returns the generated
+// key as a single row with a single column and scans it into target.
+func standardInsertAutoIncrToTarget(exec SqlExecutor, insertSql string, target interface{}, params ...interface{}) error {
+	rows, err := exec.query(insertSql, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return fmt.Errorf("No serial value returned for insert: %s Encountered error: %s", insertSql, rows.Err())
+	}
+	if err := rows.Scan(target); err != nil {
+		return err
+	}
+	if rows.Next() {
+		return fmt.Errorf("more than two serial value returned for insert: %s", insertSql)
+	}
+	return rows.Err()
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = make(map[string]Dialect)
+)
+
+// RegisterDialect makes d the Dialect returned by DialectForDriver for the
+// database/sql driver name, so packages can supply dialects of databases
+// gorp does not know, or replace a built-in one.  It is usually called from
+// the init function of the package implementing the dialect.
+//
+// Panics if name is empty or d is nil.
+func RegisterDialect(name string, d Dialect) {
+	if name == "" || d == nil {
+		panic("gorp: RegisterDialect needs a driver name and a dialect")
+	}
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	dialects[name] = d
+}
+
+// DialectForDriver returns the Dialect for the database/sql driver name.
+// Dialects registered with RegisterDialect take precedence over the
+// built-in ones, which are returned with their default settings, e.g. the
+// InnoDB engine and UTF8 encoding for "mysql".
+func DialectForDriver(name string) (Dialect, error) {
+	dialectsMu.RLock()
+	d, ok := dialects[name]
+	dialectsMu.RUnlock()
+	if ok {
+		return d, nil
+	}
+	switch name {
+	case "sqlite3":
+		return SqliteDialect{}, nil
+	case "postgres", "pgx":
+		return PostgresDialect{}, nil
+	case "mysql":
+		return MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}, nil
+	case "mssql", "sqlserver":
+		return SqlServerDialect{}, nil
+	case "oci8", "godror":
+		return OracleDialect{}, nil
+	}
+	return nil, fmt.Errorf("gorp: no dialect for driver %q, see RegisterDialect", name)
+}
+
+// IdentifierCase controls how a dialect folds the case of quoted
+// identifiers like table, column and index names.  It can be set on the
+// sqlite, PostgreSQL and Oracle dialects, MySQL and SQL Server always
+// preserve the case.
+type IdentifierCase int
+
+const (
+	// DialectCase keeps the traditional folding of the dialect: lower case
+	// for PostgreSQL, upper case for Oracle and preserved case for sqlite
+	DialectCase IdentifierCase = iota
+	// PreserveCase quotes identifiers as they are, so mixed case names
+	// round-trip
+	PreserveCase
+	// LowerCase folds identifiers to lower case
+	LowerCase
+	// UpperCase folds identifiers to upper case
+	UpperCase
+)
+
+// fold applies the case policy to s.  dialectCase is the policy used for
+// DialectCase.
+func (c IdentifierCase) fold(s string, dialectCase IdentifierCase) string {
+	if c == DialectCase {
+		c = dialectCase
+	}
+	switch c {
+	case LowerCase:
+		return strings.ToLower(s)
+	case UpperCase:
+		return strings.ToUpper(s)
+	}
+	return s
+}
+
 ///////////////////////////////////////////////////////
 // sqlite3 //
 /////////////
 
 type SqliteDialect struct {
 	suffix string
+
+	// IdentifierCase controls case folding of quoted identifiers,
+	// the default preserves the case
+	IdentifierCase IdentifierCase
 }
 
 func (d SqliteDialect) QuerySuffix() string { return ";" }
@@ -156,6 +498,8 @@ func (d SqliteDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
 	return ""
 }
 
+func (d SqliteDialect) SupportsLastInsertId() bool { return true }
+
 // Returns suffix
 func (d SqliteDialect) CreateTableSuffix() string {
 	return d.suffix
@@ -178,7 +522,7 @@ func (d SqliteDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 }
 
 func (d SqliteDialect) QuoteField(f string) string {
-	return `"` + f + `"`
+	return `"` + d.IdentifierCase.fold(f, PreserveCase) + `"`
 }
 
 // sqlite does not have schemas like PostgreSQL does, so just escape it like normal
@@ -186,6 +530,42 @@ func (d SqliteDialect) QuotedTableForQuery(schema string, table string) string {
 	return d.QuoteField(table)
 }
 
+func (d SqliteDialect) IndexHint(table string, index string) SelectClause {
+	return SelectClause{" indexed by " + d.QuoteField(index), AfterTable}
+}
+
+// SampleClause returns a random order, sqlite has no tablesample
+func (d SqliteDialect) SampleClause(percent float64) SelectClause {
+	return SelectClause{d.RandomFunc(), OrderBy}
+}
+
+func (d SqliteDialect) RandomFunc() string { return "random()" }
+
+// MaxBindParams returns 999, the default limit of sqlite before 3.32.0
+func (d SqliteDialect) MaxBindParams() int { return 999 }
+
+// Returns "1" or "0"
+func (d SqliteDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (d SqliteDialect) TransactionalDDL() bool { return true }
+
+// IsRetryable returns true for SQLITE_BUSY and SQLITE_LOCKED errors
+func (d SqliteDialect) IsRetryable(err error) bool {
+	code, ok := errorInt(err, "Code")
+	return ok && (code == 5 || code == 6)
+}
+
+// IsUndefinedTable returns true for "no such table" errors, which sqlite
+// reports with the generic SQLITE_ERROR code
+func (d SqliteDialect) IsUndefinedTable(err error) bool {
+	return strings.Contains(err.Error(), "no such table")
+}
+
 func (d SqliteDialect) QuotedIndex(table string, index string) string {
 	return d.QuoteField(index)
 }
@@ -202,9 +582,18 @@ func (d SqliteDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
 
+func (d SqliteDialect) IfIndexNotExists(command, schema, table, index string) string {
+	return fmt.Sprintf("%s if not exists", command)
+}
+
+// IfIndexExists lists the columns of the index with pragma index_info,
+// which returns no rows if the index does not exist
 func (d SqliteDialect) IfIndexExists(table, index, schema string) string {
-	panic("IfIndexExists not implemented for SqliteDialect")
-	return "Not Implemented"
+	args := quoteLiteral(d.BuildIndexName(table, index))
+	if strings.TrimSpace(schema) != "" {
+		args += ", " + quoteLiteral(schema)
+	}
+	return "select name as ColumnName from pragma_index_info(" + args + ") order by seqno"
 }
 
 // Handles building up of a schema.database string that is compatible with
@@ -212,12 +601,11 @@ func (d SqliteDialect) IfIndexExists(table, index, schema string) string {
 // table - The table that <index> is created on
 // index - The index name
 func (d SqliteDialect) BuildIndexName(table string, index string) string {
-	panic("BuildIndexName not implemented for SqliteDialect")
-	return "Not Implemented"
+	return index
 }
 
 func (d SqliteDialect) DropIndex(table *TableMap, index string) string {
-	sql := "drop index " + index + " " + d.QuotedIndex(table.SchemaName, index)
+	sql := "drop index " + d.QuotedIndex(table.SchemaName, d.BuildIndexName(table.TableName, index))
 	return sql
 }
 
@@ -227,11 +615,21 @@ func (d SqliteDialect) DropIndex(table *TableMap, index string) string {
 
 type PostgresDialect struct {
 	suffix string
+
+	// IdentifierCase controls case folding of quoted identifiers,
+	// the default folds them to lower case
+	IdentifierCase IdentifierCase
 }
 
 func (d PostgresDialect) QuerySuffix() string { return ";" }
 
 func (d PostgresDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val {
+	case ipType:
+		return "inet"
+	case macType:
+		return "macaddr"
+	}
 	switch val.Kind() {
 	case reflect.Ptr:
 		return d.ToSqlType(val.Elem(), maxsize, isAutoIncr)
@@ -284,7 +682,14 @@ func (d PostgresDialect) AutoIncrBindValue() string {
 }
 
 func (d PostgresDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
-	return " returning " + col.ColumnName
+	return " returning " + d.QuoteField(col.ColumnName)
+}
+
+func (d PostgresDialect) SupportsLastInsertId() bool { return false }
+
+// ReturningClause returns " returning " and the quoted columns
+func (d PostgresDialect) ReturningClause(cols []string) string {
+	return " returning " + quotedList(d, cols)
 }
 
 // Returns suffix
@@ -302,32 +707,32 @@ func (d PostgresDialect) BindVar(i int) string {
 }
 
 func (d PostgresDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql string, target interface{}, params ...interface{}) error {
-	rows, err := exec.query(insertSql, params...)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
+	return standardInsertAutoIncrToTarget(exec, insertSql, target, params...)
+}
 
-	if !rows.Next() {
-		return fmt.Errorf("No serial value returned for insert: %s Encountered error: %s", insertSql, rows.Err())
-	}
-	if err := rows.Scan(target); err != nil {
-		return err
-	}
-	if rows.Next() {
-		return fmt.Errorf("more than two serial value returned for insert: %s", insertSql)
-	}
-	return rows.Err()
+// Returns nextval('"sequence"')
+func (d PostgresDialect) NextValSql(sequence string) string {
+	return "nextval(" + quoteLiteral(d.QuotedTableForQuery(splitSequence(sequence))) + ")"
+}
+
+func (d PostgresDialect) BitSqlType(size int) string {
+	return fmt.Sprintf("bit(%d)", size)
+}
+
+func (d PostgresDialect) BitString() bool { return true }
+
+func (d PostgresDialect) IndexIncludeSql(columns []string) string {
+	return standardIndexInclude(d, columns)
 }
 
 func (d PostgresDialect) QuoteField(f string) string {
-	return `"` + strings.ToLower(f) + `"`
+	return `"` + d.IdentifierCase.fold(f, LowerCase) + `"`
 }
 
 // gorp with indexes, added by kim: https://github.com/kimxilxyong/gorp
 // QuoteString is used to quote strings used in a WHERE clause
 func (d PostgresDialect) QuoteString(f string) string {
-	return `'` + strings.ToLower(f) + `'`
+	return `'` + d.IdentifierCase.fold(f, LowerCase) + `'`
 }
 
 func (d PostgresDialect) QuotedTableForQuery(schema string, table string) string {
@@ -335,7 +740,56 @@ func (d PostgresDialect) QuotedTableForQuery(schema string, table string) string
 		return d.QuoteField(table)
 	}
 
-	return strings.ToLower(schema + "." + d.QuoteField(table))
+	return d.IdentifierCase.fold(schema, LowerCase) + "." + d.QuoteField(table)
+}
+
+// IndexHint returns a hint for the pg_hint_plan extension, without it the
+// hint is an ordinary comment
+func (d PostgresDialect) IndexHint(table string, index string) SelectClause {
+	return SelectClause{fmt.Sprintf("/*+ IndexScan(%s %s) */", d.QuoteField(table), index), AfterSelect}
+}
+
+func (d PostgresDialect) SampleClause(percent float64) SelectClause {
+	return SelectClause{" tablesample system (" + formatPercent(percent) + ")", AfterTable}
+}
+
+func (d PostgresDialect) RandomFunc() string { return "random()" }
+
+func (d PostgresDialect) MaxBindParams() int { return 65535 }
+
+// Returns "true" or "false"
+func (d PostgresDialect) BoolLiteral(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+func (d PostgresDialect) TransactionalDDL() bool { return true }
+
+func (d PostgresDialect) TableCommentSuffix(comment string) string { return "" }
+
+func (d PostgresDialect) TableCommentSql(schema string, table string, comment string) string {
+	return "comment on table " + d.QuotedTableForQuery(schema, table) + " is " + quoteLiteral(comment) + d.QuerySuffix()
+}
+
+// IsRetryable returns true for serialization failures (SQLSTATE 40001) and
+// deadlocks (40P01)
+func (d PostgresDialect) IsRetryable(err error) bool {
+	state := sqlState(err)
+	return state == "40001" || state == "40P01"
+}
+
+func (d PostgresDialect) ForUpdateClause() SelectClause {
+	return SelectClause{" for update", AfterWhere}
+}
+
+// IsUndefinedTable returns true for SQLSTATE 42P01
+func (d PostgresDialect) IsUndefinedTable(err error) bool {
+	return sqlState(err) == "42P01"
+}
+
+// CopyInSql returns the COPY statement which lib/pq runs with its copy
+// protocol, like pq.CopyInSchema does
+func (d PostgresDialect) CopyInSql(schema string, table string, columns []string) string {
+	return "copy " + d.QuotedTableForQuery(schema, table) + " (" + quotedList(d, columns) + ") from stdin"
 }
 
 func (d PostgresDialect) BuildIndexName(table string, index string) string {
@@ -358,6 +812,32 @@ func (d PostgresDialect) IfTableNotExists(command, schema, table string) string
 	return fmt.Sprintf("%s if not exists", command)
 }
 
+func (d PostgresDialect) OnConflictClause(columns []string, where string, update []string, updateWhere string) string {
+	s := " on conflict (" + quotedList(d, columns) + ")"
+	if where != "" {
+		s += " where " + where
+	}
+	s += " do update set "
+	for i, col := range update {
+		if i > 0 {
+			s += ", "
+		}
+		s += d.QuoteField(col) + " = excluded." + d.QuoteField(col)
+	}
+	if updateWhere != "" {
+		s += " where " + updateWhere
+	}
+	return s
+}
+
+func (d PostgresDialect) ConcurrentIndex(command string) string {
+	return command + " concurrently"
+}
+
+func (d PostgresDialect) IfIndexNotExists(command, schema, table, index string) string {
+	return fmt.Sprintf("%s if not exists", command)
+}
+
 func (d PostgresDialect) IfIndexExists(table, index, schema string) string {
 
 	sql := `select
@@ -376,7 +856,7 @@ func (d PostgresDialect) IfIndexExists(table, index, schema string) string {
 		    and t.relkind = 'r'
 		    and t.relname = ` + d.QuoteString(table) +
 		`
-		    and i.relname = ` + d.QuoteString(d.BuildIndexName(table, index))
+		    and i.relname = ` + d.QuoteString(d.IdentifierCase.fold(d.BuildIndexName(table, index), LowerCase))
 
 	if schema != "" {
 		sql = sql + `
@@ -392,8 +872,18 @@ func (d PostgresDialect) IfIndexExists(table, index, schema string) string {
 	return sql
 }
 
+// QuotedIndex returns the quoted index, qualified with schema unless it is
+// empty.  Indexes live in the schema of their table.
+func (d PostgresDialect) QuotedIndex(schema string, index string) string {
+	if strings.TrimSpace(schema) == "" {
+		return d.QuoteField(index)
+	}
+
+	return d.QuoteField(schema) + "." + d.QuoteField(index)
+}
+
 func (d PostgresDialect) DropIndex(table *TableMap, index string) string {
-	sql := "drop index " + d.QuoteField(d.BuildIndexName(table.TableName, index))
+	sql := "drop index " + d.QuotedIndex(table.SchemaName, d.BuildIndexName(table.TableName, index))
 	return sql
 }
 
@@ -414,6 +904,12 @@ type MySQLDialect struct {
 func (d MySQLDialect) QuerySuffix() string { return ";" }
 
 func (d MySQLDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val {
+	case ipType:
+		return "varbinary(16)"
+	case macType:
+		return "varbinary(6)"
+	}
 	switch val.Kind() {
 	case reflect.Ptr:
 		return d.ToSqlType(val.Elem(), maxsize, isAutoIncr)
@@ -461,6 +957,27 @@ func (d MySQLDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool)
 }
 
 // Returns auto_increment
+func (d MySQLDialect) BitSqlType(size int) string {
+	return fmt.Sprintf("bit(%d)", size)
+}
+
+func (d MySQLDialect) BitString() bool { return false }
+
+// TableCommentSuffix returns " comment='comment'", backslashes are escaped
+// as MySQL treats them as escape characters in string literals
+func (d MySQLDialect) TableCommentSuffix(comment string) string {
+	return " comment=" + quoteLiteral(strings.Replace(comment, `\`, `\\`, -1))
+}
+
+// SetNamesSql returns "set names" with the Encoding of d, which sets the
+// character set of a connection to the one of the tables, so multibyte
+// text is not mangled.  Run it on each connection with NewInitConnector.
+func (d MySQLDialect) SetNamesSql() string {
+	return "set names " + d.Encoding
+}
+
+func (d MySQLDialect) TableCommentSql(schema string, table string, comment string) string { return "" }
+
 func (d MySQLDialect) AutoIncrStr() string {
 	return "auto_increment"
 }
@@ -473,6 +990,8 @@ func (d MySQLDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
 	return ""
 }
 
+func (d MySQLDialect) SupportsLastInsertId() bool { return true }
+
 // Returns engine=%s charset=%s  based on values stored on struct
 func (d MySQLDialect) CreateTableSuffix() string {
 	if d.Engine == "" || d.Encoding == "" {
@@ -519,6 +1038,24 @@ func (d MySQLDialect) QuotedTableForQuery(schema string, table string) string {
 	return schema + "." + d.QuoteField(table)
 }
 
+func (d MySQLDialect) IndexHint(table string, index string) SelectClause {
+	return SelectClause{" use index (" + d.QuoteField(index) + ")", AfterTable}
+}
+
+// SampleClause returns a random order, mysql has no tablesample
+func (d MySQLDialect) SampleClause(percent float64) SelectClause {
+	return SelectClause{d.RandomFunc(), OrderBy}
+}
+
+func (d MySQLDialect) RandomFunc() string { return "rand()" }
+
+func (d MySQLDialect) MaxBindParams() int { return 65535 }
+
+// Returns "true" or "false"
+func (d MySQLDialect) BoolLiteral(b bool) string {
+	return strconv.FormatBool(b)
+}
+
 func (d MySQLDialect) QuotedIndex(table string, index string) string {
 	if strings.TrimSpace(table) == "" {
 		return d.QuoteField(index)
@@ -552,8 +1089,26 @@ func (d MySQLDialect) IfIndexExists(table, index, schema string) string {
 	return sql
 }
 
+// IsRetryable returns true for deadlocks (error 1213)
+func (d MySQLDialect) IsRetryable(err error) bool {
+	n, ok := errorInt(err, "Number")
+	return ok && n == 1213
+}
+
+func (d MySQLDialect) ForUpdateClause() SelectClause {
+	return SelectClause{" for update", AfterWhere}
+}
+
+// IsUndefinedTable returns true for error 1146, table doesn't exist
+func (d MySQLDialect) IsUndefinedTable(err error) bool {
+	n, ok := errorInt(err, "Number")
+	return ok && n == 1146
+}
+
+// MySQL drops indexes by name on their table
 func (d MySQLDialect) DropIndex(table *TableMap, index string) string {
-	sql := "drop index " + d.QuotedIndex(table.SchemaName, index)
+	sql := "drop index " + d.QuoteField(d.BuildIndexName(table.TableName, index)) +
+		" on " + d.QuotedTableForQuery(table.SchemaName, table.TableName)
 	return sql
 }
 
@@ -580,6 +1135,12 @@ type SqlServerDialect struct {
 }
 
 func (d SqlServerDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val {
+	case ipType:
+		return "varbinary(16)"
+	case macType:
+		return "varbinary(6)"
+	}
 	switch val.Kind() {
 	case reflect.Ptr:
 		return d.ToSqlType(val.Elem(), maxsize, isAutoIncr)
@@ -645,8 +1206,30 @@ func (d SqlServerDialect) AutoIncrBindValue() string {
 	return ""
 }
 
+// Returns an OUTPUT clause for the identity column, the go-mssqldb driver
+// does not support LastInsertId reliably
 func (d SqlServerDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
-	return ""
+	return " output inserted." + d.QuoteField(col.ColumnName)
+}
+
+func (d SqlServerDialect) SupportsLastInsertId() bool { return false }
+
+// The OUTPUT clause has to precede the VALUES clause
+func (d SqlServerDialect) AutoIncrOutputBeforeValues() bool {
+	return true
+}
+
+// ReturningClause returns an output clause of the inserted columns
+func (d SqlServerDialect) ReturningClause(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = "inserted." + d.QuoteField(col)
+	}
+	return " output " + strings.Join(quoted, ", ")
+}
+
+func (d SqlServerDialect) IndexIncludeSql(columns []string) string {
+	return standardIndexInclude(d, columns)
 }
 
 func (d SqlServerDialect) CreateTableSuffix() string { return ";" }
@@ -660,8 +1243,8 @@ func (d SqlServerDialect) BindVar(i int) string {
 	return "?"
 }
 
-func (d SqlServerDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error) {
-	return standardInsertAutoIncr(exec, insertSql, params...)
+func (d SqlServerDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql string, target interface{}, params ...interface{}) error {
+	return standardInsertAutoIncrToTarget(exec, insertSql, target, params...)
 }
 
 func (d SqlServerDialect) QuoteField(f string) string {
@@ -675,6 +1258,43 @@ func (d SqlServerDialect) QuotedTableForQuery(schema string, table string) strin
 	return d.QuoteField(schema) + "." + d.QuoteField(table)
 }
 
+func (d SqlServerDialect) IndexHint(table string, index string) SelectClause {
+	return SelectClause{" with (index(" + d.QuoteField(index) + "))", AfterTable}
+}
+
+func (d SqlServerDialect) SampleClause(percent float64) SelectClause {
+	return SelectClause{" tablesample (" + formatPercent(percent) + " percent)", AfterTable}
+}
+
+func (d SqlServerDialect) RandomFunc() string { return "newid()" }
+
+func (d SqlServerDialect) MaxBindParams() int { return 2100 }
+
+// Returns "1" or "0"
+func (d SqlServerDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (d SqlServerDialect) TransactionalDDL() bool { return true }
+
+func (d SqlServerDialect) TableCommentSuffix(comment string) string { return "" }
+
+// TableCommentSql sets the MS_Description extended property of the table,
+// unless it is already set.  The schema defaults to dbo.
+func (d SqlServerDialect) TableCommentSql(schema string, table string, comment string) string {
+	if strings.TrimSpace(schema) == "" {
+		schema = "dbo"
+	}
+	return fmt.Sprintf("if not exists (select 1 from sys.extended_properties where major_id = object_id(N%s) and minor_id = 0 and name = N'MS_Description') "+
+		"exec sp_addextendedproperty @name = N'MS_Description', @value = N%s, "+
+		"@level0type = N'SCHEMA', @level0name = N%s, @level1type = N'TABLE', @level1name = N%s%s",
+		quoteLiteral(d.QuotedTableForQuery(schema, table)), quoteLiteral(comment),
+		quoteLiteral(schema), quoteLiteral(table), d.QuerySuffix())
+}
+
 func (d SqlServerDialect) QuotedIndex(table string, index string) string {
 	if strings.TrimSpace(table) == "" {
 		return d.QuoteField(index)
@@ -702,19 +1322,67 @@ func (d SqlServerDialect) IfTableExists(command, schema, table string) string {
 func (d SqlServerDialect) IfTableNotExists(command, schema, table string) string {
 	var schema_clause string
 	if strings.TrimSpace(schema) != "" {
-		schema_clause = fmt.Sprintf("%s.", schema)
+		schema_clause = fmt.Sprintf("%s.", d.QuoteField(schema))
 	}
-	s := fmt.Sprintf("if object_id('%s%s') is null %s", schema_clause, table, command)
+	s := fmt.Sprintf("if object_id('%s%s') is null %s", schema_clause, d.QuoteField(table), command)
 	return s
 }
 
+func (d SqlServerDialect) IfIndexNotExists(command, schema, table, index string) string {
+	return fmt.Sprintf("if indexproperty(object_id('%s'), '%s', 'IndexID') is null %s",
+		d.QuotedTableForQuery(schema, table), index, command)
+}
+
 func (d SqlServerDialect) IfIndexExists(table, index, schema string) string {
 	panic("IfIndexExists not implemented for SqlServerDialect")
 	return "Not Implemented"
 }
 
+// IsRetryable returns true for deadlocks (error 1205) and update conflicts
+// of snapshot isolation (3960)
+func (d SqlServerDialect) IsRetryable(err error) bool {
+	n, ok := errorInt(err, "Number")
+	return ok && (n == 1205 || n == 3960)
+}
+
+// ForUpdateClause returns the table hint holding update locks on the
+// selected rows
+func (d SqlServerDialect) ForUpdateClause() SelectClause {
+	return SelectClause{" with (updlock, rowlock)", AfterTable}
+}
+
+// IsUndefinedTable returns true for error 208, invalid object name
+func (d SqlServerDialect) IsUndefinedTable(err error) bool {
+	n, ok := errorInt(err, "Number")
+	return ok && n == 208
+}
+
+// LimitClause returns offset and fetch, which Sql Server only allows after
+// an order by clause
+func (d SqlServerDialect) LimitClause(limit int64, offset int64, hasOrder bool) string {
+	if !hasOrder {
+		return " order by (select null)" + standardOffsetFetch(limit, offset)
+	}
+	return standardOffsetFetch(limit, offset)
+}
+
+func (d SqlServerDialect) SavepointSql(name string) string {
+	return "save transaction " + d.QuoteField(name)
+}
+
+func (d SqlServerDialect) RollbackToSavepointSql(name string) string {
+	return "rollback transaction " + d.QuoteField(name)
+}
+
+// SQL Server releases savepoints only with the transaction
+func (d SqlServerDialect) ReleaseSavepointSql(name string) string {
+	return ""
+}
+
+// SQL Server drops indexes by name on their table
 func (d SqlServerDialect) DropIndex(table *TableMap, index string) string {
-	sql := "drop index " + d.QuotedIndex(table.SchemaName, index)
+	sql := "drop index " + d.QuoteField(d.BuildIndexName(table.TableName, index)) +
+		" on " + d.QuotedTableForQuery(table.SchemaName, table.TableName)
 	return sql
 }
 
@@ -727,11 +1395,22 @@ func (d SqlServerDialect) BuildIndexName(table string, index string) string {
 ///////////
 
 // Implementation of Dialect for Oracle databases.
-type OracleDialect struct{}
+type OracleDialect struct {
+
+	// IdentifierCase controls case folding of quoted identifiers,
+	// the default folds them to upper case
+	IdentifierCase IdentifierCase
+}
 
 func (d OracleDialect) QuerySuffix() string { return "" }
 
 func (d OracleDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	switch val {
+	case ipType:
+		return "raw(16)"
+	case macType:
+		return "raw(6)"
+	}
 	switch val.Kind() {
 	case reflect.Ptr:
 		return d.ToSqlType(val.Elem(), maxsize, isAutoIncr)
@@ -789,11 +1468,24 @@ func (d OracleDialect) AutoIncrInsertSuffix(col *ColumnMap) string {
 	return " returning " + col.ColumnName
 }
 
+func (d OracleDialect) SupportsLastInsertId() bool { return false }
+
+// ReturningClause returns " returning " and the quoted columns
+func (d OracleDialect) ReturningClause(cols []string) string {
+	return " returning " + quotedList(d, cols)
+}
+
 // Returns suffix
 func (d OracleDialect) CreateTableSuffix() string {
 	return ""
 }
 
+func (d OracleDialect) TableCommentSuffix(comment string) string { return "" }
+
+func (d OracleDialect) TableCommentSql(schema string, table string, comment string) string {
+	return "comment on table " + d.QuotedTableForQuery(schema, table) + " is " + quoteLiteral(comment) + d.QuerySuffix()
+}
+
 func (d OracleDialect) TruncateClause() string {
 	return "truncate"
 }
@@ -819,8 +1511,13 @@ func (d OracleDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return 0, errors.New("No serial value returned for insert: " + insertSql + " Encountered error: " + rows.Err().Error())
 }
 
+// Returns "SEQUENCE".nextval
+func (d OracleDialect) NextValSql(sequence string) string {
+	return d.QuotedTableForQuery(splitSequence(sequence)) + ".nextval"
+}
+
 func (d OracleDialect) QuoteField(f string) string {
-	return `"` + strings.ToUpper(f) + `"`
+	return `"` + d.IdentifierCase.fold(f, UpperCase) + `"`
 }
 
 func (d OracleDialect) QuotedTableForQuery(schema string, table string) string {
@@ -831,12 +1528,32 @@ func (d OracleDialect) QuotedTableForQuery(schema string, table string) string {
 	return schema + "." + d.QuoteField(table)
 }
 
+func (d OracleDialect) IndexHint(table string, index string) SelectClause {
+	return SelectClause{fmt.Sprintf("/*+ INDEX(%s %s) */", d.QuoteField(table), index), AfterSelect}
+}
+
+func (d OracleDialect) SampleClause(percent float64) SelectClause {
+	return SelectClause{" sample (" + formatPercent(percent) + ")", AfterTable}
+}
+
+func (d OracleDialect) RandomFunc() string { return "dbms_random.value" }
+
+func (d OracleDialect) MaxBindParams() int { return 65535 }
+
+// Returns "true" or "false"
+func (d OracleDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
 func (d OracleDialect) QuotedIndex(table string, index string) string {
 	if strings.TrimSpace(table) == "" {
 		return d.QuoteField(index)
 	}
 
-	return table + "." + d.QuoteField(index)
+	return d.QuoteField(table) + "." + d.QuoteField(index)
 }
 
 func (d OracleDialect) IfSchemaNotExists(command, schema string) string {
@@ -852,15 +1569,58 @@ func (d OracleDialect) IfTableNotExists(command, schema, table string) string {
 }
 
 func (d OracleDialect) IfIndexExists(table, index, schema string) string {
-	panic("IfIndexExists not implemented for OracleDialect")
-	return "Not Implemented"
+	sql := "select column_name as ColumnName from all_ind_columns" +
+		" where index_name = " + quoteLiteral(d.IdentifierCase.fold(index, UpperCase)) +
+		" and table_name = " + quoteLiteral(d.IdentifierCase.fold(table, UpperCase))
+	if schema != "" {
+		sql += " and table_owner = " + quoteLiteral(strings.ToUpper(schema))
+	}
+	return sql + " order by column_position"
+}
+
+// IsRetryable returns true for serialization failures (ORA-08177) and
+// deadlocks (ORA-00060)
+func (d OracleDialect) IsRetryable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "ORA-08177") || strings.Contains(msg, "ORA-00060")
+}
+
+func (d OracleDialect) ForUpdateClause() SelectClause {
+	return SelectClause{" for update", AfterWhere}
+}
+
+// IsUndefinedTable returns true for ORA-00942, table or view does not exist
+func (d OracleDialect) IsUndefinedTable(err error) bool {
+	return strings.Contains(err.Error(), "ORA-00942")
+}
+
+func (d OracleDialect) LimitClause(limit int64, offset int64, hasOrder bool) string {
+	return standardOffsetFetch(limit, offset)
+}
+
+func (d OracleDialect) SavepointSql(name string) string {
+	return "savepoint " + d.QuoteField(name)
+}
+
+func (d OracleDialect) RollbackToSavepointSql(name string) string {
+	return "rollback to savepoint " + d.QuoteField(name)
+}
+
+// Oracle releases savepoints only with the transaction
+func (d OracleDialect) ReleaseSavepointSql(name string) string {
+	return ""
 }
 
 func (d OracleDialect) DropIndex(table *TableMap, index string) string {
-	sql := "drop index " + d.QuotedIndex(table.SchemaName, index)
+	sql := "drop index " + d.QuotedIndex(table.SchemaName, d.BuildIndexName(table.TableName, index))
 	return sql
 }
 
 func (d OracleDialect) BuildIndexName(table string, index string) string {
 	return index
 }
+
+// formatPercent returns percent as a decimal literal
+func formatPercent(percent float64) string {
+	return strconv.FormatFloat(percent, 'f', -1, 64)
+}
@@ -1,7 +1,11 @@
 package gorp
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 )
 
 // A non-fatal error, when a select query returns columns that do not exist
@@ -15,6 +19,112 @@ func (err *NoFieldInTypeError) Error() string {
 	return fmt.Sprintf("gorp: No fields %+v in type %s", err.MissingColNames, err.TypeName)
 }
 
+// MissingTablesError is returned by DbMap.VerifySchema if mapped tables
+// do not exist in the database
+type MissingTablesError struct {
+	TableNames []string
+}
+
+func (err *MissingTablesError) Error() string {
+	return fmt.Sprintf("gorp: missing tables %v", err.TableNames)
+}
+
+// QueryTimeoutError is returned if a statement is cancelled because it
+// ran longer than the timeout set with DbMap.SetQueryTimeout
+type QueryTimeoutError struct {
+	Query   string
+	Timeout time.Duration
+}
+
+func (err *QueryTimeoutError) Error() string {
+	return fmt.Sprintf("gorp: query timeout of %v exceeded: %s", err.Timeout, err.Query)
+}
+
+// StatementError is returned by ExecMulti for the statement which failed
+type StatementError struct {
+	Index     int // index of the statement in the list
+	Statement string
+	Err       error
+}
+
+func (err *StatementError) Error() string {
+	return fmt.Sprintf("gorp: statement %d failed: %s: %s", err.Index+1, err.Statement, err.Err.Error())
+}
+
+// MultipleRowsError is returned by SelectOne and SelectOneTo if the query
+// returns more than one row
+type MultipleRowsError struct {
+	Query string
+	Args  []interface{}
+}
+
+func (err *MultipleRowsError) Error() string {
+	return fmt.Sprintf("gorp: multiple rows returned for: %s - %v", err.Query, err.Args)
+}
+
+// ScanError is returned if a column of a result row can not be scanned
+// into its struct field
+type ScanError struct {
+	TableName  string // table name, or the type name of unmapped structs
+	ColumnName string
+	FieldName  string
+	Err        error
+}
+
+func (err *ScanError) Error() string {
+	return fmt.Sprintf("gorp: scan of column %s of table %s into field %s failed: %s", err.ColumnName, err.TableName, err.FieldName, err.Err.Error())
+}
+
+// Unwrap returns the error of the scan
+func (err *ScanError) Unwrap() error {
+	return err.Err
+}
+
+// DuplicateKeyError is returned by Insert, Update and their variants if
+// the row violates a primary key or unique constraint.  See IsDuplicateKey.
+type DuplicateKeyError struct {
+	TableName string
+	Err       error // the error of the driver
+}
+
+func (err *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("gorp: duplicate key in table %s: %s", err.TableName, err.Err.Error())
+}
+
+// Unwrap returns the error of the driver
+func (err *DuplicateKeyError) Unwrap() error {
+	return err.Err
+}
+
+// IsDuplicateKey returns true if err is a DuplicateKeyError or a driver
+// error of a primary key or unique constraint violation: SQLSTATE 23505 of
+// postgres, error 1062 of mysql, 2627 and 2601 of sql server, the
+// constraint errors of sqlite and ORA-00001 of oracle.
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	var dke *DuplicateKeyError
+	if errors.As(err, &dke) {
+		return true
+	}
+	if sqlState(err) == "23505" {
+		return true
+	}
+	if n, ok := errorInt(err, "Number"); ok && (n == 1062 || n == 2627 || n == 2601) {
+		return true
+	}
+	// sqlite reports SQLITE_CONSTRAINT_UNIQUE or _PRIMARYKEY as extended
+	// code, drivers without it only SQLITE_CONSTRAINT
+	if n, ok := errorInt(err, "ExtendedCode"); ok {
+		return n == 2067 || n == 1555
+	}
+	if n, ok := errorInt(err, "Code"); ok && n == 19 {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "ORA-00001")
+}
+
 // returns true if the error is non-fatal (ie, we shouldn't immediately return)
 func NonFatalError(err error) bool {
 	switch err.(type) {
@@ -24,3 +134,43 @@ func NonFatalError(err error) bool {
 		return false
 	}
 }
+
+// sqlState returns the SQLSTATE code of the driver error in the chain of
+// err, read from its SQLState() method or from a Code field of type string
+// like that of lib/pq errors, or ""
+func sqlState(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if s, ok := err.(interface{ SQLState() string }); ok {
+			return s.SQLState()
+		}
+		if f := errorField(err, "Code"); f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// errorInt returns the integer field name of the driver error in the chain
+// of err, like the Number of MySQL and SQL Server errors
+func errorInt(err error, name string) (int64, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		f := errorField(err, name)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return f.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(f.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+// errorField returns the field name of the struct err is or points to, or
+// the zero Value
+func errorField(err error, name string) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return v.FieldByName(name)
+}
@@ -23,15 +23,22 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -145,6 +152,14 @@ func (e OptimisticLockError) Error() string {
 //
 // Example use cases: Implement type converter to convert bool types to "y"/"n" strings,
 // or serialize a struct member as a JSON blob.
+//
+// Pointer fields are passed to a TypeConverter as they are first.  If it
+// does not handle them, ToDb is called with the value pointed to and
+// FromDb with a pointer to a new value of the pointed to type.  Nil
+// pointers are written as NULL and NULL columns scan into nil pointers.
+//
+// Fields of types with both driver.Valuer and sql.Scanner methods are not
+// passed to the TypeConverter, their Value and Scan methods are used.
 type TypeConverter interface {
 	// ToDb converts val to another type. Called before INSERT/UPDATE operations
 	ToDb(val interface{}) (interface{}, error)
@@ -159,6 +174,146 @@ type TypeConverter interface {
 	FromDb(target interface{}) (CustomScanner, bool)
 }
 
+// convertToDb calls conv.ToDb for val, dereferencing pointers conv does not
+// convert
+func convertToDb(conv TypeConverter, val interface{}) (interface{}, error) {
+	v, err := conv.ToDb(val)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Ptr || v != val {
+		return v, nil
+	}
+	if _, ok := val.(driver.Valuer); ok {
+		return v, nil
+	}
+	if rv.IsNil() {
+		return nil, nil
+	}
+	return conv.ToDb(rv.Elem().Interface())
+}
+
+// bindValue returns the argument bound for the field value val, which has
+// no converter: NULL for a nil pointer and the pointed to value for other
+// pointers, unless they are driver.Valuers themselves.  Drivers with their
+// own argument conversion do not all dereference pointers.
+func bindValue(val interface{}) interface{} {
+	rv := reflect.ValueOf(val)
+	for rv.Kind() == reflect.Ptr {
+		if _, ok := rv.Interface().(driver.Valuer); ok {
+			return rv.Interface()
+		}
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+// convertFromDb calls conv.FromDb for target, which points to a field.  If
+// conv does not handle a pointer field, the scanner for a new value of the
+// pointed to type is used and the field is set to that value unless the
+// column is NULL.
+func convertFromDb(conv TypeConverter, target interface{}) (CustomScanner, bool) {
+	scanner, ok := conv.FromDb(target)
+	if ok {
+		return scanner, true
+	}
+	tv := reflect.ValueOf(target)
+	if tv.Kind() != reflect.Ptr || tv.Elem().Kind() != reflect.Ptr {
+		return scanner, false
+	}
+	inner := reflect.New(tv.Elem().Type().Elem())
+	scanner, ok = conv.FromDb(inner.Interface())
+	if !ok {
+		return scanner, false
+	}
+	holder := &nullHolder{dest: scanner.Holder}
+	binder := func(_, target interface{}) error {
+		field := reflect.ValueOf(target).Elem()
+		if !holder.valid {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		err := scanner.Bind()
+		if err != nil {
+			return err
+		}
+		field.Set(inner)
+		return nil
+	}
+	return CustomScanner{holder, target, binder}, true
+}
+
+// nullAsZeroScanner returns a CustomScanner which reads a column into the
+// field target points to, or sets the field to its zero value if the column
+// is NULL, see DbMap.NullAsZero
+func nullAsZeroScanner(target interface{}) CustomScanner {
+	holder := reflect.New(reflect.TypeOf(target))
+	binder := func(holder, target interface{}) error {
+		field := reflect.ValueOf(target).Elem()
+		if v := reflect.ValueOf(holder).Elem(); v.IsNil() {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(v.Elem())
+		}
+		return nil
+	}
+	return CustomScanner{holder.Interface(), target, binder}
+}
+
+// zeroOnNull wraps scanner so a NULL column sets the field to its zero
+// value instead of being bound
+func zeroOnNull(scanner CustomScanner) CustomScanner {
+	holder := &nullHolder{dest: scanner.Holder}
+	binder := func(_, target interface{}) error {
+		if !holder.valid {
+			field := reflect.ValueOf(target).Elem()
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		return scanner.Bind()
+	}
+	return CustomScanner{holder, scanner.Target, binder}
+}
+
+// nullHolder scans a column that may be NULL into dest
+type nullHolder struct {
+	dest  interface{}
+	valid bool
+}
+
+// Scan implements the sql.Scanner interface
+func (h *nullHolder) Scan(src interface{}) error {
+	h.valid = src != nil
+	if !h.valid {
+		return nil
+	}
+	if s, ok := h.dest.(sql.Scanner); ok {
+		return s.Scan(src)
+	}
+	if b, ok := src.([]byte); ok {
+		// the driver may reuse b
+		src = append([]byte(nil), b...)
+	}
+	dv := reflect.ValueOf(h.dest).Elem()
+	sv := reflect.ValueOf(src)
+	switch {
+	case dv.Kind() == reflect.String && sv.Kind() != reflect.String && sv.Type() != bytesType:
+		dv.SetString(fmt.Sprint(src))
+	case sv.Type().ConvertibleTo(dv.Type()):
+		dv.Set(sv.Convert(dv.Type()))
+	default:
+		return fmt.Errorf("gorp: can not scan %T into %T", src, h.dest)
+	}
+	return nil
+}
+
 // CustomScanner binds a database column value to a Go type
 type CustomScanner struct {
 	// After a row is scanned, Holder will contain the value from the database column.
@@ -179,748 +334,3146 @@ func (me CustomScanner) Bind() error {
 	return me.Binder(me.Holder, me.Target)
 }
 
-// DbMap is the root gorp mapping object. Create one of these for each
-// database schema you wish to map.  Each DbMap contains a list of
-// mapped tables.
+// ColumnHolders is used as CustomScanner.Holder to scan several adjacent
+// columns into one struct field, e.g. the longitude and latitude of a point.
+// When selecting into structs, the CustomScanner of the field claims the
+// column mapped to it and the len(ColumnHolders)-1 columns following it,
+// which must not be mapped to other fields.  Each column is scanned into the
+// holder at the same position, then Binder is called once with the
+// ColumnHolders.  The number of holders must be the same for every row.
 //
 // Example:
 //
-//     dialect := gorp.MySQLDialect{"InnoDB", "UTF8"}
-//     dbmap := &gorp.DbMap{Db: db, Dialect: dialect}
+//     func (c PointConverter) FromDb(target interface{}) (gorp.CustomScanner, bool) {
+//         if _, ok := target.(*Point); !ok {
+//             return gorp.CustomScanner{}, false
+//         }
+//         binder := func(holder, target interface{}) error {
+//             h := holder.(gorp.ColumnHolders)
+//             *target.(*Point) = Point{*h[0].(*float64), *h[1].(*float64)}
+//             return nil
+//         }
+//         return gorp.CustomScanner{gorp.ColumnHolders{new(float64), new(float64)}, target, binder}, true
+//     }
 //
-type DbMap struct {
-	// Db handle to use with this map
-	Db *sql.DB
-
-	// Dialect implementation to use with this map
-	Dialect Dialect
-
-	TypeConverter TypeConverter
-
-	tables    []*TableMap
-	logger    GorpLogger
-	logPrefix string
-
-	DebugLevel        int
-	LastOpInfo        CRUDInfo // info about the last operation on this database
-	CheckAffectedRows bool     // if true an error is raised if affected rows was 0
+//     // lat and lng are both read into Pos
+//     var places []Place
+//     _, err := dbmap.Select(&places, "select id, lat as pos, lng from place")
+//
+// Pointer fields are not wrapped automatically, their converter has to
+// handle the pointer target itself.
+type ColumnHolders []interface{}
+
+// RatConverter is the built-in column converter registered as "rat".  It
+// stores big.Rat and *big.Rat fields as exact decimal strings and scans
+// numeric columns back without the precision loss of float64.  Select it
+// with the "scan" tag option:
+//
+//     Amount big.Rat `db:"amount, type:numeric(20,4), scan:rat"`
+//
+type RatConverter struct{}
+
+// ToDb converts big.Rat values to decimal strings
+func (RatConverter) ToDb(val interface{}) (interface{}, error) {
+	switch r := val.(type) {
+	case big.Rat:
+		return ratToDecimal(&r)
+	case *big.Rat:
+		if r == nil {
+			return nil, nil
+		}
+		return ratToDecimal(r)
+	}
+	return val, nil
+}
+
+// FromDb returns a CustomScanner which reads the column as string and
+// parses it into a big.Rat
+func (RatConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	switch target.(type) {
+	case *big.Rat, **big.Rat:
+	default:
+		return CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		s := holder.(*sql.NullString)
+		var r *big.Rat
+		if s.Valid {
+			var ok bool
+			r, ok = new(big.Rat).SetString(s.String)
+			if !ok {
+				return fmt.Errorf("gorp: can not scan %q into big.Rat", s.String)
+			}
+		}
+		switch t := target.(type) {
+		case *big.Rat:
+			if r == nil {
+				r = new(big.Rat)
+			}
+			t.Set(r)
+		case **big.Rat:
+			*t = r
+		}
+		return nil
+	}
+	return CustomScanner{new(sql.NullString), target, binder}, true
 }
 
-// TableMap represents a mapping between a Go struct and a database table
-// Use dbmap.AddTable() or dbmap.AddTableWithName() to create these
-type TableMap struct {
-	// Name of database table.
-	TableName      string
-	SchemaName     string
-	gotype         reflect.Type
-	Columns        []*ColumnMap
-	Indexes        []*IndexMap    // list of indexes for this table
-	Relations      []*RelationMap // list of detail/child tables for this table
-	keys           []*ColumnMap
-	uniqueTogether [][]string
-	version        *ColumnMap
-	insertPlan     bindPlan
-	updatePlan     bindPlan
-	deletePlan     bindPlan
-	getPlan        bindPlan
-	dbmap          *DbMap
+// EpochConverter is the built-in column converter registered as
+// "epoch_seconds" and, with Millis set, as "epoch_millis".  It stores
+// time.Time and *time.Time fields as integer Unix time and scans them back
+// as UTC.  Select it with the "type" tag option, which also creates the
+// column as bigint:
+//
+//     Created time.Time `db:"created, type:epoch_millis"`
+//
+type EpochConverter struct {
+	Millis bool // if true, milliseconds instead of seconds are stored
 }
 
-func (t TableMap) String() string {
-	var s string
-	s = "TableName: " + t.TableName + "\n"
-	s = s + "Type: " + t.gotype.Name() + "\n"
-	for _, c := range t.Columns {
-		s = s + "Column: " + c.ColumnName + "\n"
+// ToDb converts time.Time values to Unix time
+func (c EpochConverter) ToDb(val interface{}) (interface{}, error) {
+	if t, ok := val.(time.Time); ok {
+		if c.Millis {
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+		return t.Unix(), nil
 	}
-	return s
+	return val, nil
 }
 
-// RelationMap represents a mapping between a master table and a detail table
-// Use tablemap.AddRelation() or field tag `db:"relation:<foreignkey field in detail table>"`to create these
-// Example:	Comments  []*Comment `db:"relation:PostId"`
-type RelationMap struct {
-	DetailTable         *TableMap
-	ForeignKeyFieldName string
-	DetailTableType     interface{}
-	MasterFieldName     string
-	Limit               uint64 // Limits the number of rows a child query returns
-	Offset              uint64 // Starting at row offset when queriyng a child table
+// FromDb returns a CustomScanner which reads the column as integer and
+// converts it to time.Time
+func (c EpochConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	if _, ok := target.(*time.Time); !ok {
+		return CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		n := holder.(*sql.NullInt64)
+		t := target.(*time.Time)
+		switch {
+		case !n.Valid:
+			*t = time.Time{}
+		case c.Millis:
+			*t = time.Unix(n.Int64/1000, n.Int64%1000*int64(time.Millisecond)).UTC()
+		default:
+			*t = time.Unix(n.Int64, 0).UTC()
+		}
+		return nil
+	}
+	return CustomScanner{new(sql.NullInt64), target, binder}, true
 }
 
-func (r RelationMap) String() string {
-	var s string
-	s = "TableMap: " + r.DetailTable.String() + "\n"
-	s = s + "ForeignKeyFieldName: " + r.ForeignKeyFieldName + "\n"
-	s = s + "DetailTableType: " + reflect.TypeOf(r.DetailTableType).Name() + "\n"
-	s = s + "MasterFieldName: " + r.MasterFieldName
-	return s
-}
+// DateConverter is the built-in column converter of time.Time and
+// *time.Time fields with the "type:date" tag option, which are created as
+// date columns:
+//
+//     Birthday time.Time `db:"birthday, type:date"`
+//
+// It writes the calendar date of the time in its location as text like
+// "2006-01-02", so neither time of day nor time zone reach the database,
+// and scans dates as midnight UTC.
+type DateConverter struct{}
 
-// CRUDInfo contains info about a CRUD operation
-type CRUDInfo struct {
-	Type                CRUDType
-	BindPlanUsed        *bindPlan
-	RowCount            int64
-	ChildUpdateRowCount int64
-	ChildInsertRowCount int64
+const dateLayout = "2006-01-02"
+
+// ToDb converts time.Time values to their date
+func (c DateConverter) ToDb(val interface{}) (interface{}, error) {
+	if t, ok := val.(time.Time); ok {
+		return t.Format(dateLayout), nil
+	}
+	return val, nil
 }
 
-// Resets all field to its zero value
-func (i *CRUDInfo) Reset() {
-	i.Type = Unknown
-	i.BindPlanUsed = nil
-	i.RowCount = 0
-	i.ChildUpdateRowCount = 0
-	i.ChildInsertRowCount = 0
+// FromDb returns a CustomScanner which reads the date of the column into
+// a time.Time
+func (c DateConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	if _, ok := target.(*time.Time); !ok {
+		return CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		*target.(*time.Time) = holder.(*dateHolder).t
+		return nil
+	}
+	return CustomScanner{new(dateHolder), target, binder}, true
 }
 
-type CRUDType int
+// dateHolder scans a date, which drivers return as time.Time or as text,
+// into a time.Time at midnight UTC.  NULL scans into the zero time.
+type dateHolder struct {
+	t time.Time
+}
 
-const (
-	Unknown CRUDType = iota
-	Insert
-	Select
-	Update
-	Delete
-)
+// Scan implements the sql.Scanner interface
+func (h *dateHolder) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		h.t = time.Time{}
+	case time.Time:
+		h.t = time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC)
+	case []byte:
+		return h.parse(string(v))
+	case string:
+		return h.parse(v)
+	default:
+		return fmt.Errorf("gorp: can not scan %T into a date", src)
+	}
+	return nil
+}
 
-func (t CRUDType) String() string {
-	var s string
-	if t&Insert == Insert {
-		s = "Insert"
-	} else if t&Select == Select {
-		s = "Select"
-	} else if t&Update == Update {
-		s = "Update"
-	} else if t&Delete == Delete {
-		s = "Delete"
-	} else {
-		s = "Unknown"
+// parse reads the date at the start of s, e.g. of "2006-01-02 00:00:00"
+func (h *dateHolder) parse(s string) error {
+	if len(s) > len(dateLayout) {
+		s = s[:len(dateLayout)]
 	}
-	return s
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return fmt.Errorf("gorp: can not scan %q into a date", s)
+	}
+	h.t = t
+	return nil
 }
 
-// ResetSql removes cached insert/update/select/delete SQL strings
-// associated with this TableMap.  Call this if you've modified
-// any column names or the table name itself.
-func (t *TableMap) ResetSql() {
-	t.insertPlan = bindPlan{}
-	t.updatePlan = bindPlan{}
-	t.deletePlan = bindPlan{}
-	t.getPlan = bindPlan{}
+// isEpochType returns true if dbType selects an EpochConverter
+func isEpochType(dbType string) bool {
+	return dbType == "epoch_seconds" || dbType == "epoch_millis"
 }
 
-// SetKeys lets you specify the fields on a struct that map to primary
-// key columns on the table.  If isAutoIncr is set, result.LastInsertId()
-// will be used after INSERT to bind the generated id to the Go struct.
-//
-// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+// BitConverter is the column converter of fields with the "type:bit" tag
+// option, which are created as bit string columns of size bits on dialects
+// implementing BitDialect:
 //
-// Panics if isAutoIncr is true, and fieldNames length != 1
+//     Flags uint8 `db:"flags, type:bit, size:8"`
 //
-func (t *TableMap) SetKeys(isAutoIncr bool, fieldNames ...string) *TableMap {
-	if isAutoIncr && len(fieldNames) != 1 {
-		panic(fmt.Sprintf(
-			"gorp: SetKeys: fieldNames length must be 1 if key is auto-increment. (Saw %v fieldNames)",
-			len(fieldNames)))
+// Unsigned integer fields hold the bits as number, []byte fields hold them
+// right aligned in big endian order.  On other dialects the tag option is
+// ignored and the field is stored with its usual column type.
+type BitConverter struct {
+	Size   int  // number of bits of the column
+	String bool // if true, bits are passed as string of 0 and 1, see BitDialect
+}
+
+// ToDb converts unsigned integers and []byte to the bit representation of
+// the column
+func (c BitConverter) ToDb(val interface{}) (interface{}, error) {
+	var b []byte
+	v := reflect.ValueOf(val)
+	switch {
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v.Uint())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.IsNil() {
+			return nil, nil
+		}
+		b = v.Bytes()
+	default:
+		return val, nil
+	}
+	bits := bytesToBits(b)
+	if len(bits) > c.Size {
+		if strings.Contains(bits[:len(bits)-c.Size], "1") {
+			return nil, fmt.Errorf("gorp: value %v exceeds %d bits", val, c.Size)
+		}
+		bits = bits[len(bits)-c.Size:]
+	} else {
+		bits = strings.Repeat("0", c.Size-len(bits)) + bits
 	}
-	t.keys = make([]*ColumnMap, 0)
-	for _, name := range fieldNames {
-		colmap := t.ColMap(name)
-		colmap.isPK = true
-		colmap.isAutoIncr = isAutoIncr
-		t.keys = append(t.keys, colmap)
+	if c.String {
+		return bits, nil
 	}
-	t.ResetSql()
-
-	return t
+	return bitsToBytes(bits)
 }
 
-// SetUniqueTogether lets you specify uniqueness constraints across multiple
-// columns on the table. Each call adds an additional constraint for the
-// specified columns.
-//
-// Automatically calls ResetSql() to ensure SQL statements are regenerated.
-//
-// Panics if fieldNames length < 2.
-//
-func (t *TableMap) SetUniqueTogether(fieldNames ...string) *TableMap {
-	if len(fieldNames) < 2 {
-		panic(fmt.Sprintf(
-			"gorp: SetUniqueTogether: must provide at least two fieldNames to set uniqueness constraint."))
+// FromDb returns a CustomScanner which converts the bit representation of
+// the column to unsigned integers and []byte
+func (c BitConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	tv := reflect.ValueOf(target)
+	if tv.Kind() != reflect.Ptr {
+		return CustomScanner{}, false
 	}
-
-	columns := make([]string, 0)
-	for _, name := range fieldNames {
-		columns = append(columns, name)
+	switch k := tv.Elem().Kind(); {
+	case k >= reflect.Uint && k <= reflect.Uint64:
+	case k == reflect.Slice && tv.Elem().Type().Elem().Kind() == reflect.Uint8:
+	default:
+		return CustomScanner{}, false
 	}
-	t.uniqueTogether = append(t.uniqueTogether, columns)
-	t.ResetSql()
-
-	return t
+	binder := func(holder, target interface{}) error {
+		b := *holder.(*[]byte)
+		if c.String && b != nil {
+			var err error
+			if b, err = bitsToBytes(string(b)); err != nil {
+				return err
+			}
+		}
+		v := reflect.ValueOf(target).Elem()
+		if v.Kind() == reflect.Slice {
+			v.SetBytes(b)
+			return nil
+		}
+		var n uint64
+		for _, x := range b {
+			if n>>56 != 0 {
+				return fmt.Errorf("gorp: bit value %x overflows %v", b, v.Type())
+			}
+			n = n<<8 | uint64(x)
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("gorp: bit value %x overflows %v", b, v.Type())
+		}
+		v.SetUint(n)
+		return nil
+	}
+	return CustomScanner{new([]byte), target, binder}, true
 }
 
-// ColMap returns the ColumnMap pointer matching the given struct field
-// name.  It panics if the struct does not contain a field matching this
-// name.
-func (t *TableMap) ColMap(field string) *ColumnMap {
-	col := colMapOrNil(t, field)
-	if col == nil {
-		e := fmt.Sprintf("No ColumnMap in table %s type %s with field %s",
-			t.TableName, t.gotype.Name(), field)
-
-		panic(e)
+// bytesToBits returns the bits of b as string of 0 and 1
+func bytesToBits(b []byte) string {
+	bits := make([]byte, 0, len(b)*8)
+	for _, x := range b {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, '0'+x>>uint(i)&1)
+		}
 	}
-	return col
+	return string(bits)
 }
 
-func colMapOrNil(t *TableMap, field string) *ColumnMap {
-	for _, col := range t.Columns {
-		if strings.ToLower(col.fieldName) == strings.ToLower(field) || strings.ToLower(col.ColumnName) == strings.ToLower(field) {
-			// Ignore ignored Columns, "-" is the indentifier for columns which should be ignored
-			//if col.ColumnName != "-" { // old code, depending on a hardcoded string is avoided now
-			if col.Transient == false {
-				return col
-			}
+// bitsToBytes packs a string of 0 and 1 right aligned into bytes
+func bitsToBytes(bits string) ([]byte, error) {
+	b := make([]byte, (len(bits)+7)/8)
+	pad := len(b)*8 - len(bits)
+	for i, r := range bits {
+		switch r {
+		case '1':
+			j := pad + i
+			b[j/8] |= 1 << uint(7-j%8)
+		case '0':
+		default:
+			return nil, fmt.Errorf("gorp: invalid bit string %q", bits)
 		}
 	}
-	return nil
+	return b, nil
 }
 
-// SetVersionCol sets the column to use as the Version field.  By default
-// the "Version" field is used.  Returns the column found, or panics
-// if the struct does not contain a field matching this name.
-//
-// Automatically calls ResetSql() to ensure SQL statements are regenerated.
-func (t *TableMap) SetVersionCol(field string) *ColumnMap {
-	c := t.ColMap(field)
-	t.version = c
-	t.ResetSql()
-	return c
+// NetConverter is the built-in column converter of net.IP and
+// net.HardwareAddr fields, registered as "inet" and "macaddr".  With Text
+// set, which is used for the inet and macaddr columns of Postgres, values
+// are passed in their string form, otherwise as bytes.  IPv4 addresses are
+// stored and scanned back in their 4 byte form.
+type NetConverter struct {
+	Text bool // if true, values are passed as strings
 }
 
-// SqlForCreateTable gets a sequence of SQL commands that will create
-// the specified table and any associated schema
-func (t *TableMap) SqlForCreate(ifNotExists bool) string {
-	s := bytes.Buffer{}
-	dialect := t.dbmap.Dialect
-
-	if strings.TrimSpace(t.SchemaName) != "" {
-		schemaCreate := "create schema"
-		if ifNotExists {
-			s.WriteString(dialect.IfSchemaNotExists(schemaCreate, t.SchemaName))
-		} else {
-			s.WriteString(schemaCreate)
+// ToDb converts net.IP and net.HardwareAddr values
+func (c NetConverter) ToDb(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case net.IP:
+		if v == nil {
+			return nil, nil
 		}
-		s.WriteString(fmt.Sprintf(" %s;", t.SchemaName))
-	}
-
-	tableCreate := "create table"
-	if ifNotExists {
-		s.WriteString(dialect.IfTableNotExists(tableCreate, t.SchemaName, t.TableName))
-	} else {
-		s.WriteString(tableCreate)
-	}
-	s.WriteString(fmt.Sprintf(" %s (", dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
-
-	x := 0
-	for _, col := range t.Columns {
-		if !col.Transient {
-			if x > 0 {
-				s.WriteString(", ")
-			}
-			stype := dialect.ToSqlType(col.gotype, col.MaxSize, col.isAutoIncr)
-			s.WriteString(fmt.Sprintf("%s %s", dialect.QuoteField(col.ColumnName), stype))
-
-			if col.isPK || col.isNotNull {
-				s.WriteString(" not null")
+		if c.Text {
+			return v.String(), nil
+		}
+		if v4 := v.To4(); v4 != nil {
+			return []byte(v4), nil
+		}
+		return []byte(v), nil
+	case net.HardwareAddr:
+		if v == nil {
+			return nil, nil
+		}
+		if c.Text {
+			return v.String(), nil
+		}
+		return []byte(v), nil
+	}
+	return val, nil
+}
+
+// FromDb returns a CustomScanner which converts the column to net.IP or
+// net.HardwareAddr
+func (c NetConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	switch target.(type) {
+	case *net.IP, *net.HardwareAddr:
+	default:
+		return CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		b := *holder.(*[]byte)
+		switch t := target.(type) {
+		case *net.IP:
+			*t = nil
+			if b == nil {
+				return nil
 			}
-			if col.isPK && len(t.keys) == 1 {
-				s.WriteString(" primary key")
+			ip := net.IP(b)
+			if c.Text {
+				s := string(b)
+				if i := strings.IndexByte(s, '/'); i >= 0 {
+					// inet values with a netmask
+					s = s[:i]
+				}
+				if ip = net.ParseIP(s); ip == nil {
+					return fmt.Errorf("gorp: invalid IP address %q", b)
+				}
 			}
-			if col.Unique {
-				s.WriteString(" unique")
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
 			}
-			if col.isAutoIncr {
-				s.WriteString(fmt.Sprintf(" %s", dialect.AutoIncrStr()))
+			*t = ip
+		case *net.HardwareAddr:
+			*t = nil
+			if b == nil {
+				return nil
 			}
-
-			x++
-		}
-	}
-	if len(t.keys) > 1 {
-		s.WriteString(", primary key (")
-		for x := range t.keys {
-			if x > 0 {
-				s.WriteString(", ")
+			if !c.Text {
+				*t = net.HardwareAddr(b)
+				return nil
 			}
-			s.WriteString(dialect.QuoteField(t.keys[x].ColumnName))
-		}
-		s.WriteString(")")
-	}
-	if len(t.uniqueTogether) > 0 {
-		for _, columns := range t.uniqueTogether {
-			s.WriteString(", unique (")
-			for i, column := range columns {
-				if i > 0 {
-					s.WriteString(", ")
-				}
-				s.WriteString(dialect.QuoteField(column))
+			mac, err := net.ParseMAC(string(b))
+			if err != nil {
+				return err
 			}
-			s.WriteString(")")
+			*t = mac
 		}
+		return nil
 	}
-	s.WriteString(") ")
-	s.WriteString(dialect.CreateTableSuffix())
-	s.WriteString(dialect.QuerySuffix())
-	return s.String()
+	return CustomScanner{new([]byte), target, binder}, true
 }
 
-type bindPlan struct {
-	query             string
-	argFields         []string
-	keyFields         []string
-	versField         string
-	autoIncrIdx       int
-	autoIncrFieldName string
-}
+// jsonConverter stores values as JSON text, see DbMap.AutoJSON
+type jsonConverter struct{}
 
-func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter) (bindInstance, error) {
-	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField}
-	if plan.versField != "" {
-		bi.existingVersion = elem.FieldByName(plan.versField).Int()
+// ToDb marshals val to JSON, nil maps and slices are written as NULL
+func (jsonConverter) ToDb(val interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(val)
+	if (rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil, nil
 	}
-
-	var err error
-
-	for i := 0; i < len(plan.argFields); i++ {
-		k := plan.argFields[i]
-		if k == versFieldConst {
-			newVer := bi.existingVersion + 1
-			bi.args = append(bi.args, newVer)
-			if bi.existingVersion == 0 {
-				elem.FieldByName(plan.versField).SetInt(int64(newVer))
-			}
-		} else {
-			val := elem.FieldByName(k).Interface()
-			if conv != nil {
-				val, err = conv.ToDb(val)
-				if err != nil {
-					return bindInstance{}, err
-				}
-			}
-			bi.args = append(bi.args, val)
-		}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
 	}
+	return string(b), nil
+}
 
-	for i := 0; i < len(plan.keyFields); i++ {
-		k := plan.keyFields[i]
-		val := elem.FieldByName(k).Interface()
-		if conv != nil {
-			val, err = conv.ToDb(val)
-			if err != nil {
-				return bindInstance{}, err
-			}
+// FromDb returns a CustomScanner which unmarshals the JSON text of the
+// column into target
+func (jsonConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	binder := func(holder, target interface{}) error {
+		s := holder.(*sql.NullString)
+		v := reflect.ValueOf(target).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		if !s.Valid {
+			return nil
 		}
-		bi.keys = append(bi.keys, val)
+		return json.Unmarshal([]byte(s.String), target)
 	}
-
-	return bi, nil
-}
-
-type bindInstance struct {
-	query             string
-	args              []interface{}
-	keys              []interface{}
-	existingVersion   int64
-	versField         string
-	autoIncrIdx       int
-	autoIncrFieldName string
+	return CustomScanner{new(sql.NullString), target, binder}, true
 }
 
-func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
-	plan := t.insertPlan
-	if plan.query == "" {
-		plan.autoIncrIdx = -1
+// valuerConverter is used for fields whose type has driver.Valuer and
+// sql.Scanner methods, which take precedence over the TypeConverter of the
+// DbMap.  ToDb calls Value, FromDb leaves the scan to the Scan method.
+type valuerConverter struct{}
 
-		s := bytes.Buffer{}
-		s2 := bytes.Buffer{}
-		s.WriteString(fmt.Sprintf("insert into %s (", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+// ToDb returns the result of the Value method of val, nil pointers are
+// written as NULL
+func (valuerConverter) ToDb(val interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, nil
+	}
+	if v, ok := val.(driver.Valuer); ok {
+		return v.Value()
+	}
+	return val, nil
+}
 
-		x := 0
-		first := true
-		for y := range t.Columns {
-			col := t.Columns[y]
-			if !(col.isAutoIncr && t.dbmap.Dialect.AutoIncrBindValue() == "") {
-				if !col.Transient {
-					if !first {
-						s.WriteString(",")
-						s2.WriteString(",")
-					}
-					s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+func (valuerConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	return CustomScanner{}, false
+}
 
-					if col.isAutoIncr {
-						s2.WriteString(t.dbmap.Dialect.AutoIncrBindValue())
-						plan.autoIncrIdx = y
-						plan.autoIncrFieldName = col.fieldName
-					} else {
-						if col.DefaultValue == "" {
-							s2.WriteString(t.dbmap.Dialect.BindVar(x))
-							if col == t.version {
-								plan.versField = col.fieldName
-								plan.argFields = append(plan.argFields, versFieldConst)
-							} else {
-								plan.argFields = append(plan.argFields, col.fieldName)
-							}
-							x++
-						} else {
+// cryptoConverter stores the values of a column encrypted, see
+// ColumnMap.SetCryptoTransform
+type cryptoConverter struct {
+	encrypt func([]byte) ([]byte, error)
+	decrypt func([]byte) ([]byte, error)
+}
 
-							// Check if this column is a NOT NULL
-							if err := checkForNotNull(elem, col, t); err != nil {
-								return bindInstance{}, err
-							}
-							plan.argFields = append(plan.argFields, col.fieldName)
-							s2.WriteString(col.DefaultValue)
-						}
-					}
-					first = false
-				}
-			} else {
-				plan.autoIncrIdx = y
-				plan.autoIncrFieldName = col.fieldName
-			}
+// ToDb encrypts string and []byte values, and the string or []byte value
+// of a driver.Valuer.  nil is written as NULL.
+func (c cryptoConverter) ToDb(val interface{}) (interface{}, error) {
+	var err error
+	if v, ok := val.(driver.Valuer); ok {
+		if val, err = v.Value(); err != nil {
+			return nil, err
 		}
-		s.WriteString(") values (")
-		s.WriteString(s2.String())
-		s.WriteString(")")
-		if plan.autoIncrIdx > -1 {
-			s.WriteString(t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx]))
+	}
+	var plain []byte
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		plain = []byte(v)
+	case []byte:
+		if v == nil {
+			return nil, nil
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
-
-		plan.query = s.String()
-		t.insertPlan = plan
+		plain = v
+	default:
+		return nil, fmt.Errorf("gorp: cannot encrypt value of type %T", val)
+	}
+	return c.encrypt(plain)
+}
+
+// FromDb decrypts the column into string and []byte fields, or passes
+// the decrypted bytes to the Scan method of the field
+func (c cryptoConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	binder := func(holder, target interface{}) error {
+		cipher := *holder.(*[]byte)
+		field := reflect.ValueOf(target).Elem()
+		if cipher == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		plain, err := c.decrypt(cipher)
+		if err != nil {
+			return err
+		}
+		if sc, ok := target.(sql.Scanner); ok {
+			return sc.Scan(plain)
+		}
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(string(plain))
+		case field.Type() == bytesType:
+			field.SetBytes(plain)
+		default:
+			return fmt.Errorf("gorp: cannot decrypt into %s", field.Type())
+		}
+		return nil
 	}
-
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return CustomScanner{new([]byte), target, binder}, true
 }
 
-func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
-	plan := t.updatePlan
-	if plan.query == "" {
-
-		s := bytes.Buffer{}
-		s.WriteString(fmt.Sprintf("update %s set ", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
-		x := 0
+// sentinelConverter reads the sentinel value of a column as the zero value
+// of the field and writes the zero value as the sentinel, see
+// ColumnMap.SetNullSentinel.  conv is the converter of the column without
+// the sentinel, or nil.
+type sentinelConverter struct {
+	sentinel interface{}
+	conv     TypeConverter
+}
 
-		for y := range t.Columns {
-			col := t.Columns[y]
-			if !col.isAutoIncr && !col.Transient {
-				if x > 0 {
-					s.WriteString(", ")
-				}
-				s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
-				s.WriteString("=")
-				s.WriteString(t.dbmap.Dialect.BindVar(x))
+// ToDb returns the sentinel for nil and zero values, else the value
+// converted by conv
+func (c sentinelConverter) ToDb(val interface{}) (interface{}, error) {
+	if val == nil || reflect.ValueOf(val).IsZero() {
+		return c.sentinel, nil
+	}
+	if c.conv == nil {
+		return val, nil
+	}
+	return c.conv.ToDb(val)
+}
 
-				if col == t.version {
-					plan.versField = col.fieldName
-					plan.argFields = append(plan.argFields, versFieldConst)
-				} else {
-					// Check if this column is a NOT NULL
-					if err := checkForNotNull(elem, col, t); err != nil {
-						return bindInstance{}, err
-					}
-					plan.argFields = append(plan.argFields, col.fieldName)
-				}
-				x++
-			}
+// FromDb scans the column with the scanner of conv, or directly into
+// target, and then sets the field to its zero value if it holds the
+// sentinel
+func (c sentinelConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	scanner := CustomScanner{target, target, func(holder, target interface{}) error { return nil }}
+	if c.conv != nil {
+		if s, ok := convertFromDb(c.conv, target); ok {
+			scanner = s
 		}
-
-		s.WriteString(" where ")
-		for y := range t.keys {
-			col := t.keys[y]
-			if y > 0 {
-				s.WriteString(" and ")
+	}
+	bind := scanner.Binder
+	scanner.Binder = func(holder, target interface{}) error {
+		if err := bind(holder, target); err != nil {
+			return err
+		}
+		field := reflect.ValueOf(target).Elem()
+		v := field
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
 			}
-			s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(x))
-
-			plan.argFields = append(plan.argFields, col.fieldName)
-			plan.keyFields = append(plan.keyFields, col.fieldName)
-			x++
+			v = v.Elem()
 		}
-		if plan.versField != "" {
-			s.WriteString(" and ")
-			s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(x))
-			plan.argFields = append(plan.argFields, plan.versField)
+		if isSentinel(v, c.sentinel) {
+			field.Set(reflect.Zero(field.Type()))
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+		return nil
+	}
+	return scanner, true
+}
 
-		plan.query = s.String()
-		t.updatePlan = plan
+// sentinelFits tells if sentinel can be compared with values of type t
+func sentinelFits(sentinel interface{}, t reflect.Type) bool {
+	st := reflect.TypeOf(sentinel)
+	if st == timeType || t == timeType {
+		return st == t
 	}
+	return st.ConvertibleTo(t) && (st.Kind() == reflect.String) == (t.Kind() == reflect.String)
+}
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+// isSentinel tells if v holds the value of sentinel, times are compared
+// with time.Time.Equal
+func isSentinel(v reflect.Value, sentinel interface{}) bool {
+	if !sentinelFits(sentinel, v.Type()) {
+		return false
+	}
+	if t, ok := sentinel.(time.Time); ok {
+		return t.Equal(v.Interface().(time.Time))
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.ValueOf(sentinel).Convert(v.Type()).Interface())
 }
 
-func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
-	plan := t.deletePlan
-	if plan.query == "" {
+// selfConverting tells if values of type t, or the type t points to,
+// convert themselves with driver.Valuer and sql.Scanner methods
+func selfConverting(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Implements(valuerType) && reflect.PtrTo(t).Implements(scannerType)
+}
 
-		s := bytes.Buffer{}
-		s.WriteString(fmt.Sprintf("delete from %s", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+// CompositeScanner returns a CustomScanner which reads a Postgres
+// composite (row) value into the struct target points to.  fields names
+// the struct fields in the order of the attributes of the composite type,
+// if it is empty all exported fields are used in declaration order.
+//
+// Example:
+//
+//     var addr Address
+//     s := gorp.CompositeScanner(&addr, "Street", "City", "Zip")
+//     err := dbmap.Db.QueryRow("select address_of($1)", id).Scan(s.Holder)
+//     if err == nil {
+//         err = s.Bind()
+//     }
+//
+func CompositeScanner(target interface{}, fields ...string) CustomScanner {
+	binder := func(holder, target interface{}) error {
+		s := holder.(*sql.NullString)
+		v := reflect.ValueOf(target).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		if !s.Valid {
+			return nil
+		}
+		values, err := ParseComposite(s.String)
+		if err != nil {
+			return err
+		}
+		names := fields
+		if len(names) == 0 {
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).PkgPath == "" {
+					names = append(names, v.Type().Field(i).Name)
+				}
+			}
+		}
+		if len(names) != len(values) {
+			return fmt.Errorf("gorp: composite %s has %d attributes, but %d fields are given", s.String, len(values), len(names))
+		}
+		for i, name := range names {
+			f := fieldByPath(v, name)
+			if !f.IsValid() {
+				return fmt.Errorf("gorp: no field %s in type %s", name, v.Type())
+			}
+			if !values[i].Valid {
+				continue
+			}
+			err = setFromString(f, values[i].String)
+			if err != nil {
+				return fmt.Errorf("gorp: composite attribute %s: %s", name, err.Error())
+			}
+		}
+		return nil
+	}
+	return CustomScanner{new(sql.NullString), target, binder}
+}
+
+// ParseComposite splits the text of a Postgres composite value like
+// (1,"a,b",,"") into its attributes.  Attributes without any characters
+// are NULL, quoted attributes may contain commas, doubled quotes and
+// backslash escapes.
+func ParseComposite(s string) ([]sql.NullString, error) {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return nil, fmt.Errorf("gorp: invalid composite value %q", s)
+	}
+	body := s[1 : len(s)-1]
+
+	var values []sql.NullString
+	b := bytes.Buffer{}
+	valid := false
+	inQuotes := false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(body) {
+				return nil, fmt.Errorf("gorp: invalid composite value %q", s)
+			}
+			b.WriteByte(body[i])
+			valid = true
+		case inQuotes && c == '"':
+			if i+1 < len(body) && body[i+1] == '"' {
+				b.WriteByte('"')
+				i++
+			} else {
+				inQuotes = false
+			}
+		case inQuotes:
+			b.WriteByte(c)
+		case c == '"':
+			inQuotes = true
+			valid = true
+		case c == ',':
+			values = append(values, sql.NullString{String: b.String(), Valid: valid})
+			b.Reset()
+			valid = false
+		default:
+			b.WriteByte(c)
+			valid = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("gorp: unterminated quote in composite value %q", s)
+	}
+	values = append(values, sql.NullString{String: b.String(), Valid: valid})
+	return values, nil
+}
+
+// setFromString sets f to the value of the text representation s
+func setFromString(f reflect.Value, s string) error {
+	if sc, ok := f.Addr().Interface().(sql.Scanner); ok {
+		return sc.Scan(s)
+	}
+	switch f.Kind() {
+	case reflect.Ptr:
+		v := reflect.New(f.Type().Elem())
+		err := setFromString(v.Elem(), s)
+		if err != nil {
+			return err
+		}
+		f.Set(v)
+		return nil
+	case reflect.String:
+		f.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+		return nil
+	}
+	if f.Type() == timeType {
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999-07", "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				f.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("can not parse time %q", s)
+	}
+	if f.Type() == bytesType {
+		f.SetBytes([]byte(s))
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", f.Type())
+}
+
+var (
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	ipType      = reflect.TypeOf(net.IP{})
+	macType     = reflect.TypeOf(net.HardwareAddr{})
+)
+
+// autoJSON tells if fields of type t are stored as JSON, see AutoJSON
+func (m *DbMap) autoJSON(t reflect.Type) bool {
+	if !m.AutoJSON || t.Implements(valuerType) || reflect.PtrTo(t).Implements(scannerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Slice:
+		e := t.Elem()
+		if e.Kind() == reflect.Ptr {
+			e = e.Elem()
+		}
+		return e.Kind() == reflect.Struct
+	}
+	return false
+}
+
+// ratToDecimal formats r as exact decimal string.  Values like 1/3 which
+// have no finite decimal representation are an error.
+func ratToDecimal(r *big.Rat) (string, error) {
+	if r.IsInt() {
+		return r.Num().String(), nil
+	}
+	d := new(big.Int).Set(r.Denom())
+	mod := new(big.Int)
+	prec := 0
+	for _, f := range []int64{2, 5} {
+		n := 0
+		for {
+			q, m := new(big.Int).QuoRem(d, big.NewInt(f), mod)
+			if m.Sign() != 0 {
+				break
+			}
+			d = q
+			n++
+		}
+		if n > prec {
+			prec = n
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return "", fmt.Errorf("gorp: %s has no exact decimal representation", r.String())
+	}
+	return r.FloatString(prec), nil
+}
+
+// ScanInterceptor is called with the column and the value of a struct
+// field after a row has been scanned, including values bound by a
+// CustomScanner.  The returned value is stored in the field, so it must be
+// assignable to the field type.  Returning nil sets the zero value.
+//
+// For structs that are not mapped with AddTable, col only holds the column
+// name as returned by the query.
+type ScanInterceptor func(col *ColumnMap, value interface{}) interface{}
+
+// ArgRedactor is called for each bind parameter before it is logged by
+// TraceOn.  colName is the column the parameter is bound to in the
+// statements gorp generates, e.g. for Insert, Upsert, Update, UpdateBatch,
+// Delete and Get.  It is empty for other statements and for the args of
+// where clauses.  The returned value is logged instead of value.
+type ArgRedactor func(colName string, value interface{}) interface{}
+
+// MetricsCallback is called after each statement gorp sends to the
+// database with the lower case first keyword of the statement as op, e.g.
+// "insert" or "select", the duration and the error of the statement.
+type MetricsCallback func(op string, dur time.Duration, err error)
+
+// DbMap is the root gorp mapping object. Create one of these for each
+// database schema you wish to map.  Each DbMap contains a list of
+// mapped tables.
+//
+// Example:
+//
+//     dialect := gorp.MySQLDialect{"InnoDB", "UTF8"}
+//     dbmap := &gorp.DbMap{Db: db, Dialect: dialect}
+//
+type DbMap struct {
+	// Db handle to use with this map
+	Db *sql.DB
+
+	// Dialect implementation to use with this map
+	Dialect Dialect
+
+	TypeConverter TypeConverter
+
+	tables          []*TableMap
+	logger          GorpLogger
+	logPrefix       string
+	scanInterceptor ScanInterceptor
+	scanConverters  map[string]TypeConverter
+	intEnums        map[reflect.Type]intEnum
+	metricsCallback MetricsCallback
+	argRedactor     ArgRedactor
+	queryTimeout    time.Duration
+	ddlTransaction  *bool
+	tableNameCase   IdentifierCase
+	snapshots       *sync.Map // snapshots of tracked structs by pointer, see Track
+
+	DebugLevel        int
+	LastOpInfo        CRUDInfo // info about the last operation on this database
+	CheckAffectedRows bool     // if true an error is raised if affected rows was 0
+
+	// If DynamicTypes is true, interface{} fields of Select destinations
+	// receive a value of the Go type the driver reports for the column
+	// (int64, float64, string, []byte, time.Time, bool or nil for NULL)
+	// instead of the raw driver value.
+	DynamicTypes bool
+
+	// If AutoJSON is true, map fields and fields of slices of structs are
+	// stored as JSON text, unless a TypeConverter, a scan converter, or
+	// driver.Valuer and sql.Scanner methods of the type handle them.
+	AutoJSON bool
+
+	// Select maps the columns of a query to struct fields ignoring case, as
+	// databases may fold the case of unquoted names and aliases, e.g.
+	// Oracle to upper case and Postgres to lower case.  If
+	// StrictColumnCase is true, column names must match exactly.
+	StrictColumnCase bool
+
+	// If MapKeysLastWins is true, SelectToMap stores the last row of rows
+	// with the same key instead of returning an error.
+	MapKeysLastWins bool
+
+	// If NullAsZero is true, Select scans NULL into the zero value of
+	// fields which can not hold NULL, like int64, float64, bool, string
+	// and time.Time, instead of returning an error.  This suits aggregates
+	// of missing groups, e.g. sum() over no rows.
+	NullAsZero bool
+
+	// TransactionRetries is how often WithTransactionRetry runs a
+	// transaction again after a retryable error.  Zero means
+	// DefaultTransactionRetries, a negative value no retries.
+	TransactionRetries int
+}
+
+// DefaultTransactionRetries is the number of retries of
+// DbMap.WithTransactionRetry unless DbMap.TransactionRetries is set
+const DefaultTransactionRetries = 3
+
+// TableMap represents a mapping between a Go struct and a database table
+// Use dbmap.AddTable() or dbmap.AddTableWithName() to create these
+type TableMap struct {
+	// Name of database table.
+	TableName      string
+	SchemaName     string
+	gotype         reflect.Type
+	Columns        []*ColumnMap
+	Indexes        []*IndexMap    // list of indexes for this table
+	Relations      []*RelationMap // list of detail/child tables for this table
+	keys           []*ColumnMap
+	keysFromTags   bool // true if the keys were declared with primarykey tags
+	uniqueTogether [][]string
+	uniqueGroups   map[string]int // index in uniqueTogether of unique tag groups
+	partitionBy    string
+	comment        string
+	isView         bool
+	partialOf      *TableMap // the full mapping of a partial table
+	version        *ColumnMap
+	keyGenerator   func() interface{}
+	insertPlan     bindPlan
+	updatePlan     bindPlan
+	deletePlan     bindPlan
+	getPlan        bindPlan
+	lockPlan       bindPlan // getPlan locking the row, see bindGetForUpdate
+	dbmap          *DbMap
+}
+
+func (t TableMap) String() string {
+	var s string
+	s = "TableName: " + t.TableName + "\n"
+	s = s + "Type: " + t.gotype.Name() + "\n"
+	for _, c := range t.Columns {
+		s = s + "Column: " + c.ColumnName + "\n"
+	}
+	return s
+}
+
+// RelationMap represents a mapping between a master table and a detail table
+// Use tablemap.AddRelation() or field tag `db:"relation:<foreignkey field in detail table>"`to create these
+// Example:	Comments  []*Comment `db:"relation:PostId"`
+// The tag `db:"hasmany:<detail table>, fk:<foreignkey column in detail table>"`
+// also names the detail table.
+// Example:	Comments  []*Comment `db:"hasmany:comment, fk:post_id"`
+type RelationMap struct {
+	DetailTable         *TableMap
+	ForeignKeyFieldName string
+	DetailTableType     interface{}
+	MasterFieldName     string
+	Limit               uint64 // Limits the number of rows a child query returns
+	Offset              uint64 // Starting at row offset when queriyng a child table
+}
+
+func (r RelationMap) String() string {
+	var s string
+	s = "TableMap: " + r.DetailTable.String() + "\n"
+	s = s + "ForeignKeyFieldName: " + r.ForeignKeyFieldName + "\n"
+	s = s + "DetailTableType: " + reflect.TypeOf(r.DetailTableType).Name() + "\n"
+	s = s + "MasterFieldName: " + r.MasterFieldName
+	return s
+}
+
+// CRUDInfo contains info about a CRUD operation
+type CRUDInfo struct {
+	Type                CRUDType
+	BindPlanUsed        *bindPlan
+	RowCount            int64
+	ChildUpdateRowCount int64
+	ChildInsertRowCount int64
+}
+
+// Resets all field to its zero value
+func (i *CRUDInfo) Reset() {
+	i.Type = Unknown
+	i.BindPlanUsed = nil
+	i.RowCount = 0
+	i.ChildUpdateRowCount = 0
+	i.ChildInsertRowCount = 0
+}
+
+type CRUDType int
+
+const (
+	Unknown CRUDType = iota
+	Insert
+	Select
+	Update
+	Delete
+)
+
+func (t CRUDType) String() string {
+	var s string
+	if t&Insert == Insert {
+		s = "Insert"
+	} else if t&Select == Select {
+		s = "Select"
+	} else if t&Update == Update {
+		s = "Update"
+	} else if t&Delete == Delete {
+		s = "Delete"
+	} else {
+		s = "Unknown"
+	}
+	return s
+}
+
+// ResetSql removes cached insert/update/select/delete SQL strings
+// associated with this TableMap.  Call this if you've modified
+// any column names or the table name itself.
+func (t *TableMap) ResetSql() {
+	t.insertPlan = bindPlan{}
+	t.updatePlan = bindPlan{}
+	t.deletePlan = bindPlan{}
+	t.getPlan = bindPlan{}
+	t.lockPlan = bindPlan{}
+}
+
+// SetKeys lets you specify the fields on a struct that map to primary
+// key columns on the table.  If isAutoIncr is set, result.LastInsertId()
+// will be used after INSERT to bind the generated id to the Go struct.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+//
+// Panics if isAutoIncr is true, and fieldNames length != 1
+//
+// Panics if a name in fieldNames is neither a field nor a column of the
+// table.  Transient fields can not be keys.
+//
+// Panics if the keys have been declared with "primarykey"/"pk" field tags
+// and fieldNames or isAutoIncr do not agree with them.
+//
+func (t *TableMap) SetKeys(isAutoIncr bool, fieldNames ...string) *TableMap {
+	if isAutoIncr && len(fieldNames) != 1 {
+		panic(fmt.Sprintf(
+			"gorp: SetKeys: fieldNames length must be 1 if key is auto-increment. (Saw %v fieldNames)",
+			len(fieldNames)))
+	}
+	for _, name := range fieldNames {
+		if colMapOrNil(t, name) == nil {
+			panic(fmt.Sprintf("gorp: SetKeys: type %s of table %s has no mapped field or column %s",
+				t.gotype.Name(), t.TableName, name))
+		}
+	}
+	if t.keysFromTags {
+		t.checkKeysAgree(isAutoIncr, fieldNames)
+	}
+	t.keys = make([]*ColumnMap, 0)
+	for _, name := range fieldNames {
+		colmap := t.ColMap(name)
+		colmap.isPK = true
+		colmap.isAutoIncr = isAutoIncr
+		t.keys = append(t.keys, colmap)
+	}
+	t.ResetSql()
+
+	return t
+}
+
+// SetKeyGenerator sets a function which returns new primary key values,
+// e.g. UUID strings.  Insert calls it before the PreInsert hook and stores
+// its result in the key field if that field has the zero value.
+//
+// Panics if the table does not have a single, not auto-increment key, so
+// call SetKeys first.
+//
+// Example:  dbmap.AddTable(Order{}).SetKeys(false, "Id").SetKeyGenerator(newUUID)
+//
+func (t *TableMap) SetKeyGenerator(gen func() interface{}) *TableMap {
+	if len(t.keys) != 1 || t.keys[0].isAutoIncr {
+		panic(fmt.Sprintf("gorp: SetKeyGenerator: table %s needs a single key which is not auto-increment", t.TableName))
+	}
+	t.keyGenerator = gen
+	return t
+}
+
+// generateKey stores a generated key in the zero key field of elem
+func (t *TableMap) generateKey(elem reflect.Value) error {
+	if t.keyGenerator == nil {
+		return nil
+	}
+	f := fieldByPath(elem, t.keys[0].fieldName)
+	if !f.IsZero() {
+		return nil
+	}
+	v := reflect.ValueOf(t.keyGenerator())
+	switch {
+	case !v.IsValid():
+		return fmt.Errorf("gorp: key generator of table %s returned nil", t.TableName)
+	case v.Type().AssignableTo(f.Type()):
+		f.Set(v)
+	case v.Kind() == f.Kind() && v.Type().ConvertibleTo(f.Type()):
+		f.Set(v.Convert(f.Type()))
+	default:
+		return fmt.Errorf("gorp: key generator of table %s returned %s, field %s is %s",
+			t.TableName, v.Type(), t.keys[0].fieldName, f.Type())
+	}
+	return nil
+}
+
+// checkKeysAgree panics if the keys declared by field tags differ from the
+// fields given to SetKeys
+func (t *TableMap) checkKeysAgree(isAutoIncr bool, fieldNames []string) {
+	agree := len(fieldNames) == len(t.keys)
+	for _, name := range fieldNames {
+		found := false
+		for _, k := range t.keys {
+			if strings.ToLower(k.fieldName) == strings.ToLower(name) || strings.ToLower(k.ColumnName) == strings.ToLower(name) {
+				found = k.isAutoIncr == isAutoIncr
+				break
+			}
+		}
+		agree = agree && found
+	}
+	if !agree {
+		tagged := make([]string, 0, len(t.keys))
+		for _, k := range t.keys {
+			tagged = append(tagged, k.fieldName)
+		}
+		panic(fmt.Sprintf(
+			"gorp: SetKeys: keys %v (autoincrement %t) of table %s do not agree with the keys %v declared by field tags",
+			fieldNames, isAutoIncr, t.TableName, tagged))
+	}
+}
+
+// SetUniqueTogether lets you specify uniqueness constraints across multiple
+// columns on the table. Each call adds an additional constraint for the
+// specified columns.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+//
+// Panics if fieldNames length < 2.
+//
+func (t *TableMap) SetUniqueTogether(fieldNames ...string) *TableMap {
+	if len(fieldNames) < 2 {
+		panic(fmt.Sprintf(
+			"gorp: SetUniqueTogether: must provide at least two fieldNames to set uniqueness constraint."))
+	}
+
+	columns := make([]string, 0)
+	for _, name := range fieldNames {
+		columns = append(columns, name)
+	}
+	t.uniqueTogether = append(t.uniqueTogether, columns)
+	t.ResetSql()
+
+	return t
+}
+
+// addUniqueGroupColumn adds column to the unique constraint of the
+// "unique:group" tag option
+func (t *TableMap) addUniqueGroupColumn(group string, column string) {
+	if x, ok := t.uniqueGroups[group]; ok {
+		t.uniqueTogether[x] = append(t.uniqueTogether[x], column)
+		return
+	}
+	if t.uniqueGroups == nil {
+		t.uniqueGroups = make(map[string]int)
+	}
+	t.uniqueGroups[group] = len(t.uniqueTogether)
+	t.uniqueTogether = append(t.uniqueTogether, []string{column})
+}
+
+// AddIndex adds an index over the columns of fieldNames to the table,
+// e.g. a composite index which would need the same index tag on many
+// fields.  fieldNames may be struct field names or column names.
+// CreateIndexes creates it like the indexes declared by tags.
+//
+// Panics if name is empty or already used by an index of the table, if
+// fieldNames is empty, or if a field is not mapped.
+//
+// Example:  dbmap.AddTable(Post{}).AddIndex("idx_site_day", true, "Site", "Day")
+//
+func (t *TableMap) AddIndex(name string, unique bool, fieldNames ...string) *TableMap {
+	if name == "" || len(fieldNames) == 0 {
+		panic(fmt.Sprintf("gorp: AddIndex: index of table %s needs a name and at least one field", t.TableName))
+	}
+	for _, index := range t.Indexes {
+		if index.IndexName == name {
+			panic(fmt.Sprintf("gorp: AddIndex: table %s already has an index %s", t.TableName, name))
+		}
+	}
+	columns := make([]string, 0, len(fieldNames))
+	for _, field := range fieldNames {
+		col := colMapOrNil(t, field)
+		if col == nil {
+			panic(fmt.Sprintf("gorp: AddIndex: type %s of table %s has no mapped field or column %s",
+				t.gotype.Name(), t.TableName, field))
+		}
+		columns = append(columns, col.ColumnName)
+	}
+	t.Indexes = append(t.Indexes, &IndexMap{
+		IndexName:  name,
+		Unique:     unique,
+		fieldNames: columns,
+		gotype:     t.gotype,
+	})
+	return t
+}
+
+// ColMap returns the ColumnMap pointer matching the given struct field
+// name.  Transient columns are returned too, so they can be included
+// again with SetTransient(false).  It panics if the struct does not
+// contain a field matching this name.
+func (t *TableMap) ColMap(field string) *ColumnMap {
+	col := colMapOrNil(t, field)
+	if col == nil {
+		for _, c := range t.Columns {
+			if strings.EqualFold(c.fieldName, field) {
+				col = c
+				break
+			}
+		}
+	}
+	if col == nil {
+		e := fmt.Sprintf("No ColumnMap in table %s type %s with field %s",
+			t.TableName, t.gotype.Name(), field)
+
+		panic(e)
+	}
+	return col
+}
+
+// checkColumnNames panics if two fields map to the same column, e.g. a
+// field and a field of an embedded struct with the same column tag
+func (t *TableMap) checkColumnNames() {
+	for i, col := range t.Columns {
+		if col.Transient {
+			continue
+		}
+		for _, other := range t.Columns[:i] {
+			if !other.Transient && strings.EqualFold(col.ColumnName, other.ColumnName) {
+				panic(fmt.Sprintf("gorp: AddTable: fields %s.%s and %s.%s both map to column '%s'",
+					t.gotype.Name(), other.fieldName, t.gotype.Name(), col.fieldName, col.ColumnName))
+			}
+		}
+	}
+}
+
+// converterFor returns the TypeConverter for the struct field, wrapped by
+// the sentinelConverter of columns with a null sentinel: the crypto
+// converter of encrypted columns, the scan converter of its column if one
+// is set, the valuerConverter for types with driver.Valuer and sql.Scanner
+// methods, the JSON converter for columns stored as JSON, else the
+// TypeConverter of the DbMap
+func (t *TableMap) converterFor(fieldName string) (TypeConverter, error) {
+	col := colMapForField(t, fieldName)
+	if col == nil {
+		return t.dbmap.TypeConverter, nil
+	}
+	conv, err := t.columnConverter(col)
+	if err != nil || col.nullSentinel == nil {
+		return conv, err
+	}
+	return sentinelConverter{col.nullSentinel, conv}, nil
+}
+
+// columnConverter returns the TypeConverter of col without its null
+// sentinel, see converterFor
+func (t *TableMap) columnConverter(col *ColumnMap) (TypeConverter, error) {
+	if col.crypto != nil {
+		return *col.crypto, nil
+	}
+	if col.ScanAs == "bit" && t.dbmap.scanConverters["bit"] == nil {
+		bd, _ := t.dbmap.Dialect.(BitDialect)
+		return BitConverter{Size: col.MaxSize, String: bd != nil && bd.BitString()}, nil
+	}
+	if col.ScanAs != "" {
+		return t.dbmap.scanConverter(col.ScanAs)
+	}
+	if col.selfConverting {
+		return valuerConverter{}, nil
+	}
+	if t.dbmap.autoJSON(col.gotype) {
+		return jsonConverter{}, nil
+	}
+	return t.dbmap.TypeConverter, nil
+}
+
+// colMapForField returns the non transient ColumnMap of the struct field
+// with the given name, or nil
+func colMapForField(t *TableMap, fieldName string) *ColumnMap {
+	for _, col := range t.Columns {
+		if col.fieldName == fieldName && !col.Transient {
+			return col
+		}
+	}
+	return nil
+}
+
+func colMapOrNil(t *TableMap, field string) *ColumnMap {
+	for _, col := range t.Columns {
+		if strings.ToLower(col.fieldName) == strings.ToLower(field) || strings.ToLower(col.ColumnName) == strings.ToLower(field) {
+			// Ignore ignored Columns, "-" is the indentifier for columns which should be ignored
+			//if col.ColumnName != "-" { // old code, depending on a hardcoded string is avoided now
+			if col.Transient == false {
+				return col
+			}
+		}
+	}
+	return nil
+}
+
+// SetIsView marks the table as an updatable view, e.g. one with INSTEAD OF
+// triggers.  Insert does not write autoincrement columns of views and does
+// not read back their values, as there is no last insert id.  Views are
+// skipped by CreateTables, CreateIndexes, DropTables and TruncateTables.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetIsView(isView bool) *TableMap {
+	t.isView = isView
+	t.ResetSql()
+	return t
+}
+
+// SetPartitionBy declares the table as range partitioned by expr.  Create
+// tables then emits "partition by range (expr)", use CreatePartition() to
+// add the child partitions.
+//
+// CreateTables and CreatePartition return an error if the dialect is not
+// PostgresDialect, the only one supported, or if expr names a column the
+// table does not have.
+//
+// Example:  dbmap.AddTable(Event{}).SetPartitionBy("created")
+//
+func (t *TableMap) SetPartitionBy(expr string) *TableMap {
+	t.partitionBy = expr
+	return t
+}
+
+var partitionColumnRegexp = regexp.MustCompile(`^[[:word:]]+$`)
+
+// partitionError returns the error of the partition by expression set
+// with SetPartitionBy, nil if it is valid or not set
+func (t *TableMap) partitionError() error {
+	if t.partitionBy == "" {
+		return nil
+	}
+	if _, ok := t.dbmap.Dialect.(PostgresDialect); !ok {
+		return fmt.Errorf("gorp: partitioning of table %s is not supported by %T", t.TableName, t.dbmap.Dialect)
+	}
+	for _, part := range strings.Split(t.partitionBy, ",") {
+		name := strings.TrimSpace(part)
+		if !partitionColumnRegexp.MatchString(name) {
+			// an expression, left to the database
+			continue
+		}
+		found := false
+		for _, col := range t.Columns {
+			if !col.Transient && strings.EqualFold(col.ColumnName, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("gorp: no column %s to partition table %s by", name, t.TableName)
+		}
+	}
+	return nil
+}
+
+// partitionClause returns the partition by clause for create table
+func (t *TableMap) partitionClause() string {
+	if t.partitionBy == "" {
+		return ""
+	}
+	return fmt.Sprintf("partition by range (%s) ", t.partitionBy)
+}
+
+// SetComment sets the comment of the table, which CreateTables stores in
+// the database.  PostgresDialect and OracleDialect run "comment on table",
+// MySQLDialect adds it to the create table statement and SqlServerDialect
+// sets the MS_Description extended property.  SqliteDialect has no table
+// comments and ignores it.
+//
+// Example:  dbmap.AddTable(Invoice{}).SetComment("customer invoices")
+//
+func (t *TableMap) SetComment(comment string) *TableMap {
+	t.comment = comment
+	return t
+}
+
+// commentSuffix returns the comment clause for create table
+func (t *TableMap) commentSuffix() string {
+	if d, ok := t.dbmap.Dialect.(TableCommentDialect); ok && t.comment != "" {
+		return d.TableCommentSuffix(t.comment)
+	}
+	return ""
+}
+
+// sqlForComment returns the statement setting the comment after create
+// table, or "" if there is none
+func (t *TableMap) sqlForComment() string {
+	if d, ok := t.dbmap.Dialect.(TableCommentDialect); ok && t.comment != "" {
+		return d.TableCommentSql(t.SchemaName, t.TableName, t.comment)
+	}
+	return ""
+}
+
+// SqlForCreatePartition returns the SQL to create the partition name of
+// this table for the values from (inclusive) to (exclusive).  from and to
+// are SQL expressions, e.g. "'2015-01-01'" or "maxvalue".
+func (t *TableMap) SqlForCreatePartition(name string, from string, to string) string {
+	dialect := t.dbmap.Dialect
+	return fmt.Sprintf("create table %s partition of %s for values from (%s) to (%s)%s",
+		dialect.QuotedTableForQuery(t.SchemaName, name),
+		dialect.QuotedTableForQuery(t.SchemaName, t.TableName),
+		from, to, dialect.QuerySuffix())
+}
+
+// SetVersionCol sets the column to use as the Version field.  By default
+// the "Version" field is used.  Returns the column found, or panics
+// if the struct does not contain a field matching this name.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetVersionCol(field string) *ColumnMap {
+	c := t.ColMap(field)
+	t.version = c
+	t.ResetSql()
+	return c
+}
+
+// SqlForCreateSchema returns the statement which creates the schema of
+// this table, or "" if the table has no schema.
+func (t *TableMap) SqlForCreateSchema(ifNotExists bool) string {
+	if strings.TrimSpace(t.SchemaName) == "" {
+		return ""
+	}
+	dialect := t.dbmap.Dialect
+	schemaCreate := "create schema"
+	if ifNotExists {
+		schemaCreate = dialect.IfSchemaNotExists(schemaCreate, t.SchemaName)
+	}
+	return schemaCreate + " " + t.SchemaName + dialect.QuerySuffix()
+}
+
+// SqlForCreateTable gets a sequence of SQL commands that will create
+// the specified table and any associated schema
+func (t *TableMap) SqlForCreate(ifNotExists bool) string {
+	s := bytes.Buffer{}
+	dialect := t.dbmap.Dialect
+
+	// Dialects without a query suffix cannot separate two statements
+	// in one string, use SqlForCreateSchema for the schema instead.
+	if dialect.QuerySuffix() != "" {
+		s.WriteString(t.SqlForCreateSchema(ifNotExists))
+	}
+
+	tableCreate := "create table"
+	if ifNotExists {
+		s.WriteString(dialect.IfTableNotExists(tableCreate, t.SchemaName, t.TableName))
+	} else {
+		s.WriteString(tableCreate)
+	}
+	s.WriteString(fmt.Sprintf(" %s (", dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+
+	x := 0
+	for _, col := range t.Columns {
+		if !col.Transient {
+			if x > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(fmt.Sprintf("%s %s", dialect.QuoteField(col.ColumnName), col.sqlType(dialect)))
+			if col.DbDefault != "" {
+				s.WriteString(fmt.Sprintf(" default %s", col.DbDefault))
+			}
+
+			if col.isPK || col.isNotNull {
+				s.WriteString(" not null")
+			}
+			if col.isPK && len(t.keys) == 1 {
+				s.WriteString(" primary key")
+			}
+			if col.Unique {
+				s.WriteString(" unique")
+			}
+			if col.isAutoIncr {
+				s.WriteString(fmt.Sprintf(" %s", dialect.AutoIncrStr()))
+			}
+			s.WriteString(t.checkClause(col))
+
+			x++
+		}
+	}
+	if len(t.keys) > 1 {
+		s.WriteString(", primary key (")
+		for x := range t.keys {
+			if x > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(dialect.QuoteField(t.keys[x].ColumnName))
+		}
+		s.WriteString(")")
+	}
+	if len(t.uniqueTogether) > 0 {
+		for _, columns := range t.uniqueTogether {
+			s.WriteString(", unique (")
+			for i, column := range columns {
+				if i > 0 {
+					s.WriteString(", ")
+				}
+				s.WriteString(dialect.QuoteField(column))
+			}
+			s.WriteString(")")
+		}
+	}
+	for _, col := range t.Columns {
+		if !col.Transient && col.References != "" {
+			s.WriteString(t.foreignKeyClause(col))
+		}
+	}
+	s.WriteString(") ")
+	s.WriteString(t.partitionClause())
+	s.WriteString(dialect.CreateTableSuffix())
+	s.WriteString(t.commentSuffix())
+	s.WriteString(dialect.QuerySuffix())
+	s.WriteString(t.sqlForComment())
+	return s.String()
+}
+
+type bindPlan struct {
+	query             string
+	argFields         []string
+	keyFields         []string
+	versField         string
+	autoIncrIdx       int
+	autoIncrFieldName string
+	readFields        []string
+	returnFields      []string
+}
+
+func (plan bindPlan) createBindInstance(elem reflect.Value, t *TableMap) (bindInstance, error) {
+	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField, readFields: plan.readFields, returnFields: plan.returnFields,
+		table: t, argFields: plan.argFields, keyFields: plan.keyFields}
+	if plan.versField != "" {
+		bi.existingVersion = fieldByPath(elem, plan.versField).Int()
+	}
+
+	for i := 0; i < len(plan.argFields); i++ {
+		k := plan.argFields[i]
+		if k == versFieldConst {
+			newVer := bi.existingVersion + 1
+			bi.args = append(bi.args, newVer)
+			if bi.existingVersion == 0 {
+				fieldByPath(elem, plan.versField).SetInt(int64(newVer))
+			}
+		} else {
+			val := fieldByPath(elem, k).Interface()
+			conv, err := t.converterFor(k)
+			if err != nil {
+				return bindInstance{}, err
+			}
+			if conv != nil {
+				val, err = convertToDb(conv, val)
+				if err != nil {
+					return bindInstance{}, err
+				}
+			} else {
+				val = bindValue(val)
+			}
+			bi.args = append(bi.args, val)
+		}
+	}
+
+	for i := 0; i < len(plan.keyFields); i++ {
+		k := plan.keyFields[i]
+		val := fieldByPath(elem, k).Interface()
+		conv, err := t.converterFor(k)
+		if err != nil {
+			return bindInstance{}, err
+		}
+		if conv != nil {
+			val, err = convertToDb(conv, val)
+			if err != nil {
+				return bindInstance{}, err
+			}
+		} else {
+			val = bindValue(val)
+		}
+		bi.keys = append(bi.keys, val)
+	}
+
+	return bi, nil
+}
+
+type bindInstance struct {
+	query             string
+	args              []interface{}
+	keys              []interface{}
+	existingVersion   int64
+	versField         string
+	autoIncrIdx       int
+	autoIncrFieldName string
+	readFields        []string
+	returnFields      []string
+	plan              *bindPlan // the plan bound, for CRUDInfo.BindPlanUsed
+	table             *TableMap
+	argFields         []string
+	keyFields         []string
+}
+
+// boundArgs returns the fields the args of bi are bound to
+func (bi bindInstance) boundArgs() *argFields {
+	return &argFields{bi.table, bi.argFields}
+}
+
+// boundKeys returns the fields the keys of bi are bound to
+func (bi bindInstance) boundKeys() *argFields {
+	return &argFields{bi.table, bi.keyFields}
+}
+
+// fieldsExecutor is the executor passed to the auto increment inserters of
+// the dialect, whose statements are traced with the columns of fields
+type fieldsExecutor struct {
+	SqlExecutor
+	fields *argFields
+}
+
+func (e fieldsExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return e.execFields(e.fields, query, args...)
+}
+
+func (e fieldsExecutor) query(query string, args ...interface{}) (*timeoutRows, error) {
+	return e.queryFields(e.fields, query, args...)
+}
+
+func (e fieldsExecutor) queryRow(query string, args ...interface{}) *timeoutRow {
+	return e.queryRowFields(e.fields, query, args...)
+}
+
+func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
+	return t.bindInsertReturning(elem, nil)
+}
+
+// bindInsertReturning binds an insert which also returns the columns of
+// the fields or column names in returning, see DbMap.InsertReturning
+func (t *TableMap) bindInsertReturning(elem reflect.Value, returning []string) (bindInstance, error) {
+	return t.bindInsertConflict(elem, returning, nil)
+}
+
+// bindInsertConflict is bindInsertReturning with the on conflict clause of
+// Upsert for target if it is not nil
+func (t *TableMap) bindInsertConflict(elem reflect.Value, returning []string, target *ConflictTarget) (bindInstance, error) {
+	var returnCols []*ColumnMap
+	for _, name := range returning {
+		col := colMapOrNil(t, name)
+		if col == nil || col.Transient {
+			return bindInstance{}, fmt.Errorf("gorp: no column %s in table %s", name, t.TableName)
+		}
+		returnCols = append(returnCols, col)
+	}
+
+	// Columns the database fills are left out, which needs a plan of
+	// its own that is not cached
+	var filled []*ColumnMap
+	for _, col := range t.Columns {
+		if !col.Transient && col.dbFilled(fieldByPath(elem, col.fieldName)) {
+			filled = append(filled, col)
+		}
+	}
+
+	plan := t.insertPlan
+	planUsed := &t.insertPlan
+	if plan.query == "" || len(filled) > 0 || len(returnCols) > 0 || target != nil {
+		plan = bindPlan{autoIncrIdx: -1}
+		var inserted []*ColumnMap
+
+		s := bytes.Buffer{}
+		s2 := bytes.Buffer{}
+		s.WriteString(fmt.Sprintf("insert into %s (", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+
+		x := 0
+		first := true
+		for y := range t.Columns {
+			col := t.Columns[y]
+			if col.isAutoIncr && t.isView {
+				// the triggers of the view assign the value
+				continue
+			}
+			if containsColumn(filled, col) {
+				if col.ReadDefault {
+					plan.readFields = append(plan.readFields, col.fieldName)
+				}
+				continue
+			}
+			if !(col.isAutoIncr && t.dbmap.Dialect.AutoIncrBindValue() == "") {
+				if !col.Transient {
+					if !first {
+						s.WriteString(",")
+						s2.WriteString(",")
+					}
+					s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+					inserted = append(inserted, col)
+
+					if col.Sequence != "" {
+						sd, ok := t.dbmap.Dialect.(SequenceDialect)
+						if !ok {
+							return bindInstance{}, fmt.Errorf("gorp: column %s.%s uses sequence %s, but the dialect does not support sequences", t.TableName, col.ColumnName, col.Sequence)
+						}
+						s2.WriteString(sd.NextValSql(col.Sequence))
+						plan.autoIncrIdx = y
+						plan.autoIncrFieldName = col.fieldName
+					} else if col.isAutoIncr {
+						s2.WriteString(t.dbmap.Dialect.AutoIncrBindValue())
+						plan.autoIncrIdx = y
+						plan.autoIncrFieldName = col.fieldName
+					} else {
+						if col.DefaultValue == "" {
+							s2.WriteString(t.dbmap.Dialect.BindVar(x))
+							if col == t.version {
+								plan.versField = col.fieldName
+								plan.argFields = append(plan.argFields, versFieldConst)
+							} else {
+								plan.argFields = append(plan.argFields, col.fieldName)
+							}
+							x++
+						} else {
+
+							// Check if this column is a NOT NULL
+							if err := checkForNotNull(elem, col, t); err != nil {
+								return bindInstance{}, err
+							}
+							plan.argFields = append(plan.argFields, col.fieldName)
+							s2.WriteString(col.DefaultValue)
+						}
+					}
+					first = false
+				}
+			} else {
+				plan.autoIncrIdx = y
+				plan.autoIncrFieldName = col.fieldName
+			}
+		}
+		s.WriteString(")")
+
+		suffix := ""
+		if plan.autoIncrIdx > -1 {
+			suffix = t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx])
+		}
+		if rd, ok := t.dbmap.Dialect.(ReturningDialect); ok && len(returnCols) > 0 {
+			// the returned row also carries the generated key
+			if plan.autoIncrIdx > -1 && !containsColumn(returnCols, t.Columns[plan.autoIncrIdx]) {
+				returnCols = append([]*ColumnMap{t.Columns[plan.autoIncrIdx]}, returnCols...)
+			}
+			names := make([]string, len(returnCols))
+			for i, col := range returnCols {
+				names[i] = col.ColumnName
+				plan.returnFields = append(plan.returnFields, col.fieldName)
+			}
+			suffix = rd.ReturningClause(names)
+		} else {
+			// read the columns with a select by primary key
+			for _, col := range returnCols {
+				if !containsString(plan.readFields, col.fieldName) {
+					plan.readFields = append(plan.readFields, col.fieldName)
+				}
+			}
+		}
+
+		outputBeforeValues := false
+		if od, ok := t.dbmap.Dialect.(AutoIncrOutputDialect); ok {
+			outputBeforeValues = od.AutoIncrOutputBeforeValues()
+		}
+		if outputBeforeValues {
+			s.WriteString(suffix)
+		}
+		s.WriteString(" values (")
+		s.WriteString(s2.String())
+		s.WriteString(")")
+		if target != nil {
+			clause, err := t.onConflictClause(target, inserted)
+			if err != nil {
+				return bindInstance{}, err
+			}
+			s.WriteString(clause)
+		}
+		if !outputBeforeValues {
+			s.WriteString(suffix)
+		}
+		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+
+		plan.query = s.String()
+		if len(filled) == 0 && len(returnCols) == 0 && target == nil {
+			t.insertPlan = plan
+		} else {
+			planUsed = &plan
+		}
+	}
+
+	bi, err := plan.createBindInstance(elem, t)
+	bi.plan = planUsed
+	return bi, err
+}
+
+// onConflictClause returns the clause of Upsert which updates the inserted
+// non key columns of the row conflicting on target
+func (t *TableMap) onConflictClause(target *ConflictTarget, inserted []*ColumnMap) (string, error) {
+	ud, ok := t.dbmap.Dialect.(UpsertDialect)
+	if !ok {
+		return "", fmt.Errorf("gorp: Upsert is not supported by %T", t.dbmap.Dialect)
+	}
+	if len(target.Columns) == 0 {
+		return "", fmt.Errorf("gorp: Upsert into table %s needs the columns of a unique index", t.TableName)
+	}
+	var targetCols []*ColumnMap
+	var columns []string
+	for _, name := range target.Columns {
+		col := colMapOrNil(t, name)
+		if col == nil {
+			return "", fmt.Errorf("gorp: Upsert: no column %s in table %s", name, t.TableName)
+		}
+		targetCols = append(targetCols, col)
+		columns = append(columns, col.ColumnName)
+	}
+	var update []string
+	for _, col := range inserted {
+		if !col.isPK && !col.isAutoIncr && !containsColumn(targetCols, col) {
+			update = append(update, col.ColumnName)
+		}
+	}
+	if len(update) == 0 {
+		// an update is needed to return the generated key of the row
+		update = columns
+	}
+	return ud.OnConflictClause(columns, target.Where, update, target.UpdateWhere), nil
+}
+
+// containsColumn returns true if col is in cols
+func containsColumn(cols []*ColumnMap, col *ColumnMap) bool {
+	for _, c := range cols {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readBack selects the columns of fields for the row of elem and stores
+// them in elem
+func (t *TableMap) readBack(exec SqlExecutor, elem reflect.Value, fields []string) error {
+	if len(t.keys) == 0 {
+		return fmt.Errorf("gorp: cannot read back defaults of table %s without primary key", t.TableName)
+	}
+	d := t.dbmap.Dialect
+	plan := bindPlan{}
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	for x, fieldName := range fields {
+		if x > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(colMapForField(t, fieldName).ColumnName))
+	}
+	s.WriteString(" from ")
+	s.WriteString(d.QuotedTableForQuery(t.SchemaName, t.TableName))
+	s.WriteString(" where ")
+	for x, col := range t.keys {
+		if x > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(d.BindVar(x))
+		plan.keyFields = append(plan.keyFields, col.fieldName)
+	}
+	s.WriteString(d.QuerySuffix())
+	plan.query = s.String()
+
+	bi, err := plan.createBindInstance(elem, t)
+	if err != nil {
+		return err
+	}
+
+	dest, custScan, err := t.scanTargets(elem, fields)
+	if err != nil {
+		return err
+	}
+	err = exec.queryRowFields(bi.boundKeys(), bi.query, bi.keys...).Scan(dest...)
+	if err != nil {
+		return fmt.Errorf("gorp: read back of defaults failed for table '%s': %w", t.TableName, err)
+	}
+	for _, c := range custScan {
+		err = c.Bind()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertReturning runs the insert of bi, which returns one row with the
+// columns of bi.returnFields, and stores the row in elem
+func (t *TableMap) insertReturning(exec SqlExecutor, elem reflect.Value, bi bindInstance) error {
+	dest, custScan, err := t.scanTargets(elem, bi.returnFields)
+	if err != nil {
+		return err
+	}
+	rows, err := exec.queryFields(bi.boundArgs(), bi.query, bi.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+		return fmt.Errorf("no row returned for insert: %s", bi.query)
+	}
+	err = rows.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	for _, c := range custScan {
+		err = c.Bind()
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Close()
+}
+
+// scanTargets returns the scan destinations for the fields of elem, and the
+// CustomScanners which have to be bound after the scan
+func (t *TableMap) scanTargets(elem reflect.Value, fields []string) ([]interface{}, []CustomScanner, error) {
+	dest := make([]interface{}, len(fields))
+	custScan := make([]CustomScanner, 0)
+	for x, fieldName := range fields {
+		target := fieldByPath(elem, fieldName).Addr().Interface()
+		conv, err := t.converterFor(fieldName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if conv != nil {
+			scanner, ok := convertFromDb(conv, target)
+			if ok {
+				target = scanner.Holder
+				custScan = append(custScan, scanner)
+			}
+		}
+		dest[x] = target
+	}
+	return dest, custScan, nil
+}
+
+func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
+	plan := t.updatePlan
+	if plan.query == "" {
+		var err error
+		plan, err = t.updatePlanFor(elem, nil)
+		if err != nil {
+			return bindInstance{}, err
+		}
+		t.updatePlan = plan
+	}
+
+	bi, err := plan.createBindInstance(elem, t)
+	bi.plan = &t.updatePlan
+	return bi, err
+}
+
+// bindUpdateChanged binds an update of the columns in changed and the
+// version column only, see DbMap.Track.  The plan is not cached.
+func (t *TableMap) bindUpdateChanged(elem reflect.Value, changed map[*ColumnMap]bool) (bindInstance, error) {
+	plan, err := t.updatePlanFor(elem, changed)
+	if err != nil {
+		return bindInstance{}, err
+	}
+	bi, err := plan.createBindInstance(elem, t)
+	bi.plan = &plan
+	return bi, err
+}
+
+// updatePlanFor returns the update plan of the table, which sets the
+// columns in only and the version column, or all columns if only is nil
+func (t *TableMap) updatePlanFor(elem reflect.Value, only map[*ColumnMap]bool) (bindPlan, error) {
+	plan, s, err := t.updateSetPlan(elem, only)
+	if err != nil {
+		return bindPlan{}, err
+	}
+	x := len(plan.argFields)
+
+	s.WriteString(" where ")
+	for y := range t.keys {
+		col := t.keys[y]
+		if y > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(t.dbmap.Dialect.BindVar(x))
+
+		plan.argFields = append(plan.argFields, col.fieldName)
+		plan.keyFields = append(plan.keyFields, col.fieldName)
+		x++
+	}
+	if plan.versField != "" {
+		s.WriteString(" and ")
+		s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
+		s.WriteString("=")
+		s.WriteString(t.dbmap.Dialect.BindVar(x))
+		plan.argFields = append(plan.argFields, plan.versField)
+	}
+	s.WriteString(t.dbmap.Dialect.QuerySuffix())
+
+	plan.query = s.String()
+	return plan, nil
+}
+
+// updateSetPlan returns the plan and the statement up to the WHERE clause
+// of an update of the table, see updatePlanFor
+func (t *TableMap) updateSetPlan(elem reflect.Value, only map[*ColumnMap]bool) (bindPlan, *bytes.Buffer, error) {
+	plan := bindPlan{}
+	s := &bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("update %s set ", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+	x := 0
+
+	for y := range t.Columns {
+		col := t.Columns[y]
+		if !col.isAutoIncr && !col.Transient && (only == nil || only[col] || col == t.version) {
+			if x > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+			s.WriteString("=")
+			s.WriteString(t.dbmap.Dialect.BindVar(x))
+
+			if col == t.version {
+				plan.versField = col.fieldName
+				plan.argFields = append(plan.argFields, versFieldConst)
+			} else {
+				// Check if this column is a NOT NULL
+				if err := checkForNotNull(elem, col, t); err != nil {
+					return bindPlan{}, nil, err
+				}
+				plan.argFields = append(plan.argFields, col.fieldName)
+			}
+			x++
+		}
+	}
+	return plan, s, nil
+}
+
+func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
+	plan := t.deletePlan
+	if plan.query == "" {
+
+		s := bytes.Buffer{}
+		s.WriteString(fmt.Sprintf("delete from %s", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+
+		for y := range t.Columns {
+			col := t.Columns[y]
+			if !col.Transient {
+				if col == t.version {
+					plan.versField = col.fieldName
+				}
+			}
+		}
+
+		s.WriteString(" where ")
+		for x := range t.keys {
+			k := t.keys[x]
+			if x > 0 {
+				s.WriteString(" and ")
+			}
+			s.WriteString(t.dbmap.Dialect.QuoteField(k.ColumnName))
+			s.WriteString("=")
+			s.WriteString(t.dbmap.Dialect.BindVar(x))
+
+			plan.keyFields = append(plan.keyFields, k.fieldName)
+			plan.argFields = append(plan.argFields, k.fieldName)
+		}
+		if plan.versField != "" {
+			s.WriteString(" and ")
+			s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
+			s.WriteString("=")
+			s.WriteString(t.dbmap.Dialect.BindVar(len(plan.argFields)))
+
+			plan.argFields = append(plan.argFields, plan.versField)
+		}
+		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+
+		plan.query = s.String()
+		t.deletePlan = plan
+	}
+
+	return plan.createBindInstance(elem, t)
+}
+
+// keyArgs returns the converted values of the key fields of elem, the
+// arguments of the get statement
+func (t *TableMap) keyArgs(elem reflect.Value) ([]interface{}, error) {
+	plan := t.bindGet()
+	keys := make([]interface{}, 0, len(plan.keyFields))
+	for _, k := range plan.keyFields {
+		val := fieldByPath(elem, k).Interface()
+		conv, err := t.converterFor(k)
+		if err != nil {
+			return nil, err
+		}
+		if conv != nil {
+			val, err = convertToDb(conv, val)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			val = bindValue(val)
+		}
+		keys = append(keys, val)
+	}
+	return keys, nil
+}
+
+func (t *TableMap) bindGet() bindPlan {
+	if t.getPlan.query == "" {
+		t.getPlan = t.buildGet(SelectClause{})
+	}
+	return t.getPlan
+}
+
+// bindGetForUpdate is bindGet locking the row until the end of the
+// transaction with the clause of the dialect, if it implements LockDialect
+func (t *TableMap) bindGetForUpdate() bindPlan {
+	ld, ok := t.dbmap.Dialect.(LockDialect)
+	if !ok {
+		return t.bindGet()
+	}
+	if t.lockPlan.query == "" {
+		t.lockPlan = t.buildGet(ld.ForUpdateClause())
+	}
+	return t.lockPlan
+}
+
+// buildGet builds the select of a row by its keys, with the lock clause if
+// it is not empty
+func (t *TableMap) buildGet(lock SelectClause) bindPlan {
+	var plan bindPlan
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+
+	x := 0
+	for _, col := range t.Columns {
+		if !col.Transient {
+			if x > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+			plan.argFields = append(plan.argFields, col.fieldName)
+			x++
+		}
+	}
+	s.WriteString(" from ")
+	s.WriteString(t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName))
+	if lock.Placement == AfterTable {
+		s.WriteString(lock.Sql)
+	}
+	s.WriteString(" where ")
+	for x := range t.keys {
+		col := t.keys[x]
+		if x > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(t.dbmap.Dialect.BindVar(x))
+
+		plan.keyFields = append(plan.keyFields, col.fieldName)
+	}
+	if lock.Placement == AfterWhere {
+		s.WriteString(lock.Sql)
+	}
+	s.WriteString(t.dbmap.Dialect.QuerySuffix())
+
+	plan.query = s.String()
+	return plan
+}
+
+// ColumnMap represents a mapping between a Go struct field and a single
+// column in a table.
+// Unique and MaxSize only inform the
+// CreateTables() function and are not used by Insert/Update/Delete/Get.
+type ColumnMap struct {
+	// Column name in db table
+	ColumnName string
+
+	// If true, this column is skipped in generated SQL statements
+	Transient bool
+
+	// If true, " unique" is added to create table statements.
+	// Not used elsewhere
+	Unique bool
+
+	// Passed to Dialect.ToSqlType() to assist in informing the
+	// correct column type to map to in CreateTables()
+	MaxSize int
+
+	//DbType overrides the conversion from go types to dab types
+	DbType string
+
+	// If RawSQLType is set, create table statements use it verbatim as the
+	// type of the column, without the interpretation of DbType, e.g.
+	// "tsvector".  Set by the "sqltype" tag.
+	RawSQLType string
+
+	// If EnforceNotNull is true then an error will be generated if
+	// a zero value for this coumn is inserted/updated into a table
+	EnforceNotNull bool
+
+	DefaultValue string
+
+	// If Sequence is set, the column is filled from this database sequence
+	// on insert and the generated value is bound to the struct field.
+	// Requires a dialect implementing SequenceDialect.
+	Sequence string
+
+	// If ScanAs is set, values of this column are converted by the
+	// converter registered under this name with DbMap.AddScanConverter
+	ScanAs string
+
+	// If DbDefault is set, " default DbDefault" is added to create table
+	// statements.  A time.Time column with a DbDefault is left out of
+	// inserts while it holds the zero time, so the database fills it.
+	DbDefault string
+
+	// If ReadDefault is true, the value the database filled in for a
+	// DbDefault column is read back into the field after the insert.
+	// Requires a primary key.
+	ReadDefault bool
+
+	// If OmitEmpty is true, the column is left out of inserts while its
+	// field holds the zero value of its Go type, so the database default
+	// applies.  An explicitly set zero value can not be told apart and is
+	// left out too.
+	OmitEmpty bool
+
+	// If References is set, a foreign key constraint on the referenced
+	// column, given as "table.column" or "schema.table.column", is added
+	// to create table statements.  OnDelete and OnUpdate are its
+	// referential actions, see SetReferences.
+	References string
+	OnDelete   string
+	OnUpdate   string
+
+	fieldName      string
+	gotype         reflect.Type
+	selfConverting bool             // the field type has Value and Scan methods
+	crypto         *cryptoConverter // see SetCryptoTransform
+	nullSentinel   interface{}      // see SetNullSentinel
+	isPK           bool
+	isAutoIncr     bool
+	isNotNull      bool
+	table          *TableMap
+}
+
+// sqlType returns the type of the column in create table statements
+func (c *ColumnMap) sqlType(d Dialect) string {
+	if c.RawSQLType != "" {
+		return c.RawSQLType
+	}
+	if c.DbType != "" {
+		return c.DbType
+	}
+	return d.ToSqlType(c.gotype, c.MaxSize, c.isAutoIncr)
+}
+
+// IndexMap represents the data to create an index
+type IndexMap struct {
+	// Index name in db table
+	IndexName string
+
+	// If true, " unique" is added to the create index statement.
+	Unique bool
+
+	// Non key columns stored in the index, for dialects which
+	// implement IndexIncludeDialect.  Ignored by other dialects.
+	Include []string
+
+	// If true, the index is built without blocking writes to the table,
+	// for dialects which implement ConcurrentIndexDialect.  Ignored by
+	// other dialects.  As PostgreSQL can not build an index concurrently
+	// in a transaction, CreateIndexes never runs in one.
+	Concurrently bool
+
+	// List of fields for the index
+	fieldNames []string
+	gotype     reflect.Type
+}
+
+// This const is used to flag an index whos name should be autogenerated
+const autoGenerateIndexname string = "autogenerateindexname"
+
+// Rename allows you to specify the column name in the table
+//
+// Example:  table.ColMap("Updated").Rename("date_updated")
+//
+func (c *ColumnMap) Rename(colname string) *ColumnMap {
+	c.ColumnName = colname
+	return c
+}
+
+// SetTransient allows you to mark the column as transient. If true
+// this column will be skipped when SQL statements are generated.
+// It may be toggled after the table has been used, the cached statements
+// of the table are reset.
+func (c *ColumnMap) SetTransient(b bool) *ColumnMap {
+	c.Transient = b
+	if c.table != nil {
+		c.table.ResetSql()
+	}
+	return c
+}
+
+// SetUnique adds "unique" to the create table statements for this
+// column, if b is true.
+func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
+	c.Unique = b
+	return c
+}
+
+// SetNotNull adds "not null" to the create table statements for this
+// column, if nn is true.
+func (c *ColumnMap) SetNotNull(nn bool) *ColumnMap {
+	c.isNotNull = nn
+	return c
+}
+
+// SetSequence sets the database sequence used to fill this column on insert.
+//
+// Example:  table.ColMap("Id").SetSequence("invoice_id_seq")
+//
+func (c *ColumnMap) SetSequence(sequence string) *ColumnMap {
+	c.Sequence = sequence
+	return c
+}
+
+// SetScanAs sets the name of the scan converter for this column.
+//
+// Example:  table.ColMap("Amount").SetScanAs("rat")
+//
+func (c *ColumnMap) SetScanAs(name string) *ColumnMap {
+	c.ScanAs = name
+	return c
+}
+
+// SetCryptoTransform stores the column encrypted: values are written as
+// the result of encrypt and read through decrypt.  It replaces the
+// converters of the column and handles string and []byte fields, and
+// fields which are driver.Valuer and sql.Scanner of strings or bytes.
+// Unless a DbType is set, the column is created with the binary type of
+// the dialect.
+//
+// Example:  table.ColMap("CardNumber").SetCryptoTransform(encrypt, decrypt)
+//
+func (c *ColumnMap) SetCryptoTransform(encrypt func([]byte) ([]byte, error), decrypt func([]byte) ([]byte, error)) *ColumnMap {
+	c.crypto = &cryptoConverter{encrypt, decrypt}
+	if c.DbType == "" && c.table != nil {
+		c.DbType = c.table.dbmap.Dialect.ToSqlType(bytesType, 0, false)
+	}
+	return c
+}
+
+// SetNullSentinel declares value as the NULL of a legacy column, e.g. ""
+// or 0 or a time like 1900-01-01.  A column holding value is read as the
+// zero value of the field, nil for pointer fields, and a zero value or nil
+// is written as value.  A nil value removes the sentinel.  Panics if value
+// can not be compared with the field type.
+//
+// Example:  table.ColMap("Closed").SetNullSentinel(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))
+//
+func (c *ColumnMap) SetNullSentinel(value interface{}) *ColumnMap {
+	if value != nil && c.gotype != nil {
+		t := c.gotype
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if !sentinelFits(value, t) {
+			panic(fmt.Sprintf("gorp: SetNullSentinel: %T sentinel does not fit field %s of type %s", value, c.fieldName, c.gotype))
+		}
+	}
+	c.nullSentinel = value
+	return c
+}
 
-		for y := range t.Columns {
-			col := t.Columns[y]
-			if !col.Transient {
-				if col == t.version {
-					plan.versField = col.fieldName
-				}
-			}
+// SetDbDefault sets the database default of this column.
+//
+// Example:  table.ColMap("Created").SetDbDefault("CURRENT_TIMESTAMP")
+//
+func (c *ColumnMap) SetDbDefault(expr string) *ColumnMap {
+	c.DbDefault = expr
+	return c
+}
+
+// SetReadDefault reads the value the database filled in for the DbDefault
+// of this column back into the field after Insert, if b is true.
+func (c *ColumnMap) SetReadDefault(b bool) *ColumnMap {
+	c.ReadDefault = b
+	return c
+}
+
+// SetReferences adds a foreign key constraint on ref, given as
+// "table.column", to the create table statements for this column.  The
+// referential actions onDelete and onUpdate may be empty, or one of
+// "cascade", "set null", "set default", "restrict" and "no action".  It
+// panics for other actions.  Not all dialects support all actions, e.g.
+// Oracle has no on update actions.
+//
+// Example:  table.ColMap("ParentId").SetReferences("parent.id", "cascade", "")
+//
+func (c *ColumnMap) SetReferences(ref, onDelete, onUpdate string) *ColumnMap {
+	c.References = ref
+	c.OnDelete = referentialAction(onDelete)
+	c.OnUpdate = referentialAction(onUpdate)
+	return c
+}
+
+// referentialActions are the valid on delete and on update actions of
+// foreign keys
+var referentialActions = []string{"cascade", "set null", "set default", "restrict", "no action"}
+
+// referentialAction returns action in lower case with single spaces, so
+// "SET_NULL" becomes "set null".  It panics if action is not empty and not
+// one of referentialActions.
+func referentialAction(action string) string {
+	a := strings.Join(strings.Fields(strings.ToLower(strings.Replace(action, "_", " ", -1))), " ")
+	if a == "" {
+		return ""
+	}
+	for _, valid := range referentialActions {
+		if a == valid {
+			return a
 		}
+	}
+	panic(fmt.Sprintf("gorp: invalid referential action '%s', expected one of %v", action, referentialActions))
+}
 
-		s.WriteString(" where ")
-		for x := range t.keys {
-			k := t.keys[x]
-			if x > 0 {
-				s.WriteString(" and ")
-			}
-			s.WriteString(t.dbmap.Dialect.QuoteField(k.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(x))
+// foreignKeyClause returns the foreign key constraint of col for create
+// table statements
+func (t *TableMap) foreignKeyClause(col *ColumnMap) string {
+	dialect := t.dbmap.Dialect
+	parts := strings.Split(col.References, ".")
+	var schema, table, column string
+	switch len(parts) {
+	case 1:
+		table = parts[0]
+	case 2:
+		table, column = parts[0], parts[1]
+	default:
+		schema, table, column = strings.Join(parts[:len(parts)-2], "."), parts[len(parts)-2], parts[len(parts)-1]
+	}
+	s := fmt.Sprintf(", foreign key (%s) references %s", dialect.QuoteField(col.ColumnName), dialect.QuotedTableForQuery(schema, table))
+	if column != "" {
+		s += fmt.Sprintf(" (%s)", dialect.QuoteField(column))
+	}
+	if col.OnDelete != "" {
+		s += " on delete " + col.OnDelete
+	}
+	if col.OnUpdate != "" {
+		s += " on update " + col.OnUpdate
+	}
+	return s
+}
 
-			plan.keyFields = append(plan.keyFields, k.fieldName)
-			plan.argFields = append(plan.argFields, k.fieldName)
+// SetOmitEmpty leaves the column out of inserts while its field holds the
+// zero value, if b is true.  See OmitEmpty.
+func (c *ColumnMap) SetOmitEmpty(b bool) *ColumnMap {
+	c.OmitEmpty = b
+	return c
+}
+
+// dbFilled returns true if the database fills the column on insert for
+// the value v of its field
+func (c *ColumnMap) dbFilled(v reflect.Value) bool {
+	if c.OmitEmpty && v.IsZero() {
+		return true
+	}
+	if c.DbDefault == "" {
+		return false
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v.Type().Elem() == timeType
 		}
-		if plan.versField != "" {
-			s.WriteString(" and ")
-			s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(len(plan.argFields)))
+		v = v.Elem()
+	}
+	t, ok := v.Interface().(time.Time)
+	return ok && t.IsZero()
+}
 
-			plan.argFields = append(plan.argFields, plan.versField)
+// SetMaxSize specifies the max length of values of this column. This is
+// passed to the dialect.ToSqlType() function, which can use the value
+// to alter the generated type for "create table" statements
+func (c *ColumnMap) SetMaxSize(size int) *ColumnMap {
+	c.MaxSize = size
+	return c
+}
+
+// Transaction represents a database transaction.
+// Insert/Update/Delete/Get/Exec operations will be run in the context
+// of that transaction.  Transactions should be terminated with
+// a call to Commit() or Rollback()
+type Transaction struct {
+	dbmap  *DbMap
+	tx     *sql.Tx
+	closed bool
+	opts   *sql.TxOptions // options the transaction was started with, nil for Begin()
+}
+
+// Executor exposes the sql.DB and sql.Tx Exec function so that it can be used
+// on internal functions that convert named parameters for the Exec function.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SqlExecutor exposes gorp operations that can be run from Pre/Post
+// hooks.  This hides whether the current operation that triggered the
+// hook is in a transaction.
+//
+// See the DbMap function docs for each of the functions below for more
+// information.
+type SqlExecutor interface {
+	Get(i interface{}, keys ...interface{}) (interface{}, error)
+	Refresh(ptr interface{}) error
+	GetByExample(i interface{}) (interface{}, error)
+	Insert(list ...interface{}) error
+	Update(list ...interface{}) (int64, error)
+	UpdateWhere(ptr interface{}, where string, arg ...interface{}) (int64, error)
+	Delete(list ...interface{}) (int64, error)
+	DeleteWhere(i interface{}, where string, arg ...interface{}) (int64, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Notify(channel string, payload string) error
+	Select(i interface{}, query string,
+		args ...interface{}) ([]interface{}, error)
+	SelectInto(dest interface{}, query string, args ...interface{}) error
+	SelectChan(holderType interface{}, ch interface{}, query string, args ...interface{}) error
+	SelectChanContext(ctx context.Context, holderType interface{}, ch interface{}, query string, args ...interface{}) error
+	SelectBatchIter(holder interface{}, batchSize int, fn func(batch []interface{}) error, query string, args ...interface{}) error
+	SelectToMap(dest interface{}, keyColumn string, query string, args ...interface{}) error
+	SelectInt(query string, args ...interface{}) (int64, error)
+	SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error)
+	SelectFloat(query string, args ...interface{}) (float64, error)
+	SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error)
+	SelectStr(query string, args ...interface{}) (string, error)
+	SelectStrLimit1(query string, args ...interface{}) (string, error)
+	SelectNullStr(query string, args ...interface{}) (sql.NullString, error)
+	SelectCount(query string, args ...interface{}) (int64, error)
+	SelectJSON(query string, args ...interface{}) ([]byte, error)
+	SelectRow(query string, args ...interface{}) RowScanner
+	SelectOne(holder interface{}, query string, args ...interface{}) error
+	SelectOneTo(holder interface{}, query string, args ...interface{}) error
+	SelectJoin(holders []JoinHolder, query string, args ...interface{}) error
+	query(query string, args ...interface{}) (*timeoutRows, error)
+	queryRow(query string, args ...interface{}) *timeoutRow
+	dbMap() *DbMap
+	execFields(fields *argFields, query string, args ...interface{}) (sql.Result, error)
+	queryFields(fields *argFields, query string, args ...interface{}) (*timeoutRows, error)
+	queryRowFields(fields *argFields, query string, args ...interface{}) *timeoutRow
+}
+
+// Compile-time check that DbMap and Transaction implement the SqlExecutor
+// interface.
+var _, _ SqlExecutor = &DbMap{}, &Transaction{}
+
+type GorpLogger interface {
+	Printf(format string, v ...interface{})
+}
+
+// TraceOn turns on SQL statement logging for this DbMap.  After this is
+// called, all SQL statements will be sent to the logger.  If prefix is
+// a non-empty string, it will be written to the front of all logged
+// strings, which can aid in filtering log lines.
+//
+// Use TraceOn if you want to spy on the SQL statements that gorp
+// generates.
+//
+// Note that the base log.Logger type satisfies GorpLogger, but adapters can
+// easily be written for other logging packages (e.g., the golang-sanctioned
+// glog framework).
+func (m *DbMap) TraceOn(prefix string, logger GorpLogger) {
+	m.logger = logger
+	if prefix == "" {
+		m.logPrefix = prefix
+	} else {
+		m.logPrefix = fmt.Sprintf("%s ", prefix)
+	}
+}
+
+// SetArgRedactor sets a function which masks sensitive bind parameters in
+// the log of TraceOn.  Pass nil to log all parameters as they are.
+//
+// Example:
+//
+//     dbmap.SetArgRedactor(func(colName string, value interface{}) interface{} {
+//         if colName == "password" {
+//             return "***"
+//         }
+//         return value
+//     })
+//
+func (m *DbMap) SetArgRedactor(f ArgRedactor) {
+	m.argRedactor = f
+}
+
+// redactArgs returns args with the arg redactor applied, cols are the
+// column names of args if known
+func (m *DbMap) redactArgs(cols []string, args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		colName := ""
+		if i < len(cols) {
+			colName = cols[i]
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+		redacted[i] = m.argRedactor(colName, arg)
+	}
+	return redacted
+}
 
-		plan.query = s.String()
-		t.deletePlan = plan
+// argFields are the fields of table bound to the args of a statement gorp
+// generates, an empty field name for an arg of no field.  They are passed
+// to trace next to the args, so that the ArgRedactor gets the column names
+// of the args.
+type argFields struct {
+	table  *TableMap
+	fields []string
+}
+
+// columns returns the column names of the fields, nil if af is nil
+func (af *argFields) columns() []string {
+	if af == nil {
+		return nil
 	}
+	cols := make([]string, len(af.fields))
+	for i, fieldName := range af.fields {
+		if fieldName == versFieldConst {
+			fieldName = af.table.version.fieldName
+		}
+		if col := colMapForField(af.table, fieldName); col != nil {
+			cols[i] = col.ColumnName
+		}
+	}
+	return cols
+}
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+// SetScanInterceptor sets a function which may modify every field value
+// read by Get and Select, e.g. to trim the padding of CHAR columns.
+// Pass nil to remove the interceptor.
+//
+// Example:
+//
+//     dbmap.SetScanInterceptor(func(col *gorp.ColumnMap, value interface{}) interface{} {
+//         if s, ok := value.(string); ok {
+//             return strings.TrimRight(s, " ")
+//         }
+//         return value
+//     })
+//
+func (m *DbMap) SetScanInterceptor(f ScanInterceptor) {
+	m.scanInterceptor = f
 }
 
-func (t *TableMap) bindGet() bindPlan {
-	plan := t.getPlan
-	if plan.query == "" {
+// AddScanConverter registers conv under name for columns tagged with the
+// "scan" option or set with ColumnMap.SetScanAs.  For these columns conv
+// is used instead of the TypeConverter of the DbMap.  The names "rat",
+// "epoch_seconds", "epoch_millis", "date", "bit", "inet" and "macaddr"
+// select RatConverter, EpochConverter, DateConverter, BitConverter and
+// NetConverter unless they are registered otherwise.
+//
+// Example:
+//
+//     dbmap.AddScanConverter("money", MoneyConverter{})
+//
+//     type Order struct {
+//         Total Money `db:"total, type:numeric(12,2), scan:money"`
+//     }
+//
+func (m *DbMap) AddScanConverter(name string, conv TypeConverter) {
+	if m.scanConverters == nil {
+		m.scanConverters = make(map[string]TypeConverter)
+	}
+	m.scanConverters[name] = conv
+}
 
-		s := bytes.Buffer{}
-		s.WriteString("select ")
+// scanConverter returns the converter registered for name
+func (m *DbMap) scanConverter(name string) (TypeConverter, error) {
+	if conv, ok := m.scanConverters[name]; ok {
+		return conv, nil
+	}
+	switch name {
+	case "rat":
+		return RatConverter{}, nil
+	case "epoch_seconds":
+		return EpochConverter{}, nil
+	case "epoch_millis":
+		return EpochConverter{Millis: true}, nil
+	case "date":
+		return DateConverter{}, nil
+	case "inet", "macaddr":
+		_, text := m.Dialect.(PostgresDialect)
+		return NetConverter{Text: text}, nil
+	}
+	return nil, fmt.Errorf("gorp: no scan converter registered for '%s'", name)
+}
 
-		x := 0
-		for _, col := range t.Columns {
-			if !col.Transient {
-				if x > 0 {
-					s.WriteString(",")
-				}
-				s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
-				plan.argFields = append(plan.argFields, col.fieldName)
-				x++
+// intEnum is the range of values of an integer enum type
+type intEnum struct {
+	min, max int64
+}
+
+// RegisterIntEnum declares t, an integer type with constants from min to
+// max, as enum.  CreateTables adds a check constraint limiting columns of
+// type t to that range, and Select returns an error if it reads a value
+// outside of it.  Register enums before adding the tables using them.
+//
+// Panics if t is not an integer type or min is greater than max.
+//
+// Example:  dbmap.RegisterIntEnum(reflect.TypeOf(Red), int64(Red), int64(Blue))
+//
+func (m *DbMap) RegisterIntEnum(t reflect.Type, min, max int64) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		panic(fmt.Sprintf("gorp: RegisterIntEnum: %s is not an integer type", t))
+	}
+	if min > max {
+		panic(fmt.Sprintf("gorp: RegisterIntEnum: min %d of %s is greater than max %d", min, t, max))
+	}
+	if m.intEnums == nil {
+		m.intEnums = make(map[reflect.Type]intEnum)
+	}
+	m.intEnums[t] = intEnum{min, max}
+}
+
+// checkClause returns the check constraint of col if it is a registered
+// enum, or ""
+func (t *TableMap) checkClause(col *ColumnMap) string {
+	gotype := col.gotype
+	if gotype.Kind() == reflect.Ptr {
+		gotype = gotype.Elem()
+	}
+	e, ok := t.dbmap.intEnums[gotype]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" check (%s between %d and %d)", t.dbmap.Dialect.QuoteField(col.ColumnName), e.min, e.max)
+}
+
+// enumScanner returns a CustomScanner which reads a column into the enum
+// field target points to and checks it is in the range of e.  NULL sets
+// the zero value if nullAsZero is true and is an error otherwise.
+func enumScanner(target interface{}, e intEnum, nullAsZero bool) CustomScanner {
+	binder := func(holder, target interface{}) error {
+		field := reflect.ValueOf(target).Elem()
+		v := holder.(*sql.NullInt64)
+		if !v.Valid {
+			if !nullAsZero {
+				return fmt.Errorf("gorp: cannot scan NULL into enum %s", field.Type())
 			}
+			field.Set(reflect.Zero(field.Type()))
+			return nil
 		}
-		s.WriteString(" from ")
-		s.WriteString(t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName))
-		s.WriteString(" where ")
-		for x := range t.keys {
-			col := t.keys[x]
-			if x > 0 {
-				s.WriteString(" and ")
-			}
-			s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(x))
-
-			plan.keyFields = append(plan.keyFields, col.fieldName)
+		if v.Int64 < e.min || v.Int64 > e.max {
+			return fmt.Errorf("gorp: value %d out of range %d to %d of enum %s", v.Int64, e.min, e.max, field.Type())
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+		switch field.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.SetUint(uint64(v.Int64))
+		default:
+			field.SetInt(v.Int64)
+		}
+		return nil
+	}
+	return CustomScanner{new(sql.NullInt64), target, binder}
+}
 
-		plan.query = s.String()
-		t.getPlan = plan
+// interceptScan runs the scan interceptor, if any, on the field f
+func (m *DbMap) interceptScan(col *ColumnMap, f reflect.Value) error {
+	if m.scanInterceptor == nil || col == nil {
+		return nil
+	}
+	v := m.scanInterceptor(col, f.Interface())
+	if v == nil {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
 	}
+	val := reflect.ValueOf(v)
+	if !val.Type().AssignableTo(f.Type()) {
+		return fmt.Errorf("gorp: scan interceptor returned %v for column %s, which is not assignable to %v", val.Type(), col.ColumnName, f.Type())
+	}
+	f.Set(val)
+	return nil
+}
 
-	return plan
+// SetMetricsCallback sets a function called after every statement, e.g. to
+// export query counts and durations.  Pass nil to remove the callback.
+//
+// Example:
+//
+//     dbmap.SetMetricsCallback(func(op string, dur time.Duration, err error) {
+//         queryDuration.WithLabelValues(op).Observe(dur.Seconds())
+//     })
+//
+func (m *DbMap) SetMetricsCallback(f MetricsCallback) {
+	m.metricsCallback = f
 }
 
-// ColumnMap represents a mapping between a Go struct field and a single
-// column in a table.
-// Unique and MaxSize only inform the
-// CreateTables() function and are not used by Insert/Update/Delete/Get.
-type ColumnMap struct {
-	// Column name in db table
-	ColumnName string
+// metrics calls the metrics callback, if any, for query
+func (m *DbMap) metrics(started time.Time, query string, err error) {
+	if m.metricsCallback == nil {
+		return
+	}
+	m.metricsCallback(queryOp(query), time.Now().Sub(started), err)
+}
 
-	// If true, this column is skipped in generated SQL statements
-	Transient bool
+// queryOp returns the lower case first keyword of query
+func queryOp(query string) string {
+	words := strings.Fields(strings.TrimLeft(query, " \t\r\n("))
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(words[0], ";"))
+}
 
-	// If true, " unique" is added to create table statements.
-	// Not used elsewhere
-	Unique bool
+// SetQueryTimeout sets the time after which a statement is cancelled.
+// A statement which is cancelled returns a *QueryTimeoutError.  For selects
+// the timeout also covers reading the rows.  Zero, the default, disables
+// the timeout.
+func (m *DbMap) SetQueryTimeout(d time.Duration) {
+	m.queryTimeout = d
+}
 
-	// Passed to Dialect.ToSqlType() to assist in informing the
-	// correct column type to map to in CreateTables()
-	MaxSize int
+var pragmaRegexp = regexp.MustCompile(`^-?[[:word:]]+(\.[[:word:]]+)?$`)
 
-	//DbType overrides the conversion from go types to dab types
-	DbType string
+// ApplyPragmas runs "pragma key = value" for each entry of pragmas, e.g.
+// {"foreign_keys": "on", "journal_mode": "wal"}.  It is only supported by
+// SqliteDialect.  Pragmas like foreign_keys are settings of a connection,
+// and database/sql keeps a pool of them: set them in the DSN, if the driver
+// supports that, or limit the pool to one connection with
+// Db.SetMaxOpenConns(1).  Keys and values must be plain words or numbers.
+func (m *DbMap) ApplyPragmas(pragmas map[string]string) error {
+	if _, ok := m.Dialect.(SqliteDialect); !ok {
+		return fmt.Errorf("gorp: ApplyPragmas is not supported by %T", m.Dialect)
+	}
+	keys := make([]string, 0, len(pragmas))
+	for key, value := range pragmas {
+		if !pragmaRegexp.MatchString(key) || !pragmaRegexp.MatchString(value) {
+			return fmt.Errorf("gorp: invalid pragma %s = %s", key, value)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, err := m.Exec(fmt.Sprintf("pragma %s = %s", key, pragmas[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// If EnforceNotNull is true then an error will be generated if
-	// a zero value for this coumn is inserted/updated into a table
-	EnforceNotNull bool
+// NewInitConnector returns a driver.Connector for sql.OpenDB, which opens
+// connections to dsn with drv and runs the statements stmts on each new
+// connection.  Use it for settings of a connection, which database/sql
+// would otherwise only apply to one connection of its pool, e.g. the
+// connection character set of MySQL:
+//
+//     connector, err := gorp.NewInitConnector(&mysql.MySQLDriver{}, dsn, dialect.SetNamesSql())
+//     dbmap := &gorp.DbMap{Db: sql.OpenDB(connector), Dialect: dialect}
+//
+func NewInitConnector(drv driver.Driver, dsn string, stmts ...string) (driver.Connector, error) {
+	c := &initConnector{drv: drv, dsn: dsn, stmts: stmts}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		var err error
+		c.connector, err = dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
 
-	DefaultValue string
+// initConnector is the driver.Connector of NewInitConnector
+type initConnector struct {
+	drv       driver.Driver
+	connector driver.Connector // connector of drv, if it has one
+	dsn       string
+	stmts     []string
+}
+
+// Connect opens a connection and runs the statements of c on it
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if c.connector != nil {
+		conn, err = c.connector.Connect(ctx)
+	} else {
+		conn, err = c.drv.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range c.stmts {
+		err = execConn(ctx, conn, stmt)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("gorp: connection init %q: %v", stmt, err)
+		}
+	}
+	return conn, nil
+}
 
-	fieldName  string
-	gotype     reflect.Type
-	isPK       bool
-	isAutoIncr bool
-	isNotNull  bool
+func (c *initConnector) Driver() driver.Driver {
+	return c.drv
 }
 
-// IndexMap represents the data to create an index
-type IndexMap struct {
-	// Index name in db table
-	IndexName string
+// execConn runs stmt without arguments on the driver connection conn
+func execConn(ctx context.Context, conn driver.Conn, stmt string) error {
+	if e, ok := conn.(driver.ExecerContext); ok {
+		_, err := e.ExecContext(ctx, stmt, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	st, err := conn.Prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	if sc, ok := st.(driver.StmtExecContext); ok {
+		_, err = sc.ExecContext(ctx, nil)
+	} else {
+		_, err = st.Exec(nil)
+	}
+	return err
+}
 
-	// If true, " unique" is added to the create index statement.
-	Unique bool
+// SetDDLTransaction sets if CreateTables, CreateTablesIfNotExists,
+// DropTables and DropTablesIfExists run their statements in a transaction,
+// overriding the default of the dialect, see TransactionalDDLDialect.
+// Disable it e.g. behind a connection pooler in transaction mode.
+func (m *DbMap) SetDDLTransaction(b bool) {
+	m.ddlTransaction = &b
+}
 
-	// List of fields for the index
-	fieldNames []string
-	gotype     reflect.Type
+// runDDL runs f with a transaction if DDL statements are run in one, see
+// SetDDLTransaction, otherwise with m
+func (m *DbMap) runDDL(f func(exec SqlExecutor) error) error {
+	wrap := false
+	if m.ddlTransaction != nil {
+		wrap = *m.ddlTransaction
+	} else if d, ok := m.Dialect.(TransactionalDDLDialect); ok {
+		wrap = d.TransactionalDDL()
+	}
+	if !wrap {
+		return f(m)
+	}
+	trans, err := m.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(trans)
+	if err != nil {
+		trans.Rollback()
+		return err
+	}
+	return trans.Commit()
 }
 
-// This const is used to flag an index whos name should be autogenerated
-const autoGenerateIndexname string = "autogenerateindexname"
+// timeoutContext returns the context to run a statement with
+func (m *DbMap) timeoutContext() (context.Context, context.CancelFunc) {
+	if m.queryTimeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), m.queryTimeout)
+}
 
-// Rename allows you to specify the column name in the table
-//
-// Example:  table.ColMap("Updated").Rename("date_updated")
-//
-func (c *ColumnMap) Rename(colname string) *ColumnMap {
-	c.ColumnName = colname
-	return c
+// timeoutError returns a *QueryTimeoutError if err is caused by the
+// deadline of ctx, else err
+func (m *DbMap) timeoutError(ctx context.Context, query string, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &QueryTimeoutError{Query: query, Timeout: m.queryTimeout}
+	}
+	return err
 }
 
-// SetTransient allows you to mark the column as transient. If true
-// this column will be skipped when SQL statements are generated
-func (c *ColumnMap) SetTransient(b bool) *ColumnMap {
-	c.Transient = b
-	return c
+// timeoutRows are the rows of a query run with the context of
+// timeoutContext.  Close releases the context, and errors of reading the
+// rows caused by its deadline are returned as *QueryTimeoutError.
+type timeoutRows struct {
+	*sql.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+	dbmap  *DbMap
+	query  string
 }
 
-// SetUnique adds "unique" to the create table statements for this
-// column, if b is true.
-func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
-	c.Unique = b
-	return c
+func (r *timeoutRows) Scan(dest ...interface{}) error {
+	return r.dbmap.timeoutError(r.ctx, r.query, r.Rows.Scan(dest...))
 }
 
-// SetNotNull adds "not null" to the create table statements for this
-// column, if nn is true.
-func (c *ColumnMap) SetNotNull(nn bool) *ColumnMap {
-	c.isNotNull = nn
-	return c
+func (r *timeoutRows) Err() error {
+	return r.dbmap.timeoutError(r.ctx, r.query, r.Rows.Err())
 }
 
-// SetMaxSize specifies the max length of values of this column. This is
-// passed to the dialect.ToSqlType() function, which can use the value
-// to alter the generated type for "create table" statements
-func (c *ColumnMap) SetMaxSize(size int) *ColumnMap {
-	c.MaxSize = size
-	return c
+func (r *timeoutRows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
 }
 
-// Transaction represents a database transaction.
-// Insert/Update/Delete/Get/Exec operations will be run in the context
-// of that transaction.  Transactions should be terminated with
-// a call to Commit() or Rollback()
-type Transaction struct {
+// timeoutRow is the row of a query run with the context of
+// timeoutContext.  Scan releases the context and returns errors caused by
+// its deadline as *QueryTimeoutError.
+type timeoutRow struct {
+	*sql.Row
+	ctx    context.Context
+	cancel context.CancelFunc
 	dbmap  *DbMap
-	tx     *sql.Tx
-	closed bool
+	query  string
 }
 
-// Executor exposes the sql.DB and sql.Tx Exec function so that it can be used
-// on internal functions that convert named parameters for the Exec function.
-type executor interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
+func (r *timeoutRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.dbmap.timeoutError(r.ctx, r.query, r.Row.Scan(dest...))
 }
 
-// SqlExecutor exposes gorp operations that can be run from Pre/Post
-// hooks.  This hides whether the current operation that triggered the
-// hook is in a transaction.
-//
-// See the DbMap function docs for each of the functions below for more
-// information.
-type SqlExecutor interface {
-	Get(i interface{}, keys ...interface{}) (interface{}, error)
-	Insert(list ...interface{}) error
-	Update(list ...interface{}) (int64, error)
-	Delete(list ...interface{}) (int64, error)
-	Exec(query string, args ...interface{}) (sql.Result, error)
-	Select(i interface{}, query string,
-		args ...interface{}) ([]interface{}, error)
-	SelectInt(query string, args ...interface{}) (int64, error)
-	SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error)
-	SelectFloat(query string, args ...interface{}) (float64, error)
-	SelectNullFloat(query string, args ...interface{}) (sql.NullFloat64, error)
-	SelectStr(query string, args ...interface{}) (string, error)
-	SelectNullStr(query string, args ...interface{}) (sql.NullString, error)
-	SelectOne(holder interface{}, query string, args ...interface{}) error
-	query(query string, args ...interface{}) (*sql.Rows, error)
-	queryRow(query string, args ...interface{}) *sql.Row
+// TraceOff turns off tracing. It is idempotent.
+func (m *DbMap) TraceOff() {
+	m.logger = nil
+	m.logPrefix = ""
 }
 
-// Compile-time check that DbMap and Transaction implement the SqlExecutor
-// interface.
-var _, _ SqlExecutor = &DbMap{}, &Transaction{}
-
-type GorpLogger interface {
-	Printf(format string, v ...interface{})
+// Clone returns a copy of the DbMap with a configuration of its own, e.g.
+// to set a logger, query timeout or DebugLevel per request.  The copy
+// shares the database handle and the TableMaps with m.  Tables added to one
+// of both afterwards are not seen by the other, changes to a shared
+// TableMap are.  Scan converters and enums are copied, but the tables
+// resolve their converters through the DbMap they were added to, so
+// register converters, enums, TypeConverter and AutoJSON before cloning.
+//
+// Clone reads the configuration of m, which must not be changed at the
+// same time.  Afterwards m and the copy can be configured and used by
+// different goroutines independently.
+func (m *DbMap) Clone() *DbMap {
+	clone := *m
+	clone.tables = append([]*TableMap(nil), m.tables...)
+	if m.scanConverters != nil {
+		clone.scanConverters = make(map[string]TypeConverter, len(m.scanConverters))
+		for name, conv := range m.scanConverters {
+			clone.scanConverters[name] = conv
+		}
+	}
+	if m.intEnums != nil {
+		clone.intEnums = make(map[reflect.Type]intEnum, len(m.intEnums))
+		for t, e := range m.intEnums {
+			clone.intEnums[t] = e
+		}
+	}
+	clone.LastOpInfo = CRUDInfo{}
+	clone.snapshots = nil
+	return &clone
 }
 
-// TraceOn turns on SQL statement logging for this DbMap.  After this is
-// called, all SQL statements will be sent to the logger.  If prefix is
-// a non-empty string, it will be written to the front of all logged
-// strings, which can aid in filtering log lines.
+// WithSchema returns a copy of the DbMap whose generated SQL uses schema
+// for all mapped tables, e.g. to serve a request of a tenant with a schema
+// of its own.  The copy shares the database handle and the column mappings
+// with m, m itself is not changed.  Tables added to m afterwards are not
+// seen by the copy.  SQL passed to Select or Exec is not rewritten.
 //
-// Use TraceOn if you want to spy on the SQL statements that gorp
-// generates.
+// Example:
 //
-// Note that the base log.Logger type satisfies GorpLogger, but adapters can
-// easily be written for other logging packages (e.g., the golang-sanctioned
-// glog framework).
-func (m *DbMap) TraceOn(prefix string, logger GorpLogger) {
-	m.logger = logger
-	if prefix == "" {
-		m.logPrefix = prefix
-	} else {
-		m.logPrefix = fmt.Sprintf("%s ", prefix)
+//     tenant := dbmap.WithSchema("tenant_42")
+//     obj, err := tenant.Get(Invoice{}, id)
+//
+func (m *DbMap) WithSchema(schema string) *DbMap {
+	clone := m.Clone()
+	cloned := make(map[*TableMap]*TableMap, len(m.tables))
+	for i, t := range m.tables {
+		ct := *t
+		ct.SchemaName = schema
+		ct.dbmap = clone
+		ct.ResetSql()
+		clone.tables[i] = &ct
+		cloned[t] = &ct
+	}
+	for _, ct := range clone.tables {
+		relations := make([]*RelationMap, len(ct.Relations))
+		for i, r := range ct.Relations {
+			cr := *r
+			if detail, ok := cloned[r.DetailTable]; ok {
+				cr.DetailTable = detail
+			}
+			relations[i] = &cr
+		}
+		ct.Relations = relations
 	}
+	return clone
 }
 
-// TraceOff turns off tracing. It is idempotent.
-func (m *DbMap) TraceOff() {
-	m.logger = nil
-	m.logPrefix = ""
+// SetTableNameCase sets the case of the table names AddTable derives from
+// struct names, e.g. LowerCase so a struct InvoiceTest maps to the table
+// invoicetest with every dialect, whatever case it folds quoted names to.
+// DialectCase and PreserveCase keep the struct name.  Names passed to
+// AddTableWithName are used as they are.  Only tables added afterwards are
+// affected.
+func (m *DbMap) SetTableNameCase(c IdentifierCase) {
+	m.tableNameCase = c
 }
 
 // AddTable registers the given interface type with gorp. The table name
-// will be given the name of the TypeOf(i).  You must call this function,
+// will be given the name of the TypeOf(i), see SetTableNameCase.  You must call this function,
 // or AddTableWithName, for any struct type you wish to persist with
 // the given DbMap.
 //
@@ -941,7 +3494,7 @@ func (m *DbMap) AddTableWithName(i interface{}, name string) *TableMap {
 func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name string) *TableMap {
 	t := reflect.TypeOf(i)
 	if name == "" {
-		name = t.Name()
+		name = m.tableNameCase.fold(t.Name(), PreserveCase)
 	}
 
 	// check if we have a table for this type already
@@ -965,6 +3518,17 @@ func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name str
 	tmap := &TableMap{gotype: t, TableName: name, SchemaName: schema, dbmap: m}
 
 	tmap.Columns = m.readStructColumns(t, tmap)
+	tmap.checkColumnNames()
+	tmap.keysFromTags = len(tmap.keys) > 0
+	if len(tmap.keys) > 1 {
+		for _, k := range tmap.keys {
+			if k.isAutoIncr {
+				panic(fmt.Sprintf(
+					"gorp: AddTable: table %s has %d primary key fields, an auto-increment key must be the only key",
+					name, len(tmap.keys)))
+			}
+		}
+	}
 
 	m.tables = append(m.tables, tmap)
 	if m.DebugLevel > 3 {
@@ -979,6 +3543,69 @@ func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name str
 	return tmap
 }
 
+// AddPartialTable maps the type of i to the table of the already mapped
+// type of full, e.g. a struct with a subset of the columns for a list, or
+// one with extra fields filled by a select.  Fields of i without a column
+// in the full mapping are transient.  The keys and the version column are
+// taken from the full mapping when AddPartialTable is called, so i needs
+// fields for them and SetKeys must be called on full before.  Insert, Update
+// and Delete only write the columns mapped by i, the other columns keep
+// their default or current value.  Partial tables are skipped by
+// CreateTables, CreateIndexes, DropTables and TruncateTables.
+//
+// Panics if the type of full is not mapped or i has no field for one of
+// its key or version columns.
+//
+// Example:  dbmap.AddPartialTable(InvoiceSummary{}, Invoice{})
+//
+func (m *DbMap) AddPartialTable(i interface{}, full interface{}) *TableMap {
+	ft, err := toType(full)
+	if err != nil {
+		panic(fmt.Sprintf("gorp: AddPartialTable: %s", err))
+	}
+	fullMap := tableOrNil(m, ft)
+	if fullMap == nil {
+		panic(fmt.Sprintf("gorp: AddPartialTable: type %s is not mapped", ft.Name()))
+	}
+	if fullMap.partialOf != nil {
+		fullMap = fullMap.partialOf
+	}
+
+	tmap := m.AddTableWithNameAndSchema(i, fullMap.SchemaName, fullMap.TableName)
+	tmap.SchemaName = fullMap.SchemaName
+	tmap.partialOf = fullMap
+	for _, col := range tmap.Columns {
+		col.isPK = false
+		col.isAutoIncr = false
+		if !col.Transient && colMapOrNil(fullMap, col.ColumnName) == nil {
+			col.Transient = true
+		}
+	}
+
+	partialCol := func(fcol *ColumnMap) *ColumnMap {
+		for _, col := range tmap.Columns {
+			if !col.Transient && strings.EqualFold(col.ColumnName, fcol.ColumnName) {
+				return col
+			}
+		}
+		panic(fmt.Sprintf("gorp: AddPartialTable: type %s has no field for column %s of table %s",
+			tmap.gotype.Name(), fcol.ColumnName, fullMap.TableName))
+	}
+	tmap.keys = make([]*ColumnMap, 0, len(fullMap.keys))
+	for _, fkey := range fullMap.keys {
+		col := partialCol(fkey)
+		col.isPK = true
+		col.isAutoIncr = fkey.isAutoIncr
+		tmap.keys = append(tmap.keys, col)
+	}
+	tmap.version = nil
+	if fullMap.version != nil {
+		tmap.version = partialCol(fullMap.version)
+	}
+	tmap.ResetSql()
+	return tmap
+}
+
 func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnMap) {
 
 	// Create slice for primary keys - initially empty
@@ -1012,6 +3639,38 @@ func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnM
 				pt.ColumnName = strings.Trim(strings.Split(f.Name, ",")[0], " ")
 			}
 
+			// Unexported fields can not be set by reflection, so they can never
+			// be mapped to a column.  Untagged ones are skipped with a warning
+			// to the trace logger, or the log package if DebugLevel is set,
+			// tagged ones (other than "-") are a mapping error.
+			if f.PkgPath != "" && !pt.Transient {
+				if f.Tag.Get("gorp") != "" || f.Tag.Get("db") != "" {
+					panic(fmt.Sprintf("gorp: AddTable: field %s.%s is unexported and can not be mapped to column '%s'. Export the field or tag it with `db:\"-\"`",
+						t.Name(), f.Name, pt.ColumnName))
+				}
+				if m.logger != nil {
+					m.logger.Printf("%sgorp: AddTable: skipping unexported field %s.%s, tag it with `db:\"-\"` to silence this warning", m.logPrefix, t.Name(), f.Name)
+				} else if m.DebugLevel > 0 {
+					log.Printf("gorp: AddTable: skipping unexported field %s.%s, tag it with `db:\"-\"` to silence this warning\n", t.Name(), f.Name)
+				}
+				pt.Transient = true
+			}
+
+			// Expanded struct fields map each of their fields to a column
+			if pt.Expand && !pt.Transient {
+				if f.Type.Kind() != reflect.Struct {
+					panic(fmt.Sprintf("gorp: AddTable: field %s.%s is tagged expand, but is not a struct", t.Name(), f.Name))
+				}
+				keys := tm.keys
+				subcols := m.readStructColumns(f.Type, tm)
+				tm.keys = keys
+				for _, subcol := range subcols {
+					subcol.fieldName = f.Name + "." + subcol.fieldName
+					cols = append(cols, subcol)
+				}
+				continue
+			}
+
 			// Is this field is marked as a relation to a child/detail struct/table?
 			if pt.ForeignKey != "" {
 
@@ -1028,8 +3687,19 @@ func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnM
 				}
 
 				subFieldValueInterface := subFieldValue.Interface()
-				rtm := m.AddTable(subFieldValueInterface)
-				r := RelationMap{DetailTable: rtm, ForeignKeyFieldName: pt.ForeignKey,
+				var rtm *TableMap
+				foreignKey := pt.ForeignKey
+				if pt.HasMany != "" {
+					rtm = m.AddTableWithName(subFieldValueInterface, pt.HasMany)
+					col := colMapOrNil(rtm, foreignKey)
+					if col == nil {
+						panic(fmt.Sprintf("gorp: field %s of %s: no column %s in child table %s", f.Name, t.Name(), foreignKey, pt.HasMany))
+					}
+					foreignKey = col.fieldName
+				} else {
+					rtm = m.AddTable(subFieldValueInterface)
+				}
+				r := RelationMap{DetailTable: rtm, ForeignKeyFieldName: foreignKey,
 					DetailTableType: subFieldValueInterface, MasterFieldName: masterFieldName}
 
 				tm.Relations = append(tm.Relations, &r)
@@ -1037,17 +3707,24 @@ func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnM
 
 			gotype := f.Type
 			value := reflect.New(gotype).Interface()
-			if m.TypeConverter != nil {
+			if m.TypeConverter != nil && !selfConverting(f.Type) {
 				// Make a new pointer to a value of type gotype and
 				// pass it to the TypeConverter's FromDb method to see
 				// if a different type should be used for the column
 				// type during table creation.
-				scanner, useHolder := m.TypeConverter.FromDb(value)
+				scanner, useHolder := convertFromDb(m.TypeConverter, value)
 				if useHolder {
 					value = scanner.Holder
+					if nh, ok := value.(*nullHolder); ok {
+						value = nh.dest
+					}
 					gotype = reflect.TypeOf(value)
 				}
 			}
+			if f.Type.Kind() == reflect.Ptr && selfConverting(f.Type) {
+				// the column type follows the value pointed to
+				value = reflect.New(f.Type.Elem()).Interface()
+			}
 			if typer, ok := value.(SqlTyper); ok {
 				gotype = reflect.TypeOf(typer.SqlType())
 			} else if valuer, ok := value.(driver.Valuer); ok {
@@ -1064,13 +3741,54 @@ func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnM
 				Transient:      pt.Transient,
 				fieldName:      f.Name,
 				gotype:         gotype,
+				selfConverting: selfConverting(f.Type),
 				MaxSize:        pt.MaxColumnSize,
 				DbType:         pt.DbType,
+				RawSQLType:     pt.RawSQLType,
+				Sequence:       pt.Sequence,
+				ScanAs:         pt.ScanAs,
+				DbDefault:      pt.DbDefault,
+				ReadDefault:    pt.ReadDefault,
+				OmitEmpty:      pt.OmitEmpty,
+				References:     pt.References,
+				OnDelete:       pt.OnDelete,
+				OnUpdate:       pt.OnUpdate,
 				isNotNull:      pt.IsNotNull,
 				EnforceNotNull: pt.EnforceNotNull,
 				Unique:         pt.IsFieldUnique,
 				isPK:           pt.IsPk,
 				isAutoIncr:     pt.IsAutoIncr,
+				table:          tm,
+			}
+			if isEpochType(cm.DbType) {
+				// stored as integer and converted by an EpochConverter
+				cm.ScanAs = cm.DbType
+				cm.DbType = m.Dialect.ToSqlType(reflect.TypeOf(int64(0)), 0, false)
+			}
+			if cm.ScanAs == "" {
+				// net types are converted by a NetConverter, dates by a
+				// DateConverter
+				switch gotype {
+				case ipType, reflect.PtrTo(ipType):
+					cm.ScanAs = "inet"
+				case macType, reflect.PtrTo(macType):
+					cm.ScanAs = "macaddr"
+				case timeType, reflect.PtrTo(timeType):
+					if strings.EqualFold(cm.DbType, "date") {
+						cm.ScanAs = "date"
+					}
+				}
+			}
+			if cm.DbType == "bit" {
+				// bit string converted by a BitConverter, see BitDialect
+				cm.DbType = ""
+				if bd, ok := m.Dialect.(BitDialect); ok {
+					if cm.MaxSize == 0 {
+						cm.MaxSize = 1
+					}
+					cm.ScanAs = "bit"
+					cm.DbType = bd.BitSqlType(cm.MaxSize)
+				}
 			}
 			// Check for nested fields of the same field name and
 			// override them.
@@ -1086,6 +3804,9 @@ func (m *DbMap) readStructColumns(t reflect.Type, tm *TableMap) (cols []*ColumnM
 				cols = append(cols, cm)
 				// Collect info for Index creation from the current column
 				tm.Indexes = m.addIndexForColumn(cm, f.Tag, *tm)
+				for _, group := range pt.UniqueGroups {
+					tm.addUniqueGroupColumn(group, cm.ColumnName)
+				}
 
 				if pt.IsPk {
 					colmap := &ColumnMap{ColumnName: cm.ColumnName, fieldName: cm.fieldName}
@@ -1153,6 +3874,8 @@ func (m *DbMap) addIndexForColumn(cm *ColumnMap, tag reflect.StructTag, tm Table
 		for _, im = range indexes {
 			if im.IndexName == it.IndexName {
 				im.fieldNames = append(im.fieldNames, fn)
+				im.Include = append(im.Include, it.Include...)
+				im.Concurrently = im.Concurrently || it.Concurrently
 				shouldAppend = false
 
 				if m.DebugLevel > 3 {
@@ -1167,9 +3890,11 @@ func (m *DbMap) addIndexForColumn(cm *ColumnMap, tag reflect.StructTag, tm Table
 		if shouldAppend {
 
 			im = &IndexMap{
-				IndexName:  it.IndexName,
-				Unique:     it.IsIndexUnique,
-				fieldNames: []string{fn},
+				IndexName:    it.IndexName,
+				Unique:       it.IsIndexUnique,
+				Include:      it.Include,
+				Concurrently: it.Concurrently,
+				fieldNames:   []string{fn},
 			}
 			indexes = append(indexes, im)
 
@@ -1187,34 +3912,47 @@ func (m *DbMap) addIndexForColumn(cm *ColumnMap, tag reflect.StructTag, tm Table
 //
 // This is particularly useful in unit tests where you want to create
 // and destroy the schema automatically.
+//
+// With dialects which can roll back DDL the statements run in a
+// transaction, see SetDDLTransaction.
 func (m *DbMap) CreateTables() error {
 	return m.createTables(false)
 }
 
 // CreateTablesIfNotExists is similar to CreateTables, but starts
 // each statement with "create table if not exists" so that existing
-// tables do not raise errors
+// tables do not raise errors.  Like CreateTables it does not create the
+// indexes, use CreateIndexesIfNotExists, which can run repeatedly as well.
 func (m *DbMap) CreateTablesIfNotExists() error {
 	return m.createTables(true)
 }
 
 func (m *DbMap) createTables(ifNotExists bool) error {
+	return m.runDDL(func(exec SqlExecutor) error {
+		return m.createTablesWith(exec, ifNotExists)
+	})
+}
+
+func (m *DbMap) createTablesWith(exec SqlExecutor, ifNotExists bool) error {
 	var err error
 	for i := range m.tables {
 		table := m.tables[i]
+		if table.isView || table.partialOf != nil {
+			continue
+		}
+		if err = table.partitionError(); err != nil {
+			break
+		}
 
-		s := bytes.Buffer{}
-
-		if strings.TrimSpace(table.SchemaName) != "" {
-			schemaCreate := "create schema"
-			if ifNotExists {
-				s.WriteString(m.Dialect.IfSchemaNotExists(schemaCreate, table.SchemaName))
-			} else {
-				s.WriteString(schemaCreate)
+		if schema := table.SqlForCreateSchema(ifNotExists); schema != "" {
+			_, err = exec.Exec(schema)
+			if err != nil {
+				break
 			}
-			s.WriteString(fmt.Sprintf(" %s;", table.SchemaName))
 		}
 
+		s := bytes.Buffer{}
+
 		tableCreate := "create table"
 		if ifNotExists {
 			s.WriteString(m.Dialect.IfTableNotExists(tableCreate, table.SchemaName, table.TableName))
@@ -1229,12 +3967,10 @@ func (m *DbMap) createTables(ifNotExists bool) error {
 				if x > 0 {
 					s.WriteString(", ")
 				}
-				// Check if the db type has been overriden
-				stype := col.DbType
-				if stype == "" {
-					stype = m.Dialect.ToSqlType(col.gotype, col.MaxSize, col.isAutoIncr)
+				s.WriteString(fmt.Sprintf("%s %s", m.Dialect.QuoteField(col.ColumnName), col.sqlType(m.Dialect)))
+				if col.DbDefault != "" {
+					s.WriteString(fmt.Sprintf(" default %s", col.DbDefault))
 				}
-				s.WriteString(fmt.Sprintf("%s %s", m.Dialect.QuoteField(col.ColumnName), stype))
 
 				if col.isPK || col.isNotNull {
 					s.WriteString(" not null")
@@ -1248,6 +3984,7 @@ func (m *DbMap) createTables(ifNotExists bool) error {
 				if col.isAutoIncr {
 					s.WriteString(fmt.Sprintf(" %s", m.Dialect.AutoIncrStr()))
 				}
+				s.WriteString(table.checkClause(col))
 
 				x++
 			}
@@ -1274,20 +4011,60 @@ func (m *DbMap) createTables(ifNotExists bool) error {
 				s.WriteString(")")
 			}
 		}
+		for _, col := range table.Columns {
+			if !col.Transient && col.References != "" {
+				s.WriteString(table.foreignKeyClause(col))
+			}
+		}
 
 		s.WriteString(") ")
+		s.WriteString(table.partitionClause())
 		s.WriteString(m.Dialect.CreateTableSuffix())
+		s.WriteString(table.commentSuffix())
 		s.WriteString(m.Dialect.QuerySuffix())
 
-		_, err = m.Exec(s.String())
+		_, err = exec.Exec(s.String())
 		if err != nil {
 			break
 		}
+		if comment := table.sqlForComment(); comment != "" {
+			_, err = exec.Exec(comment)
+			if err != nil {
+				break
+			}
+		}
 	}
 
 	return err
 }
 
+// CreatePartition creates the partition name of the table mapped to the
+// type of i for the values from (inclusive) to (exclusive).  The table must
+// be partitioned with TableMap.SetPartitionBy().
+//
+// Example:
+//
+//     err := dbmap.CreatePartition(Event{}, "event_2015", "'2015-01-01'", "'2016-01-01'")
+//
+func (m *DbMap) CreatePartition(i interface{}, name string, from string, to string) error {
+	t, err := toType(i)
+	if err != nil {
+		return err
+	}
+	table, err := m.TableFor(t, false)
+	if err != nil {
+		return err
+	}
+	if table.partitionBy == "" {
+		return fmt.Errorf("gorp: CreatePartition: table %s is not partitioned", table.TableName)
+	}
+	if err = table.partitionError(); err != nil {
+		return err
+	}
+	_, err = m.Exec(table.SqlForCreatePartition(name, from, to))
+	return err
+}
+
 // Creates indexes from a list of IndexMaps in the TableMap
 // If the index already exists it is checked if the index in the database has all
 // the same fields as in the TableMap
@@ -1311,10 +4088,22 @@ func (m *DbMap) createIndexes(ifNotExists bool) error {
 	var err error
 
 	for _, table := range m.tables {
+		if table.isView || table.partialOf != nil {
+			continue
+		}
 		for _, index := range table.Indexes {
 			var exists bool
 			var matches bool
 
+			if _, ok := m.Dialect.(IndexNotExistsDialect); ok && ifNotExists {
+				_, err = m.Exec(table.sqlForCreateIndex(index, true))
+				if err != nil {
+					err = errors.New("Create index " + index.IndexName + " failed: " + err.Error())
+					return err
+				}
+				continue
+			}
+
 			exists, matches, err = m.checkIfIndexMatches(table, index)
 			if err != nil {
 				err = errors.New("checkIfIndexMatches for index " + index.IndexName + " failed: " + err.Error())
@@ -1342,26 +4131,7 @@ func (m *DbMap) createIndexes(ifNotExists bool) error {
 				}
 			}
 
-			// Build the create index sql string
-			var indexCreate string
-			if index.Unique {
-				indexCreate = "create unique index "
-			} else {
-				indexCreate = "create index "
-			}
-
-			s := bytes.Buffer{}
-			s.WriteString(indexCreate)
-			s.WriteString(strings.Trim(fmt.Sprintf(" %s ", m.Dialect.BuildIndexName(table.TableName, index.IndexName)), " "))
-			s.WriteString(fmt.Sprintf(" on %s (", m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
-
-			sep := ""
-			for _, field := range index.fieldNames {
-				s.WriteString(sep + m.Dialect.QuoteField(field))
-				sep = ","
-			}
-			s.WriteString(")")
-			_, err = m.Exec(s.String())
+			_, err = m.Exec(table.SqlForCreateIndex(index))
 			if err != nil {
 				err = errors.New("Create index " + index.IndexName + " failed: " + err.Error())
 				break
@@ -1372,10 +4142,56 @@ func (m *DbMap) createIndexes(ifNotExists bool) error {
 	return err
 }
 
+// SqlForCreateIndex returns the create index statement for index.  The
+// index is named by Dialect.BuildIndexName, like in Dialect.DropIndex.
+func (t *TableMap) SqlForCreateIndex(index *IndexMap) string {
+	return t.sqlForCreateIndex(index, false)
+}
+
+// sqlForCreateIndex returns the create index statement for index, guarded
+// by IndexNotExistsDialect.IfIndexNotExists if ifNotExists is true
+func (t *TableMap) sqlForCreateIndex(index *IndexMap, ifNotExists bool) string {
+	dialect := t.dbmap.Dialect
+	name := dialect.BuildIndexName(t.TableName, index.IndexName)
+
+	// Build the create index sql string
+	var indexCreate string
+	if index.Unique {
+		indexCreate = "create unique index"
+	} else {
+		indexCreate = "create index"
+	}
+	if d, ok := dialect.(ConcurrentIndexDialect); ok && index.Concurrently {
+		indexCreate = d.ConcurrentIndex(indexCreate)
+	}
+	if d, ok := dialect.(IndexNotExistsDialect); ok && ifNotExists {
+		indexCreate = d.IfIndexNotExists(indexCreate, t.SchemaName, t.TableName, name)
+	}
+
+	s := bytes.Buffer{}
+	s.WriteString(indexCreate)
+	s.WriteString(" ")
+	s.WriteString(dialect.QuoteField(name))
+	s.WriteString(fmt.Sprintf(" on %s (", dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+
+	sep := ""
+	for _, field := range index.fieldNames {
+		s.WriteString(sep + dialect.QuoteField(field))
+		sep = ","
+	}
+	s.WriteString(")")
+	if len(index.Include) > 0 {
+		if d, ok := dialect.(IndexIncludeDialect); ok {
+			s.WriteString(d.IndexIncludeSql(index.Include))
+		}
+	}
+	return s.String()
+}
+
 // Tests if an index already exists for a table
 // and if the fields in the index matches the given input IndexMap
 func (m *DbMap) checkIfIndexMatches(table *TableMap, index *IndexMap) (exists bool, matches bool, err error) {
-	var rows *sql.Rows
+	var rows *timeoutRows
 	var columnList []string
 	var columnName string
 
@@ -1458,14 +4274,16 @@ func (m *DbMap) DropTablesIfExists() error {
 // Goes through all the registered tables, dropping them one by one.
 // If an error is encountered, then it is returned and the rest of
 // the tables are not dropped.
-func (m *DbMap) dropTables(addIfExists bool) (err error) {
-	for _, table := range m.tables {
-		err = m.dropTableImpl(table, addIfExists)
-		if err != nil {
-			return
+func (m *DbMap) dropTables(addIfExists bool) error {
+	return m.runDDL(func(exec SqlExecutor) error {
+		for _, table := range m.tables {
+			err := m.dropTableImpl(exec, table, addIfExists)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return err
+		return nil
+	})
 }
 
 // Implementation of dropping a single table.
@@ -1475,15 +4293,18 @@ func (m *DbMap) dropTable(t reflect.Type, addIfExists bool) error {
 		return errors.New(fmt.Sprintf("table %s was not registered!", table.TableName))
 	}
 
-	return m.dropTableImpl(table, addIfExists)
+	return m.dropTableImpl(m, table, addIfExists)
 }
 
-func (m *DbMap) dropTableImpl(table *TableMap, ifExists bool) (err error) {
+func (m *DbMap) dropTableImpl(exec SqlExecutor, table *TableMap, ifExists bool) (err error) {
+	if table.isView || table.partialOf != nil {
+		return nil
+	}
 	tableDrop := "drop table"
 	if ifExists {
 		tableDrop = m.Dialect.IfTableExists(tableDrop, table.SchemaName, table.TableName)
 	}
-	_, err = m.Exec(fmt.Sprintf("%s %s;", tableDrop, m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
+	_, err = exec.Exec(fmt.Sprintf("%s %s%s", tableDrop, m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName), m.Dialect.QuerySuffix()))
 	return err
 }
 
@@ -1495,7 +4316,10 @@ func (m *DbMap) TruncateTables() error {
 	var err error
 	for i := range m.tables {
 		table := m.tables[i]
-		_, e := m.Exec(fmt.Sprintf("%s %s;", m.Dialect.TruncateClause(), m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
+		if table.isView || table.partialOf != nil {
+			continue
+		}
+		_, e := m.Exec(fmt.Sprintf("%s %s%s", m.Dialect.TruncateClause(), m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName), m.Dialect.QuerySuffix()))
 		if e != nil {
 			err = e
 		}
@@ -1503,6 +4327,48 @@ func (m *DbMap) TruncateTables() error {
 	return err
 }
 
+// insertAutoIncr runs the insert statement query and stores the generated
+// key in the autoincrement field f.  Dialects which support LastInsertId
+// execute the statement, the others query the key returned by the
+// AutoIncrInsertSuffix of the statement.  IntegerAutoIncrInserter and
+// TargetedAutoIncrInserter replace the standard implementation of the path.
+func insertAutoIncr(d Dialect, exec SqlExecutor, f reflect.Value, query string, args ...interface{}) error {
+	if !d.SupportsLastInsertId() {
+		if inserter, ok := d.(TargetedAutoIncrInserter); ok {
+			return inserter.InsertAutoIncrToTarget(exec, query, f.Addr().Interface(), args...)
+		}
+		return standardInsertAutoIncrToTarget(exec, query, f.Addr().Interface(), args...)
+	}
+
+	var id int64
+	var err error
+	if inserter, ok := d.(IntegerAutoIncrInserter); ok {
+		id, err = inserter.InsertAutoIncr(exec, query, args...)
+	} else {
+		id, err = standardInsertAutoIncr(exec, query, args...)
+	}
+	if err != nil {
+		return err
+	}
+	switch f.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.SetUint(uint64(id))
+	default:
+		f.SetInt(id)
+	}
+	return nil
+}
+
+// isIntKind returns true for the signed and unsigned integer kinds
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 // Insert runs a SQL INSERT statement for each element in list.
 // List items must be pointers.
 //
@@ -1534,6 +4400,74 @@ func (m *DbMap) InsertWithChilds(list ...interface{}) error {
 	return insert(m, m, true, list...)
 }
 
+// InsertReturning runs a SQL INSERT statement for i, which must be a
+// pointer, and stores the values the database computed for the columns
+// cols in i, e.g. defaults or generated columns.  cols may be struct field
+// or column names.
+//
+// Dialects implementing ReturningDialect return the columns from the
+// insert statement itself, for other dialects the columns are selected
+// by primary key after the insert.
+//
+// Example:
+//
+//     err := dbmap.InsertReturning(&invoice, "Created", "Number")
+//
+func (m *DbMap) InsertReturning(i interface{}, cols ...string) error {
+	return insertWithReturning(m, m, false, cols, i)
+}
+
+// ConflictTarget names the unique index whose conflicts Upsert resolves.
+// For a partial unique index, Where is its predicate.  If UpdateWhere is
+// set, only conflicting rows matching it are updated, e.g. to skip rows
+// which would not change.  It may refer to the values of the insert with
+// "excluded.<column>".
+type ConflictTarget struct {
+	Columns     []string // struct field or column names of the index
+	Where       string   // e.g. "deleted_at is null", may be empty
+	UpdateWhere string   // e.g. "subscriber.name <> excluded.name", may be empty
+}
+
+// Upsert inserts the rows of list like Insert, but a row conflicting with
+// an existing row on the unique index of target updates the other non key
+// columns of that row instead, and its key is stored in the field like the
+// key of an inserted row.  The key field of a row whose update is skipped
+// by the UpdateWhere of target is not changed.  Only dialects implementing
+// UpsertDialect are supported.
+//
+// Example:
+//
+//     target := gorp.ConflictTarget{Columns: []string{"Email"}, Where: "deleted_at is null"}
+//     err := dbmap.Upsert(target, &subscriber)
+//
+func (m *DbMap) Upsert(target ConflictTarget, list ...interface{}) error {
+	return insertConflict(m, m, false, nil, &target, list...)
+}
+
+// CopyIn bulk loads rows, structs or pointers to structs of the type of
+// table, with the COPY protocol of the driver, which is much faster than
+// INSERT statements for many rows.  All rows are loaded in one transaction.
+// Only dialects implementing CopyInDialect support it, i.e. PostgreSQL with
+// the lib/pq driver.
+//
+// Autoincrement and sequence columns are filled by the database, but not
+// read back, and no hooks are run.
+func (m *DbMap) CopyIn(table interface{}, rows []interface{}) error {
+	if _, ok := m.Dialect.(CopyInDialect); !ok {
+		return fmt.Errorf("gorp: CopyIn is not supported by %T", m.Dialect)
+	}
+	trans, err := m.Begin()
+	if err != nil {
+		return err
+	}
+	err = copyIn(m, trans.tx, table, rows)
+	if err != nil {
+		trans.Rollback()
+		return err
+	}
+	return trans.Commit()
+}
+
 /*
 // Store checks for each element in the list if it is already present in the
 // database by checking on the primary key. If not present an SQL INSERT is done,
@@ -1603,7 +4537,7 @@ func (m *DbMap) InsertFromValue(exec SqlExecutor, value reflect.Value) error {
 	}
 
 	if bi.autoIncrIdx > -1 {
-		f := elem.FieldByName(bi.autoIncrFieldName)
+		f := fieldByPath(elem, bi.autoIncrFieldName)
 		switch inserter := m.Dialect.(type) {
 		case IntegerAutoIncrInserter:
 			id, err := inserter.InsertAutoIncr(exec, bi.query, bi.args...)
@@ -1671,6 +4605,139 @@ func (m *DbMap) Update(list ...interface{}) (int64, error) {
 	return update(m, m, false, list...)
 }
 
+// Track records the current column values of the struct ptr points to,
+// e.g. after Get.  A later Update of ptr only sets the columns which changed
+// since, and runs no statement if none did.  The snapshot is refreshed by
+// the Update and kept until Untrack is called.  Values are compared with
+// reflect.DeepEqual, the values of pointer, slice and map fields are copied
+// one level deep.
+//
+// An Update in a transaction refreshes the snapshot too, track ptr again
+// after a rollback.
+//
+// Returns an error if the type of ptr has not been registered with AddTable
+func (m *DbMap) Track(ptr interface{}) error {
+	table, elem, err := m.tableForPointer(ptr, false)
+	if err != nil {
+		return err
+	}
+	m.setSnapshot(elem.Addr().Interface(), table.snapshot(elem))
+	return nil
+}
+
+// Untrack forgets the snapshot Track recorded for ptr, so Update sets all
+// columns again.
+func (m *DbMap) Untrack(ptr interface{}) {
+	if snapshots := m.trackedSnapshots(false); snapshots != nil {
+		snapshots.Delete(ptr)
+	}
+}
+
+// trackMu guards the creation of the snapshots of DbMaps
+var trackMu sync.Mutex
+
+// trackedSnapshots returns the snapshots of m, which are created if create
+// is true
+func (m *DbMap) trackedSnapshots(create bool) *sync.Map {
+	trackMu.Lock()
+	defer trackMu.Unlock()
+	if m.snapshots == nil && create {
+		m.snapshots = new(sync.Map)
+	}
+	return m.snapshots
+}
+
+func (m *DbMap) setSnapshot(ptr interface{}, snap map[string]interface{}) {
+	m.trackedSnapshots(true).Store(ptr, snap)
+}
+
+// snapshotOf returns the snapshot of ptr, or nil if ptr is not tracked
+func (m *DbMap) snapshotOf(ptr interface{}) map[string]interface{} {
+	if snapshots := m.trackedSnapshots(false); snapshots != nil {
+		if snap, ok := snapshots.Load(ptr); ok {
+			return snap.(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+// snapshot returns copies of the column values of elem, see DbMap.Track
+func (t *TableMap) snapshot(elem reflect.Value) map[string]interface{} {
+	snap := make(map[string]interface{}, len(t.Columns))
+	for _, col := range t.Columns {
+		if !col.Transient {
+			snap[col.fieldName] = copyValue(fieldByPath(elem, col.fieldName))
+		}
+	}
+	return snap
+}
+
+// changedColumns returns the columns of elem, other than keys and the
+// version column, whose values differ from snap
+func (t *TableMap) changedColumns(elem reflect.Value, snap map[string]interface{}) map[*ColumnMap]bool {
+	changed := make(map[*ColumnMap]bool)
+	for _, col := range t.Columns {
+		if col.Transient || col.isPK || col == t.version {
+			continue
+		}
+		if !reflect.DeepEqual(fieldByPath(elem, col.fieldName).Interface(), snap[col.fieldName]) {
+			changed[col] = true
+		}
+	}
+	return changed
+}
+
+// copyValue returns the value of v.  Pointers, slices and maps are copied,
+// so changes of the values they refer to are not shared with the copy.
+func copyValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			break
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+		return c.Interface()
+	case reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c.Interface()
+	case reflect.Map:
+		if v.IsNil() {
+			break
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, v.MapIndex(k))
+		}
+		return c.Interface()
+	}
+	return v.Interface()
+}
+
+// UpdateWhere runs a SQL UPDATE statement which sets all columns of the row
+// ptr points to in the rows matching where, e.g. of a table without primary
+// key.  Where holds named parameters of the form ":name", which are read
+// from arg, a map[string]interface{} or a struct, or from the fields of ptr
+// if arg is omitted:
+//
+//     dbmap.UpdateWhere(&row, "code = :Code")
+//     dbmap.UpdateWhere(&row, "code = :old", map[string]interface{}{"old": "A1"})
+//
+// An empty where clause is refused unless AllowFullTable is passed too.
+//
+// The hook functions PreUpdate() and/or PostUpdate() will be executed
+// before/after the UPDATE statement if the interface defines them.  A
+// version column is incremented but not checked, and Track is ignored.
+//
+// Returns the number of rows updated.
+func (m *DbMap) UpdateWhere(ptr interface{}, where string, arg ...interface{}) (int64, error) {
+	return updateWhere(m, m, ptr, where, arg...)
+}
+
 // UpdateWithChilds runs a SQL UPDATE statement for each element in list.
 // If nested structures exist in one of the elements in list, they are
 // inserted or updated, too.
@@ -1686,6 +4753,68 @@ func (m *DbMap) UpdateWithChilds(list ...interface{}) (int64, error) {
 	return update(m, m, true, list...)
 }
 
+// UpdateBatch has the same behavior as Update(), but updates the rows of
+// each TableMap with a single UPDATE statement of the form:
+//
+//     update t set c1 = case when id = ? then ? ... else c1 end, ... where id in (?, ...)
+//
+// Rows of tables with a composite primary key or a version column, and rows
+// with a zero primary key (which Update inserts), fall back to one Update()
+// per row.  PreUpdate() and PostUpdate() hooks are run for every row.
+//
+// If the rows of a table need more bind params than Dialect.MaxBindParams()
+// allows, they are split into several statements.  Use a Transaction to
+// apply them atomically.
+//
+// Returns the number of rows updated.
+func (m *DbMap) UpdateBatch(list ...interface{}) (int64, error) {
+	return updateBatch(m, m, list...)
+}
+
+// UpdateWithOld runs a SQL UPDATE statement for ptr like Update(), but
+// first selects the current row by its primary key(s) and returns it.
+// This is useful for audit trails.  The select and the update run in one
+// transaction.  Dialects implementing LockDialect lock the row with the
+// select, so the returned row is the one which was updated.  With other
+// dialects, like sqlite which locks the whole database for writes, run it
+// in a transaction of an isolation level which prevents lost updates.
+//
+// Returns a pointer to a struct holding the row before the update, or nil if
+// no row was found, and the number of rows updated.
+//
+// Returns an error if SetKeys has not been called on the TableMap
+func (m *DbMap) UpdateWithOld(ptr interface{}) (interface{}, int64, error) {
+	trans, err := m.Begin()
+	if err != nil {
+		return nil, -1, err
+	}
+	old, count, err := updateWithOld(m, trans, ptr)
+	if err != nil {
+		trans.Rollback()
+		return nil, -1, err
+	}
+	err = trans.Commit()
+	if err != nil {
+		return nil, -1, err
+	}
+	return old, count, nil
+}
+
+// DeleteWhere runs a SQL DELETE statement which deletes the rows of the
+// table of i, a struct or a pointer to one, matching where.  Where holds
+// named parameters of the form ":name" like in UpdateWhere, which are read
+// from arg or from the fields of i:
+//
+//     dbmap.DeleteWhere(Invoice{}, "paid = :paid", map[string]interface{}{"paid": true})
+//
+// An empty where clause is refused unless AllowFullTable is passed too.
+// No hooks are run.
+//
+// Returns the number of rows deleted.
+func (m *DbMap) DeleteWhere(i interface{}, where string, arg ...interface{}) (int64, error) {
+	return deleteWhere(m, m, i, where, arg...)
+}
+
 // Delete runs a SQL DELETE statement for each element in list.  List
 // items must be pointers.
 //
@@ -1700,6 +4829,79 @@ func (m *DbMap) Delete(list ...interface{}) (int64, error) {
 	return delete(m, m, list...)
 }
 
+// InsertSQL returns the INSERT statement and its bind arguments which
+// Insert would run for ptr, without running it.  Hooks are not called and
+// ptr is not changed.
+func (m *DbMap) InsertSQL(ptr interface{}) (string, []interface{}, error) {
+	table, elem, err := m.tableForPointer(ptr, false)
+	if err != nil {
+		return "", nil, err
+	}
+	elem = copyElem(elem)
+	err = table.generateKey(elem)
+	if err != nil {
+		return "", nil, err
+	}
+	bi, err := table.bindInsert(elem)
+	if err != nil {
+		return "", nil, err
+	}
+	table.generateInsertSQL(elem, &bi)
+	return bi.query, bi.args, nil
+}
+
+// UpdateSQL returns the UPDATE statement and its bind arguments which
+// Update would run for ptr, without running it.  Hooks are not called and
+// ptr is not changed.  Note that Update inserts rows with a zero primary
+// key instead.
+func (m *DbMap) UpdateSQL(ptr interface{}) (string, []interface{}, error) {
+	table, elem, err := m.tableForPointer(ptr, true)
+	if err != nil {
+		return "", nil, err
+	}
+	bi, err := table.bindUpdate(copyElem(elem))
+	if err != nil {
+		return "", nil, err
+	}
+	return bi.query, bi.args, nil
+}
+
+// DeleteSQL returns the DELETE statement and its bind arguments which
+// Delete would run for ptr, without running it.  Hooks are not called.
+func (m *DbMap) DeleteSQL(ptr interface{}) (string, []interface{}, error) {
+	table, elem, err := m.tableForPointer(ptr, true)
+	if err != nil {
+		return "", nil, err
+	}
+	bi, err := table.bindDelete(copyElem(elem))
+	if err != nil {
+		return "", nil, err
+	}
+	return bi.query, bi.args, nil
+}
+
+// GetSQL returns the SELECT statement and its bind arguments which Get
+// would run for i and keys, without running it.
+func (m *DbMap) GetSQL(i interface{}, keys ...interface{}) (string, []interface{}, error) {
+	t, err := toType(i)
+	if err != nil {
+		return "", nil, err
+	}
+	table, err := m.TableFor(t, true)
+	if err != nil {
+		return "", nil, err
+	}
+	return table.bindGet().query, keys, nil
+}
+
+// copyElem returns an addressable copy of the struct elem, so binding it
+// does not change the fields of the original
+func copyElem(elem reflect.Value) reflect.Value {
+	c := reflect.New(elem.Type()).Elem()
+	c.Set(elem)
+	return c
+}
+
 // Get runs a SQL SELECT to fetch a single row from the table based on the
 // primary key(s)
 //
@@ -1719,56 +4921,254 @@ func (m *DbMap) Get(i interface{}, keys ...interface{}) (interface{}, error) {
 	return get(m, m, i, false, 0, 0, keys...)
 }
 
-// GetWithChilds runs a SQL SELECT to fetch a single row from the table based on the
-// primary key(s). All child records are fetched if a RelationMap exists for this table
-//
-// i should be an empty value for the struct to load.  keys should be
-// the primary key value(s) for the row to load.  If multiple keys
-// exist on the table, the order should match the column order
-// specified in SetKeys() when the table mapping was defined.
+// Refresh selects the row of ptr, a pointer to a struct of a mapped table,
+// by its primary key and overwrites the mapped fields of the struct in
+// place, e.g. to update an instance held by a cache.  Transient fields keep
+// their values.  The hook function PostGet() is executed afterwards.
+//
+// If the row does not exist, an error wrapping sql.ErrNoRows is returned
+// and the struct is not changed.
+func (m *DbMap) Refresh(ptr interface{}) error {
+	return refresh(m, m, ptr)
+}
+
+// GetByExample runs a SQL SELECT to fetch a single row from the table of i,
+// a struct or a pointer to one, matching the values of its non-zero primary
+// key fields.  Unlike Get the keys are named, which suits composite keys:
+//
+//     obj, err := dbmap.GetByExample(&OrderLine{OrderId: 7, Line: 2})
+//
+// Zero key fields are left out of the WHERE clause, so a partial key may
+// match more than one row, which returns a *MultipleRowsError.
+//
+// The hook function PostGet() will be executed after the SELECT
+// statement if the interface defines them.
+//
+// Returns a pointer to a new struct that matches or nil if no row is found.
+// Returns an error if all key fields are zero.
+func (m *DbMap) GetByExample(i interface{}) (interface{}, error) {
+	return getByExample(m, m, i)
+}
+
+// GetWithChilds runs a SQL SELECT to fetch a single row from the table based on the
+// primary key(s). All child records are fetched if a RelationMap exists for this table
+//
+// i should be an empty value for the struct to load.  keys should be
+// the primary key value(s) for the row to load.  If multiple keys
+// exist on the table, the order should match the column order
+// specified in SetKeys() when the table mapping was defined.
+//
+// The hook function PostGet() will be executed after the SELECT
+// statement if the interface defines them.
+//
+// Returns a pointer to a struct that matches or nil if no row is found.
+//
+// Returns an error if SetKeys has not been called on the TableMap
+// Panics if any interface in the list has not been registered with AddTable
+func (m *DbMap) GetWithChilds(i interface{}, ChildLimit int64, ChildOffset int64, keys ...interface{}) (interface{}, error) {
+	return get(m, m, i, true, ChildLimit, ChildOffset, keys...)
+}
+
+// Select runs an arbitrary SQL query, binding the columns in the result
+// to fields on the struct specified by i.  args represent the bind
+// parameters for the SQL statement.
+//
+// Column names on the SELECT statement should be aliased to the field names
+// on the struct i. Returns an error if one or more columns in the result
+// do not match.  It is OK if fields on i are not part of the SQL
+// statement.
+//
+// The hook function PostGet() will be executed after the SELECT
+// statement if the interface defines them.
+//
+// Values are returned in one of two ways:
+// 1. If i is a struct or a pointer to a struct, returns a slice of pointers to
+// matching rows of type i.
+// 2. If i is a pointer to a slice, the results will be appended to that slice
+// and nil returned.
+//
+// i does NOT need to be registered with AddTable()
+func (m *DbMap) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return hookedselect(m, m, i, query, args...)
+}
+
+// SelectInto runs an arbitrary SQL query like Select, but dest must be a
+// pointer to a slice, which is truncated and refilled with the result rows.
+// The capacity of the slice is reused, and for slices of pointers the
+// structs already referenced beyond the length of the slice are overwritten
+// instead of allocating new ones.  Use it to reduce allocations when the
+// same query is run repeatedly into the same slice.
+//
+// Do not keep references to elements of dest across calls, they are
+// overwritten by the next call.
+func (m *DbMap) SelectInto(dest interface{}, query string, args ...interface{}) error {
+	return selectInto(m, m, dest, query, args...)
+}
+
+// SelectToMap runs an arbitrary SQL query and stores the rows in the map
+// dest points to, keyed by the value of the column keyColumn.  A nil map
+// is allocated.  If the values of the map are structs or pointers to
+// structs, rows are mapped to them like by Select, and keyColumn must map
+// to one of their fields.  Otherwise the query must return keyColumn and
+// one more column, which is scanned into the values.
+//
+// Rows with the same key are an error, unless MapKeysLastWins is set.
+//
+// Example:
+//
+//     names := map[int64]string{}
+//     err := dbmap.SelectToMap(&names, "Id", "select Id, FName from person_test")
+//
+//     people := map[int64]*Person{}
+//     err = dbmap.SelectToMap(&people, "Id", "select * from person_test")
+//
+func (m *DbMap) SelectToMap(dest interface{}, keyColumn string, query string, args ...interface{}) error {
+	return selectToMap(m, m, dest, keyColumn, query, args...)
+}
+
+// Exec runs an arbitrary SQL statement.  args represent the bind parameters.
+// This is equivalent to running:  Exec() using database/sql
+func (m *DbMap) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return m.execFields(nil, query, args...)
+}
+
+// execFields is Exec, which traces args with the columns of fields
+func (m *DbMap) execFields(fields *argFields, query string, args ...interface{}) (sql.Result, error) {
+	if m.logger != nil {
+		now := time.Now()
+		defer m.traceArgs(now, query, fields, args)
+	}
+	started := time.Now()
+	res, err := exec(m, query, args...)
+	m.metrics(started, query, err)
+	return res, err
+}
+
+// MustExec is like Exec, but panics if the statement fails.  The Must
+// functions are meant for scripts and tests, production code should use
+// the functions returning an error.
+func (m *DbMap) MustExec(query string, args ...interface{}) sql.Result {
+	res, err := m.Exec(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// MustInsert is like Insert, but panics if an insert fails.
+func (m *DbMap) MustInsert(list ...interface{}) {
+	err := m.Insert(list...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// MustGet is like Get, but panics on errors.  Like Get it returns nil if
+// no row is found.
+func (m *DbMap) MustGet(i interface{}, keys ...interface{}) interface{} {
+	obj, err := m.Get(i, keys...)
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// MustSelect is like Select, but panics if the query fails.
+func (m *DbMap) MustSelect(i interface{}, query string, args ...interface{}) []interface{} {
+	list, err := m.Select(i, query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+// ExecMulti runs statements in order, e.g. the statements of a migration
+// script, and stops at the first statement which fails.  The error is a
+// *StatementError which tells the failed statement.  Use
+// Transaction.ExecMulti to run all statements in one transaction.
+func (m *DbMap) ExecMulti(statements []string) error {
+	return execMulti(m, statements)
+}
+
+func execMulti(e SqlExecutor, statements []string) error {
+	for i, query := range statements {
+		_, err := e.Exec(query)
+		if err != nil {
+			return &StatementError{Index: i, Statement: query, Err: err}
+		}
+	}
+	return nil
+}
+
+// Notify sends payload as notification to the listeners of channel.  It is
+// only supported by PostgresDialect, which runs pg_notify().  Calling it
+// from a PostInsert hook with the executor of the hook notifies listeners
+// about new rows, within a transaction on its commit.
+//
+// Example:
+//
+//     func (i *Invoice) PostInsert(s gorp.SqlExecutor) error {
+//         return s.Notify("invoices", strconv.FormatInt(i.Id, 10))
+//     }
+//
+func (m *DbMap) Notify(channel string, payload string) error {
+	return notify(m, m, channel, payload)
+}
+
+func notify(m *DbMap, e SqlExecutor, channel string, payload string) error {
+	if _, ok := m.Dialect.(PostgresDialect); !ok {
+		return fmt.Errorf("gorp: Notify is not supported by %T", m.Dialect)
+	}
+	_, err := e.Exec("select pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// SelectChan runs query and sends each row to ch, a channel of structs or
+// of pointers to structs of the type of holderType, as soon as it is read.
+// ch is closed when all rows are sent or on an error, which is returned.
+// If the struct has a PostGet() hook, all rows are read before the hooks
+// run, as they may run queries, and a row is sent after its hook.
 //
-// The hook function PostGet() will be executed after the SELECT
-// statement if the interface defines them.
+// SelectChan blocks until all rows are received, so it is usually run in
+// a goroutine of its own.  Use SelectChanContext if the receiver may stop
+// before:
 //
-// Returns a pointer to a struct that matches or nil if no row is found.
+//     ch := make(chan *Invoice)
+//     errc := make(chan error, 1)
+//     go func() { errc <- dbmap.SelectChan(Invoice{}, ch, "select * from invoice") }()
+//     for inv := range ch {
+//         ...
+//     }
+//     err := <-errc
 //
-// Returns an error if SetKeys has not been called on the TableMap
-// Panics if any interface in the list has not been registered with AddTable
-func (m *DbMap) GetWithChilds(i interface{}, ChildLimit int64, ChildOffset int64, keys ...interface{}) (interface{}, error) {
-	return get(m, m, i, true, ChildLimit, ChildOffset, keys...)
+func (m *DbMap) SelectChan(holderType interface{}, ch interface{}, query string, args ...interface{}) error {
+	return selectChan(context.Background(), m, m, holderType, ch, query, args...)
 }
 
-// Select runs an arbitrary SQL query, binding the columns in the result
-// to fields on the struct specified by i.  args represent the bind
-// parameters for the SQL statement.
+// SelectChanContext is SelectChan, which stops sending rows and returns
+// the error of ctx when ctx is done.
+func (m *DbMap) SelectChanContext(ctx context.Context, holderType interface{}, ch interface{}, query string, args ...interface{}) error {
+	return selectChan(ctx, m, m, holderType, ch, query, args...)
+}
+
+// SelectBatchIter runs query and calls fn with batches of up to batchSize
+// rows, each a pointer to a struct of the type of holder, while the rows
+// are read.  Only one batch is held in memory, so large result sets can be
+// processed in chunks, e.g. to write them to another store.  An error
+// returned by fn stops the query and is returned.
 //
-// Column names on the SELECT statement should be aliased to the field names
-// on the struct i. Returns an error if one or more columns in the result
-// do not match.  It is OK if fields on i are not part of the SQL
-// statement.
+// If the struct has a PostGet() hook, all rows are read before the hooks
+// run, as they may run queries, and the hooks of a batch run before it is
+// passed to fn.  Then the whole result set is held in memory.
 //
-// The hook function PostGet() will be executed after the SELECT
-// statement if the interface defines them.
+// Example:
 //
-// Values are returned in one of two ways:
-// 1. If i is a struct or a pointer to a struct, returns a slice of pointers to
-// matching rows of type i.
-// 2. If i is a pointer to a slice, the results will be appended to that slice
-// and nil returned.
+//     err := dbmap.SelectBatchIter(Invoice{}, 100, func(batch []interface{}) error {
+//         return export(batch)
+//     }, "select * from invoice")
 //
-// i does NOT need to be registered with AddTable()
-func (m *DbMap) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
-	return hookedselect(m, m, i, query, args...)
-}
-
-// Exec runs an arbitrary SQL statement.  args represent the bind parameters.
-// This is equivalent to running:  Exec() using database/sql
-func (m *DbMap) Exec(query string, args ...interface{}) (sql.Result, error) {
-	if m.logger != nil {
-		now := time.Now()
-		defer m.trace(now, query, args...)
-	}
-	return exec(m, query, args...)
+func (m *DbMap) SelectBatchIter(holder interface{}, batchSize int, fn func(batch []interface{}) error, query string, args ...interface{}) error {
+	return selectBatchIter(m, m, holder, batchSize, fn, query, args...)
 }
 
 // SelectInt is a convenience wrapper around the gorp.SelectInt function
@@ -1796,16 +5196,46 @@ func (m *DbMap) SelectStr(query string, args ...interface{}) (string, error) {
 	return SelectStr(m, query, args...)
 }
 
+// SelectStrLimit1 is a convenience wrapper around the gorp.SelectStrLimit1 function
+func (m *DbMap) SelectStrLimit1(query string, args ...interface{}) (string, error) {
+	return SelectStrLimit1(m, query, args...)
+}
+
+// SelectCount is a convenience wrapper around the gorp.SelectCount function
+func (m *DbMap) SelectCount(query string, args ...interface{}) (int64, error) {
+	return SelectCount(m, query, args...)
+}
+
+// SelectJSON is a convenience wrapper around the gorp.SelectJSON function
+func (m *DbMap) SelectJSON(query string, args ...interface{}) ([]byte, error) {
+	return SelectJSON(m, query, args...)
+}
+
 // SelectNullStr is a convenience wrapper around the gorp.SelectNullStr function
 func (m *DbMap) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
 	return SelectNullStr(m, query, args...)
 }
 
+// SelectRow is a convenience wrapper around the gorp.SelectRow function
+func (m *DbMap) SelectRow(query string, args ...interface{}) RowScanner {
+	return SelectRow(m, query, args...)
+}
+
 // SelectOne is a convenience wrapper around the gorp.SelectOne function
 func (m *DbMap) SelectOne(holder interface{}, query string, args ...interface{}) error {
 	return SelectOne(m, m, holder, query, args...)
 }
 
+// SelectOneTo is a convenience wrapper around the gorp.SelectOneTo function
+func (m *DbMap) SelectOneTo(holder interface{}, query string, args ...interface{}) error {
+	return SelectOneTo(m, m, holder, query, args...)
+}
+
+// SelectJoin is a convenience wrapper around the gorp.SelectJoin function
+func (m *DbMap) SelectJoin(holders []JoinHolder, query string, args ...interface{}) error {
+	return SelectJoin(m, m, holders, query, args...)
+}
+
 // Begin starts a gorp Transaction
 func (m *DbMap) Begin() (*Transaction, error) {
 	if m.logger != nil {
@@ -1816,7 +5246,137 @@ func (m *DbMap) Begin() (*Transaction, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{m, tx, false}, nil
+	return &Transaction{m, tx, false, nil}, nil
+}
+
+// WithTransactionRetry runs fn in a transaction, which is committed if fn
+// returns nil and rolled back otherwise.  If fn or the commit fail with an
+// error the dialect considers retryable, see IsRetryable, the whole
+// transaction is run again, up to TransactionRetries times.  fn must not
+// have other side effects, as it may run more than once.
+//
+// Retries are needed for SERIALIZABLE transactions in particular, which
+// WithTransactionRetryTx can start:
+//
+//     err := dbmap.WithTransactionRetry(func(trans *gorp.Transaction) error {
+//         inv, err := trans.Get(Invoice{}, id)
+//         ...
+//         _, err = trans.Update(inv)
+//         return err
+//     })
+//
+func (m *DbMap) WithTransactionRetry(fn func(*Transaction) error) error {
+	return m.WithTransactionRetryTx(context.Background(), nil, fn)
+}
+
+// WithTransactionRetryTx is WithTransactionRetry with transactions started
+// like BeginTx, e.g. with sql.LevelSerializable isolation.
+func (m *DbMap) WithTransactionRetryTx(ctx context.Context, opts *sql.TxOptions, fn func(*Transaction) error) error {
+	retries := m.TransactionRetries
+	if retries == 0 {
+		retries = DefaultTransactionRetries
+	}
+	for attempt := 0; ; attempt++ {
+		err := m.runTransaction(ctx, opts, fn)
+		if err == nil || attempt >= retries || !m.IsRetryable(err) {
+			return err
+		}
+		if m.DebugLevel > 2 {
+			log.Printf("[gorp] transaction retry %d after: %s\n", attempt+1, err.Error())
+		}
+	}
+}
+
+// runTransaction runs fn in a transaction, which is committed if fn returns
+// nil and rolled back otherwise
+func (m *DbMap) runTransaction(ctx context.Context, opts *sql.TxOptions, fn func(*Transaction) error) error {
+	trans, err := m.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			trans.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(trans); err != nil {
+		trans.Rollback()
+		return err
+	}
+	return trans.Commit()
+}
+
+// IsRetryable returns true if err is an error of a transaction which may
+// succeed if run again, like a serialization failure or a deadlock.  Only
+// dialects implementing RetryDialect recognize such errors.
+func (m *DbMap) IsRetryable(err error) bool {
+	d, ok := m.Dialect.(RetryDialect)
+	return ok && err != nil && d.IsRetryable(err)
+}
+
+// BeginTx starts a gorp Transaction using the given context and
+// options.  opts may be used to set the isolation level or to start a
+// read-only transaction, if the driver supports it.  A nil opts uses
+// the driver defaults, like Begin().
+//
+// Example:
+//
+//     trans, err := dbmap.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+//
+func (m *DbMap) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+	if m.logger != nil {
+		now := time.Now()
+		defer m.trace(now, "begin;")
+	}
+	tx, err := m.Db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{m, tx, false, opts}, nil
+}
+
+// Ping verifies the connection to the database is still alive,
+// establishing a connection if necessary.
+func (m *DbMap) Ping(ctx context.Context) error {
+	return m.Db.PingContext(ctx)
+}
+
+// VerifySchema checks that a table exists in the database for every
+// TableMap registered with this DbMap.  Each table is probed with a select
+// which returns no rows.  If tables are missing, a *MissingTablesError
+// naming them is returned.  Only the errors the dialect recognizes as
+// undefined table errors, see UndefinedTableDialect, mark a table as
+// missing, any other error is returned as is.  With dialects which do not
+// implement it every failed probe marks its table as missing.
+func (m *DbMap) VerifySchema() error {
+	err := m.Db.Ping()
+	if err != nil {
+		return err
+	}
+
+	ud, checkUndefined := m.Dialect.(UndefinedTableDialect)
+	var missing []string
+	for _, table := range m.tables {
+		query := fmt.Sprintf("select 1 from %s where 1=0%s",
+			m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName), m.Dialect.QuerySuffix())
+		rows, err := m.query(query)
+		if err != nil {
+			if checkUndefined && !ud.IsUndefinedTable(err) {
+				return err
+			}
+			if m.DebugLevel > 2 {
+				log.Printf("VerifySchema table %s: %s\n", table.TableName, err.Error())
+			}
+			missing = append(missing, table.TableName)
+			continue
+		}
+		rows.Close()
+	}
+	if len(missing) > 0 {
+		return &MissingTablesError{TableNames: missing}
+	}
+	return nil
 }
 
 // TableFor returns the *TableMap corresponding to the given Go Type
@@ -1848,6 +5408,25 @@ func (m *DbMap) Prepare(query string) (*sql.Stmt, error) {
 	return m.Db.Prepare(query)
 }
 
+// scanError wraps err of scanning the column of fieldName
+func (t *TableMap) scanError(fieldName string, err error) error {
+	se := &ScanError{TableName: t.TableName, FieldName: fieldName, Err: err}
+	if col := colMapForField(t, fieldName); col != nil {
+		se.ColumnName = col.ColumnName
+	}
+	return se
+}
+
+// scanErrorColumn returns the index of the column database/sql reports in
+// a scan error, or -1
+func scanErrorColumn(err error) int {
+	var x int
+	if _, e := fmt.Sscanf(err.Error(), "sql: Scan error on column index %d", &x); e != nil {
+		return -1
+	}
+	return x
+}
+
 func tableOrNil(m *DbMap, t reflect.Type) *TableMap {
 	for i := range m.tables {
 		table := m.tables[i]
@@ -1875,24 +5454,64 @@ func (m *DbMap) tableForPointer(ptr interface{}, checkPK bool) (*TableMap, refle
 	return t, elem, nil
 }
 
-func (m *DbMap) queryRow(query string, args ...interface{}) *sql.Row {
+func (m *DbMap) dbMap() *DbMap {
+	return m
+}
+
+func (m *DbMap) queryRow(query string, args ...interface{}) *timeoutRow {
+	return m.queryRowFields(nil, query, args...)
+}
+
+func (m *DbMap) queryRowFields(fields *argFields, query string, args ...interface{}) *timeoutRow {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, query, args...)
+		defer m.traceArgs(now, query, fields, args)
 	}
-	return m.Db.QueryRow(query, args...)
+	started := time.Now()
+	// the context is released by Scan
+	ctx, cancel := m.timeoutContext()
+	row := m.Db.QueryRowContext(ctx, query, args...)
+	if row.Err() != nil {
+		cancel()
+	}
+	m.metrics(started, query, row.Err())
+	return &timeoutRow{row, ctx, cancel, m, query}
+}
+
+func (m *DbMap) query(query string, args ...interface{}) (*timeoutRows, error) {
+	return m.queryFields(nil, query, args...)
 }
 
-func (m *DbMap) query(query string, args ...interface{}) (*sql.Rows, error) {
+func (m *DbMap) queryFields(fields *argFields, query string, args ...interface{}) (*timeoutRows, error) {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, query, args...)
+		defer m.traceArgs(now, query, fields, args)
+	}
+	started := time.Now()
+	// the context is released by Close
+	ctx, cancel := m.timeoutContext()
+	rows, err := m.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		err = m.timeoutError(ctx, query, err)
+		m.metrics(started, query, err)
+		return nil, err
 	}
-	return m.Db.Query(query, args...)
+	m.metrics(started, query, err)
+	return &timeoutRows{rows, ctx, cancel, m, query}, nil
 }
 
 func (m *DbMap) trace(started time.Time, query string, args ...interface{}) {
+	m.traceArgs(started, query, nil, args)
+}
+
+// traceArgs is trace, which passes the columns of fields to the
+// ArgRedactor with args
+func (m *DbMap) traceArgs(started time.Time, query string, fields *argFields, args []interface{}) {
 	if m.logger != nil {
+		if m.argRedactor != nil {
+			args = m.redactArgs(fields.columns(), args)
+		}
 		var margs = argsString(args...)
 		m.logger.Printf("%s%s [%s] (%v)", m.logPrefix, query, margs, (time.Now().Sub(started)))
 	}
@@ -1929,12 +5548,25 @@ type GorpParsedTag struct {
 	Indexes        []GorpParsedIndexTag
 	MaxColumnSize  int
 	DbType         string
+	RawSQLType     string
 	IsNotNull      bool
 	EnforceNotNull bool
 	IsAutoIncr     bool
 	IsPk           bool
 	Transient      bool
 	ForeignKey     string
+	Sequence       string
+	ScanAs         string
+	UniqueGroups   []string
+	DbDefault      string
+	ReadDefault    bool
+	OmitEmpty      bool
+	Expand         bool
+	References     string
+	OnDelete       string
+	OnUpdate       string
+	HasMany        string
+	SelectAs       string // column alias Select also scans into the field
 }
 
 func (pt GorpParsedTag) String() string {
@@ -1948,6 +5580,31 @@ type GorpParsedIndexTag struct {
 	IndexName     string
 	IsIndexUnique bool
 	ForeignKey    string
+	Include       []string
+	Concurrently  bool
+}
+
+// splitTag splits a tag string at the commas outside of parentheses, so
+// that options like "type:numeric(20,6)" are kept in one piece
+func splitTag(ts string) []string {
+	var tags []string
+	depth, start := 0, 0
+	for i, c := range ts {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				tags = append(tags, ts[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(tags, ts[start:])
 }
 
 // ParseTag extracts all field tags from input param tag and returns all found options
@@ -1960,8 +5617,9 @@ type Post struct {
 	SecondTestID int       `db:"notnull, name: SID, uniqueindex:idx_unique_sid"`
 	Created      time.Time `db:"notnull, primarykey"`
 	PostDate     time.Time `db:"notnull"`
-	Site         string    `db:"name: PostSite, notnull, size:50, index:idx_site"`
+	Site         string    `db:"name: PostSite, notnull, size:50, index:idx_site, unique:site_day"`
 	PostId       string    `db:"notnull, size:32, unique"`
+	Day          int       `db:"unique:site_day"` // unique together with PostSite
 	Score        int       `db:"notnull"`
 	Title        string    `db:"notnull, size:1024"`
 	Url          string    `db:"notnull"`
@@ -1970,6 +5628,16 @@ type Post struct {
 	UserIP       string    `db:"notnull, size:16"`
 	BodyType     string    `db:"notnull, size:64"`
 	Body         string    `db:"name:PostBody, type:mediumtext"`
+	Location     LatLng    `db:"expand"` // maps the fields of LatLng to columns
+	Edited       time.Time `db:"type:epoch_millis"` // stored as bigint
+	Rating       int       `db:"index:idx_rating, include:Score"` // covering index
+	Visits       int       `db:"index:idx_visits, concurrently"` // built without blocking writes
+	Published    time.Time `db:"default:CURRENT_TIMESTAMP, readdefault"` // filled by the database
+	ForumId      int64     `db:"fk:forum.id, ondelete:cascade"` // foreign key
+	Comments     []Comment `db:"hasmany:comment, fk:post_id"` // child rows, see RelationMap
+	Status       string    `db:"size:16, default:'new', omitempty"` // left out of inserts while empty
+	Author       string    `db:"select:author_name"` // also scanned from the author_name column of a Select
+	Search       string    `db:"sqltype:tsvector"` // created with this literal column type
 	Err          error     `db:"-"` // ignore this field when storing with gorp
 }
 */
@@ -1991,8 +5659,12 @@ func (m *DbMap) ParseTag(tag reflect.StructTag) (pt GorpParsedTag) {
 		pt.Transient = true
 	} else {
 
+		// Included columns apply to all indexes of the tag
+		var include []string
+		var concurrently bool
+
 		// Get all params from tagstring
-		tags := strings.Split(ts, ",")
+		tags := splitTag(ts)
 		for _, tag := range tags {
 			o := strings.Split(tag, ":")
 			o[0] = strings.ToLower(strings.Trim(o[0], " "))
@@ -2024,22 +5696,55 @@ func (m *DbMap) ParseTag(tag reflect.StructTag) (pt GorpParsedTag) {
 				}
 			case "type":
 				pt.DbType = strings.Trim(o[1], " ")
+			case "sqltype":
+				pt.RawSQLType = strings.Trim(o[1], " ")
 			case "notnull":
 				pt.IsNotNull = true
 			case "enforcenotnull":
 				pt.IsNotNull = true
 				pt.EnforceNotNull = true
 			case "unique":
-				pt.IsFieldUnique = true
-			case "autoincrement":
+				if len(o) > 1 && strings.Trim(o[1], " ") != "" {
+					pt.UniqueGroups = append(pt.UniqueGroups, strings.Trim(o[1], " "))
+				} else {
+					pt.IsFieldUnique = true
+				}
+			case "autoincrement", "autoincr":
 				pt.IsAutoIncr = true
-			case "primarykey":
+			case "primarykey", "pk":
 				pt.IsPk = true
 			case "relation":
 				pt.Transient = true
 				pt.ForeignKey = strings.Trim(o[1], " ")
+			case "hasmany":
+				pt.Transient = true
+				pt.HasMany = strings.Trim(o[1], " ")
 			case "ignorefield":
 				pt.Transient = true
+			case "sequence":
+				pt.Sequence = strings.Trim(o[1], " ")
+			case "scan":
+				pt.ScanAs = strings.Trim(o[1], " ")
+			case "select":
+				pt.SelectAs = strings.Trim(o[1], " ")
+			case "default":
+				pt.DbDefault = strings.Trim(strings.Join(o[1:], ":"), " ")
+			case "readdefault":
+				pt.ReadDefault = true
+			case "omitempty":
+				pt.OmitEmpty = true
+			case "fk":
+				pt.References = strings.Trim(o[1], " ")
+			case "ondelete":
+				pt.OnDelete = referentialAction(o[1])
+			case "onupdate":
+				pt.OnUpdate = referentialAction(o[1])
+			case "expand":
+				pt.Expand = true
+			case "include":
+				include = append(include, strings.Trim(o[1], " "))
+			case "concurrently":
+				concurrently = true
 
 			default:
 				// Fallback to traditional gorp tags - use it as a fieldname if it is none of the tags above
@@ -2048,6 +5753,17 @@ func (m *DbMap) ParseTag(tag reflect.StructTag) (pt GorpParsedTag) {
 				}
 			}
 		}
+		for i := range pt.Indexes {
+			pt.Indexes[i].Include = include
+			pt.Indexes[i].Concurrently = concurrently
+		}
+		if pt.HasMany != "" {
+			// fk names the foreign key column of the child table
+			if pt.References == "" {
+				panic(fmt.Sprintf("gorp: tag 'hasmany:%s' needs the foreign key column of the child table in 'fk:'", pt.HasMany))
+			}
+			pt.ForeignKey, pt.References = pt.References, ""
+		}
 	}
 
 	return
@@ -2058,11 +5774,56 @@ func (t *Transaction) Insert(list ...interface{}) error {
 	return insert(t.dbmap, t, false, list...)
 }
 
+// InsertReturning has the same behavior as DbMap.InsertReturning(), but
+// runs in a transaction.
+func (t *Transaction) InsertReturning(i interface{}, cols ...string) error {
+	return insertWithReturning(t.dbmap, t, false, cols, i)
+}
+
+// Upsert has the same behavior as DbMap.Upsert(), but runs in a
+// transaction.
+func (t *Transaction) Upsert(target ConflictTarget, list ...interface{}) error {
+	return insertConflict(t.dbmap, t, false, nil, &target, list...)
+}
+
+// CopyIn has the same behavior as DbMap.CopyIn(), but runs in this
+// transaction.
+func (t *Transaction) CopyIn(table interface{}, rows []interface{}) error {
+	return copyIn(t.dbmap, t.tx, table, rows)
+}
+
 // Update had the same behavior as DbMap.Update(), but runs in a transaction.
 func (t *Transaction) Update(list ...interface{}) (int64, error) {
 	return update(t.dbmap, t, false, list...)
 }
 
+// UpdateWhere has the same behavior as DbMap.UpdateWhere(), but runs in a transaction.
+func (t *Transaction) UpdateWhere(ptr interface{}, where string, arg ...interface{}) (int64, error) {
+	return updateWhere(t.dbmap, t, ptr, where, arg...)
+}
+
+// UpdateBatch has the same behavior as DbMap.UpdateBatch(), but runs in a transaction.
+func (t *Transaction) UpdateBatch(list ...interface{}) (int64, error) {
+	return updateBatch(t.dbmap, t, list...)
+}
+
+// InsertOrGet has the same behavior as DbMap.InsertOrGet(), but runs in
+// this transaction.
+func (t *Transaction) InsertOrGet(ptr interface{}) (created bool, err error) {
+	return insertOrGet(t.dbmap, t, ptr)
+}
+
+// UpdateWithOld has the same behavior as DbMap.UpdateWithOld(), but runs in
+// this transaction.
+func (t *Transaction) UpdateWithOld(ptr interface{}) (interface{}, int64, error) {
+	return updateWithOld(t.dbmap, t, ptr)
+}
+
+// DeleteWhere has the same behavior as DbMap.DeleteWhere(), but runs in a transaction.
+func (t *Transaction) DeleteWhere(i interface{}, where string, arg ...interface{}) (int64, error) {
+	return deleteWhere(t.dbmap, t, i, where, arg...)
+}
+
 // Delete has the same behavior as DbMap.Delete(), but runs in a transaction.
 func (t *Transaction) Delete(list ...interface{}) (int64, error) {
 	return delete(t.dbmap, t, list...)
@@ -2073,18 +5834,76 @@ func (t *Transaction) Get(i interface{}, keys ...interface{}) (interface{}, erro
 	return get(t.dbmap, t, i, false, 0, 0, keys...)
 }
 
+// Refresh has the same behavior as DbMap.Refresh(), but runs in a
+// transaction.
+func (t *Transaction) Refresh(ptr interface{}) error {
+	return refresh(t.dbmap, t, ptr)
+}
+
+// GetByExample has the same behavior as DbMap.GetByExample(), but runs in a
+// transaction.
+func (t *Transaction) GetByExample(i interface{}) (interface{}, error) {
+	return getByExample(t.dbmap, t, i)
+}
+
+// SelectChan has the same behavior as DbMap.SelectChan(), but runs in a
+// transaction.
+func (t *Transaction) SelectChan(holderType interface{}, ch interface{}, query string, args ...interface{}) error {
+	return selectChan(context.Background(), t.dbmap, t, holderType, ch, query, args...)
+}
+
+// SelectChanContext has the same behavior as DbMap.SelectChanContext(), but
+// runs in a transaction.
+func (t *Transaction) SelectChanContext(ctx context.Context, holderType interface{}, ch interface{}, query string, args ...interface{}) error {
+	return selectChan(ctx, t.dbmap, t, holderType, ch, query, args...)
+}
+
+// SelectBatchIter has the same behavior as DbMap.SelectBatchIter(), but
+// runs in a transaction.
+func (t *Transaction) SelectBatchIter(holder interface{}, batchSize int, fn func(batch []interface{}) error, query string, args ...interface{}) error {
+	return selectBatchIter(t.dbmap, t, holder, batchSize, fn, query, args...)
+}
+
 // Select has the same behavior as DbMap.Select(), but runs in a transaction.
 func (t *Transaction) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
 	return hookedselect(t.dbmap, t, i, query, args...)
 }
 
+// SelectInto has the same behavior as DbMap.SelectInto(), but runs in a transaction.
+func (t *Transaction) SelectInto(dest interface{}, query string, args ...interface{}) error {
+	return selectInto(t.dbmap, t, dest, query, args...)
+}
+
+// SelectToMap has the same behavior as DbMap.SelectToMap(), but runs in a transaction.
+func (t *Transaction) SelectToMap(dest interface{}, keyColumn string, query string, args ...interface{}) error {
+	return selectToMap(t.dbmap, t, dest, keyColumn, query, args...)
+}
+
 // Exec has the same behavior as DbMap.Exec(), but runs in a transaction.
 func (t *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.execFields(nil, query, args...)
+}
+
+func (t *Transaction) execFields(fields *argFields, query string, args ...interface{}) (sql.Result, error) {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.traceArgs(now, query, fields, args)
 	}
-	return exec(t, query, args...)
+	started := time.Now()
+	res, err := exec(t, query, args...)
+	t.dbmap.metrics(started, query, err)
+	return res, err
+}
+
+// Notify has the same behavior as DbMap.Notify(), but runs in a
+// transaction.  The notification is delivered when the transaction commits.
+func (t *Transaction) Notify(channel string, payload string) error {
+	return notify(t.dbmap, t, channel, payload)
+}
+
+// ExecMulti has the same behavior as DbMap.ExecMulti(), but runs in a transaction.
+func (t *Transaction) ExecMulti(statements []string) error {
+	return execMulti(t, statements)
 }
 
 // SelectInt is a convenience wrapper around the gorp.SelectInt function.
@@ -2112,16 +5931,52 @@ func (t *Transaction) SelectStr(query string, args ...interface{}) (string, erro
 	return SelectStr(t, query, args...)
 }
 
+// SelectStrLimit1 is a convenience wrapper around the gorp.SelectStrLimit1 function.
+func (t *Transaction) SelectStrLimit1(query string, args ...interface{}) (string, error) {
+	return SelectStrLimit1(t, query, args...)
+}
+
+// SelectCount is a convenience wrapper around the gorp.SelectCount function.
+func (t *Transaction) SelectCount(query string, args ...interface{}) (int64, error) {
+	return SelectCount(t, query, args...)
+}
+
+// SelectJSON is a convenience wrapper around the gorp.SelectJSON function.
+func (t *Transaction) SelectJSON(query string, args ...interface{}) ([]byte, error) {
+	return SelectJSON(t, query, args...)
+}
+
 // SelectNullStr is a convenience wrapper around the gorp.SelectNullStr function.
 func (t *Transaction) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
 	return SelectNullStr(t, query, args...)
 }
 
+// SelectRow is a convenience wrapper around the gorp.SelectRow function.
+func (t *Transaction) SelectRow(query string, args ...interface{}) RowScanner {
+	return SelectRow(t, query, args...)
+}
+
 // SelectOne is a convenience wrapper around the gorp.SelectOne function.
 func (t *Transaction) SelectOne(holder interface{}, query string, args ...interface{}) error {
 	return SelectOne(t.dbmap, t, holder, query, args...)
 }
 
+// SelectOneTo is a convenience wrapper around the gorp.SelectOneTo function.
+func (t *Transaction) SelectOneTo(holder interface{}, query string, args ...interface{}) error {
+	return SelectOneTo(t.dbmap, t, holder, query, args...)
+}
+
+// SelectJoin is a convenience wrapper around the gorp.SelectJoin function.
+func (t *Transaction) SelectJoin(holders []JoinHolder, query string, args ...interface{}) error {
+	return SelectJoin(t.dbmap, t, holders, query, args...)
+}
+
+// Options returns the sql.TxOptions the transaction was started with,
+// or nil if it was started with Begin().
+func (t *Transaction) Options() *sql.TxOptions {
+	return t.opts
+}
+
 // Commit commits the underlying database transaction.
 func (t *Transaction) Commit() error {
 	if !t.closed {
@@ -2152,9 +6007,13 @@ func (t *Transaction) Rollback() error {
 
 // Savepoint creates a savepoint with the given name. The name is interpolated
 // directly into the SQL SAVEPOINT statement, so you must sanitize it if it is
-// derived from user input.
+// derived from user input.  Dialects with other savepoint statements
+// implement SavepointDialect.
 func (t *Transaction) Savepoint(name string) error {
 	query := "savepoint " + t.dbmap.Dialect.QuoteField(name)
+	if sd, ok := t.dbmap.Dialect.(SavepointDialect); ok {
+		query = sd.SavepointSql(name)
+	}
 	if t.dbmap.logger != nil {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
@@ -2168,6 +6027,9 @@ func (t *Transaction) Savepoint(name string) error {
 // sanitize it if it is derived from user input.
 func (t *Transaction) RollbackToSavepoint(savepoint string) error {
 	query := "rollback to savepoint " + t.dbmap.Dialect.QuoteField(savepoint)
+	if sd, ok := t.dbmap.Dialect.(SavepointDialect); ok {
+		query = sd.RollbackToSavepointSql(savepoint)
+	}
 	if t.dbmap.logger != nil {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
@@ -2178,9 +6040,16 @@ func (t *Transaction) RollbackToSavepoint(savepoint string) error {
 
 // ReleaseSavepint releases the savepoint with the given name. The name is
 // interpolated directly into the SQL SAVEPOINT statement, so you must sanitize
-// it if it is derived from user input.
+// it if it is derived from user input.  It does nothing on databases which
+// release savepoints only with the transaction, like SQL Server and Oracle.
 func (t *Transaction) ReleaseSavepoint(savepoint string) error {
 	query := "release savepoint " + t.dbmap.Dialect.QuoteField(savepoint)
+	if sd, ok := t.dbmap.Dialect.(SavepointDialect); ok {
+		query = sd.ReleaseSavepointSql(savepoint)
+		if query == "" {
+			return nil
+		}
+	}
 	if t.dbmap.logger != nil {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
@@ -2198,20 +6067,51 @@ func (t *Transaction) Prepare(query string) (*sql.Stmt, error) {
 	return t.tx.Prepare(query)
 }
 
-func (t *Transaction) queryRow(query string, args ...interface{}) *sql.Row {
+func (t *Transaction) dbMap() *DbMap {
+	return t.dbmap
+}
+
+func (t *Transaction) queryRow(query string, args ...interface{}) *timeoutRow {
+	return t.queryRowFields(nil, query, args...)
+}
+
+func (t *Transaction) queryRowFields(fields *argFields, query string, args ...interface{}) *timeoutRow {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.traceArgs(now, query, fields, args)
 	}
-	return t.tx.QueryRow(query, args...)
+	started := time.Now()
+	// the context is released by Scan
+	ctx, cancel := t.dbmap.timeoutContext()
+	row := t.tx.QueryRowContext(ctx, query, args...)
+	if row.Err() != nil {
+		cancel()
+	}
+	t.dbmap.metrics(started, query, row.Err())
+	return &timeoutRow{row, ctx, cancel, t.dbmap, query}
+}
+
+func (t *Transaction) query(query string, args ...interface{}) (*timeoutRows, error) {
+	return t.queryFields(nil, query, args...)
 }
 
-func (t *Transaction) query(query string, args ...interface{}) (*sql.Rows, error) {
+func (t *Transaction) queryFields(fields *argFields, query string, args ...interface{}) (*timeoutRows, error) {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.traceArgs(now, query, fields, args)
+	}
+	started := time.Now()
+	// the context is released by Close
+	ctx, cancel := t.dbmap.timeoutContext()
+	rows, err := t.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		err = t.dbmap.timeoutError(ctx, query, err)
+		t.dbmap.metrics(started, query, err)
+		return nil, err
 	}
-	return t.tx.Query(query, args...)
+	t.dbmap.metrics(started, query, err)
+	return &timeoutRows{rows, ctx, cancel, t.dbmap, query}, nil
 }
 
 ///////////////
@@ -2267,13 +6167,171 @@ func SelectNullFloat(e SqlExecutor, query string, args ...interface{}) (sql.Null
 // SelectStr executes the given query, which should be a SELECT statement for a single
 // char/varchar column, and returns the value of the first row returned.  If no rows are
 // found, an empty string is returned.
+//
+// Only the first row is read, but the database still runs the full query.
+// Use SelectStrLimit1 if the query may return a large result set.
 func SelectStr(e SqlExecutor, query string, args ...interface{}) (string, error) {
 	var h string
 	err := selectVal(e, &h, query, args...)
 	if err != nil && err != sql.ErrNoRows {
 		return "", err
 	}
-	return h, nil
+	return h, nil
+}
+
+// SelectStrLimit1 has the same behavior as SelectStr, but limits the query
+// with a dialect specific clause so the database returns at most one row.
+func SelectStrLimit1(e SqlExecutor, query string, args ...interface{}) (string, error) {
+	return SelectStr(e, limitOneQuery(e.dbMap().Dialect, query), args...)
+}
+
+// limitOneQuery limits query to return at most one row.  Dialects
+// implementing LimitDialect get their clause appended, else query is
+// wrapped in a select with a limit clause.
+func limitOneQuery(d Dialect, query string) string {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	if ld, ok := d.(LimitDialect); ok {
+		return query + ld.LimitClause(1, 0, orderByIndex(query) >= 0)
+	}
+	return "select * from (" + query + ") as gorp_limit1 limit 1"
+}
+
+// orderByIndex returns the index of the order by clause of query, which
+// is not inside parentheses or quotes, -1 if there is none
+func orderByIndex(query string) int {
+	lower := strings.ToLower(query)
+	x := -1
+	depth := 0
+	var quote byte
+	for i := 0; i < len(lower); i++ {
+		c := lower[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			quote = c
+			if c == '[' {
+				quote = ']'
+			}
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(lower[i:], "order") && (i == 0 || isSpace(lower[i-1])):
+			rest := strings.TrimLeft(lower[i+len("order"):], " \t\r\n")
+			if len(rest) < len(lower)-i-len("order") && strings.HasPrefix(rest, "by") {
+				x = i
+			}
+		}
+	}
+	return x
+}
+
+// isSpace returns true for the white space separating sql keywords
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// SelectCount returns the number of rows the given SELECT query would
+// return, without fetching them.  The query is wrapped as
+// "select count(*) from (<query>) gorp_count", after stripping any
+// trailing semicolon or dialect query suffix, and its order by clause
+// unless a limit or offset follows it.
+func SelectCount(e SqlExecutor, query string, args ...interface{}) (int64, error) {
+	return SelectInt(e, countQuery(e.dbMap().Dialect, query), args...)
+}
+
+// countQuery wraps query in a select which counts its rows
+func countQuery(d Dialect, query string) string {
+	suffix := ""
+	if d != nil {
+		suffix = d.QuerySuffix()
+	}
+	query = strings.TrimSpace(query)
+	for {
+		trimmed := strings.TrimSpace(strings.TrimRight(query, ";"))
+		if suffix != "" {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, suffix))
+		}
+		if trimmed == query {
+			break
+		}
+		query = trimmed
+	}
+	// the order does not change the count, and Sql Server does not allow
+	// it in a derived table without a limit
+	if x := orderByIndex(query); x >= 0 && !hasLimit(query[x:]) {
+		query = strings.TrimSpace(query[:x])
+	}
+	return "select count(*) from (" + query + ") gorp_count" + suffix
+}
+
+// hasLimit returns true if the order by clause limits the rows
+func hasLimit(orderBy string) bool {
+	for _, word := range strings.Fields(strings.ToLower(orderBy)) {
+		switch word {
+		case "limit", "offset", "fetch":
+			return true
+		}
+	}
+	return false
+}
+
+// SelectJSON executes the given query and returns its rows as a JSON
+// array of objects, which map the column names to the values, e.g. to pass
+// rows through an API without a struct.  []byte values are written as
+// strings, time.Time values as RFC 3339 strings and NULL as null.  No rows
+// return an empty array.
+func SelectJSON(e SqlExecutor, query string, args ...interface{}) ([]byte, error) {
+	rows, err := e.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	values := make([]interface{}, len(cols))
+	scanners := make([]CustomScanner, len(cols))
+	dest := make([]interface{}, len(cols))
+	for x := range cols {
+		scanners[x] = dynamicScanner(colTypes[x], &values[x])
+		dest[x] = scanners[x].Holder
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for x, col := range cols {
+			if err = scanners[x].Bind(); err != nil {
+				return nil, err
+			}
+			switch v := values[x].(type) {
+			case []byte:
+				row[col] = string(v)
+			case time.Time:
+				row[col] = v.Format(time.RFC3339Nano)
+			default:
+				row[col] = v
+			}
+		}
+		list = append(list, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(list)
 }
 
 // SelectNullStr executes the given query, which should be a SELECT
@@ -2289,6 +6347,37 @@ func SelectNullStr(e SqlExecutor, query string, args ...interface{}) (sql.NullSt
 	return h, nil
 }
 
+// RowScanner is the row returned by SelectRow.  Like *sql.Row, errors
+// are deferred until Scan is called.
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// SelectRow executes the given query, which should be a SELECT statement,
+// and returns its first row to scan several values at once without
+// defining a struct.  Errors are deferred until Scan is called, if no rows
+// are found Scan returns sql.ErrNoRows.  Scan releases the timeout of
+// SetQueryTimeout and returns a cancelled query as *QueryTimeoutError, so
+// the row must be scanned.
+//
+// Example:
+//
+//     var min, max int64
+//     err := dbmap.SelectRow("select min(Id), max(Id) from invoice_test").Scan(&min, &max)
+//
+func SelectRow(e SqlExecutor, query string, args ...interface{}) RowScanner {
+	if len(args) == 1 {
+		switch m := e.(type) {
+		case *DbMap:
+			query, args = maybeExpandNamedQuery(m, query, args)
+		case *Transaction:
+			query, args = maybeExpandNamedQuery(m.dbmap, query, args)
+		}
+	}
+	return e.queryRow(query, args...)
+}
+
 // SelectOne executes the given query (which should be a SELECT statement)
 // and binds the result to holder, which must be a pointer.
 //
@@ -2330,7 +6419,7 @@ func SelectOne(m *DbMap, e SqlExecutor, holder interface{}, query string, args .
 		if list != nil && len(list) > 0 {
 			// check for multiple rows
 			if len(list) > 1 {
-				return fmt.Errorf("gorp: multiple rows returned for: %s - %v", query, args)
+				return &MultipleRowsError{Query: query, Args: args}
 			}
 
 			// Initialize if nil
@@ -2352,6 +6441,237 @@ func SelectOne(m *DbMap, e SqlExecutor, holder interface{}, query string, args .
 	return selectVal(e, holder, query, args...)
 }
 
+// SelectOneTo executes the given query (which should be a SELECT statement)
+// and scans the row into holder, which must be a pointer to a struct.
+// Unlike SelectOne no result list is allocated.
+//
+// If no row is found, sql.ErrNoRows is returned and holder is unchanged.
+// If more than one row is found, a *MultipleRowsError is returned.
+//
+func SelectOneTo(m *DbMap, e SqlExecutor, holder interface{}, query string, args ...interface{}) error {
+	t := reflect.TypeOf(holder)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || reflect.ValueOf(holder).IsNil() {
+		return fmt.Errorf("gorp: SelectOneTo holder must be a pointer to a struct, but got: %T", holder)
+	}
+
+	list, err := hookedselectInto(m, e, holder, true, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return err
+	}
+	if len(list) == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// JoinHolder is a destination of SelectJoin.  Holder points to a struct,
+// or to a slice of structs or struct pointers, and receives the columns
+// whose names start with Prefix, with the prefix removed.
+type JoinHolder struct {
+	Holder interface{}
+	Prefix string
+}
+
+// SelectJoin executes the given query (which should be a SELECT statement)
+// and splits each row into the structs of holders, e.g. the columns of an
+// invoice and of its person selected with a join.  A column belongs to the
+// holder with the longest prefix it starts with, a holder with an empty
+// prefix takes the columns no other prefix matches.  Prefixes are matched
+// ignoring case unless DbMap.StrictColumnCase is set.
+//
+// If the holders point to structs, the query must return one row, else
+// sql.ErrNoRows or a *MultipleRowsError is returned.  If they point to
+// slices, a struct is appended to each of them for every row.  The
+// PostGet hooks of the structs are run.
+//
+// Example:
+//
+//     var inv Invoice
+//     var p Person
+//     err := dbmap.SelectJoin([]gorp.JoinHolder{{&inv, "i_"}, {&p, "p_"}},
+//         `select i.Id i_Id, i.Memo i_Memo, p.Id p_Id, p.FName p_FName
+//          from invoice_test i join person_test p on p.Id = i.PersonId
+//          where i.Id = ?`, id)
+//
+func SelectJoin(m *DbMap, e SqlExecutor, holders []JoinHolder, query string, args ...interface{}) error {
+	if len(holders) == 0 {
+		return fmt.Errorf("gorp: SelectJoin needs at least one holder")
+	}
+	types := make([]reflect.Type, len(holders))
+	slices := make([]reflect.Value, len(holders)) // invalid for struct holders
+	pointerElements := make([]bool, len(holders))
+	for h, jh := range holders {
+		v := reflect.ValueOf(jh.Holder)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("gorp: SelectJoin holder must be a non-nil pointer, but got: %T", jh.Holder)
+		}
+		t := v.Type().Elem()
+		if t.Kind() == reflect.Slice {
+			slices[h] = v.Elem()
+			t = t.Elem()
+			if pointerElements[h] = t.Kind() == reflect.Ptr; pointerElements[h] {
+				t = t.Elem()
+			}
+		}
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("gorp: SelectJoin holder must point to a struct or a slice of structs, but got: %T", jh.Holder)
+		}
+		if slices[h].IsValid() != slices[0].IsValid() {
+			return fmt.Errorf("gorp: SelectJoin holders must all point to structs or all to slices")
+		}
+		types[h] = t
+	}
+	intoSlices := slices[0].IsValid()
+
+	if len(args) == 1 {
+		query, args = maybeExpandNamedQuery(m, query, args)
+	}
+
+	rows, err := e.query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	owner, names, err := joinColumns(m, holders, cols)
+	if err != nil {
+		return err
+	}
+	var nonFatalErr error
+	index := make([][][]int, len(holders)) // field index of each column of a holder
+	for h := range holders {
+		index[h], err = columnToFieldIndex(m, types[h], names[h])
+		if err != nil {
+			if !NonFatalError(err) {
+				return err
+			}
+			if nonFatalErr == nil {
+				nonFatalErr = err
+			}
+		}
+	}
+	colToFieldIndex := make([][]int, len(cols))
+	next := make([]int, len(holders))
+	for x, h := range owner {
+		colToFieldIndex[x] = index[h][next[h]]
+		next[h]++
+	}
+
+	starts := make([]int, len(holders)) // length of the slices before the select
+	for h := range slices {
+		if intoSlices {
+			starts[h] = slices[h].Len()
+		}
+	}
+	var values []reflect.Value // struct pointers of the last row
+	n := 0
+	for rows.Next() {
+		if !intoSlices && n > 0 {
+			return &MultipleRowsError{Query: query, Args: args}
+		}
+		n++
+		values = make([]reflect.Value, len(holders))
+		for h := range holders {
+			values[h] = reflect.New(types[h])
+		}
+		dest := make([]interface{}, len(cols))
+		var custScan []CustomScanner
+		for x := range cols {
+			if colToFieldIndex[x] == nil {
+				var dummy sql.RawBytes
+				dest[x] = &dummy
+				continue
+			}
+			f := values[owner[x]].Elem().FieldByIndex(colToFieldIndex[x])
+			dest[x] = f.Addr().Interface()
+			if m.TypeConverter != nil && !selfConverting(f.Type()) {
+				if scanner, ok := convertFromDb(m.TypeConverter, dest[x]); ok {
+					dest[x] = scanner.Holder
+					custScan = append(custScan, scanner)
+				}
+			}
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		for _, c := range custScan {
+			if err = c.Bind(); err != nil {
+				return err
+			}
+		}
+		for h := range slices {
+			if intoSlices {
+				elem := values[h]
+				if !pointerElements[h] {
+					elem = elem.Elem()
+				}
+				slices[h].Set(reflect.Append(slices[h], elem))
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	// run the hooks after the rows are closed, as they may run queries
+	var hooked []interface{}
+	if intoSlices {
+		for h := range slices {
+			if slices[h].IsNil() {
+				slices[h].Set(reflect.MakeSlice(slices[h].Type(), 0, 0))
+			}
+			for i := starts[h]; i < slices[h].Len(); i++ {
+				hooked = append(hooked, slices[h].Index(i).Interface())
+			}
+		}
+	} else {
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		for h, jh := range holders {
+			reflect.ValueOf(jh.Holder).Elem().Set(values[h].Elem())
+			hooked = append(hooked, jh.Holder)
+		}
+	}
+	for _, v := range hooked {
+		if v, ok := v.(HasPostGet); ok {
+			if err = v.PostGet(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nonFatalErr
+}
+
+// joinColumns returns the index of the holder each column belongs to, see
+// SelectJoin, and the column names of each holder without the prefix
+func joinColumns(m *DbMap, holders []JoinHolder, cols []string) ([]int, [][]string, error) {
+	owner := make([]int, len(cols))
+	names := make([][]string, len(holders))
+	for x, col := range cols {
+		owner[x] = -1
+		for h, jh := range holders {
+			hasPrefix := strings.HasPrefix(col, jh.Prefix)
+			if !m.StrictColumnCase {
+				hasPrefix = strings.HasPrefix(strings.ToLower(col), strings.ToLower(jh.Prefix))
+			}
+			if hasPrefix && (owner[x] < 0 || len(jh.Prefix) > len(holders[owner[x]].Prefix)) {
+				owner[x] = h
+			}
+		}
+		if owner[x] < 0 {
+			return nil, nil, fmt.Errorf("gorp: SelectJoin: column %s has none of the holder prefixes", col)
+		}
+		names[owner[x]] = append(names[owner[x]], col[len(holders[owner[x]].Prefix):])
+	}
+	return owner, names, nil
+}
+
 func selectVal(e SqlExecutor, holder interface{}, query string, args ...interface{}) error {
 	if len(args) == 1 {
 		switch m := e.(type) {
@@ -2372,17 +6692,323 @@ func selectVal(e SqlExecutor, holder interface{}, query string, args ...interfac
 		return sql.ErrNoRows
 	}
 
-	return rows.Scan(holder)
+	return rows.Scan(holder)
+}
+
+///////////////
+
+func selectToMap(m *DbMap, exec SqlExecutor, dest interface{}, keyColumn string, query string, args ...interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Map {
+		return fmt.Errorf("gorp: SelectToMap: dest must be a pointer to a map, got %T", dest)
+	}
+	mv := dv.Elem()
+	if mv.IsNil() {
+		mv.Set(reflect.MakeMap(mv.Type()))
+	}
+	keyType := mv.Type().Key()
+	valType := mv.Type().Elem()
+	put := func(key, val reflect.Value) error {
+		if !m.MapKeysLastWins && mv.MapIndex(key).IsValid() {
+			return fmt.Errorf("gorp: SelectToMap: duplicate key %v", key.Interface())
+		}
+		mv.SetMapIndex(key, val)
+		return nil
+	}
+
+	structType := valType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() == reflect.Struct && !reflect.PtrTo(structType).Implements(scannerType) {
+		index, err := columnToFieldIndex(m, structType, []string{keyColumn})
+		if err != nil {
+			return fmt.Errorf("gorp: SelectToMap: key column %s is not mapped to a field of %s", keyColumn, typeName(structType))
+		}
+		if ft := structType.FieldByIndex(index[0]).Type; !ft.ConvertibleTo(keyType) {
+			return fmt.Errorf("gorp: SelectToMap: key column %s of type %s can not be converted to %s", keyColumn, ft, keyType)
+		}
+		list := reflect.New(reflect.SliceOf(reflect.PtrTo(structType)))
+		_, err = hookedselect(m, exec, list.Interface(), query, args...)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < list.Elem().Len(); i++ {
+			row := list.Elem().Index(i)
+			key := row.Elem().FieldByIndex(index[0]).Convert(keyType)
+			if valType.Kind() == reflect.Struct {
+				row = row.Elem()
+			}
+			err = put(key, row)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rows, err := exec.query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) != 2 {
+		return fmt.Errorf("gorp: SelectToMap: query must return the key and one value column, got %d columns", len(cols))
+	}
+	keyIndex := -1
+	for x, col := range cols {
+		if col == keyColumn || (!m.StrictColumnCase && strings.EqualFold(col, keyColumn)) {
+			keyIndex = x
+		}
+	}
+	if keyIndex < 0 {
+		return fmt.Errorf("gorp: SelectToMap: query has no column %s", keyColumn)
+	}
+	for rows.Next() {
+		key := reflect.New(keyType)
+		val := reflect.New(valType)
+		dest := []interface{}{key.Interface(), val.Interface()}
+		if keyIndex == 1 {
+			dest[0], dest[1] = dest[1], dest[0]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		err = put(key.Elem(), val.Elem())
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func selectInto(m *DbMap, exec SqlExecutor, dest interface{}, query string, args ...interface{}) error {
+	t, err := toSliceType(dest)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("gorp: SelectInto dest must be a pointer to a slice, but got: %T", dest)
+	}
+	_, err = hookedselectInto(m, exec, dest, true, query, args...)
+	return err
+}
+
+func selectChan(ctx context.Context, m *DbMap, exec SqlExecutor, holderType interface{}, ch interface{}, query string, args ...interface{}) error {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.SendDir == 0 {
+		return fmt.Errorf("gorp: SelectChan needs a channel to send to, but got: %T", ch)
+	}
+	defer chv.Close()
+	t, err := toType(holderType)
+	if err != nil {
+		return err
+	}
+	et := chv.Type().Elem()
+	if et != t && et != reflect.PtrTo(t) {
+		return fmt.Errorf("gorp: SelectChan of %v can not send to %T", t, ch)
+	}
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: chv},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	send := func(v reflect.Value) error {
+		if et == t {
+			v = v.Elem()
+		}
+		cases[0].Send = v
+		if chosen, _, _ := reflect.Select(cases); chosen == 1 {
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	if !hasPostGet(t) {
+		_, err = rawselectEach(m, exec, holderType, false, send, query, args...)
+		return err
+	}
+	// run the hooks after the rows are closed, as they may run queries
+	list, err := rawselect(m, exec, holderType, false, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return err
+	}
+	for _, v := range list {
+		if err := v.(HasPostGet).PostGet(exec); err != nil {
+			return err
+		}
+		if err := send(reflect.ValueOf(v)); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// hasPostGet returns true if pointers to structs of type t have a PostGet
+// hook
+func hasPostGet(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(reflect.TypeOf((*HasPostGet)(nil)).Elem())
+}
+
+func selectBatchIter(m *DbMap, exec SqlExecutor, holder interface{}, batchSize int, fn func(batch []interface{}) error, query string, args ...interface{}) error {
+	if batchSize < 1 {
+		return fmt.Errorf("gorp: SelectBatchIter needs a batch size of at least 1, but got %d", batchSize)
+	}
+	t, err := toType(holder)
+	if err != nil {
+		return err
+	}
+
+	if hasPostGet(t) {
+		// run the hooks after the rows are closed, as they may run queries
+		list, err := rawselect(m, exec, holder, false, query, args...)
+		if err != nil && !NonFatalError(err) {
+			return err
+		}
+		for len(list) > 0 {
+			n := batchSize
+			if n > len(list) {
+				n = len(list)
+			}
+			batch := list[:n:n]
+			list = list[n:]
+			for _, v := range batch {
+				if err := v.(HasPostGet).PostGet(exec); err != nil {
+					return err
+				}
+			}
+			if ferr := fn(batch); ferr != nil {
+				return ferr
+			}
+		}
+		return err
+	}
+
+	batch := make([]interface{}, 0, batchSize)
+	_, err = rawselectEach(m, exec, holder, false, func(v reflect.Value) error {
+		batch = append(batch, v.Interface())
+		if len(batch) < batchSize {
+			return nil
+		}
+		full := batch
+		batch = make([]interface{}, 0, batchSize)
+		return fn(full)
+	}, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return err
+	}
+	if len(batch) > 0 {
+		if ferr := fn(batch); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
+	args ...interface{}) ([]interface{}, error) {
+	return hookedselectInto(m, exec, i, false, query, args...)
+}
+
+// SelectStmt is a select query prepared with DbMap.PrepareSelect.  The
+// fields the result columns are scanned into are resolved by the first
+// Select and reused by the following ones while the columns stay the
+// same.  A SelectStmt can be used by several goroutines at the same time.
+type SelectStmt struct {
+	dbmap  *DbMap
+	exec   SqlExecutor
+	holder interface{}
+	query  string
+	plan   *selectPlan
+}
+
+// PrepareSelect returns a SelectStmt for query, whose rows are selected
+// like with Select(i, query).  Use it for queries run often, it saves
+// matching the columns to the fields of i for each call.
+//
+// Example:
+//
+//     stmt := dbmap.PrepareSelect(Invoice{}, "select * from invoice_test where PersonId = ?")
+//     list, err := stmt.Select(personId)
+//
+func (m *DbMap) PrepareSelect(i interface{}, query string) *SelectStmt {
+	return &SelectStmt{dbmap: m, exec: m, holder: i, query: query, plan: &selectPlan{}}
+}
+
+// PrepareSelect has the same behavior as DbMap.PrepareSelect(), but the
+// statement runs in this transaction.
+func (t *Transaction) PrepareSelect(i interface{}, query string) *SelectStmt {
+	return &SelectStmt{dbmap: t.dbmap, exec: t, holder: i, query: query, plan: &selectPlan{}}
+}
+
+// Select runs the query with args and returns the rows like DbMap.Select.
+func (s *SelectStmt) Select(args ...interface{}) ([]interface{}, error) {
+	return hookedselectPlan(s.dbmap, s.exec, s.plan, s.holder, false, s.query, args...)
+}
+
+// selectPlan caches the field indexes of the columns of a query, see
+// SelectStmt
+type selectPlan struct {
+	mu    sync.Mutex
+	cols  []string
+	index [][]int
+	err   error // non fatal error of columnToFieldIndex
+}
+
+// columnToFieldIndex returns the result of columnToFieldIndex, cached by p
+// unless p is nil
+func (p *selectPlan) columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error) {
+	if p == nil {
+		return columnToFieldIndex(m, t, cols)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.index != nil && sameColumns(p.cols, cols) {
+		return p.index, p.err
+	}
+	index, err := columnToFieldIndex(m, t, cols)
+	if err != nil && !NonFatalError(err) {
+		return index, err
+	}
+	p.cols, p.index, p.err = cols, index, err
+	return index, err
+}
+
+// sameColumns returns true if a and b hold the same column names
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-///////////////
+// hookedselectInto runs rawselect and the PostGet hooks.  If reuse is true
+// and i is a pointer to a slice, the slice is truncated and its backing
+// array is reused for the results.  If reuse is true and i is a pointer to
+// a struct, the only row is scanned into i.
+func hookedselectInto(m *DbMap, exec SqlExecutor, i interface{}, reuse bool, query string,
+	args ...interface{}) ([]interface{}, error) {
+	return hookedselectPlan(m, exec, nil, i, reuse, query, args...)
+}
 
-func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
+// hookedselectPlan is hookedselectInto, which resolves the fields of the
+// columns with plan
+func hookedselectPlan(m *DbMap, exec SqlExecutor, plan *selectPlan, i interface{}, reuse bool, query string,
 	args ...interface{}) ([]interface{}, error) {
 
 	var nonFatalErr error
 
-	list, err := rawselect(m, exec, i, query, args...)
+	list, err := rawselectPlan(m, exec, plan, i, reuse, nil, query, args...)
 	if err != nil {
 		if !NonFatalError(err) {
 			if m.DebugLevel > 0 {
@@ -2420,8 +7046,22 @@ func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	return list, nonFatalErr
 }
 
-func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
+func rawselect(m *DbMap, exec SqlExecutor, i interface{}, reuse bool, query string,
 	args ...interface{}) ([]interface{}, error) {
+	return rawselectEach(m, exec, i, reuse, nil, query, args...)
+}
+
+// rawselectEach is rawselect, which passes each struct row to each instead
+// of returning it in the list if each is not nil
+func rawselectEach(m *DbMap, exec SqlExecutor, i interface{}, reuse bool, each func(v reflect.Value) error,
+	query string, args ...interface{}) ([]interface{}, error) {
+	return rawselectPlan(m, exec, nil, i, reuse, each, query, args...)
+}
+
+// rawselectPlan is rawselectEach, which resolves the fields of the columns
+// with plan
+func rawselectPlan(m *DbMap, exec SqlExecutor, plan *selectPlan, i interface{}, reuse bool, each func(v reflect.Value) error,
+	query string, args ...interface{}) ([]interface{}, error) {
 	var (
 		appendToSlice   = false // Write results to i directly?
 		intoStruct      = true  // Selecting into a struct?
@@ -2485,10 +7125,17 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 		return nil, fmt.Errorf("gorp: select into non-struct slice requires 1 column, got %d", len(cols))
 	}
 
+	var colTypes []*sql.ColumnType
+	if intoStruct {
+		colTypes, err = rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var colToFieldIndex [][]int
 	if intoStruct {
-		// TODO - try to cache the columnToFieldIndex map
-		colToFieldIndex, err = columnToFieldIndex(m, t, cols)
+		colToFieldIndex, err = plan.columnToFieldIndex(m, t, cols)
 		if err != nil {
 			if !NonFatalError(err) {
 				return nil, err
@@ -2499,12 +7146,171 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 
 	conv := m.TypeConverter
 
+	// Resolve the scan converters of mapped columns
+	var colConvs []TypeConverter
+	if intoStruct {
+		if table := tableOrNil(m, t); table != nil {
+			for x := range cols {
+				if colToFieldIndex[x] == nil {
+					continue
+				}
+				col := colMapForField(table, fieldPath(t, colToFieldIndex[x]))
+				if col == nil || (col.ScanAs == "" && col.crypto == nil && col.nullSentinel == nil) {
+					continue
+				}
+				if colConvs == nil {
+					colConvs = make([]TypeConverter, len(cols))
+				}
+				colConvs[x], err = table.converterFor(col.fieldName)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Find the numeric columns of numeric fields, which drivers may return
+	// as text
+	var decimalCols []bool
+	if intoStruct {
+		table := tableOrNil(m, t)
+		for x := range cols {
+			if colToFieldIndex[x] == nil || !decimalField(t.FieldByIndex(colToFieldIndex[x]).Type) {
+				continue
+			}
+			decimal := isDecimalType(colTypes[x].DatabaseTypeName())
+			if !decimal && table != nil {
+				if col := colMapForField(table, fieldPath(t, colToFieldIndex[x])); col != nil {
+					decimal = isDecimalType(col.DbType)
+				}
+			}
+			if decimal {
+				if decimalCols == nil {
+					decimalCols = make([]bool, len(cols))
+				}
+				decimalCols[x] = true
+			}
+		}
+	}
+
+	// customScanner returns the CustomScanner which reads column x into f
+	customScanner := func(x int, f reflect.Value) (CustomScanner, bool) {
+		target := f.Addr().Interface()
+		c := conv
+		if selfConverting(f.Type()) {
+			c = nil
+		}
+		if colConvs != nil && colConvs[x] != nil {
+			c = colConvs[x]
+		}
+		if c != nil {
+			if scanner, ok := convertFromDb(c, target); ok {
+				return scanner, true
+			}
+		}
+		if m.autoJSON(f.Type()) {
+			return jsonConverter{}.FromDb(target)
+		}
+		if m.DynamicTypes && f.Kind() == reflect.Interface {
+			return dynamicScanner(colTypes[x], target), true
+		}
+		if e, ok := m.intEnums[f.Type()]; ok {
+			return enumScanner(target, e, m.NullAsZero), true
+		}
+		if decimalCols != nil && decimalCols[x] {
+			if m.NullAsZero {
+				return zeroOnNull(decimalScanner(target)), true
+			}
+			return decimalScanner(target), true
+		}
+		if m.NullAsZero && f.Kind() != reflect.Ptr && f.Kind() != reflect.Interface &&
+			!reflect.PtrTo(f.Type()).Implements(scannerType) {
+			return nullAsZeroScanner(target), true
+		}
+		return CustomScanner{}, false
+	}
+
+	// Find the columns claimed by scanners with ColumnHolders, see there
+	claims := make([]int, len(cols))
+	if intoStruct {
+		probe := reflect.New(t).Elem()
+		claimed := false
+		for x := 0; x < len(cols); x++ {
+			if colToFieldIndex[x] == nil {
+				continue
+			}
+			scanner, ok := customScanner(x, probe.FieldByIndex(colToFieldIndex[x]))
+			h, multi := scanner.Holder.(ColumnHolders)
+			if !ok || !multi {
+				continue
+			}
+			field := fieldPath(t, colToFieldIndex[x])
+			if x+len(h) > len(cols) {
+				return nil, fmt.Errorf("gorp: field %s claims %d columns from column %s, but only %d are left", field, len(h), cols[x], len(cols)-x)
+			}
+			for k := 1; k < len(h); k++ {
+				if colToFieldIndex[x+k] != nil {
+					return nil, fmt.Errorf("gorp: column %s claimed by field %s is mapped to field %s", cols[x+k], field, fieldPath(t, colToFieldIndex[x+k]))
+				}
+			}
+			claims[x] = len(h)
+			claimed = true
+			x += len(h) - 1
+		}
+		if _, ok := nonFatalErr.(*NoFieldInTypeError); ok && claimed {
+			nonFatalErr = missingColumns(t, cols, colToFieldIndex, claims)
+		}
+	}
+
+	// Resolve the ColumnMap for each column if a scan interceptor is set
+	var interceptCols []*ColumnMap
+	if m.scanInterceptor != nil {
+		interceptCols = make([]*ColumnMap, len(cols))
+		if intoStruct {
+			table := tableOrNil(m, t)
+			for x := range cols {
+				if colToFieldIndex[x] == nil {
+					continue
+				}
+				sf := t.FieldByIndex(colToFieldIndex[x])
+				fieldName := fieldPath(t, colToFieldIndex[x])
+				if table != nil {
+					interceptCols[x] = colMapForField(table, fieldName)
+				}
+				if interceptCols[x] == nil {
+					interceptCols[x] = &ColumnMap{ColumnName: cols[x], fieldName: fieldName, gotype: sf.Type}
+				}
+			}
+		} else {
+			interceptCols[0] = &ColumnMap{ColumnName: cols[0], gotype: t}
+		}
+	}
+
+	// scanErr wraps err with the table, column and field of column x
+	scanErr := func(x int, err error) error {
+		if !intoStruct || x < 0 || x >= len(cols) || colToFieldIndex[x] == nil {
+			return err
+		}
+		se := &ScanError{TableName: typeName(t), ColumnName: cols[x], FieldName: fieldPath(t, colToFieldIndex[x]), Err: err}
+		if table := tableOrNil(m, t); table != nil {
+			se.TableName = table.TableName
+		}
+		return se
+	}
+
 	// Add results to one of these two slices.
 	var (
 		list       = make([]interface{}, 0)
 		sliceValue = reflect.Indirect(reflect.ValueOf(i))
 	)
 
+	// reuse of a struct pointer scans the only row into i
+	scanInto := reuse && !appendToSlice && intoStruct
+	reuse = reuse && appendToSlice
+	if reuse {
+		sliceValue.SetLen(0)
+	}
+
 	for {
 		if !rows.Next() {
 			// if error occured return rawselect
@@ -2515,12 +7321,24 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 			break
 		}
 
-		v := reflect.New(t)
+		var v reflect.Value
+		if scanInto {
+			if len(list) > 0 {
+				return nil, &MultipleRowsError{Query: query, Args: args}
+			}
+			v = reflect.ValueOf(i)
+			v.Elem().Set(reflect.Zero(t))
+		} else if reuse {
+			v = nextSliceElem(sliceValue, t, pointerElements)
+		} else {
+			v = reflect.New(t)
+		}
 		dest := make([]interface{}, len(cols))
 
 		custScan := make([]CustomScanner, 0)
+		custCols := make([]int, 0) // column index of each CustomScanner
 
-		for x := range cols {
+		for x := 0; x < len(cols); x++ {
 			f := v.Elem()
 			if intoStruct {
 				index := colToFieldIndex[x]
@@ -2533,35 +7351,61 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 				}
 				f = f.FieldByIndex(index)
 			}
-			target := f.Addr().Interface()
-			if conv != nil {
-				scanner, ok := conv.FromDb(target)
-				if ok {
-					target = scanner.Holder
-					custScan = append(custScan, scanner)
+			scanner, ok := customScanner(x, f)
+			if !ok {
+				dest[x] = f.Addr().Interface()
+				continue
+			}
+			custScan = append(custScan, scanner)
+			custCols = append(custCols, x)
+			if h, multi := scanner.Holder.(ColumnHolders); multi && claims[x] > 0 {
+				if len(h) != claims[x] {
+					return nil, scanErr(x, fmt.Errorf("gorp: %d column holders instead of %d", len(h), claims[x]))
 				}
+				copy(dest[x:], h)
+				x += len(h) - 1
+				continue
 			}
-			dest[x] = target
+			dest[x] = scanner.Holder
 		}
 
 		err = rows.Scan(dest...)
 		if err != nil {
-			return nil, err
+			return nil, scanErr(scanErrorColumn(err), err)
 		}
 
-		for _, c := range custScan {
+		for x, c := range custScan {
 			err = c.Bind()
+			if err != nil {
+				return nil, scanErr(custCols[x], err)
+			}
+		}
+
+		for x, col := range interceptCols {
+			if col == nil {
+				continue
+			}
+			f := v.Elem()
+			if intoStruct {
+				f = f.FieldByIndex(colToFieldIndex[x])
+			}
+			err = m.interceptScan(col, f)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		if appendToSlice {
+		// when reusing, v already points into sliceValue
+		if appendToSlice && !reuse {
 			if !pointerElements {
 				v = v.Elem()
 			}
 			sliceValue.Set(reflect.Append(sliceValue, v))
-		} else {
+		} else if each != nil {
+			if err = each(v); err != nil {
+				return nil, err
+			}
+		} else if !appendToSlice {
 			list = append(list, v.Interface())
 		}
 	}
@@ -2573,6 +7417,171 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	return list, nonFatalErr
 }
 
+var (
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	rawBytesType       = reflect.TypeOf(sql.RawBytes{})
+	bytesType          = reflect.TypeOf([]byte{})
+	stringType         = reflect.TypeOf("")
+)
+
+// dynamicScanType returns the Go type used to scan a column into an
+// interface{} field when DbMap.DynamicTypes is set.  The driver's scan type
+// is used, except that raw bytes of character columns become a string and
+// unknown types fall back to the plain driver value.
+func dynamicScanType(ct *sql.ColumnType) reflect.Type {
+	st := ct.ScanType()
+	if st == nil || st.Kind() == reflect.Interface {
+		return emptyInterfaceType
+	}
+	if st == rawBytesType || st == bytesType {
+		switch strings.ToUpper(ct.DatabaseTypeName()) {
+		case "CHAR", "VARCHAR", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT",
+			"BPCHAR", "NCHAR", "NVARCHAR", "NTEXT", "ENUM", "SET", "JSON":
+			return stringType
+		}
+		return bytesType
+	}
+	return st
+}
+
+// isDecimalType returns true for the numeric and decimal column types,
+// which drivers may return as text like "12.50"
+func isDecimalType(name string) bool {
+	name = strings.ToUpper(name)
+	return strings.HasPrefix(name, "NUMERIC") || strings.HasPrefix(name, "DECIMAL") || name == "NEWDECIMAL"
+}
+
+// decimalField returns true for integer and float fields, and pointers to
+// them, which do not scan themselves
+func decimalField(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// decimalScanner returns a CustomScanner which reads a numeric column as
+// text and parses it into the integer or float field target points to.
+// Integer fields accept integral values like "12.00" only.
+func decimalScanner(target interface{}) CustomScanner {
+	binder := func(holder, target interface{}) error {
+		s := holder.(*sql.NullString)
+		f := reflect.ValueOf(target).Elem()
+		if f.Kind() == reflect.Ptr {
+			if !s.Valid {
+				f.Set(reflect.Zero(f.Type()))
+				return nil
+			}
+			v := reflect.New(f.Type().Elem())
+			if err := setDecimal(v.Elem(), s.String); err != nil {
+				return err
+			}
+			f.Set(v)
+			return nil
+		}
+		if !s.Valid {
+			return fmt.Errorf("gorp: converting NULL to %s is unsupported", f.Type())
+		}
+		return setDecimal(f, s.String)
+	}
+	return CustomScanner{new(sql.NullString), target, binder}
+}
+
+// setDecimal parses the decimal s into the integer or float field f
+func setDecimal(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || !r.IsInt() {
+		return fmt.Errorf("gorp: can not convert decimal %q to %s", s, f.Type())
+	}
+	n := r.Num()
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !n.IsInt64() || f.OverflowInt(n.Int64()) {
+			return fmt.Errorf("gorp: decimal %q overflows %s", s, f.Type())
+		}
+		f.SetInt(n.Int64())
+	default:
+		if !n.IsUint64() || f.OverflowUint(n.Uint64()) {
+			return fmt.Errorf("gorp: decimal %q overflows %s", s, f.Type())
+		}
+		f.SetUint(n.Uint64())
+	}
+	return nil
+}
+
+// dynamicScanner returns a CustomScanner which scans the column described
+// by ct into a holder of the dynamic scan type and stores the result in the
+// interface{} pointed to by target.
+func dynamicScanner(ct *sql.ColumnType, target interface{}) CustomScanner {
+	return CustomScanner{
+		Holder: reflect.New(dynamicScanType(ct)).Interface(),
+		Target: target,
+		Binder: func(holder interface{}, target interface{}) error {
+			v := reflect.ValueOf(holder).Elem().Interface()
+			// sql.NullInt64 and friends are unwrapped to their value or nil
+			if valuer, ok := v.(driver.Valuer); ok {
+				dv, err := valuer.Value()
+				if err != nil {
+					return err
+				}
+				v = dv
+			}
+			t := reflect.ValueOf(target).Elem()
+			if v == nil {
+				t.Set(reflect.Zero(t.Type()))
+				return nil
+			}
+			val := reflect.ValueOf(v)
+			if !val.Type().AssignableTo(t.Type()) {
+				return fmt.Errorf("gorp: cannot assign column %s of type %v to field of type %v", ct.Name(), val.Type(), t.Type())
+			}
+			t.Set(val)
+			return nil
+		},
+	}
+}
+
+// nextSliceElem grows sliceValue by one element, reusing its capacity, and
+// returns a pointer to the new, zeroed element.  For slices of pointers an
+// existing struct in the backing array is reused if there is one.
+func nextSliceElem(sliceValue reflect.Value, t reflect.Type, pointerElements bool) reflect.Value {
+	n := sliceValue.Len()
+	if n < sliceValue.Cap() {
+		sliceValue.SetLen(n + 1)
+	} else {
+		sliceValue.Set(reflect.Append(sliceValue, reflect.Zero(sliceValue.Type().Elem())))
+	}
+	e := sliceValue.Index(n)
+	if !pointerElements {
+		e.Set(reflect.Zero(t))
+		return e.Addr()
+	}
+	if e.IsNil() {
+		e.Set(reflect.New(t))
+	} else {
+		e.Elem().Set(reflect.Zero(t))
+	}
+	return e
+}
+
 // Calls the Exec function on the executor, but attempts to expand any eligible named
 // query arguments first.
 func exec(e SqlExecutor, query string, args ...interface{}) (sql.Result, error) {
@@ -2591,7 +7600,10 @@ func exec(e SqlExecutor, query string, args ...interface{}) (sql.Result, error)
 		query, args = maybeExpandNamedQuery(dbMap, query, args)
 	}
 
-	return executor.Exec(query, args...)
+	ctx, cancel := dbMap.timeoutContext()
+	defer cancel()
+	res, err := executor.ExecContext(ctx, query, args...)
+	return res, dbMap.timeoutError(ctx, query, err)
 }
 
 // maybeExpandNamedQuery checks the given arg to see if it's eligible to be used
@@ -2609,7 +7621,7 @@ func maybeExpandNamedQuery(m *DbMap, query string, args []interface{}) (string,
 	}
 
 	if argval.Kind() == reflect.Map && argval.Type().Key().Kind() == reflect.String {
-		return expandNamedQuery(m, query, func(key string) reflect.Value {
+		return expandNamedQuery(m, query, 0, func(key string) reflect.Value {
 			return argval.MapIndex(reflect.ValueOf(key))
 		})
 	}
@@ -2625,7 +7637,7 @@ func maybeExpandNamedQuery(m *DbMap, query string, args []interface{}) (string,
 		return query, args
 	}
 
-	return expandNamedQuery(m, query, argval.FieldByName)
+	return expandNamedQuery(m, query, 0, argval.FieldByName)
 }
 
 var keyRegexp = regexp.MustCompile(`:[[:word:]]+`)
@@ -2633,12 +7645,9 @@ var keyRegexp = regexp.MustCompile(`:[[:word:]]+`)
 // expandNamedQuery accepts a query with placeholders of the form ":key", and a
 // single arg of Kind Struct or Map[string].  It returns the query with the
 // dialect's placeholders, and a slice of args ready for positional insertion
-// into the query.
-func expandNamedQuery(m *DbMap, query string, keyGetter func(key string) reflect.Value) (string, []interface{}) {
-	var (
-		n    int
-		args []interface{}
-	)
+// into the query.  The bindvars are numbered from n.
+func expandNamedQuery(m *DbMap, query string, n int, keyGetter func(key string) reflect.Value) (string, []interface{}) {
+	var args []interface{}
 	return keyRegexp.ReplaceAllStringFunc(query, func(key string) string {
 		val := keyGetter(key[1:])
 		if !val.IsValid() {
@@ -2651,6 +7660,25 @@ func expandNamedQuery(m *DbMap, query string, keyGetter func(key string) reflect
 	}), args
 }
 
+// missingColumns returns a NoFieldInTypeError for the columns which are
+// neither mapped to a field nor claimed by the ColumnHolders of one, or nil
+func missingColumns(t reflect.Type, cols []string, colToFieldIndex [][]int, claims []int) error {
+	var missing []string
+	for x := 0; x < len(cols); x++ {
+		if claims[x] > 0 {
+			x += claims[x] - 1
+			continue
+		}
+		if colToFieldIndex[x] == nil {
+			missing = append(missing, strings.ToLower(cols[x]))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &NoFieldInTypeError{TypeName: typeName(t), MissingColNames: missing}
+}
+
 // columnToFieldIndex
 func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error) {
 	colToFieldIndex := make([][]int, len(cols))
@@ -2669,6 +7697,12 @@ func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error
 	missingColNames := []string{}
 	for x := range cols {
 		colName := strings.ToLower(cols[x])
+		matches := func(name string) bool {
+			if m.StrictColumnCase {
+				return cols[x] == name
+			}
+			return colName == strings.ToLower(name)
+		}
 
 		field, found := t.FieldByNameFunc(func(fieldName string) bool {
 			field, _ := t.FieldByName(fieldName)
@@ -2686,7 +7720,8 @@ func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error
 				log.Println("----- columnToFieldIndex END -----------")
 			}
 
-			if pt.Transient {
+			if pt.Transient || field.PkgPath != "" {
+				// ignored and unexported fields are never scan targets
 				return false
 			} else if pt.ColumnName == "" {
 				pt.ColumnName = field.Name
@@ -2703,7 +7738,7 @@ func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error
 				}
 			}
 
-			ColMatches := (colName == strings.ToLower(pt.ColumnName))
+			ColMatches := matches(pt.ColumnName) || (pt.SelectAs != "" && matches(pt.SelectAs))
 
 			if m.DebugLevel > 3 {
 				// DEBUG
@@ -2718,6 +7753,12 @@ func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error
 		})
 		if found {
 			colToFieldIndex[x] = field.Index
+		} else if tableMapped {
+			// columns of expanded struct fields are not promoted fields
+			colMap := colMapOrNil(table, colName)
+			if colMap != nil && strings.Contains(colMap.fieldName, ".") && matches(colMap.ColumnName) {
+				colToFieldIndex[x] = fieldIndexByPath(t, colMap.fieldName)
+			}
 		}
 		if colToFieldIndex[x] == nil {
 			missingColNames = append(missingColNames, colName)
@@ -2732,13 +7773,58 @@ func columnToFieldIndex(m *DbMap, t reflect.Type, cols []string) ([][]int, error
 	}
 	if len(missingColNames) > 0 {
 		return colToFieldIndex, &NoFieldInTypeError{
-			TypeName:        t.Name(),
+			TypeName:        typeName(t),
 			MissingColNames: missingColNames,
 		}
 	}
 	return colToFieldIndex, nil
 }
 
+// fieldByPath returns the field of elem a ColumnMap field name refers to.
+// Columns of expanded struct fields have dotted names like "Pos.Lat".
+func fieldByPath(elem reflect.Value, path string) reflect.Value {
+	for {
+		i := strings.IndexByte(path, '.')
+		if i < 0 {
+			return elem.FieldByName(path)
+		}
+		elem = elem.FieldByName(path[:i])
+		path = path[i+1:]
+	}
+}
+
+// fieldIndexByPath returns the index sequence of the field of t a dotted
+// ColumnMap field name refers to, or nil
+func fieldIndexByPath(t reflect.Type, path string) []int {
+	var index []int
+	for _, name := range strings.Split(path, ".") {
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return nil
+		}
+		index = append(index, f.Index...)
+		t = f.Type
+	}
+	return index
+}
+
+// fieldPath returns the ColumnMap field name of the field of t at index.
+// Embedded structs are skipped, their fields are promoted.
+func fieldPath(t reflect.Type, index []int) string {
+	var names []string
+	for _, i := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		f := t.Field(i)
+		if !f.Anonymous {
+			names = append(names, f.Name)
+		}
+		t = f.Type
+	}
+	return strings.Join(names, ".")
+}
+
 func fieldByName(val reflect.Value, fieldName string) *reflect.Value {
 	// try to find field by exact match
 	f := val.FieldByName(fieldName)
@@ -2781,6 +7867,15 @@ func toSliceType(i interface{}) (reflect.Type, error) {
 	return t.Elem(), nil
 }
 
+// typeName returns the name of t, or its definition for anonymous structs
+// which are used as Select destinations
+func typeName(t reflect.Type) string {
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.Name()
+}
+
 func toType(i interface{}) (reflect.Type, error) {
 	t := reflect.TypeOf(i)
 
@@ -2795,54 +7890,185 @@ func toType(i interface{}) (reflect.Type, error) {
 	return t, nil
 }
 
-func get(m *DbMap, exec SqlExecutor, i interface{}, getChilds bool, ChildLimit int64, ChildOffset int64,
-	keys ...interface{}) (interface{}, error) {
-
-	t, err := toType(i)
+func getByExample(m *DbMap, exec SqlExecutor, i interface{}) (interface{}, error) {
+	if _, err := toType(i); err != nil {
+		return nil, err
+	}
+	elem := reflect.Indirect(reflect.ValueOf(i))
+	table, err := m.TableFor(elem.Type(), true)
 	if err != nil {
 		return nil, err
 	}
+	d := m.Dialect
 
-	table, err := m.TableFor(t, true)
+	var names []string
+	for _, col := range table.Columns {
+		if !col.Transient {
+			names = append(names, col.ColumnName)
+		}
+	}
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	s.WriteString(quotedList(d, names))
+	s.WriteString(" from ")
+	s.WriteString(d.QuotedTableForQuery(table.SchemaName, table.TableName))
+	var args []interface{}
+	for _, col := range table.keys {
+		f := fieldByPath(elem, col.fieldName)
+		if f.IsZero() {
+			continue
+		}
+		if len(args) == 0 {
+			s.WriteString(" where ")
+		} else {
+			s.WriteString(" and ")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(d.BindVar(len(args)))
+
+		val := f.Interface()
+		conv, err := table.converterFor(col.fieldName)
+		if err != nil {
+			return nil, err
+		}
+		if conv != nil {
+			val, err = convertToDb(conv, val)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			val = bindValue(val)
+		}
+		args = append(args, val)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("gorp: GetByExample from table %s needs a non-zero key field", table.TableName)
+	}
+	s.WriteString(d.QuerySuffix())
+
+	holder := reflect.New(elem.Type())
+	err = SelectOne(m, exec, holder.Interface(), s.String(), args...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	return holder.Interface(), nil
+}
 
-	plan := table.bindGet()
-
-	v := reflect.New(t)
+// getRow scans the row of table with the keys into v, a pointer to a new
+// struct.  It returns false if there is no such row.
+func getRow(m *DbMap, exec SqlExecutor, table *TableMap, plan bindPlan, v reflect.Value, keys ...interface{}) (bool, error) {
 	dest := make([]interface{}, len(plan.argFields))
 
-	conv := m.TypeConverter
 	custScan := make([]CustomScanner, 0)
+	custFields := make([]string, 0) // field of each CustomScanner
 
 	for x, fieldName := range plan.argFields {
-		f := v.Elem().FieldByName(fieldName)
+		f := fieldByPath(v.Elem(), fieldName)
 		target := f.Addr().Interface()
+		conv, err := table.converterFor(fieldName)
+		if err != nil {
+			return false, err
+		}
+		converted := false
 		if conv != nil {
-			scanner, ok := conv.FromDb(target)
+			scanner, ok := convertFromDb(conv, target)
 			if ok {
 				target = scanner.Holder
 				custScan = append(custScan, scanner)
+				custFields = append(custFields, fieldName)
+				converted = true
 			}
 		}
+		if col := colMapForField(table, fieldName); !converted && col != nil && isDecimalType(col.DbType) && decimalField(f.Type()) {
+			scanner := decimalScanner(target)
+			target = scanner.Holder
+			custScan = append(custScan, scanner)
+			custFields = append(custFields, fieldName)
+		}
 		dest[x] = target
 	}
 
-	row := exec.queryRow(plan.query, keys...)
-	err = row.Scan(dest...)
+	row := exec.queryRowFields(&argFields{table, plan.keyFields}, plan.query, keys...)
+	err := row.Scan(dest...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if x := scanErrorColumn(err); x >= 0 && x < len(plan.argFields) {
+			err = table.scanError(plan.argFields[x], err)
+		}
+		return false, err
+	}
+
+	for x, c := range custScan {
+		err = c.Bind()
+		if err != nil {
+			return false, table.scanError(custFields[x], err)
+		}
+	}
+
+	if m.scanInterceptor != nil {
+		for _, fieldName := range plan.argFields {
+			err = m.interceptScan(colMapForField(table, fieldName), fieldByPath(v.Elem(), fieldName))
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func refresh(m *DbMap, exec SqlExecutor, ptr interface{}) error {
+	table, elem, err := m.tableForPointer(ptr, true)
+	if err != nil {
+		return err
+	}
+	keys, err := table.keyArgs(elem)
+	if err != nil {
+		return err
+	}
+
+	// scan into a new struct, so a failed scan leaves ptr unchanged
+	v := reflect.New(elem.Type())
+	found, err := getRow(m, exec, table, table.bindGet(), v, keys...)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("gorp: Refresh found no row in table %s with key %v: %w", table.TableName, keys, sql.ErrNoRows)
+	}
+	for _, fieldName := range table.bindGet().argFields {
+		fieldByPath(elem, fieldName).Set(fieldByPath(v.Elem(), fieldName))
+	}
+
+	if v, ok := ptr.(HasPostGet); ok {
+		return v.PostGet(exec)
+	}
+	return nil
+}
+
+func get(m *DbMap, exec SqlExecutor, i interface{}, getChilds bool, ChildLimit int64, ChildOffset int64,
+	keys ...interface{}) (interface{}, error) {
+
+	t, err := toType(i)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := m.TableFor(t, true)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			err = nil
-		}
 		return nil, err
 	}
 
-	for _, c := range custScan {
-		err = c.Bind()
-		if err != nil {
-			return nil, err
-		}
+	v := reflect.New(t)
+	found, err := getRow(m, exec, table, table.bindGet(), v, keys...)
+	if err != nil || !found {
+		return nil, err
 	}
 
 	if getChilds {
@@ -2865,9 +8091,13 @@ func get(m *DbMap, exec SqlExecutor, i interface{}, getChilds bool, ChildLimit i
 			}
 			if fv.Kind() == reflect.Slice {
 
+				fkColumn := r.ForeignKeyFieldName
+				if col := colMapForField(r.DetailTable, r.ForeignKeyFieldName); col != nil {
+					fkColumn = col.ColumnName
+				}
 				sql := fmt.Sprintf("select * from %s where %s = %d",
-					m.Dialect.QuotedTableForQuery(table.SchemaName, r.DetailTable.TableName),
-					m.Dialect.QuoteField(r.ForeignKeyFieldName), PkId)
+					m.Dialect.QuotedTableForQuery(r.DetailTable.SchemaName, r.DetailTable.TableName),
+					m.Dialect.QuoteField(fkColumn), PkId)
 
 				if (ChildLimit > -1) && (ChildOffset > -1) {
 					sql = fmt.Sprintf(sql+" limit %d offset %d", ChildLimit, ChildOffset)
@@ -2919,7 +8149,7 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
-		res, err := exec.Exec(bi.query, bi.args...)
+		res, err := exec.execFields(bi.boundArgs(), bi.query, bi.args...)
 		if err != nil {
 			return -1, err
 		}
@@ -2954,130 +8184,667 @@ func update(m *DbMap, exec SqlExecutor, updateChilds bool, list ...interface{})
 	count := int64(0)
 	for _, ptr := range list {
 
-		// Check if a pointer to reflect.Value has been passed
-		if reflect.TypeOf(ptr).String() == "*reflect.Value" {
-			// Indirect from Pointer to Value
-			ptr = *ptr.(*reflect.Value)
+		// Check if a pointer to reflect.Value has been passed
+		if reflect.TypeOf(ptr).String() == "*reflect.Value" {
+			// Indirect from Pointer to Value
+			ptr = *ptr.(*reflect.Value)
+		}
+
+		// Check if a reflect.Value has been passed
+		if reflect.TypeOf(ptr).String() == "reflect.Value" {
+			elem = ptr.(reflect.Value)
+			table, err = m.TableFor(elem.Type(), false)
+			if err != nil {
+				return -1, err
+			}
+		} else {
+
+			table, elem, err = m.tableForPointer(ptr, false)
+			if err != nil {
+				return -1, err
+			}
+		}
+		if len(table.keys) == 0 {
+			return -1, fmt.Errorf("gorp: cannot update table %s without primary key, call SetKeys or use UpdateWhere", table.TableName)
+		}
+
+		eval := elem.Addr().Interface()
+		if v, ok := eval.(HasPreUpdate); ok {
+			err = v.PreUpdate(exec)
+			if err != nil {
+				return -1, err
+			}
+		}
+		// Get the primaty key for this table
+		// Use the first PK found, multiple PKs are not supported
+		// by now and will yield an error
+		PkId, _, err := m.GetPrimaryKey(*table, elem)
+		if err != nil {
+			return count, errors.New("GetPrimaryKey failed: " + err.Error())
+		}
+		if m.DebugLevel > 2 {
+			fmt.Printf("Update table %s with primarykey %d\n", table.TableName, PkId)
+		}
+
+		// changed is nil unless the struct is tracked, see Track
+		var changed map[*ColumnMap]bool
+		if snap := m.snapshotOf(eval); snap != nil {
+			changed = table.changedColumns(elem, snap)
+		}
+
+		var bi bindInstance
+		var rows int64
+		if PkId == 0 {
+			err = insert(m, exec, false, ptr)
+			//bi, err = table.bindInsert(elem)
+			if err != nil {
+				return -1, err
+			}
+			rows = 1
+			if m.DebugLevel > 2 {
+				fmt.Printf("Update table %s has empty primary key, doing insert\n", table.TableName)
+			}
+		} else if changed != nil && len(changed) == 0 {
+			if m.DebugLevel > 2 {
+				fmt.Printf("Update table %s skipped, no column changed\n", table.TableName)
+			}
+		} else {
+			if changed != nil {
+				bi, err = table.bindUpdateChanged(elem, changed)
+			} else {
+				bi, err = table.bindUpdate(elem)
+			}
+			if err != nil {
+				return -1, err
+			}
+			res, err := exec.execFields(bi.boundArgs(), bi.query, bi.args...)
+			if err != nil {
+				return -1, writeError("update", table, err)
+			}
+			rows, err = res.RowsAffected()
+			if m.DebugLevel > 2 {
+				fmt.Printf("Update RowsAffected %d\n", rows)
+			}
+			if err != nil {
+				return -1, err
+			}
+
+			if rows == 0 && bi.existingVersion > 0 {
+				return lockError(m, exec, table.TableName,
+					bi.existingVersion, elem, bi.keys...)
+			}
+
+			if bi.versField != "" {
+				fieldByPath(elem, bi.versField).SetInt(bi.existingVersion + 1)
+			}
+			if changed != nil {
+				m.setSnapshot(eval, table.snapshot(elem))
+			}
+		}
+
+		count += rows
+		// Store info about this update operation
+		m.LastOpInfo.Type = Update
+		m.LastOpInfo.BindPlanUsed = &table.updatePlan
+		if bi.plan != nil {
+			m.LastOpInfo.BindPlanUsed = bi.plan
+		}
+		m.LastOpInfo.RowCount = count
+
+		if updateChilds {
+			// Get the primaty key for this table
+			// Use the first PK found, multiple PKs are not supported
+			// by now and will yield an error
+			PkId, _, err = m.GetPrimaryKey(*table, elem)
+			if err != nil {
+				return count, errors.New("GetPrimaryKey failed: " + err.Error())
+			}
+			if PkId == 0 {
+				return -1, errors.New(fmt.Sprintf("Update childs of table %s failed, primary key is zero\n", table.TableName))
+			}
+			//m.SetPrimaryKey(*table, elem, PkId)
+			if m.DebugLevel > 2 {
+				fmt.Printf("Update childs of table %s with primarykey %d\n", table.TableName, PkId)
+			}
+			// Update child records if present
+			for _, r := range table.Relations {
+
+				fv := elem.FieldByName(r.MasterFieldName)
+
+				if fv.Kind() == reflect.Slice {
+					updatecount, insertcount, err := m.UpdateDetailsFromSlice(elem, r, PkId)
+
+					if err != nil {
+						return count, errors.New("Update child relation on table '" + r.DetailTable.TableName + "' failed: " + err.Error())
+					}
+					m.LastOpInfo.ChildUpdateRowCount += updatecount
+					m.LastOpInfo.ChildInsertRowCount += insertcount
+				}
+			}
+		}
+
+		if v, ok := eval.(HasPostUpdate); ok {
+			err = v.PostUpdate(exec)
+			if err != nil {
+				return -1, err
+			}
+		}
+	}
+	return count, nil
+}
+
+// batchGroup holds the rows of one table for updateBatch
+type batchGroup struct {
+	table *TableMap
+	ptrs  []interface{}
+	elems []reflect.Value
+}
+
+func updateBatch(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	count := int64(0)
+	var groups []*batchGroup
+
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, true)
+		if err != nil {
+			return -1, err
+		}
+		if len(table.keys) != 1 || table.version != nil || fieldByPath(elem, table.keys[0].fieldName).IsZero() {
+			rows, err := update(m, exec, false, ptr)
+			if err != nil {
+				return -1, err
+			}
+			count += rows
+			continue
+		}
+
+		var group *batchGroup
+		for _, g := range groups {
+			if g.table == table {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &batchGroup{table: table}
+			groups = append(groups, group)
+		}
+		group.ptrs = append(group.ptrs, ptr)
+		group.elems = append(group.elems, elem)
+	}
+
+	for _, g := range groups {
+		if len(g.elems) == 1 {
+			rows, err := update(m, exec, false, g.ptrs[0])
+			if err != nil {
+				return -1, err
+			}
+			count += rows
+			continue
+		}
+
+		for _, elem := range g.elems {
+			if v, ok := elem.Addr().Interface().(HasPreUpdate); ok {
+				err := v.PreUpdate(exec)
+				if err != nil {
+					return -1, err
+				}
+			}
+		}
+
+		size := batchSize(m.Dialect, g.table.updateBatchParams())
+		for start := 0; start < len(g.elems); start += size {
+			end := start + size
+			if end > len(g.elems) {
+				end = len(g.elems)
+			}
+			bi, err := g.table.bindUpdateBatch(g.elems[start:end])
+			if err != nil {
+				return -1, err
+			}
+			res, err := exec.execFields(bi.boundArgs(), bi.query, bi.args...)
+			if err != nil {
+				return -1, fmt.Errorf("gorp: update batch failed for table '%s': %w", g.table.TableName, err)
+			}
+			rows, err := res.RowsAffected()
+			if err != nil {
+				return -1, err
+			}
+			count += rows
+		}
+
+		for _, elem := range g.elems {
+			if v, ok := elem.Addr().Interface().(HasPostUpdate); ok {
+				err := v.PostUpdate(exec)
+				if err != nil {
+					return -1, err
+				}
+			}
+		}
+	}
+
+	m.LastOpInfo.Type = Update
+	m.LastOpInfo.BindPlanUsed = nil
+	m.LastOpInfo.RowCount = count
+
+	return count, nil
+}
+
+// batchSize returns how many rows with perRow bind params each fit into one
+// statement, see Dialect.MaxBindParams
+func batchSize(d Dialect, perRow int) int {
+	n := d.MaxBindParams() / perRow
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// updateBatchParams returns the number of bind params bindUpdateBatch uses
+// per row
+func (t *TableMap) updateBatchParams() int {
+	n := 1
+	for _, col := range t.Columns {
+		if !col.isAutoIncr && !col.Transient && !col.isPK {
+			n += 2
+		}
+	}
+	return n
+}
+
+// bindUpdateBatch builds a single UPDATE statement for elems, which must
+// all belong to this table, using a CASE expression on the primary key for
+// every column
+func (t *TableMap) bindUpdateBatch(elems []reflect.Value) (bindInstance, error) {
+	d := t.dbmap.Dialect
+	key := t.keys[0]
+	quotedKey := d.QuoteField(key.ColumnName)
+
+	var args []interface{}
+	var fields []string
+	x := 0
+	bind := func(elem reflect.Value, fieldName string) (string, error) {
+		val := fieldByPath(elem, fieldName).Interface()
+		conv, err := t.converterFor(fieldName)
+		if err != nil {
+			return "", err
+		}
+		if conv != nil {
+			val, err = convertToDb(conv, val)
+			if err != nil {
+				return "", err
+			}
+		} else {
+			val = bindValue(val)
+		}
+		args = append(args, val)
+		fields = append(fields, fieldName)
+		bv := d.BindVar(x)
+		x++
+		return bv, nil
+	}
+
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("update %s set ", d.QuotedTableForQuery(t.SchemaName, t.TableName)))
+	first := true
+	for _, col := range t.Columns {
+		if col.isAutoIncr || col.Transient || col.isPK {
+			continue
+		}
+		if !first {
+			s.WriteString(", ")
+		}
+		first = false
+		quotedCol := d.QuoteField(col.ColumnName)
+		s.WriteString(quotedCol)
+		s.WriteString(" = case")
+		for _, elem := range elems {
+			// Check if this column is a NOT NULL
+			if err := checkForNotNull(elem, col, t); err != nil {
+				return bindInstance{}, err
+			}
+			kv, err := bind(elem, key.fieldName)
+			if err != nil {
+				return bindInstance{}, err
+			}
+			cv, err := bind(elem, col.fieldName)
+			if err != nil {
+				return bindInstance{}, err
+			}
+			s.WriteString(fmt.Sprintf(" when %s = %s then %s", quotedKey, kv, cv))
+		}
+		// the else branch also gives postgres the type of the bind vars
+		s.WriteString(fmt.Sprintf(" else %s end", quotedCol))
+	}
+
+	s.WriteString(fmt.Sprintf(" where %s in (", quotedKey))
+	for i, elem := range elems {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		kv, err := bind(elem, key.fieldName)
+		if err != nil {
+			return bindInstance{}, err
+		}
+		s.WriteString(kv)
+	}
+	s.WriteString(")")
+	s.WriteString(d.QuerySuffix())
+
+	return bindInstance{query: s.String(), args: args, table: t, argFields: fields}, nil
+}
+
+// InsertOrGet inserts the row ptr points to, unless a row with the same
+// unique key already exists.  In that case no error is returned, and the
+// existing row is loaded into ptr.  created tells which of both happened.
+//
+// If the insert fails with a duplicate key, the existing row is looked up
+// by the primary key if it is not autoincrement, then by each unique
+// column, SetUniqueTogether() set and unique index of the table.  Other
+// errors of the insert are returned unchanged.  The insert runs behind a
+// savepoint in its own transaction, so a failed insert does not abort it
+// on Postgres.
+func (m *DbMap) InsertOrGet(ptr interface{}) (created bool, err error) {
+	trans, err := m.Begin()
+	if err != nil {
+		return false, err
+	}
+	created, err = insertOrGet(m, trans, ptr)
+	if err != nil {
+		trans.Rollback()
+		return false, err
+	}
+	err = trans.Commit()
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+func insertOrGet(m *DbMap, t *Transaction, ptr interface{}) (bool, error) {
+	table, elem, err := m.tableForPointer(ptr, false)
+	if err != nil {
+		return false, err
+	}
+	keys, err := table.uniqueKeys()
+	if err != nil {
+		return false, err
+	}
+
+	const savepoint = "gorp_insert_or_get"
+	err = t.Savepoint(savepoint)
+	if err != nil {
+		return false, err
+	}
+	insertErr := insert(m, t, false, ptr)
+	if insertErr == nil {
+		return true, t.ReleaseSavepoint(savepoint)
+	}
+	err = t.RollbackToSavepoint(savepoint)
+	if err != nil {
+		return false, err
+	}
+	if !IsDuplicateKey(insertErr) {
+		return false, insertErr
+	}
+
+	// The insert conflicts with an existing row on one of the unique keys
+	for _, fields := range keys {
+		list, err := selectByFields(m, t, table, elem, fields)
+		if err != nil {
+			return false, err
+		}
+		if len(list) > 0 {
+			elem.Set(reflect.ValueOf(list[0]).Elem())
+			return false, nil
 		}
+	}
+	return false, insertErr
+}
 
-		// Check if a reflect.Value has been passed
-		if reflect.TypeOf(ptr).String() == "reflect.Value" {
-			elem = ptr.(reflect.Value)
-			table, err = m.TableFor(elem.Type(), true)
+// selectByFields selects the rows of table whose fields equal the ones
+// of elem
+func selectByFields(m *DbMap, exec SqlExecutor, table *TableMap, elem reflect.Value, fields []string) ([]interface{}, error) {
+	q := newQueryBuilder(m, exec, elem.Interface())
+	for _, fieldName := range fields {
+		conv, err := table.converterFor(fieldName)
+		if err != nil {
+			return nil, err
+		}
+		val := fieldByPath(elem, fieldName).Interface()
+		if conv != nil {
+			val, err = convertToDb(conv, val)
 			if err != nil {
-				return -1, err
+				return nil, err
 			}
 		} else {
-
-			table, elem, err = m.tableForPointer(ptr, true)
-			if err != nil {
-				return -1, err
-			}
+			val = bindValue(val)
 		}
-
-		eval := elem.Addr().Interface()
-		if v, ok := eval.(HasPreUpdate); ok {
-			err = v.PreUpdate(exec)
-			if err != nil {
-				return -1, err
+		col := colMapForField(table, fieldName)
+		q.Where(m.Dialect.QuoteField(col.ColumnName)+" = ?", val)
+	}
+	return q.Select(elem.Interface())
+}
+
+// uniqueKeys returns the fields of the unique keys used by InsertOrGet:
+// the primary key unless it is autoincrement, the unique columns, the
+// SetUniqueTogether sets and the unique indexes
+func (t *TableMap) uniqueKeys() ([][]string, error) {
+	var keys [][]string
+	if len(t.keys) > 0 {
+		var fields []string
+		for _, key := range t.keys {
+			if key.isAutoIncr || key.Sequence != "" {
+				fields = nil
+				break
 			}
+			fields = append(fields, key.fieldName)
 		}
-		// Get the primaty key for this table
-		// Use the first PK found, multiple PKs are not supported
-		// by now and will yield an error
-		PkId, _, err := m.GetPrimaryKey(*table, elem)
-		if err != nil {
-			return count, errors.New("GetPrimaryKey failed: " + err.Error())
+		if fields != nil {
+			keys = append(keys, fields)
 		}
-		if m.DebugLevel > 2 {
-			fmt.Printf("Update table %s with primarykey %d\n", table.TableName, PkId)
+	}
+	for _, col := range t.Columns {
+		if col.Unique && !col.Transient {
+			keys = append(keys, []string{col.fieldName})
 		}
-
-		var bi bindInstance
-		var rows int64
-		if PkId == 0 {
-			err = insert(m, exec, false, ptr)
-			//bi, err = table.bindInsert(elem)
-			if err != nil {
-				return -1, err
-			}
-			rows = 1
-			if m.DebugLevel > 2 {
-				fmt.Printf("Update table %s has empty primary key, doing insert\n", table.TableName)
-			}
-		} else {
-			bi, err = table.bindUpdate(elem)
-			if err != nil {
-				return -1, err
-			}
-			res, err := exec.Exec(bi.query, bi.args...)
-			if err != nil {
-				return -1, fmt.Errorf("gorp: update failed for table '%s': %s", table.TableName, err.Error())
-			}
-			rows, err = res.RowsAffected()
-			if m.DebugLevel > 2 {
-				fmt.Printf("Update RowsAffected %d\n", rows)
-			}
-			if err != nil {
-				return -1, err
+	}
+	// unique sets and indexes hold field or column names
+	var sets [][]string
+	sets = append(sets, t.uniqueTogether...)
+	for _, index := range t.Indexes {
+		if index.Unique {
+			sets = append(sets, index.fieldNames)
+		}
+	}
+	for _, set := range sets {
+		var fields []string
+		for _, name := range set {
+			col := colMapOrNil(t, name)
+			if col == nil {
+				fields = nil
+				break
 			}
+			fields = append(fields, col.fieldName)
 		}
-
-		if rows == 0 && bi.existingVersion > 0 {
-			return lockError(m, exec, table.TableName,
-				bi.existingVersion, elem, bi.keys...)
+		if fields != nil {
+			keys = append(keys, fields)
 		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("gorp: table %s has no unique key other than an autoincrement primary key", t.TableName)
+	}
+	return keys, nil
+}
+
+// allowFullTable is the type of AllowFullTable
+type allowFullTable struct{}
 
-		if bi.versField != "" {
-			elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+// AllowFullTable is passed to UpdateWhere or DeleteWhere along with an
+// empty where clause to update or delete all rows of the table, which they
+// refuse otherwise:
+//
+//     dbmap.DeleteWhere(Invoice{}, "", gorp.AllowFullTable)
+//
+var AllowFullTable = allowFullTable{}
+
+// whereParams returns the getter of the named parameters of the where
+// clause of UpdateWhere or DeleteWhere, read from the only arg or from
+// the struct of elem.  An empty where clause is an error unless arg holds
+// AllowFullTable.
+func whereParams(op string, table *TableMap, elem reflect.Value, where string, arg []interface{}) (func(key string) reflect.Value, error) {
+	full := false
+	for x := 0; x < len(arg); x++ {
+		if _, ok := arg[x].(allowFullTable); ok {
+			full = true
+			arg = append(arg[:x:x], arg[x+1:]...)
+			x--
 		}
+	}
+	if strings.TrimSpace(where) == "" && !full {
+		return nil, fmt.Errorf("gorp: %s of table %s without where clause, pass AllowFullTable to change all rows", op, table.TableName)
+	}
+	if len(arg) > 1 {
+		return nil, fmt.Errorf("gorp: %s of table %s takes a single map or struct of named parameters", op, table.TableName)
+	}
+	params := elem
+	if len(arg) == 1 {
+		params = reflect.Indirect(reflect.ValueOf(arg[0]))
+	}
+	switch {
+	case params.Kind() == reflect.Map && params.Type().Key().Kind() == reflect.String:
+		return func(key string) reflect.Value {
+			return params.MapIndex(reflect.ValueOf(key))
+		}, nil
+	case params.Kind() == reflect.Struct:
+		return params.FieldByName, nil
+	}
+	return nil, fmt.Errorf("gorp: %s of table %s takes a map or struct of named parameters, not %T", op, table.TableName, arg[0])
+}
 
-		count += rows
-		// Store info about this update operation
-		m.LastOpInfo.Type = Update
-		m.LastOpInfo.BindPlanUsed = &table.updatePlan
-		m.LastOpInfo.RowCount = count
+// appendWhere appends the where clause, if any, to the statement s
+func appendWhere(s *bytes.Buffer, where string) {
+	// the dialect query suffix terminates the statement
+	where = strings.TrimRight(strings.TrimSpace(where), ";")
+	if where != "" {
+		s.WriteString(" where ")
+		s.WriteString(where)
+	}
+}
 
-		if updateChilds {
-			// Get the primaty key for this table
-			// Use the first PK found, multiple PKs are not supported
-			// by now and will yield an error
-			PkId, _, err = m.GetPrimaryKey(*table, elem)
-			if err != nil {
-				return count, errors.New("GetPrimaryKey failed: " + err.Error())
-			}
-			if PkId == 0 {
-				return -1, errors.New(fmt.Sprintf("Update childs of table %s failed, primary key is zero\n", table.TableName))
-			}
-			//m.SetPrimaryKey(*table, elem, PkId)
-			if m.DebugLevel > 2 {
-				fmt.Printf("Update childs of table %s with primarykey %d\n", table.TableName, PkId)
-			}
-			// Update child records if present
-			for _, r := range table.Relations {
+func updateWhere(m *DbMap, exec SqlExecutor, ptr interface{}, where string, arg ...interface{}) (int64, error) {
+	table, elem, err := m.tableForPointer(ptr, false)
+	if err != nil {
+		return -1, err
+	}
+	keyGetter, err := whereParams("UpdateWhere", table, elem, where, arg)
+	if err != nil {
+		return -1, err
+	}
 
-				fv := elem.FieldByName(r.MasterFieldName)
+	eval := elem.Addr().Interface()
+	if v, ok := eval.(HasPreUpdate); ok {
+		err = v.PreUpdate(exec)
+		if err != nil {
+			return -1, err
+		}
+	}
 
-				if fv.Kind() == reflect.Slice {
-					updatecount, insertcount, err := m.UpdateDetailsFromSlice(elem, r, PkId)
+	plan, s, err := table.updateSetPlan(elem, nil)
+	if err != nil {
+		return -1, err
+	}
+	bi, err := plan.createBindInstance(elem, table)
+	if err != nil {
+		return -1, err
+	}
+	cond, args := expandNamedQuery(m, where, len(bi.args), keyGetter)
+	appendWhere(s, cond)
+	s.WriteString(m.Dialect.QuerySuffix())
 
-					if err != nil {
-						return count, errors.New("Update child relation on table '" + r.DetailTable.TableName + "' failed: " + err.Error())
-					}
-					m.LastOpInfo.ChildUpdateRowCount += updatecount
-					m.LastOpInfo.ChildInsertRowCount += insertcount
-				}
-			}
+	// the args of the where clause are not bound to fields
+	fields := append(append([]string(nil), bi.argFields...), make([]string, len(args))...)
+	res, err := exec.execFields(&argFields{table, fields}, s.String(), append(bi.args, args...)...)
+	if err != nil {
+		return -1, writeError("update", table, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+	if bi.versField != "" && rows > 0 {
+		fieldByPath(elem, bi.versField).SetInt(bi.existingVersion + 1)
+	}
+
+	if v, ok := eval.(HasPostUpdate); ok {
+		err = v.PostUpdate(exec)
+		if err != nil {
+			return -1, err
 		}
+	}
+	return rows, nil
+}
 
-		if v, ok := eval.(HasPostUpdate); ok {
-			err = v.PostUpdate(exec)
-			if err != nil {
-				return -1, err
+func deleteWhere(m *DbMap, exec SqlExecutor, i interface{}, where string, arg ...interface{}) (int64, error) {
+	t, err := toType(i)
+	if err != nil {
+		return -1, err
+	}
+	table, err := m.TableFor(t, false)
+	if err != nil {
+		return -1, err
+	}
+	keyGetter, err := whereParams("DeleteWhere", table, reflect.Indirect(reflect.ValueOf(i)), where, arg)
+	if err != nil {
+		return -1, err
+	}
+
+	cond, args := expandNamedQuery(m, where, 0, keyGetter)
+	s := &bytes.Buffer{}
+	s.WriteString("delete from ")
+	s.WriteString(m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName))
+	appendWhere(s, cond)
+	s.WriteString(m.Dialect.QuerySuffix())
+
+	res, err := exec.Exec(s.String(), args...)
+	if err != nil {
+		return -1, fmt.Errorf("gorp: delete failed for table '%s': %w", table.TableName, err)
+	}
+	return res.RowsAffected()
+}
+
+func updateWithOld(m *DbMap, exec SqlExecutor, ptr interface{}) (interface{}, int64, error) {
+	table, elem, err := m.tableForPointer(ptr, true)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	keys, err := table.keyArgs(elem)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	// lock the old row, so no other transaction updates it before ptr
+	v := reflect.New(elem.Type())
+	found, err := getRow(m, exec, table, table.bindGetForUpdate(), v, keys...)
+	if err != nil {
+		return nil, -1, err
+	}
+	var old interface{}
+	if found {
+		if v, ok := v.Interface().(HasPostGet); ok {
+			if err = v.PostGet(exec); err != nil {
+				return nil, -1, err
 			}
 		}
+		old = v.Interface()
 	}
-	return count, nil
+
+	count, err := update(m, exec, false, ptr)
+	if err != nil {
+		return nil, -1, err
+	}
+	return old, count, nil
 }
 
 // GetPrimaryKey returns the value(PkId) and the name (PkName) of a primary key from a table, if it exists
@@ -3155,6 +8922,45 @@ func (m *DbMap) SetPrimaryKey(table TableMap, elem reflect.Value, pk int64) (err
 }
 
 func insert(m *DbMap, exec SqlExecutor, insertChilds bool, list ...interface{}) error {
+	return insertWithReturning(m, exec, insertChilds, nil, list...)
+}
+
+// insertWithReturning inserts list and stores the columns of the fields or
+// column names in returning in each inserted element
+func insertWithReturning(m *DbMap, exec SqlExecutor, insertChilds bool, returning []string, list ...interface{}) error {
+	return insertConflict(m, exec, insertChilds, returning, nil, list...)
+}
+
+// writeError wraps the error of the driver for the insert or update of a
+// row of table, as DuplicateKeyError for constraint violations
+func writeError(op string, table *TableMap, err error) error {
+	if IsDuplicateKey(err) {
+		return &DuplicateKeyError{TableName: table.TableName, Err: err}
+	}
+	return fmt.Errorf("gorp: %s failed for table '%s': %w", op, table.TableName, err)
+}
+
+// upsertKey runs the upsert statement query and scans the key it returns
+// into f.  It returns true if no row is returned, because the UpdateWhere
+// predicate skipped the update of the conflicting row.
+func upsertKey(exec SqlExecutor, f reflect.Value, fields *argFields, query string, args ...interface{}) (bool, error) {
+	rows, err := exec.queryFields(fields, query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return true, rows.Err()
+	}
+	if err = rows.Scan(f.Addr().Interface()); err != nil {
+		return false, err
+	}
+	return false, rows.Err()
+}
+
+// insertConflict inserts list like insertWithReturning, rows conflicting
+// on target are updated if target is not nil, see Upsert
+func insertConflict(m *DbMap, exec SqlExecutor, insertChilds bool, returning []string, target *ConflictTarget, list ...interface{}) error {
 
 	var table *TableMap
 	var elem reflect.Value
@@ -3182,6 +8988,11 @@ func insert(m *DbMap, exec SqlExecutor, insertChilds bool, list ...interface{})
 			}
 		}
 
+		err = table.generateKey(elem)
+		if err != nil {
+			return err
+		}
+
 		eval := elem.Addr().Interface()
 		if v, ok := eval.(HasPreInsert); ok {
 			err := v.PreInsert(exec)
@@ -3190,45 +9001,49 @@ func insert(m *DbMap, exec SqlExecutor, insertChilds bool, list ...interface{})
 			}
 		}
 
-		bi, err := table.bindInsert(elem)
+		bi, err := table.bindInsertConflict(elem, returning, target)
 		if err != nil {
 			return err
 		}
+		if target == nil {
+			table.generateInsertSQL(elem, &bi)
+		}
+		skipped := false // the update of a conflicting row was skipped
 
-		if bi.autoIncrIdx > -1 {
-			f := elem.FieldByName(bi.autoIncrFieldName)
-			switch inserter := m.Dialect.(type) {
-			case IntegerAutoIncrInserter:
-				id, err := inserter.InsertAutoIncr(exec, bi.query, bi.args...)
-				if err != nil {
-					return fmt.Errorf("gorp: insert failed for table '%s': %s", table.TableName, err.Error())
-				}
-				k := f.Kind()
-				if (k == reflect.Int) || (k == reflect.Int16) || (k == reflect.Int32) || (k == reflect.Int64) {
-					f.SetInt(id)
-				} else if (k == reflect.Uint) || (k == reflect.Uint16) || (k == reflect.Uint32) || (k == reflect.Uint64) {
-					f.SetUint(uint64(id))
-				} else {
-					return fmt.Errorf("gorp: Cannot set autoincrement value on non-Int field. SQL=%s  autoIncrIdx=%d autoIncrFieldName=%s", bi.query, bi.autoIncrIdx, bi.autoIncrFieldName)
-				}
-			case TargetedAutoIncrInserter:
-				err := inserter.InsertAutoIncrToTarget(exec, bi.query, f.Addr().Interface(), bi.args...)
-				if err != nil {
-					return fmt.Errorf("gorp: insert failed for table '%s': %s", table.TableName, err.Error())
-				}
-			default:
-				return fmt.Errorf("gorp: Cannot use autoincrement fields on dialects that do not implement an autoincrementing interface")
+		if len(bi.returnFields) > 0 {
+			err := table.insertReturning(exec, elem, bi)
+			if err != nil {
+				return writeError("insert", table, err)
+			}
+		} else if bi.autoIncrIdx > -1 {
+			f := fieldByPath(elem, bi.autoIncrFieldName)
+			if target != nil && target.UpdateWhere != "" && !m.Dialect.SupportsLastInsertId() {
+				skipped, err = upsertKey(exec, f, bi.boundArgs(), bi.query, bi.args...)
+			} else if m.Dialect.SupportsLastInsertId() && !isIntKind(f.Kind()) {
+				return fmt.Errorf("gorp: Cannot set autoincrement value on non-Int field. SQL=%s  autoIncrIdx=%d autoIncrFieldName=%s", bi.query, bi.autoIncrIdx, bi.autoIncrFieldName)
+			} else {
+				err = insertAutoIncr(m.Dialect, fieldsExecutor{exec, bi.boundArgs()}, f, bi.query, bi.args...)
+			}
+			if err != nil {
+				return writeError("insert", table, err)
 			}
 		} else {
-			_, err := exec.Exec(bi.query, bi.args...)
+			_, err := exec.execFields(bi.boundArgs(), bi.query, bi.args...)
+			if err != nil {
+				return writeError("insert", table, err)
+			}
+		}
+
+		if len(bi.readFields) > 0 && !skipped {
+			err = table.readBack(exec, elem, bi.readFields)
 			if err != nil {
-				return fmt.Errorf("gorp: Exec failed: %s", err.Error())
+				return err
 			}
 		}
 
 		// Store info about this update operation
 		m.LastOpInfo.Type = Insert
-		m.LastOpInfo.BindPlanUsed = &table.insertPlan
+		m.LastOpInfo.BindPlanUsed = bi.plan
 		m.LastOpInfo.RowCount++
 
 		if insertChilds {
@@ -3268,6 +9083,72 @@ func insert(m *DbMap, exec SqlExecutor, insertChilds bool, list ...interface{})
 	return nil
 }
 
+func copyIn(m *DbMap, tx *sql.Tx, table interface{}, rows []interface{}) error {
+	t, err := m.TableFor(reflect.Indirect(reflect.ValueOf(table)).Type(), false)
+	if err != nil {
+		return err
+	}
+	d, ok := m.Dialect.(CopyInDialect)
+	if !ok {
+		return fmt.Errorf("gorp: CopyIn into table %s is not supported by %T", t.TableName, m.Dialect)
+	}
+
+	var cols []*ColumnMap
+	var names []string
+	for _, col := range t.Columns {
+		if col.Transient || col.isAutoIncr || col.Sequence != "" {
+			continue
+		}
+		cols = append(cols, col)
+		names = append(names, col.ColumnName)
+	}
+
+	query := d.CopyInSql(t.SchemaName, t.TableName, names)
+	if m.logger != nil {
+		now := time.Now()
+		defer m.trace(now, query)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	args := make([]interface{}, len(cols))
+	for _, row := range rows {
+		elem := reflect.Indirect(reflect.ValueOf(row))
+		if elem.Type() != t.gotype {
+			return fmt.Errorf("gorp: CopyIn into table %s got a %T row", t.TableName, row)
+		}
+		for x, col := range cols {
+			f := fieldByPath(elem, col.fieldName)
+			if col == t.version && f.Int() == 0 {
+				args[x] = int64(1)
+				continue
+			}
+			args[x] = f.Interface()
+			conv, err := t.converterFor(col.fieldName)
+			if err != nil {
+				return err
+			}
+			if conv != nil {
+				args[x], err = convertToDb(conv, args[x])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if _, err = stmt.Exec(args...); err != nil {
+			return fmt.Errorf("gorp: CopyIn into table %s failed: %w", t.TableName, err)
+		}
+	}
+	// the final execution without arguments flushes the rows
+	if _, err = stmt.Exec(); err != nil {
+		return fmt.Errorf("gorp: CopyIn into table %s failed: %w", t.TableName, err)
+	}
+	return stmt.Close()
+}
+
 // InsertDetailsFromSlice inserts embedded structs described by the RelationMap r
 // and sets the foreign key into each slice element from PK
 // The master table is described by "elem"
@@ -3422,7 +9303,7 @@ func (m *DbMap) UpdateDetailsFromSlice(elem reflect.Value, r *RelationMap, PK ui
 func checkForNotNull(elem reflect.Value, col *ColumnMap, table *TableMap) (err error) {
 	var isNull bool
 	if col.EnforceNotNull && col.isNotNull {
-		val := elem.FieldByName(col.fieldName)
+		val := fieldByPath(elem, col.fieldName)
 		if val.Kind() == reflect.String {
 			valstring := val.String()
 			// DEBUG
@@ -3499,3 +9380,24 @@ type HasPreUpdate interface {
 type HasPreInsert interface {
 	PreInsert(SqlExecutor) error
 }
+
+// InsertSQLer is implemented by structs which generate their own INSERT
+// statement, e.g. to add a hint.  GenerateInsertSQL is called after
+// PreInsert() and returns the statement and its bind arguments, which
+// replace the ones gorp generates.  Autoincrement keys and returned columns
+// are read like for the generated statement, so it has to end in the same
+// suffix, see Dialect.AutoIncrInsertSuffix.  The ArgRedactor gets no column
+// names for the bind arguments.
+type InsertSQLer interface {
+	GenerateInsertSQL(d Dialect, t *TableMap) (string, []interface{})
+}
+
+// generateInsertSQL replaces the statement of bi with the one generated by
+// elem if it implements InsertSQLer
+func (t *TableMap) generateInsertSQL(elem reflect.Value, bi *bindInstance) {
+	if v, ok := elem.Addr().Interface().(InsertSQLer); ok {
+		bi.query, bi.args = v.GenerateInsertSQL(t.dbmap.Dialect, t)
+		// the args are no longer the ones of the plan
+		bi.argFields = nil
+	}
+}
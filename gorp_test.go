@@ -2,14 +2,19 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"math/rand"
+	"net"
 	"os"
 	"reflect"
 	"strconv"
@@ -18,7 +23,7 @@ import (
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	//_ "github.com/mattn/go-sqlite3"
 	//_ "github.com/ziutek/mymysql/godrv"
 )
@@ -139,6 +144,12 @@ func (p *PersonValuerScanner) Scan(value interface{}) (err error) {
 	return
 }
 
+type PersonDynamic struct {
+	Id    interface{}
+	FName interface{}
+	LName interface{}
+}
+
 type FNameOnly struct {
 	FName string
 }
@@ -151,6 +162,74 @@ type InvoicePersonView struct {
 	LegacyVersion int64
 }
 
+// Document has a column with a literal SQL type, see ColumnMap.RawSQLType
+type Document struct {
+	Id     int64
+	Title  string `db:"size:100"`
+	Search string `db:"sqltype:tsvector"`
+}
+
+// LegacyRow has columns with NULL sentinels, see ColumnMap.SetNullSentinel
+type LegacyRow struct {
+	Id     int64
+	Count  int64
+	Closed time.Time
+	Note   *string
+}
+
+// OptionalFields has pointer fields, which are NULL if nil
+type OptionalFields struct {
+	Id   int64
+	Name *string
+	Qty  *int64
+	Due  *time.Time
+}
+
+// Secret has a column stored encrypted, see ColumnMap.SetCryptoTransform
+type Secret struct {
+	Id   int64
+	Name string
+	Card string
+}
+
+// xorCipher is a toy cipher for the tests, it is its own inverse
+func xorCipher(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x5a
+	}
+	return out, nil
+}
+
+// Subscriber has a partial unique index on its email, see TestUpsert
+type Subscriber struct {
+	Id      int64
+	Email   string
+	Name    string
+	Deleted bool
+}
+
+// Color is an integer enum, see DbMap.RegisterIntEnum
+type Color int16
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+type Paint struct {
+	Id    int64
+	Color Color
+}
+
+// InvoiceAliasView scans the aliased columns of a join
+type InvoiceAliasView struct {
+	InvoiceId int64  `db:"Id, select:invoice_id"`
+	Memo      string `db:"select:invoice_memo"`
+	FName     string `db:"select:person_name"`
+}
+
 type TableWithNull struct {
 	Id      int64
 	Str     sql.NullString
@@ -166,6 +245,236 @@ type WithIgnoredColumn struct {
 	Created  int64
 }
 
+type WithUnexportedField struct {
+	Id      int64
+	secret  string
+	Created int64
+}
+
+type WithTaggedUnexportedField struct {
+	Id     int64
+	secret string `db:"name:secret_col"`
+}
+
+type WithSequence struct {
+	Id   int64 `db:"primarykey, sequence:gorp_test_seq"`
+	Name string
+}
+
+type TagKey struct {
+	Id   int64 `db:"pk, autoincr"`
+	Name string
+}
+
+type TagCompositeKey struct {
+	Region string `db:"pk, size:20"`
+	Code   int64  `db:"name:code, pk"`
+	Name   string
+}
+
+type WithRat struct {
+	Id     int64    `db:"pk, autoincr"`
+	Amount big.Rat  `db:"type:numeric(20,6), scan:rat"`
+	Fee    *big.Rat `db:"type:numeric(20,6), scan:rat"`
+}
+
+type WithUniqueCode struct {
+	Id   int64  `db:"pk, autoincr"`
+	Code string `db:"size:20, unique"`
+	Name string `db:"size:50"`
+}
+
+type WithDbDefault struct {
+	Id      int64     `db:"pk, autoincr"`
+	Name    string    `db:"size:50"`
+	Created time.Time `db:"default:CURRENT_TIMESTAMP, readdefault"`
+}
+
+type WithOmitEmpty struct {
+	Id       int64  `db:"pk, autoincr"`
+	Status   string `db:"size:16, default:'new', omitempty, readdefault"`
+	Priority int64  `db:"default:3, omitempty"`
+}
+
+type WithComputed struct {
+	Id      int64     `db:"pk, autoincr"`
+	Name    string    `db:"size:50"`
+	Created time.Time `db:"default:CURRENT_TIMESTAMP"`
+}
+
+type WithEpoch struct {
+	Id      int64      `db:"pk, autoincr"`
+	Created time.Time  `db:"type:epoch_millis"`
+	Updated *time.Time `db:"type:epoch_seconds"`
+}
+
+type WithDate struct {
+	Id       int64      `db:"pk, autoincr"`
+	Birthday time.Time  `db:"type:date"`
+	Due      *time.Time `db:"type:date"`
+}
+
+type Keyless struct {
+	Code string
+	Name string
+	Qty  int64
+}
+
+type WithBits struct {
+	Id    int64  `db:"pk, autoincr"`
+	Flags uint8  `db:"type:bit, size:8"`
+	Mask  []byte `db:"type:bit, size:12"`
+}
+
+type WithNet struct {
+	Id      int64 `db:"pk, autoincr"`
+	Addr    net.IP
+	Mac     net.HardwareAddr
+	Gateway *net.IP
+}
+
+type WithDecimal struct {
+	Id    int64   `db:"pk, autoincr"`
+	Price float64 `db:"type:numeric(10,2)"`
+	Qty   int64   `db:"type:decimal(10,2)"`
+	Limit *uint32 `db:"type:numeric(12,0)"`
+}
+
+type LatLng struct {
+	Lat float64 `db:"lat"`
+	Lng float64 `db:"lng"`
+}
+
+type WithLatLng struct {
+	Id   int64 `db:"pk, autoincr"`
+	Name string
+	Pos  LatLng `db:"expand"`
+}
+
+// PlaceRow is selected from latlng_test with both coordinates read into Pos
+type PlaceRow struct {
+	Id  int64
+	Pos LatLng
+}
+
+// latLngConverter scans two columns into a LatLng
+type latLngConverter struct{}
+
+func (latLngConverter) ToDb(val interface{}) (interface{}, error) {
+	return val, nil
+}
+
+func (latLngConverter) FromDb(target interface{}) (CustomScanner, bool) {
+	if _, ok := target.(*LatLng); !ok {
+		return CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		h := holder.(ColumnHolders)
+		*target.(*LatLng) = LatLng{*h[0].(*float64), *h[1].(*float64)}
+		return nil
+	}
+	return CustomScanner{ColumnHolders{new(float64), new(float64)}, target, binder}, true
+}
+
+type TypeConversionPtrExample struct {
+	Id   int64
+	Name *CustomStringType
+}
+
+type InvoiceView Invoice
+
+type Author struct {
+	Id    int64 `db:"pk, autoincr"`
+	Name  string
+	Books []*Book `db:"hasmany:book_test, fk:author_id"`
+}
+
+type Book struct {
+	Id       int64 `db:"pk, autoincr"`
+	AuthorId int64 `db:"author_id"`
+	Title    string
+}
+
+type AuthorWithoutFk struct {
+	Id    int64   `db:"pk, autoincr"`
+	Books []*Book `db:"hasmany:book_test, fk:writer_id"`
+}
+
+type HintedInvoice struct {
+	Id   int64 `db:"pk, autoincr"`
+	Memo string
+}
+
+// GenerateInsertSQL inserts the memo in upper case behind a hint comment
+func (h *HintedInvoice) GenerateInsertSQL(d Dialect, t *TableMap) (string, []interface{}) {
+	suffix := d.AutoIncrInsertSuffix(t.ColMap("Id"))
+	output := ""
+	if od, ok := d.(AutoIncrOutputDialect); ok && od.AutoIncrOutputBeforeValues() {
+		output, suffix = suffix, ""
+	}
+	query := "insert /* hint */ into " + d.QuotedTableForQuery(t.SchemaName, t.TableName) +
+		" (" + d.QuoteField(t.ColMap("Memo").ColumnName) + ")" + output +
+		" values (" + d.BindVar(0) + ")" + suffix + d.QuerySuffix()
+	return query, []interface{}{strings.ToUpper(h.Memo)}
+}
+
+// HintedSecret binds only the memo in its own INSERT statement
+type HintedSecret struct {
+	Id     int64 `db:"pk, autoincr"`
+	Secret string
+	Memo   string
+}
+
+func (h *HintedSecret) GenerateInsertSQL(d Dialect, t *TableMap) (string, []interface{}) {
+	query := "insert /* hint */ into " + d.QuotedTableForQuery(t.SchemaName, t.TableName) +
+		" (" + d.QuoteField(t.ColMap("Memo").ColumnName) + ") values (" + d.BindVar(0) + ")" +
+		d.AutoIncrInsertSuffix(t.ColMap("Id")) + d.QuerySuffix()
+	return query, []interface{}{h.Memo}
+}
+
+// OpenRowsInvoice records the rows of the execTestDriver open when its
+// PostGet hook runs
+type OpenRowsInvoice struct {
+	Id     int64
+	Hooked bool `db:"-"`
+	Open   int  `db:"-"`
+}
+
+func (i *OpenRowsInvoice) PostGet(s SqlExecutor) error {
+	i.Hooked = true
+	i.Open = s.(*DbMap).Db.Driver().(*execTestDriver).open
+	return nil
+}
+
+type WithIndex struct {
+	Id    int64  `db:"pk, autoincr"`
+	Email string `db:"size:100, index:idx_Email"`
+}
+
+type WithIncludeIndex struct {
+	Id    int64  `db:"pk, autoincr"`
+	Email string `db:"size:100, uniqueindex:idx_email, include:Name, include:Age"`
+	Name  string `db:"size:100"`
+	Age   int
+}
+
+type WithConcurrentIndex struct {
+	Id    int64  `db:"pk, autoincr"`
+	Email string `db:"size:100, index:idx_email, concurrently"`
+}
+
+type JSONItem struct {
+	Name  string
+	Count int
+}
+
+type WithJSON struct {
+	Id    int64                  `db:"pk, autoincr"`
+	Attrs map[string]interface{} `db:"size:1000"`
+	Items []JSONItem             `db:"size:1000"`
+	Tags  map[string]string      `db:"size:1000"`
+}
+
 type IdCreated struct {
 	Id      int64
 	Created int64
@@ -176,6 +485,12 @@ type IdCreatedExternal struct {
 	External int64
 }
 
+type InvoiceMemo struct {
+	Id   int64
+	Memo string
+	Note string
+}
+
 type WithStringPk struct {
 	Id   string
 	Name string
@@ -230,6 +545,17 @@ type WithEmbeddedAutoincr struct {
 	MiddleName string
 }
 
+type WithColumnCollision struct {
+	Id int64
+	Names
+	First string `db:"FirstName"`
+}
+
+type WithKeyCollision struct {
+	Id  int64
+	Key string `db:"ID"`
+}
+
 type Names struct {
 	FirstName string
 	LastName  string
@@ -251,6 +577,19 @@ type UniqueColumns struct {
 	ZipCode   int64
 }
 
+type WithForeignKey struct {
+	Id       int64 `db:"pk"`
+	ParentId int64 `db:"fk:parent.id, ondelete:cascade, onupdate:no_action"`
+	OwnerId  int64 `db:"fk:owner, ondelete:SET NULL"`
+}
+
+type WithUniqueGroup struct {
+	Id        int64  `db:"pk"`
+	Email     string `db:"size:50, unique"`
+	FirstName string `db:"size:20, unique:name"`
+	LastName  string `db:"size:20, unique:name"`
+}
+
 type SingleColumnTable struct {
 	SomeId string
 }
@@ -259,6 +598,37 @@ type CustomDate struct {
 	time.Time
 }
 
+// UUID is stored as its text form by its Value and Scan methods
+type UUID [16]byte
+
+func (u UUID) Value() (driver.Value, error) {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+func (u *UUID) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("UUID: can not scan %T", src)
+	}
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != len(u) {
+		return fmt.Errorf("UUID: invalid %q", s)
+	}
+	copy(u[:], b)
+	return nil
+}
+
+type WithUUID struct {
+	Id     int64 `db:"pk, autoincr"`
+	Uid    UUID
+	Parent *UUID
+}
+
 type WithCustomDate struct {
 	Id    int64
 	Added CustomDate
@@ -484,1405 +854,6689 @@ func TestUIntPrimaryKey(t *testing.T) {
 	}
 }
 
-func TestSetUniqueTogether(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.AddTable(UniqueColumns{}).SetUniqueTogether("FirstName", "LastName").SetUniqueTogether("City", "ZipCode")
-	err := dbmap.CreateTablesIfNotExists()
-	if err != nil {
-		panic(err)
+func TestKeysFromTags(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+
+	table := dbmap.AddTableWithName(TagKey{}, "tag_key_test")
+	if len(table.keys) != 1 || table.keys[0].fieldName != "Id" || !table.keys[0].isAutoIncr {
+		t.Errorf("unexpected keys %v", table.keys)
 	}
-	defer dropAndClose(dbmap)
+	// SetKeys which agrees with the tags is fine
+	table.SetKeys(true, "Id")
 
-	n1 := &UniqueColumns{"Steve", "Jobs", "Cupertino", 95014}
-	err = dbmap.Insert(n1)
-	if err != nil {
-		t.Error(err)
+	table = dbmap.AddTableWithName(TagCompositeKey{}, "tag_composite_key_test")
+	if len(table.keys) != 2 || table.keys[0].fieldName != "Region" || table.keys[1].ColumnName != "code" {
+		t.Errorf("unexpected keys %v", table.keys)
+	}
+	want := `create table "tag_composite_key_test" ("Region" varchar(20) not null, "code" integer not null, "Name" varchar(255), primary key ("Region", "code")) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
 	}
 
-	// Should fail because of the first constraint
-	n2 := &UniqueColumns{"Steve", "Jobs", "Sunnyvale", 94085}
-	err = dbmap.Insert(n2)
-	if err == nil {
-		t.Error(err)
+	assertPanics := func(msg string, f func()) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic: %s", msg)
+			}
+		}()
+		f()
 	}
-	// "unique" for Postgres/SQLite, "Duplicate entry" for MySQL
-	errLower := strings.ToLower(err.Error())
-	if !strings.Contains(errLower, "unique") && !strings.Contains(errLower, "duplicate entry") {
-		t.Error(err)
+	assertPanics("SetKeys with other fields than tags", func() {
+		dbmap.AddTableWithName(TagKey{}, "tag_key_test").SetKeys(true, "Name")
+	})
+	assertPanics("SetKeys with other autoincrement than tags", func() {
+		dbmap.AddTableWithName(TagKey{}, "tag_key_test").SetKeys(false, "Id")
+	})
+	assertPanics("SetKeys with a subset of the tagged keys", func() {
+		dbmap.AddTableWithName(TagCompositeKey{}, "tag_composite_key_test").SetKeys(false, "Region")
+	})
+}
+
+func TestSetKeysUnknownField(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	panicMessage := func(f func()) (msg string) {
+		defer func() {
+			msg = fmt.Sprint(recover())
+		}()
+		f()
+		return ""
+	}
+	msg := panicMessage(func() {
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Ident")
+	})
+	if !strings.Contains(msg, "Invoice") || !strings.Contains(msg, "Ident") {
+		t.Errorf("unexpected panic %q", msg)
+	}
+	msg = panicMessage(func() {
+		dbmap.AddTableWithName(TagKey{}, "tag_key_test").SetKeys(true, "Ident")
+	})
+	if !strings.Contains(msg, "TagKey") || !strings.Contains(msg, "Ident") {
+		t.Errorf("unexpected panic %q for tagged keys", msg)
+	}
+	msg = panicMessage(func() {
+		table := dbmap.AddTableWithName(Invoice{}, "invoice_test")
+		table.ColMap("Memo").SetTransient(true)
+		table.SetKeys(false, "Memo")
+	})
+	if !strings.Contains(msg, "Memo") {
+		t.Errorf("unexpected panic %q for transient key", msg)
+	}
+	// keys can be given by column name
+	table := dbmap.AddTableWithName(AliasTransientField{}, "alias_trans_field_test").SetKeys(false, "bar")
+	if len(table.keys) != 1 || table.keys[0].fieldName != "BarStr" {
+		t.Errorf("unexpected keys %v", table.keys)
 	}
+}
 
-	// Should also fail because of the second unique-together
-	n3 := &UniqueColumns{"Steve", "Wozniak", "Cupertino", 95014}
-	err = dbmap.Insert(n3)
-	if err == nil {
-		t.Error(err)
+func TestColumnNameCollision(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	for _, tt := range []struct {
+		table  interface{}
+		fields []string
+	}{
+		{WithColumnCollision{}, []string{"WithColumnCollision.FirstName and WithColumnCollision.First", "'firstname'"}},
+		{WithKeyCollision{}, []string{"WithKeyCollision.Id and WithKeyCollision.Key", "'id'"}},
+	} {
+		func() {
+			defer func() {
+				msg := fmt.Sprint(recover())
+				for _, s := range tt.fields {
+					if !strings.Contains(msg, s) {
+						t.Errorf("panic %q does not contain %q", msg, s)
+					}
+				}
+			}()
+			dbmap.AddTable(tt.table)
+		}()
+	}
+	if len(dbmap.tables) != 0 {
+		t.Errorf("tables with collisions were added")
+	}
+	// shadowed fields of embedded structs are no collision
+	dbmap.AddTable(WithEmbeddedStructConflictingEmbeddedMemberNames{})
+}
+
+func TestUniqueTags(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `create table "unique_group_test" ("Id" integer not null primary key, "Email" varchar(50) unique, "FirstName" varchar(20), "LastName" varchar(20), unique ("FirstName", "LastName")) ;`},
+		{PostgresDialect{}, `create table "unique_group_test" ("id" bigint not null primary key, "email" varchar(50) unique, "firstname" varchar(20), "lastname" varchar(20), unique ("firstname", "lastname")) ;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create table `unique_group_test` (`Id` bigint not null primary key, `Email` varchar(50) unique, `FirstName` varchar(20), `LastName` varchar(20), unique (`FirstName`, `LastName`))  engine=InnoDB charset=UTF8;"},
+		{SqlServerDialect{}, `create table [unique_group_test] ([Id] bigint not null primary key, [Email] nvarchar(50) unique, [FirstName] nvarchar(20), [LastName] nvarchar(20), unique ([FirstName], [LastName])) ;;`},
+		{OracleDialect{}, `create table "UNIQUE_GROUP_TEST" ("ID" bigint not null primary key, "EMAIL" varchar(50) unique, "FIRSTNAME" varchar(20), "LASTNAME" varchar(20), unique ("FIRSTNAME", "LASTNAME")) `},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		table := dbmap.AddTableWithName(WithUniqueGroup{}, "unique_group_test")
+		if got := table.SqlForCreate(false); got != test.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", test.dialect, got, test.want)
+		}
 	}
-	// "unique" for Postgres/SQLite, "Duplicate entry" for MySQL
-	errLower = strings.ToLower(err.Error())
-	if !strings.Contains(errLower, "unique") && !strings.Contains(errLower, "duplicate entry") {
-		t.Error(err)
+}
+
+func TestForeignKeyTags(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `create table "fk_test" ("Id" integer not null primary key, "ParentId" integer, "OwnerId" integer, foreign key ("ParentId") references "parent" ("id") on delete cascade on update no action, foreign key ("OwnerId") references "owner" on delete set null) ;`},
+		{PostgresDialect{}, `create table "fk_test" ("id" bigint not null primary key, "parentid" bigint, "ownerid" bigint, foreign key ("parentid") references "parent" ("id") on delete cascade on update no action, foreign key ("ownerid") references "owner" on delete set null) ;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create table `fk_test` (`Id` bigint not null primary key, `ParentId` bigint, `OwnerId` bigint, foreign key (`ParentId`) references `parent` (`id`) on delete cascade on update no action, foreign key (`OwnerId`) references `owner` on delete set null)  engine=InnoDB charset=UTF8;"},
+		{SqlServerDialect{}, `create table [fk_test] ([Id] bigint not null primary key, [ParentId] bigint, [OwnerId] bigint, foreign key ([ParentId]) references [parent] ([id]) on delete cascade on update no action, foreign key ([OwnerId]) references [owner] on delete set null) ;;`},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		table := dbmap.AddTableWithName(WithForeignKey{}, "fk_test")
+		if got := table.SqlForCreate(false); got != test.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", test.dialect, got, test.want)
+		}
 	}
 
-	// This one should finally succeed
-	n4 := &UniqueColumns{"Steve", "Wozniak", "Sunnyvale", 94085}
-	err = dbmap.Insert(n4)
-	if err != nil {
-		t.Error(err)
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	table.ColMap("PersonId").SetReferences("sales.person_test.id", "restrict", "Cascade")
+	want := `create table "invoice_test" ("id" bigserial not null primary key , "created" bigint, "updated" bigint, "memo" varchar(255), "personid" bigint, "ispaid" boolean, foreign key ("personid") references sales."person_test" ("id") on delete restrict on update cascade) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+
+	for _, action := range []string{"delete", "set", "cascade all"} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("expected panic for action %q", action)
+				}
+			}()
+			table.ColMap("PersonId").SetReferences("person_test.id", action, "")
+		}()
 	}
 }
 
-func TestPersistentUser(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.Exec("drop table if exists PersistentUser")
-	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
-	table.ColMap("Key").Rename("mykey")
-	err := dbmap.CreateTablesIfNotExists()
-	if err != nil {
-		panic(err)
+func TestTableComment(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `create table "comment_test" ("id" integer, "bar" varchar(255)) ;`},
+		{PostgresDialect{}, `create table "comment_test" ("id" bigint, "bar" varchar(255)) ;comment on table "comment_test" is 'it''s a test';`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create table `comment_test` (`id` bigint, `bar` varchar(255))  engine=InnoDB charset=UTF8 comment='it''s a test';"},
+		{SqlServerDialect{}, `create table [comment_test] ([id] bigint, [bar] nvarchar(max)) ;;if not exists (select 1 from sys.extended_properties where major_id = object_id(N'[dbo].[comment_test]') and minor_id = 0 and name = N'MS_Description') exec sp_addextendedproperty @name = N'MS_Description', @value = N'it''s a test', @level0type = N'SCHEMA', @level0name = N'dbo', @level1type = N'TABLE', @level1name = N'comment_test';`},
+		{OracleDialect{}, `create table "COMMENT_TEST" ("ID" bigint, "BAR" text) comment on table "COMMENT_TEST" is 'it''s a test'`},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		table := dbmap.AddTableWithName(AliasTransientField{}, "comment_test").SetComment("it's a test")
+		if got := table.SqlForCreate(false); got != test.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", test.dialect, got, test.want)
+		}
 	}
+
+	dbmap := &DbMap{Dialect: MySQLDialect{"InnoDB", "UTF8"}}
+	table := dbmap.AddTableWithName(AliasTransientField{}, "comment_test").SetComment(`C:\temp`)
+	if got, want := table.commentSuffix(), ` comment='C:\\temp'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	dbmap = newDbMap()
 	defer dropAndClose(dbmap)
-	pu := &PersistentUser{43, "33r", false}
-	err = dbmap.Insert(pu)
+	dbmap.AddTableWithName(AliasTransientField{}, "comment_test").SetComment("it's a test")
+	err := dbmap.CreateTables()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	var comment string
+	switch dbmap.Dialect.(type) {
+	case PostgresDialect:
+		comment, err = dbmap.SelectStr("select obj_description('comment_test'::regclass, 'pg_class')")
+	case MySQLDialect:
+		comment, err = dbmap.SelectStr("select table_comment from information_schema.tables where table_schema = database() and table_name = 'comment_test'")
+	default:
+		t.Skipf("table comments are not read back for %T", dbmap.Dialect)
 	}
-
-	// prove we can pass a pointer into Get
-	pu2, err := dbmap.Get(pu, pu.Key)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(pu, pu2) {
-		t.Errorf("%v!=%v", pu, pu2)
+	if comment != "it's a test" {
+		t.Errorf("comment = %q", comment)
 	}
+}
 
-	arr, err := dbmap.Select(pu, "select * from PersistentUser")
-	if err != nil {
-		panic(err)
+// initTestDriver records the statements run on its connections
+type initTestDriver struct {
+	execs *[]string
+}
+
+func (d initTestDriver) Open(dsn string) (driver.Conn, error) {
+	return initTestConn(d), nil
+}
+
+type initTestConn initTestDriver
+
+func (c initTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c initTestConn) Close() error { return nil }
+
+func (c initTestConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("begin not supported")
+}
+
+func (c initTestConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query == "fail" {
+		return nil, errors.New("failed")
 	}
-	if !reflect.DeepEqual(pu, arr[0]) {
-		t.Errorf("%v!=%v", pu, arr[0])
+	*c.execs = append(*c.execs, query)
+	return driver.RowsAffected(0), nil
+}
+
+func TestInitConnector(t *testing.T) {
+	if got := (MySQLDialect{"InnoDB", "utf8mb4"}).SetNamesSql(); got != "set names utf8mb4" {
+		t.Errorf("SetNamesSql = %q", got)
 	}
 
-	// prove we can get the results back in a slice
-	var puArr []*PersistentUser
-	_, err = dbmap.Select(&puArr, "select * from PersistentUser")
+	var execs []string
+	connector, err := NewInitConnector(initTestDriver{&execs}, "", "set names utf8mb4")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+	for i := 0; i < 2; i++ {
+		_, err = db.Exec("update t set x = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
-	if !reflect.DeepEqual(pu, puArr[0]) {
-		t.Errorf("%v!=%v", pu, puArr[0])
+	want := []string{"set names utf8mb4", "update t set x = 1", "set names utf8mb4", "update t set x = 1"}
+	if !reflect.DeepEqual(execs, want) {
+		t.Errorf("got %v, want %v", execs, want)
 	}
 
-	// prove we can get the results back in a non-pointer slice
-	var puValues []PersistentUser
-	_, err = dbmap.Select(&puValues, "select * from PersistentUser")
-	if err != nil {
-		panic(err)
+	connector, _ = NewInitConnector(initTestDriver{&execs}, "", "fail")
+	_, err = sql.OpenDB(connector).Exec("update t set x = 1")
+	if err == nil || !strings.Contains(err.Error(), "connection init") {
+		t.Errorf("expected init error, got %v", err)
 	}
-	if len(puValues) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+
+	d, drv := dialectAndDriver()
+	dialect, ok := d.(MySQLDialect)
+	if !ok {
+		t.Skip("connection character sets are only tested with MySQL")
 	}
-	if !reflect.DeepEqual(*pu, puValues[0]) {
-		t.Errorf("%v!=%v", *pu, puValues[0])
+	conn := connect(drv)
+	connector, err = NewInitConnector(conn.Driver(), os.Getenv("GORP_TEST_DSN"), dialect.SetNamesSql())
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	// prove we can get the results back in a string slice
-	var idArr []*string
-	_, err = dbmap.Select(&idArr, "select Id from PersistentUser")
+	dbmap := &DbMap{Db: sql.OpenDB(connector), Dialect: dialect}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
-	if len(idArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	defer dropAndClose(dbmap)
+
+	inv := &Invoice{Memo: "Grüße, 東京"}
+	_insert(dbmap, inv)
+	memo, err := dbmap.SelectStr("select memo from invoice_test")
+	if err != nil || memo != inv.Memo {
+		t.Errorf("memo = %q, %v, want %q", memo, err, inv.Memo)
 	}
-	if !reflect.DeepEqual(pu.Id, *idArr[0]) {
-		t.Errorf("%v!=%v", pu.Id, *idArr[0])
+}
+
+// txTestDriver counts the transactions on its connections
+type txTestDriver struct {
+	begins, commits, rollbacks int
+}
+
+func (d *txTestDriver) Open(dsn string) (driver.Conn, error) {
+	return (*txTestConn)(d), nil
+}
+
+type txTestConn txTestDriver
+
+func (c *txTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *txTestConn) Close() error { return nil }
+
+func (c *txTestConn) Begin() (driver.Tx, error) {
+	c.begins++
+	return c, nil
+}
+
+func (c *txTestConn) Commit() error {
+	c.commits++
+	return nil
+}
+
+func (c *txTestConn) Rollback() error {
+	c.rollbacks++
+	return nil
+}
+
+// execTestDriver records the statements executed and queried on its
+// connections, and fails them with err if it is set.  Executed statements
+// report 42 as last insert id, queries return a single row with 42 in
+// column id, or row in columns if they are set.
+type execTestDriver struct {
+	queries []string
+	queried []string
+	err     error
+	columns []string
+	row     []driver.Value
+	open    int // rows not closed yet
+}
+
+func (d *execTestDriver) Open(dsn string) (driver.Conn, error) {
+	return (*execTestConn)(d), nil
+}
+
+type execTestConn execTestDriver
+
+func (c *execTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *execTestConn) Close() error { return nil }
+
+func (c *execTestConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+func (c *execTestConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	if c.err != nil {
+		return nil, c.err
 	}
+	return execTestResult{}, nil
+}
 
-	// prove we can get the results back in an int slice
-	var keyArr []*int32
-	_, err = dbmap.Select(&keyArr, "select mykey from PersistentUser")
-	if err != nil {
-		panic(err)
+func (c *execTestConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queried = append(c.queried, query)
+	if c.err != nil {
+		return nil, c.err
 	}
-	if len(keyArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	c.open++
+	if c.columns != nil {
+		return &execTestRows{columns: c.columns, row: c.row, conn: c}, nil
 	}
-	if !reflect.DeepEqual(pu.Key, *keyArr[0]) {
-		t.Errorf("%v!=%v", pu.Key, *keyArr[0])
+	return &execTestRows{columns: []string{"id"}, row: []driver.Value{int64(42)}, conn: c}, nil
+}
+
+type execTestResult struct{}
+
+func (r execTestResult) LastInsertId() (int64, error) { return 42, nil }
+func (r execTestResult) RowsAffected() (int64, error) { return 0, nil }
+
+type execTestRows struct {
+	columns []string
+	row     []driver.Value
+	done    bool
+	conn    *execTestConn
+}
+
+func (r *execTestRows) Columns() []string { return r.columns }
+
+func (r *execTestRows) Close() error {
+	r.conn.open--
+	return nil
+}
+
+func (r *execTestRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
 	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
 
-	// prove we can get the results back in a bool slice
-	var passedArr []*bool
-	_, err = dbmap.Select(&passedArr, "select PassedTraining from PersistentUser")
+func TestSelectJSON(t *testing.T) {
+	drv := &execTestDriver{
+		columns: []string{"id", "name", "created", "note", "score", "paid"},
+		row: []driver.Value{int64(42), []byte("bob"), time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+			nil, 1.5, true},
+	}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	if len(passedArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+
+	got, err := dbmap.SelectJSON("select * from person_test")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(pu.PassedTraining, *passedArr[0]) {
-		t.Errorf("%v!=%v", pu.PassedTraining, *passedArr[0])
+	want := `[{"created":"2020-05-01T10:00:00Z","id":42,"name":"bob","note":null,"paid":true,"score":1.5}]`
+	if string(got) != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
 	}
 
-	// prove we can get the results back in a non-pointer slice
-	var stringArr []string
-	_, err = dbmap.Select(&stringArr, "select Id from PersistentUser")
+	drv.err = errors.New("boom")
+	if _, err = dbmap.SelectJSON("select * from person_test"); err == nil {
+		t.Errorf("expected error of the query")
+	}
+
+	dbmap = initDbMap()
+	defer dropAndClose(dbmap)
+	got, err = dbmap.SelectJSON("select * from invoice_test")
+	if err != nil || string(got) != "[]" {
+		t.Errorf("empty SelectJSON = %s, %v", got, err)
+	}
+	_insert(dbmap, &Invoice{Created: 1, Memo: "a"}, &Invoice{Created: 2, Memo: "b"})
+	got, err = dbmap.SelectJSON("select memo from invoice_test order by created")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	if len(stringArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	var rows []map[string]interface{}
+	if err = json.Unmarshal(got, &rows); err != nil {
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(pu.Id, stringArr[0]) {
-		t.Errorf("%v!=%v", pu.Id, stringArr[0])
+	// the case of the column name depends on the database
+	var memos []interface{}
+	for _, row := range rows {
+		for _, v := range row {
+			memos = append(memos, v)
+		}
+	}
+	if !reflect.DeepEqual(memos, []interface{}{"a", "b"}) {
+		t.Errorf("rows %s", got)
 	}
 }
 
-func TestNamedQueryMap(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.Exec("drop table if exists PersistentUser")
-	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
-	table.ColMap("Key").Rename("mykey")
-	err := dbmap.CreateTablesIfNotExists()
+func TestInsertAutoIncrPath(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+	}{
+		{SqliteDialect{}, `insert into "invoice_test" ("Id","Created","Updated","Memo","PersonId","IsPaid") values (null,?,?,?,?,?);`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "insert into `invoice_test` (`Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid`) values (null,?,?,?,?,?);"},
+		{PostgresDialect{}, `insert into "invoice_test" ("id","created","updated","memo","personid","ispaid") values (default,$1,$2,$3,$4,$5) returning "id";`},
+		{SqlServerDialect{}, `insert into [invoice_test] ([Created],[Updated],[Memo],[PersonId],[IsPaid]) output inserted.[Id] values (?,?,?,?,?);`},
+		{OracleDialect{}, `insert into "INVOICE_TEST" ("ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID") values (default,:1,:2,:3,:4,:5) returning Id`},
+	}
+	for _, tt := range tests {
+		drv := &execTestDriver{}
+		connector, err := NewInitConnector(drv, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := sql.OpenDB(connector)
+		dbmap := &DbMap{Db: db, Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		inv := &Invoice{Memo: "a"}
+		if err = dbmap.Insert(inv); err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+		} else if inv.Id != 42 {
+			t.Errorf("%T: Id = %d", tt.dialect, inv.Id)
+		}
+		got := drv.queried
+		if tt.dialect.SupportsLastInsertId() {
+			got = drv.queries
+		}
+		if !reflect.DeepEqual(got, []string{tt.query}) || len(drv.queries)+len(drv.queried) != 1 {
+			t.Errorf("%T: executed %q, queried %q", tt.dialect, drv.queries, drv.queried)
+		}
+		db.Close()
+	}
+}
+
+func TestPartialTable(t *testing.T) {
+	drv := &execTestDriver{}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: MySQLDialect{"InnoDB", "UTF8"}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	table := dbmap.AddPartialTable(InvoiceMemo{}, &Invoice{})
+
+	if table.TableName != "invoice_test" || len(table.keys) != 1 || !table.keys[0].isAutoIncr {
+		t.Errorf("partial table = %s %v", table.TableName, table.keys)
+	}
+	if !table.ColMap("Note").Transient || table.ColMap("Memo").Transient {
+		t.Errorf("only Note should be transient")
+	}
+	if _, err = dbmap.Update(&InvoiceMemo{Id: 1, Memo: "m", Note: "n"}); err != nil {
+		t.Fatal(err)
 	}
+	if err = dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err = dbmap.DropTables(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"update `invoice_test` set `Memo`=? where `Id`=?;",
+		"create table `invoice_test` (`Id` bigint not null primary key auto_increment, `Created` bigint, `Updated` bigint, `Memo` varchar(255), `PersonId` bigint, `IsPaid` boolean)  engine=InnoDB charset=UTF8;",
+		"drop table `invoice_test`;",
+	}
+	if !reflect.DeepEqual(drv.queries, want) {
+		t.Errorf("\n got: %q\nwant: %q", drv.queries, want)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "FName") {
+			t.Errorf("expected panic for missing key field, got %v", r)
+		}
+	}()
+	dbmap.AddTableWithName(Person{}, "person_test").SetKeys(false, "Id", "FName")
+	dbmap.AddPartialTable(InvoiceMemo{}, Person{})
+}
+
+func TestPartialTableCRUD(t *testing.T) {
+	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
-	pu := &PersistentUser{43, "33r", false}
-	pu2 := &PersistentUser{500, "abc", false}
-	err = dbmap.Insert(pu, pu2)
-	if err != nil {
-		panic(err)
+
+	ext := &IdCreatedExternal{IdCreated: IdCreated{Created: 10}, External: 5}
+	_insert(dbmap, ext)
+	if ext.Id == 0 {
+		t.Fatalf("autoincr key not set on partial insert")
+	}
+	obj := _get(dbmap, IdCreated{}, ext.Id).(*IdCreated)
+	if obj.Created != 10 {
+		t.Errorf("IdCreated.Created = %d", obj.Created)
 	}
 
-	// Test simple case
-	var puArr []*PersistentUser
-	_, err = dbmap.Select(&puArr, "select * from PersistentUser where mykey = :Key", map[string]interface{}{
-		"Key": 43,
-	})
-	if err != nil {
-		t.Errorf("Failed to select: %s", err)
-		t.FailNow()
+	obj.Created = 20
+	_update(dbmap, obj)
+	got := _get(dbmap, IdCreatedExternal{}, ext.Id).(*IdCreatedExternal)
+	if got.Created != 20 || got.External != 0 {
+		t.Errorf("IdCreatedExternal = %+v", got)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+
+	got.Created = 30
+	got.External = 6
+	_update(dbmap, got)
+	obj = _get(dbmap, IdCreated{}, ext.Id).(*IdCreated)
+	if obj.Created != 30 {
+		t.Errorf("IdCreated.Created = %d", obj.Created)
 	}
-	if !reflect.DeepEqual(pu, puArr[0]) {
-		t.Errorf("%v!=%v", pu, puArr[0])
+
+	if count := _del(dbmap, got); count != 1 {
+		t.Errorf("delete count = %d", count)
 	}
+	if _get(dbmap, IdCreated{}, ext.Id) != nil {
+		t.Errorf("row not deleted")
+	}
+}
 
-	// Test more specific map value type is ok
-	puArr = nil
-	_, err = dbmap.Select(&puArr, "select * from PersistentUser where mykey = :Key", map[string]int{
-		"Key": 43,
-	})
+func TestOracleQuerySuffix(t *testing.T) {
+	drv := &execTestDriver{}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		t.Errorf("Failed to select: %s", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: OracleDialect{}}
+	table := dbmap.AddTableWithNameAndSchema(Invoice{}, "sales", "invoice_test").SetKeys(true, "Id")
+
+	if got, want := table.SqlForCreateSchema(false), "create schema sales"; got != want {
+		t.Errorf("%q != %q", got, want)
+	}
+	if got := table.SqlForCreate(false); strings.Contains(got, "create schema") {
+		t.Errorf("schema statement in %q", got)
 	}
 
-	// Test multiple parameters set.
-	puArr = nil
-	_, err = dbmap.Select(&puArr, `
-select * from PersistentUser
- where mykey = :Key
-   and PassedTraining = :PassedTraining
-   and Id = :Id`, map[string]interface{}{
-		"Key":            43,
-		"PassedTraining": false,
-		"Id":             "33r",
-	})
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbmap.DeleteWhere(Invoice{}, "memo = 'x';"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbmap.TruncateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbmap.DropTables(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"create schema sales",
+		`create table sales."INVOICE_TEST" ("ID" bigserial not null primary key , "CREATED" bigint, "UPDATED" bigint, "MEMO" text, "PERSONID" bigint, "ISPAID" boolean) `,
+		`delete from sales."INVOICE_TEST" where memo = 'x'`,
+		`truncate sales."INVOICE_TEST"`,
+		`drop table sales."INVOICE_TEST"`,
+	}
+	if !reflect.DeepEqual(drv.queries, want) {
+		t.Errorf("queries\n%q\n!=\n%q", drv.queries, want)
+	}
+}
+
+// sqlStateError, codeError and numberError look like driver errors
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "SQLSTATE " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+type codeError struct{ Code int }
+
+func (e *codeError) Error() string { return fmt.Sprint("code ", e.Code) }
+
+type numberError struct{ Number uint16 }
+
+func (e *numberError) Error() string { return fmt.Sprint("error ", e.Number) }
+
+type sqliteError struct{ Code, ExtendedCode int }
+
+func (e *sqliteError) Error() string { return fmt.Sprint("constraint failed ", e.ExtendedCode) }
+
+func TestDuplicateKey(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{sqlStateError("23505"), true},
+		{sqlStateError("23503"), false},
+		{&numberError{1062}, true},
+		{&numberError{2627}, true},
+		{&numberError{2601}, true},
+		{&numberError{1213}, false},
+		{&sqliteError{19, 2067}, true},
+		{&sqliteError{19, 1555}, true},
+		{&sqliteError{19, 787}, false},
+		{&codeError{19}, true},
+		{errors.New("ORA-00001: unique constraint (S.PK) violated"), true},
+		{fmt.Errorf("wrapped: %w", sqlStateError("23505")), true},
+		{errors.New("duplicate"), false},
+		{nil, false},
+	} {
+		if got := IsDuplicateKey(tt.err); got != tt.want {
+			t.Errorf("IsDuplicateKey(%v) = %t", tt.err, got)
+		}
+	}
+
+	drv := &execTestDriver{err: sqlStateError("23505")}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		t.Errorf("Failed to select: %s", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(false, "Id")
+
+	err = dbmap.Insert(&WithStringPk{"1", "foo"})
+	var dke *DuplicateKeyError
+	if !errors.As(err, &dke) || dke.TableName != "string_pk_test" || !IsDuplicateKey(err) {
+		t.Errorf("Insert = %#v", err)
 	}
+	drv.err = sqlStateError("23502")
+	if err = dbmap.Insert(&WithStringPk{"2", "bar"}); err == nil || IsDuplicateKey(err) {
+		t.Errorf("Insert = %v", err)
+	}
+}
 
-	// Test colon within a non-key string
-	// Test having extra, unused properties in the map.
-	puArr = nil
-	_, err = dbmap.Select(&puArr, `
-select * from PersistentUser
- where mykey = :Key
-   and Id != 'abc:def'`, map[string]interface{}{
-		"Key":            43,
-		"PassedTraining": false,
-	})
+func TestTransactionRetry(t *testing.T) {
+	for _, tt := range []struct {
+		dialect   Dialect
+		err       error
+		retryable bool
+	}{
+		{PostgresDialect{}, sqlStateError("40001"), true},
+		{PostgresDialect{}, fmt.Errorf("gorp: update failed for table 'x': %w", sqlStateError("40P01")), true},
+		{PostgresDialect{}, sqlStateError("23505"), false},
+		{MySQLDialect{"InnoDB", "UTF8"}, &numberError{1213}, true},
+		{MySQLDialect{"InnoDB", "UTF8"}, &numberError{1062}, false},
+		{SqlServerDialect{}, &numberError{1205}, true},
+		{OracleDialect{}, errors.New("ORA-08177: can't serialize access for this transaction"), true},
+		{SqliteDialect{}, &codeError{5}, true},
+		{SqliteDialect{}, errors.New("database is locked"), false},
+	} {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		if got := dbmap.IsRetryable(tt.err); got != tt.retryable {
+			t.Errorf("%T.IsRetryable(%v) = %t", tt.dialect, tt.err, got)
+		}
+	}
+
+	drv := &txTestDriver{}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		t.Errorf("Failed to select: %s", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+
+	// the serialization failure of the first two attempts is retried
+	attempts := 0
+	err = dbmap.WithTransactionRetry(func(trans *Transaction) error {
+		attempts++
+		if attempts < 3 {
+			return sqlStateError("40001")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || drv.begins != 3 || drv.commits != 1 || drv.rollbacks != 2 {
+		t.Errorf("err %v, %d attempts, %+v", err, attempts, *drv)
 	}
 
-	// Test to delete with Exec and named params.
-	result, err := dbmap.Exec("delete from PersistentUser where mykey = :Key", map[string]interface{}{
-		"Key": 43,
+	attempts = 0
+	dbmap.TransactionRetries = 1
+	err = dbmap.WithTransactionRetry(func(trans *Transaction) error {
+		attempts++
+		return sqlStateError("40001")
 	})
-	count, err := result.RowsAffected()
-	if err != nil {
-		t.Errorf("Failed to exec: %s", err)
-		t.FailNow()
+	if err == nil || attempts != 2 {
+		t.Errorf("err %v, %d attempts", err, attempts)
 	}
-	if count != 1 {
-		t.Errorf("Expected 1 persistentuser to be deleted, but %d deleted", count)
+
+	attempts = 0
+	err = dbmap.WithTransactionRetry(func(trans *Transaction) error {
+		attempts++
+		return sqlStateError("23505")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("err %v, %d attempts", err, attempts)
 	}
 }
-
-func TestNamedQueryStruct(t *testing.T) {
+func TestSetUniqueTogether(t *testing.T) {
 	dbmap := newDbMap()
-	dbmap.Exec("drop table if exists PersistentUser")
-	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
-	table.ColMap("Key").Rename("mykey")
+	dbmap.AddTable(UniqueColumns{}).SetUniqueTogether("FirstName", "LastName").SetUniqueTogether("City", "ZipCode")
 	err := dbmap.CreateTablesIfNotExists()
 	if err != nil {
 		panic(err)
 	}
 	defer dropAndClose(dbmap)
-	pu := &PersistentUser{43, "33r", false}
-	pu2 := &PersistentUser{500, "abc", false}
-	err = dbmap.Insert(pu, pu2)
+
+	n1 := &UniqueColumns{"Steve", "Jobs", "Cupertino", 95014}
+	err = dbmap.Insert(n1)
 	if err != nil {
-		panic(err)
+		t.Error(err)
 	}
 
-	// Test select self
-	var puArr []*PersistentUser
-	_, err = dbmap.Select(&puArr, `
-select * from PersistentUser
- where mykey = :Key
-   and PassedTraining = :PassedTraining
-   and Id = :Id`, pu)
+	// Should fail because of the first constraint
+	n2 := &UniqueColumns{"Steve", "Jobs", "Sunnyvale", 94085}
+	err = dbmap.Insert(n2)
+	if err == nil {
+		t.Error(err)
+	}
+	// "unique" for Postgres/SQLite, "Duplicate entry" for MySQL
+	errLower := strings.ToLower(err.Error())
+	if !strings.Contains(errLower, "unique") && !strings.Contains(errLower, "duplicate entry") {
+		t.Error(err)
+	}
+
+	// Should also fail because of the second unique-together
+	n3 := &UniqueColumns{"Steve", "Wozniak", "Cupertino", 95014}
+	err = dbmap.Insert(n3)
+	if err == nil {
+		t.Error(err)
+	}
+	// "unique" for Postgres/SQLite, "Duplicate entry" for MySQL
+	errLower = strings.ToLower(err.Error())
+	if !strings.Contains(errLower, "unique") && !strings.Contains(errLower, "duplicate entry") {
+		t.Error(err)
+	}
+
+	// This one should finally succeed
+	n4 := &UniqueColumns{"Steve", "Wozniak", "Sunnyvale", 94085}
+	err = dbmap.Insert(n4)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPersistentUser(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.Exec("drop table if exists PersistentUser")
+	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
+	table.ColMap("Key").Rename("mykey")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	pu := &PersistentUser{43, "33r", false}
+	err = dbmap.Insert(pu)
+	if err != nil {
+		panic(err)
+	}
+
+	// prove we can pass a pointer into Get
+	pu2, err := dbmap.Get(pu, pu.Key)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(pu, pu2) {
+		t.Errorf("%v!=%v", pu, pu2)
+	}
+
+	arr, err := dbmap.Select(pu, "select * from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(pu, arr[0]) {
+		t.Errorf("%v!=%v", pu, arr[0])
+	}
+
+	// prove we can get the results back in a slice
+	var puArr []*PersistentUser
+	_, err = dbmap.Select(&puArr, "select * from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu, puArr[0]) {
+		t.Errorf("%v!=%v", pu, puArr[0])
+	}
+
+	// prove we can get the results back in a non-pointer slice
+	var puValues []PersistentUser
+	_, err = dbmap.Select(&puValues, "select * from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(puValues) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(*pu, puValues[0]) {
+		t.Errorf("%v!=%v", *pu, puValues[0])
+	}
+
+	// prove we can get the results back in a string slice
+	var idArr []*string
+	_, err = dbmap.Select(&idArr, "select Id from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(idArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu.Id, *idArr[0]) {
+		t.Errorf("%v!=%v", pu.Id, *idArr[0])
+	}
+
+	// prove we can get the results back in an int slice
+	var keyArr []*int32
+	_, err = dbmap.Select(&keyArr, "select mykey from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(keyArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu.Key, *keyArr[0]) {
+		t.Errorf("%v!=%v", pu.Key, *keyArr[0])
+	}
+
+	// prove we can get the results back in a bool slice
+	var passedArr []*bool
+	_, err = dbmap.Select(&passedArr, "select PassedTraining from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(passedArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu.PassedTraining, *passedArr[0]) {
+		t.Errorf("%v!=%v", pu.PassedTraining, *passedArr[0])
+	}
+
+	// prove we can get the results back in a non-pointer slice
+	var stringArr []string
+	_, err = dbmap.Select(&stringArr, "select Id from PersistentUser")
+	if err != nil {
+		panic(err)
+	}
+	if len(stringArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu.Id, stringArr[0]) {
+		t.Errorf("%v!=%v", pu.Id, stringArr[0])
+	}
+}
+
+func TestNamedQueryMap(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.Exec("drop table if exists PersistentUser")
+	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
+	table.ColMap("Key").Rename("mykey")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	pu := &PersistentUser{43, "33r", false}
+	pu2 := &PersistentUser{500, "abc", false}
+	err = dbmap.Insert(pu, pu2)
+	if err != nil {
+		panic(err)
+	}
+
+	// Test simple case
+	var puArr []*PersistentUser
+	_, err = dbmap.Select(&puArr, "select * from PersistentUser where mykey = :Key", map[string]interface{}{
+		"Key": 43,
+	})
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu, puArr[0]) {
+		t.Errorf("%v!=%v", pu, puArr[0])
+	}
+
+	// Test more specific map value type is ok
+	puArr = nil
+	_, err = dbmap.Select(&puArr, "select * from PersistentUser where mykey = :Key", map[string]int{
+		"Key": 43,
+	})
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+
+	// Test multiple parameters set.
+	puArr = nil
+	_, err = dbmap.Select(&puArr, `
+select * from PersistentUser
+ where mykey = :Key
+   and PassedTraining = :PassedTraining
+   and Id = :Id`, map[string]interface{}{
+		"Key":            43,
+		"PassedTraining": false,
+		"Id":             "33r",
+	})
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+
+	// Test colon within a non-key string
+	// Test having extra, unused properties in the map.
+	puArr = nil
+	_, err = dbmap.Select(&puArr, `
+select * from PersistentUser
+ where mykey = :Key
+   and Id != 'abc:def'`, map[string]interface{}{
+		"Key":            43,
+		"PassedTraining": false,
+	})
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+
+	// Test to delete with Exec and named params.
+	result, err := dbmap.Exec("delete from PersistentUser where mykey = :Key", map[string]interface{}{
+		"Key": 43,
+	})
+	count, err := result.RowsAffected()
+	if err != nil {
+		t.Errorf("Failed to exec: %s", err)
+		t.FailNow()
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 persistentuser to be deleted, but %d deleted", count)
+	}
+}
+
+func TestNamedQueryStruct(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.Exec("drop table if exists PersistentUser")
+	table := dbmap.AddTable(PersistentUser{}).SetKeys(false, "Key")
+	table.ColMap("Key").Rename("mykey")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	pu := &PersistentUser{43, "33r", false}
+	pu2 := &PersistentUser{500, "abc", false}
+	err = dbmap.Insert(pu, pu2)
+	if err != nil {
+		panic(err)
+	}
+
+	// Test select self
+	var puArr []*PersistentUser
+	_, err = dbmap.Select(&puArr, `
+select * from PersistentUser
+ where mykey = :Key
+   and PassedTraining = :PassedTraining
+   and Id = :Id`, pu)
+	if err != nil {
+		t.Errorf("Failed to select: %s", err)
+		t.FailNow()
+	}
+	if len(puArr) != 1 {
+		t.Errorf("Expected one persistentuser, found none")
+	}
+	if !reflect.DeepEqual(pu, puArr[0]) {
+		t.Errorf("%v!=%v", pu, puArr[0])
+	}
+
+	// Test delete self.
+	result, err := dbmap.Exec(`
+delete from PersistentUser
+ where mykey = :Key
+   and PassedTraining = :PassedTraining
+   and Id = :Id`, pu)
+	count, err := result.RowsAffected()
+	if err != nil {
+		t.Errorf("Failed to exec: %s", err)
+		t.FailNow()
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 persistentuser to be deleted, but %d deleted", count)
+	}
+}
+
+// Ensure that the slices containing SQL results are non-nil when the result set is empty.
+func TestReturnsNonNilSlice(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	noResultsSQL := "select * from invoice_test where id=99999"
+	var r1 []*Invoice
+	_rawselect(dbmap, &r1, noResultsSQL)
+	if r1 == nil {
+		t.Errorf("r1==nil")
+	}
+
+	r2 := _rawselect(dbmap, Invoice{}, noResultsSQL)
+	if r2 == nil {
+		t.Errorf("r2==nil")
+	}
+}
+
+func TestOverrideVersionCol(t *testing.T) {
+	dbmap := newDbMap()
+	t1 := dbmap.AddTable(InvoicePersonView{}).SetKeys(false, "InvoiceId", "PersonId")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	c1 := t1.SetVersionCol("LegacyVersion")
+	if c1.ColumnName != "LegacyVersion" {
+		t.Errorf("Wrong col returned: %v", c1)
+	}
+
+	ipv := &InvoicePersonView{1, 2, "memo", "fname", 0}
+	_update(dbmap, ipv)
+	if ipv.LegacyVersion != 1 {
+		t.Errorf("LegacyVersion not updated: %d", ipv.LegacyVersion)
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "Bob", "Smith", 0}
+	dbmap.Insert(p1) // Version is now 1
+	if p1.Version != 1 {
+		t.Errorf("Insert didn't incr Version: %d != %d", 1, p1.Version)
+		return
+	}
+	if p1.Id == 0 {
+		t.Errorf("Insert didn't return a generated PK")
+		return
+	}
+
+	obj, err := dbmap.Get(Person{}, p1.Id)
+	if err != nil {
+		panic(err)
+	}
+	p2 := obj.(*Person)
+	p2.LName = "Edwards"
+	dbmap.Update(p2) // Version is now 2
+	if p2.Version != 2 {
+		t.Errorf("Update didn't incr Version: %d != %d", 2, p2.Version)
+	}
+
+	p1.LName = "Howard"
+	count, err := dbmap.Update(p1)
+	if _, ok := err.(OptimisticLockError); !ok {
+		t.Errorf("update - Expected OptimisticLockError, got: %v", err)
+	}
+	if count != -1 {
+		t.Errorf("update - Expected -1 count, got: %d", count)
+	}
+
+	count, err = dbmap.Delete(p1)
+	if _, ok := err.(OptimisticLockError); !ok {
+		t.Errorf("delete - Expected OptimisticLockError, got: %v", err)
+	}
+	if count != -1 {
+		t.Errorf("delete - Expected -1 count, got: %d", count)
+	}
+}
+
+// what happens if a legacy table has a null value?
+func TestDoubleAddTable(t *testing.T) {
+	dbmap := newDbMap()
+	t1 := dbmap.AddTable(TableWithNull{}).SetKeys(false, "Id")
+	t2 := dbmap.AddTable(TableWithNull{})
+	if t1 != t2 {
+		t.Errorf("%v != %v", t1, t2)
+	}
+}
+
+// what happens if a legacy table has a null value?
+func TestNullValues(t *testing.T) {
+	dbmap := initDbMapNulls()
+	defer dropAndClose(dbmap)
+
+	// insert a row directly
+	_rawexec(dbmap, "insert into TableWithNull values (10, null, "+
+		"null, null, null, null)")
+
+	// try to load it
+	expected := &TableWithNull{Id: 10}
+	obj := _get(dbmap, TableWithNull{}, 10)
+	t1 := obj.(*TableWithNull)
+	if !reflect.DeepEqual(expected, t1) {
+		t.Errorf("%v != %v", expected, t1)
+	}
+
+	// update it
+	t1.Str = sql.NullString{"hi", true}
+	expected.Str = t1.Str
+	t1.Int64 = sql.NullInt64{999, true}
+	expected.Int64 = t1.Int64
+	t1.Float64 = sql.NullFloat64{53.33, true}
+	expected.Float64 = t1.Float64
+	t1.Bool = sql.NullBool{true, true}
+	expected.Bool = t1.Bool
+	t1.Bytes = []byte{1, 30, 31, 33}
+	expected.Bytes = t1.Bytes
+	_update(dbmap, t1)
+
+	obj = _get(dbmap, TableWithNull{}, 10)
+	t1 = obj.(*TableWithNull)
+	if t1.Str.String != "hi" {
+		t.Errorf("%s != hi", t1.Str.String)
+	}
+	if !reflect.DeepEqual(expected, t1) {
+		t.Errorf("%v != %v", expected, t1)
+	}
+}
+
+func TestScannerValuer(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(PersonValuerScanner{}, "person_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(InvoiceWithValuer{}, "invoice_test").SetKeys(true, "Id")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	pv := PersonValuerScanner{}
+	pv.FName = "foo"
+	pv.LName = "bar"
+	err = dbmap.Insert(&pv)
+	if err != nil {
+		t.Errorf("Could not insert PersonValuerScanner using Person table: %v", err)
+		t.FailNow()
+	}
+
+	inv := InvoiceWithValuer{}
+	inv.Memo = "foo"
+	inv.Person = pv
+	err = dbmap.Insert(&inv)
+	if err != nil {
+		t.Errorf("Could not insert InvoiceWithValuer using Invoice table: %v", err)
+		t.FailNow()
+	}
+
+	res, err := dbmap.Get(InvoiceWithValuer{}, inv.Id)
+	if err != nil {
+		t.Errorf("Could not get InvoiceWithValuer: %v", err)
+		t.FailNow()
+	}
+	dbInv := res.(*InvoiceWithValuer)
+
+	if dbInv.Person.Id != pv.Id {
+		t.Errorf("InvoiceWithValuer got wrong person ID: %d (expected) != %d (actual)", pv.Id, dbInv.Person.Id)
+	}
+}
+
+func TestSetTransientToggle(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	inv := reflect.ValueOf(&Invoice{Memo: "a"}).Elem()
+	bi, err := table.bindInsert(inv)
+	if err != nil || !strings.Contains(bi.query, `"Memo"`) {
+		t.Errorf("Memo should be inserted: %s %v", bi.query, err)
+	}
+	table.ColMap("Memo").SetTransient(true)
+	bi, err = table.bindInsert(inv)
+	if err != nil || strings.Contains(bi.query, `"Memo"`) {
+		t.Errorf("transient Memo should not be inserted: %s %v", bi.query, err)
+	}
+	table.ColMap("Memo").SetTransient(false)
+
+	dbmap = initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 0, 0, "persisted", 0, false}
+	_insert(dbmap, inv1)
+	table, _ = dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+	table.ColMap("Memo").SetTransient(true)
+	inv2 := &Invoice{0, 0, 0, "not persisted", 0, false}
+	_insert(dbmap, inv2)
+	table.ColMap("Memo").SetTransient(false)
+
+	obj := _get(dbmap, Invoice{}, inv1.Id).(*Invoice)
+	if obj.Memo != "persisted" {
+		t.Errorf("Memo of first insert: %q", obj.Memo)
+	}
+	count := selectInt(dbmap, "select count(*) from invoice_test where Memo is null and Id = ?", inv2.Id)
+	if count != 1 {
+		t.Errorf("Memo of insert with transient column should be null")
+	}
+}
+
+func TestColumnProps(t *testing.T) {
+	dbmap := newDbMap()
+	t1 := dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
+	t1.ColMap("Created").Rename("date_created")
+	t1.ColMap("Updated").SetTransient(true)
+	t1.ColMap("Memo").SetMaxSize(10)
+	t1.ColMap("PersonId").SetUnique(true)
+
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	// test transient
+	inv := &Invoice{0, 0, 1, "my invoice", 0, true}
+	_insert(dbmap, inv)
+	obj := _get(dbmap, Invoice{}, inv.Id)
+	inv = obj.(*Invoice)
+	if inv.Updated != 0 {
+		t.Errorf("Saved transient column 'Updated'")
+	}
+
+	// test max size
+	inv.Memo = "this memo is too long"
+	err = dbmap.Insert(inv)
+	if err == nil {
+		t.Errorf("max size exceeded, but Insert did not fail.")
+	}
+
+	// test unique - same person id
+	inv = &Invoice{0, 0, 1, "my invoice2", 0, false}
+	err = dbmap.Insert(inv)
+	if err == nil {
+		t.Errorf("same PersonId inserted, but Insert did not fail.")
+	}
+}
+
+func TestRawSelect(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+
+	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, true}
+	_insert(dbmap, inv1)
+
+	expected := &InvoicePersonView{inv1.Id, p1.Id, inv1.Memo, p1.FName, 0}
+
+	query := "select i.Id InvoiceId, p.Id PersonId, i.Memo, p.FName " +
+		"from invoice_test i, person_test p " +
+		"where i.PersonId = p.Id"
+	list := _rawselect(dbmap, InvoicePersonView{}, query)
+	if len(list) != 1 {
+		t.Errorf("len(list) != 1: %d", len(list))
+	} else if !reflect.DeepEqual(expected, list[0]) {
+		t.Errorf("%v != %v", expected, list[0])
+	}
+}
+
+func TestColumnCase(t *testing.T) {
+	m := &DbMap{Dialect: SqliteDialect{}}
+	m.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	cols := []string{"ID", "memo", "PersonId"}
+	idx, err := columnToFieldIndex(m, reflect.TypeOf(Invoice{}), cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int{{0}, {3}, {4}}; !reflect.DeepEqual(idx, want) {
+		t.Errorf("got %v, want %v", idx, want)
+	}
+	m.StrictColumnCase = true
+	idx, err = columnToFieldIndex(m, reflect.TypeOf(Invoice{}), cols)
+	nf, ok := err.(*NoFieldInTypeError)
+	if !ok || !reflect.DeepEqual(nf.MissingColNames, []string{"id", "memo"}) || idx[2] == nil {
+		t.Errorf("unexpected %v, %v", idx, err)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	inv := &Invoice{0, 100, 200, "xmas order", 1, true}
+	_insert(dbmap, inv)
+
+	var list []Invoice
+	_, err = dbmap.Select(&list, "select Id as ID, Memo as MEMO, PersonId as personid from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Id != inv.Id || list[0].Memo != inv.Memo || list[0].PersonId != inv.PersonId {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestSelectAnonymousStruct(t *testing.T) {
+	var rows []struct {
+		InvoiceId int64
+		Name      string `db:"FName"`
+		Memo      string
+	}
+	err := missingColumns(reflect.TypeOf(rows).Elem(), []string{"Other"}, [][]int{nil}, []int{0})
+	if !strings.Contains(fmt.Sprint(err), "struct {") {
+		t.Errorf("anonymous type not named in error: %v", err)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, true}
+	_insert(dbmap, inv1)
+
+	query := "select i.Id InvoiceId, p.FName, i.Memo " +
+		"from invoice_test i, person_test p " +
+		"where i.PersonId = p.Id"
+	_, err = dbmap.Select(&rows, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].InvoiceId != inv1.Id || rows[0].Name != "bob" || rows[0].Memo != inv1.Memo {
+		t.Errorf("unexpected %v", rows)
+	}
+
+	var row struct {
+		InvoiceId int64
+		Name      string `db:"FName"`
+		Memo      string
+	}
+	err = dbmap.SelectOneTo(&row, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row != rows[0] {
+		t.Errorf("%v != %v", row, rows[0])
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+	if p1.Created == 0 || p1.Updated == 0 {
+		t.Errorf("p1.PreInsert() didn't run: %v", p1)
+	} else if p1.LName != "postinsert" {
+		t.Errorf("p1.PostInsert() didn't run: %v", p1)
+	}
+
+	obj := _get(dbmap, Person{}, p1.Id)
+	p1 = obj.(*Person)
+	if p1.LName != "postget" {
+		t.Errorf("p1.PostGet() didn't run: %v", p1)
+	}
+
+	_update(dbmap, p1)
+	if p1.FName != "preupdate" {
+		t.Errorf("p1.PreUpdate() didn't run: %v", p1)
+	} else if p1.LName != "postupdate" {
+		t.Errorf("p1.PostUpdate() didn't run: %v", p1)
+	}
+
+	var persons []*Person
+	bindVar := dbmap.Dialect.BindVar(0)
+	_rawselect(dbmap, &persons, "select * from person_test where id = "+bindVar, p1.Id)
+	if persons[0].LName != "postget" {
+		t.Errorf("p1.PostGet() didn't run after select: %v", p1)
+	}
+
+	_del(dbmap, p1)
+	if p1.FName != "predelete" {
+		t.Errorf("p1.PreDelete() didn't run: %v", p1)
+	} else if p1.LName != "postdelete" {
+		t.Errorf("p1.PostDelete() didn't run: %v", p1)
+	}
+
+	// Test error case
+	p2 := &Person{0, 0, 0, "badname", "", 0}
+	err := dbmap.Insert(p2)
+	if err == nil {
+		t.Errorf("p2.PreInsert() didn't return an error")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "t1", 0, true}
+	inv2 := &Invoice{0, 100, 200, "t2", 0, false}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	trans.Insert(inv1, inv2)
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	obj, err := dbmap.Get(Invoice{}, inv1.Id)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(inv1, obj) {
+		t.Errorf("%v != %v", inv1, obj)
+	}
+	obj, err = dbmap.Get(Invoice{}, inv2.Id)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(inv2, obj) {
+		t.Errorf("%v != %v", inv2, obj)
+	}
+}
+
+func TestTransactionWithOptions(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "serializable", 0, true}
+
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	trans, err := dbmap.BeginTx(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trans.Options() != opts {
+		t.Errorf("transaction options not stored: %v != %v", trans.Options(), opts)
+	}
+	err = trans.Insert(inv1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := dbmap.Get(Invoice{}, inv1.Id)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(inv1, obj) {
+		t.Errorf("%v != %v", inv1, obj)
+	}
+
+	// read-only transactions can still select
+	trans, err = dbmap.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memo, err := trans.SelectStr("select memo from invoice_test")
+	if err != nil {
+		t.Error(err)
+	}
+	if memo != inv1.Memo {
+		t.Errorf("%q != %q", memo, inv1.Memo)
+	}
+	err = trans.Rollback()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSavepointDialect(t *testing.T) {
+	tests := []struct {
+		dialect                      SavepointDialect
+		savepoint, rollback, release string
+	}{
+		{SqlServerDialect{}, "save transaction [sp]", "rollback transaction [sp]", ""},
+		{OracleDialect{}, `savepoint "SP"`, `rollback to savepoint "SP"`, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.SavepointSql("sp"); got != tt.savepoint {
+			t.Errorf("%T: SavepointSql = %s", tt.dialect, got)
+		}
+		if got := tt.dialect.RollbackToSavepointSql("sp"); got != tt.rollback {
+			t.Errorf("%T: RollbackToSavepointSql = %s", tt.dialect, got)
+		}
+		if got := tt.dialect.ReleaseSavepointSql("sp"); got != tt.release {
+			t.Errorf("%T: ReleaseSavepointSql = %s", tt.dialect, got)
+		}
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "unpaid", 0, false}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	trans.Insert(inv1)
+
+	var checkMemo = func(want string) {
+		memo, err := trans.SelectStr("select memo from invoice_test")
+		if err != nil {
+			panic(err)
+		}
+		if memo != want {
+			t.Errorf("%q != %q", want, memo)
+		}
+	}
+	checkMemo("unpaid")
+
+	err = trans.Savepoint("foo")
+	if err != nil {
+		panic(err)
+	}
+	checkMemo("unpaid")
+
+	inv1.Memo = "paid"
+	_, err = trans.Update(inv1)
+	if err != nil {
+		panic(err)
+	}
+	checkMemo("paid")
+
+	err = trans.RollbackToSavepoint("foo")
+	if err != nil {
+		panic(err)
+	}
+	checkMemo("unpaid")
+
+	err = trans.Rollback()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestInsertOrGet(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(WithUniqueCode{}, "unique_code_test")
+	keys, err := table.uniqueKeys()
+	if err != nil || !reflect.DeepEqual(keys, [][]string{{"Code"}}) {
+		t.Errorf("unique keys of unique_code_test: %v %v", keys, err)
+	}
+	table = dbmap.AddTable(UniqueColumns{}).SetUniqueTogether("FirstName", "LastName").SetUniqueTogether("City", "ZipCode")
+	keys, err = table.uniqueKeys()
+	if err != nil || !reflect.DeepEqual(keys, [][]string{{"FirstName", "LastName"}, {"City", "ZipCode"}}) {
+		t.Errorf("unique keys of UniqueColumns: %v %v", keys, err)
+	}
+	table = dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	if _, err = table.uniqueKeys(); err == nil {
+		t.Errorf("expected error for table without unique key")
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(WithUniqueCode{}, "unique_code_test")
+	dbmap.AddTableWithName(UniqueColumns{}, "unique_columns_test").
+		SetUniqueTogether("FirstName", "LastName").SetUniqueTogether("City", "ZipCode")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	u1 := &WithUniqueCode{Code: "abc", Name: "first"}
+	created, err := dbmap.InsertOrGet(u1)
+	if err != nil || !created || u1.Id == 0 {
+		t.Fatalf("first InsertOrGet: %v %v %v", created, err, u1)
+	}
+
+	u2 := &WithUniqueCode{Code: "abc", Name: "second"}
+	created, err = dbmap.InsertOrGet(u2)
+	if err != nil || created {
+		t.Fatalf("second InsertOrGet: %v %v", created, err)
+	}
+	if !reflect.DeepEqual(u1, u2) {
+		t.Errorf("%v != %v", u1, u2)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	u3 := &WithUniqueCode{Code: "def", Name: "third"}
+	created, err = trans.InsertOrGet(u3)
+	if err != nil || !created {
+		t.Errorf("InsertOrGet in transaction: %v %v", created, err)
+	}
+	created, err = trans.InsertOrGet(&WithUniqueCode{Code: "abc"})
+	if err != nil || created {
+		t.Errorf("InsertOrGet of existing row in transaction: %v %v", created, err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+	if count := selectInt(dbmap, "select count(*) from unique_code_test"); count != 2 {
+		t.Errorf("expected 2 rows, got %d", count)
+	}
+
+	// the row conflicts on the second unique set
+	c1 := &UniqueColumns{"bob", "smith", "Springfield", 12345}
+	_insert(dbmap, c1)
+	c2 := &UniqueColumns{"jane", "doe", "Springfield", 12345}
+	created, err = dbmap.InsertOrGet(c2)
+	if err != nil || created || !reflect.DeepEqual(c1, c2) {
+		t.Errorf("InsertOrGet conflicting on city and zip code: %v %v %v", created, err, c2)
+	}
+
+	// errors other than duplicate keys are returned unchanged
+	missing := newDbMap()
+	defer missing.Db.Close()
+	missing.AddTableWithName(WithUniqueCode{}, "no_such_table_test")
+	logBuffer := &bytes.Buffer{}
+	missing.TraceOn("", log.New(logBuffer, "", 0))
+	_, err = missing.InsertOrGet(&WithUniqueCode{Code: "abc"})
+	if err == nil || IsDuplicateKey(err) {
+		t.Errorf("expected the error of the insert, got %v", err)
+	}
+	if strings.Contains(logBuffer.String(), "select") {
+		t.Errorf("existing row looked up after %v", err)
+	}
+}
+
+func TestUpdateWithOld(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "before", 0, false}
+	_insert(dbmap, inv1)
+	before := *inv1
+
+	inv1.Memo = "after"
+	inv1.IsPaid = true
+	old, count, err := dbmap.UpdateWithOld(inv1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("update 1 != %d", count)
+	}
+	if !reflect.DeepEqual(&before, old) {
+		t.Errorf("%v != %v", &before, old)
+	}
+
+	obj := _get(dbmap, Invoice{}, inv1.Id)
+	if !reflect.DeepEqual(inv1, obj) {
+		t.Errorf("%v != %v", inv1, obj)
+	}
+
+	for _, tt := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{PostgresDialect{}, `select "id","created","updated","memo","personid","ispaid" from "invoice_test" where "id"=$1 for update;`},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] with (updlock, rowlock) where [Id]=?;`},
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where "Id"=?;`},
+	} {
+		table := (&DbMap{Dialect: tt.dialect}).AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+		if got := table.bindGetForUpdate().query; got != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.want)
+		}
+	}
+
+	drv := &execTestDriver{
+		columns: []string{"id", "created", "updated", "memo", "personid", "ispaid"},
+		row:     []driver.Value{int64(1), int64(100), int64(200), "before", int64(0), false},
+	}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	pgmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	pgmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	old, _, err = updateWithOld(pgmap, pgmap, &Invoice{Id: 1, Memo: "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.(*Invoice).Memo != "before" || len(drv.queried) != 1 || !strings.HasSuffix(drv.queried[0], " for update;") {
+		t.Errorf("old row was not selected for update: %v %v", old, drv.queried)
+	}
+}
+
+func TestSqlServerInsertOutputClause(t *testing.T) {
+	var d Dialect = SqlServerDialect{}
+	if _, ok := d.(TargetedAutoIncrInserter); !ok {
+		t.Errorf("SqlServerDialect does not implement TargetedAutoIncrInserter")
+	}
+	if _, ok := d.(IntegerAutoIncrInserter); ok {
+		t.Errorf("SqlServerDialect should not rely on LastInsertId")
+	}
+
+	dbmap := &DbMap{Dialect: d}
+	table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	bi, err := table.bindInsert(reflect.ValueOf(&Invoice{}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "insert into [invoice_test] ([Created],[Updated],[Memo],[PersonId],[IsPaid]) output inserted.[Id] values (?,?,?,?,?);"
+	if bi.query != want {
+		t.Errorf("\n got: %s\nwant: %s", bi.query, want)
+	}
+	if bi.autoIncrFieldName != "Id" {
+		t.Errorf("autoIncrFieldName %q != %q", bi.autoIncrFieldName, "Id")
+	}
+}
+
+func TestPingAndVerifySchema(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	err := dbmap.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbmap.VerifySchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbmap.DropTable(Invoice{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbmap.VerifySchema()
+	mte, ok := err.(*MissingTablesError)
+	if !ok {
+		t.Fatalf("expected *MissingTablesError, got %v", err)
+	}
+	if !reflect.DeepEqual(mte.TableNames, []string{"invoice_test"}) {
+		t.Errorf("unexpected missing tables %v", mte.TableNames)
+	}
+
+	drv := &execTestDriver{err: sqlStateError("42P01")}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	pgmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	pgmap.AddTableWithName(Invoice{}, "invoice_test")
+	if _, ok := pgmap.VerifySchema().(*MissingTablesError); !ok {
+		t.Errorf("expected *MissingTablesError for an undefined table")
+	}
+	drv.err = sqlStateError("42501")
+	if err = pgmap.VerifySchema(); sqlState(err) != "42501" {
+		t.Errorf("expected the permission error as is, got %v", err)
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(WithOmitEmpty{}, "omit_empty_test")
+	bi, err := table.bindInsert(reflect.ValueOf(&WithOmitEmpty{}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `insert into "omit_empty_test" ("id") values (default) returning "id";`
+	if bi.query != want || !reflect.DeepEqual(bi.readFields, []string{"Status"}) {
+		t.Errorf("zero values: %s %v", bi.query, bi.readFields)
+	}
+	bi, err = table.bindInsert(reflect.ValueOf(&WithOmitEmpty{Status: "done", Priority: 1}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `insert into "omit_empty_test" ("id","status","priority") values (default,$1,$2) returning "id";`
+	if bi.query != want || len(bi.readFields) != 0 {
+		t.Errorf("values set: %s %v", bi.query, bi.readFields)
+	}
+
+	dbmap = newDbMap()
+	table = dbmap.AddTableWithName(WithOmitEmpty{}, "omit_empty_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	row := &WithOmitEmpty{}
+	_insert(dbmap, row)
+	if row.Status != "new" {
+		t.Errorf("Status not read back: %q", row.Status)
+	}
+	if plan := dbmap.LastOpInfo.BindPlanUsed; plan == nil || plan == &table.insertPlan || strings.Contains(plan.query, "Status") {
+		t.Errorf("BindPlanUsed is not the plan of the insert: %v", plan)
+	}
+	obj := _get(dbmap, WithOmitEmpty{}, row.Id).(*WithOmitEmpty)
+	if obj.Status != "new" || obj.Priority != 3 {
+		t.Errorf("defaults not applied: %v", obj)
+	}
+
+	row = &WithOmitEmpty{Status: "done", Priority: 1}
+	_insert(dbmap, row)
+	if dbmap.LastOpInfo.BindPlanUsed != &table.insertPlan {
+		t.Errorf("BindPlanUsed is not the cached insert plan")
+	}
+	obj = _get(dbmap, WithOmitEmpty{}, row.Id).(*WithOmitEmpty)
+	if obj.Status != "done" || obj.Priority != 1 {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	// updates write zero values
+	row.Priority = 0
+	_update(dbmap, row)
+	obj = _get(dbmap, WithOmitEmpty{}, row.Id).(*WithOmitEmpty)
+	if obj.Priority != 0 {
+		t.Errorf("zero value not updated: %v", obj)
+	}
+}
+
+func TestNilPointerFields(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(OptionalFields{}, "optional_fields_test").SetKeys(true, "Id")
+
+	bi, err := table.bindInsert(reflect.ValueOf(&OptionalFields{}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{nil, nil, nil}; !reflect.DeepEqual(bi.args, want) {
+		t.Errorf("nil pointers: %#v", bi.args)
+	}
+	name, count, due := "bob", int64(0), time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	bi, err = table.bindInsert(reflect.ValueOf(&OptionalFields{Name: &name, Qty: &count, Due: &due}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"bob", int64(0), due}; !reflect.DeepEqual(bi.args, want) {
+		t.Errorf("non-nil pointers: %#v", bi.args)
+	}
+	if v := bindValue(&Person{}); !reflect.DeepEqual(v, Person{}) {
+		t.Errorf("bindValue(&Person{}) = %#v", v)
+	}
+	if v := bindValue((*sql.NullString)(nil)); v != (*sql.NullString)(nil) {
+		t.Errorf("bindValue of a driver.Valuer = %#v", v)
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(OptionalFields{}, "optional_fields_test").SetKeys(true, "Id")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	empty := &OptionalFields{}
+	set := &OptionalFields{Name: &name, Qty: &count, Due: &due}
+	_insert(dbmap, empty, set)
+	if n := selectInt(dbmap, "select count(*) from optional_fields_test where Name is null and Qty is null and Due is null"); n != 1 {
+		t.Errorf("%d rows with NULL columns", n)
+	}
+	obj := _get(dbmap, OptionalFields{}, set.Id).(*OptionalFields)
+	if obj.Name == nil || *obj.Name != name || obj.Qty == nil || *obj.Qty != 0 || obj.Due == nil || !obj.Due.Equal(due) {
+		t.Errorf("Get = %+v", obj)
+	}
+	obj.Name = nil
+	_update(dbmap, obj)
+	if obj = _get(dbmap, OptionalFields{}, set.Id).(*OptionalFields); obj.Name != nil {
+		t.Errorf("Name = %q after update with nil", *obj.Name)
+	}
+}
+
+func TestDbDefault(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(WithDbDefault{}, "db_default_test")
+	want := `create table "db_default_test" ("id" bigserial not null primary key , "name" varchar(50), "created" timestamp with time zone default CURRENT_TIMESTAMP) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+
+	bi, err := table.bindInsert(reflect.ValueOf(&WithDbDefault{Name: "a"}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `insert into "db_default_test" ("id","name") values (default,$1) returning "id";`
+	if bi.query != want || !reflect.DeepEqual(bi.readFields, []string{"Created"}) {
+		t.Errorf("zero time: %s %v", bi.query, bi.readFields)
+	}
+	bi, err = table.bindInsert(reflect.ValueOf(&WithDbDefault{Name: "a", Created: time.Now()}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `insert into "db_default_test" ("id","name","created") values (default,$1,$2) returning "id";`
+	if bi.query != want || len(bi.readFields) != 0 {
+		t.Errorf("time set: %s %v", bi.query, bi.readFields)
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("database defaults are only tested with postgres")
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(WithDbDefault{}, "db_default_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	before := time.Now().Add(-time.Minute)
+	row := &WithDbDefault{Name: "filled"}
+	_insert(dbmap, row)
+	if row.Created.Before(before) {
+		t.Errorf("Created not read back: %v", row.Created)
+	}
+	obj := _get(dbmap, WithDbDefault{}, row.Id).(*WithDbDefault)
+	if !obj.Created.Equal(row.Created) {
+		t.Errorf("%v != %v", obj.Created, row.Created)
+	}
+
+	created := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	row = &WithDbDefault{Name: "given", Created: created}
+	_insert(dbmap, row)
+	obj = _get(dbmap, WithDbDefault{}, row.Id).(*WithDbDefault)
+	if !obj.Created.Equal(created) {
+		t.Errorf("%v != %v", obj.Created, created)
+	}
+}
+
+func TestInsertReturningSQL(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+		returns []string
+		reads   []string
+	}{
+		{PostgresDialect{}, `insert into "computed_test" ("id","name") values (default,$1) returning "id", "created";`, []string{"Id", "Created"}, nil},
+		{SqlServerDialect{}, `insert into [computed_test] ([Name]) output inserted.[Id], inserted.[Created] values (?);`, []string{"Id", "Created"}, nil},
+		{OracleDialect{}, `insert into "COMPUTED_TEST" ("ID","NAME") values (default,:1) returning "ID", "CREATED"`, []string{"Id", "Created"}, nil},
+		{MySQLDialect{"InnoDB", "UTF8"}, "insert into `computed_test` (`Id`,`Name`) values (null,?);", nil, []string{"Created"}},
+		{SqliteDialect{}, `insert into "computed_test" ("Id","Name") values (null,?);`, nil, []string{"Created"}},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		table := dbmap.AddTableWithName(WithComputed{}, "computed_test")
+		bi, err := table.bindInsertReturning(reflect.ValueOf(&WithComputed{Name: "a"}).Elem(), []string{"Created"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bi.query != test.query || !reflect.DeepEqual(bi.returnFields, test.returns) || !reflect.DeepEqual(bi.readFields, test.reads) {
+			t.Errorf("%T: got %s %v %v", test.dialect, bi.query, bi.returnFields, bi.readFields)
+		}
+	}
+}
+
+func TestInsertReturning(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithComputed{}, "computed_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	before := time.Now().Add(-time.Hour)
+	row := &WithComputed{Name: "a"}
+	err = dbmap.InsertReturning(row, "Created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Id == 0 || row.Created.Before(before) {
+		t.Errorf("columns not returned: %v", row)
+	}
+	obj := _get(dbmap, WithComputed{}, row.Id).(*WithComputed)
+	if obj.Name != "a" || !obj.Created.Equal(row.Created) {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	err = dbmap.InsertReturning(&WithComputed{Name: "b"}, "NoSuchColumn")
+	if err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+}
+
+func TestApplyPragmas(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	if err := dbmap.ApplyPragmas(map[string]string{"foreign_keys": "on"}); err == nil {
+		t.Errorf("expected error for postgres")
+	}
+	dbmap = &DbMap{Dialect: SqliteDialect{}}
+	for key, value := range map[string]string{"foreign_keys": "on; drop table x", "a b": "1", "cache_size": ""} {
+		if err := dbmap.ApplyPragmas(map[string]string{key: value}); err == nil {
+			t.Errorf("expected error for pragma %q = %q", key, value)
+		}
+	}
+
+	if _, ok := dialectFromEnv().(SqliteDialect); !ok {
+		t.Skip("pragmas are only tested with sqlite")
+	}
+	dbmap = newDbMap()
+	defer dbmap.Db.Close()
+	dbmap.Db.SetMaxOpenConns(1)
+	err := dbmap.ApplyPragmas(map[string]string{"foreign_keys": "on", "cache_size": "-4000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := selectInt(dbmap, "pragma foreign_keys"); n != 1 {
+		t.Errorf("foreign_keys = %d", n)
+	}
+
+	// the foreign key of fk_test is enforced now
+	dbmap.AddTableWithName(Invoice{}, "parent").SetKeys(true, "Id").ColMap("Id").Rename("id")
+	// there is no owner table
+	dbmap.AddTableWithName(WithForeignKey{}, "fk_test").ColMap("OwnerId").SetReferences("", "", "")
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dbmap.DropTablesIfExists()
+	if err = dbmap.Insert(&WithForeignKey{Id: 1, ParentId: 42}); err == nil {
+		t.Errorf("expected foreign key error")
+	}
+}
+
+func TestDDLTransaction(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+	dbmap.AddTableWithName(Invoice{}, "ddl_a_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(Person{}, "ddl_b_test").SetKeys(true, "Id").ColMap("FName").DbType = "varchar("
+	exists := func() bool {
+		_, err := dbmap.Exec("select count(*) from ddl_a_test")
+		return err == nil
+	}
+
+	_, transactional := dbmap.Dialect.(TransactionalDDLDialect)
+	for _, wrap := range []bool{true, false} {
+		if wrap && !transactional {
+			continue
+		}
+		dbmap.SetDDLTransaction(wrap)
+		if err := dbmap.CreateTables(); err == nil {
+			t.Errorf("expected error for invalid column type")
+		}
+		if exists() == wrap {
+			t.Errorf("DDL transaction %v: table ddl_a_test exists: %v", wrap, !wrap)
+		}
+		_rawexec(dbmap, "drop table if exists ddl_a_test")
+	}
+}
+
+func TestDbMapClone(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}, DebugLevel: 1}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("orig:", log.New(logBuffer, "", 0))
+	dbmap.SetQueryTimeout(time.Second)
+	dbmap.AddScanConverter("rat", RatConverter{})
+	dbmap.LastOpInfo.RowCount = 3
+
+	clone := dbmap.Clone()
+	if clone.LastOpInfo.RowCount != 0 {
+		t.Errorf("LastOpInfo copied: %v", clone.LastOpInfo)
+	}
+	clone.TraceOff()
+	clone.SetQueryTimeout(0)
+	clone.AddScanConverter("rat", EpochConverter{})
+	clone.AddTableWithName(Person{}, "person_test")
+	clone.DebugLevel = 4
+
+	if dbmap.logger == nil || dbmap.queryTimeout != time.Second || dbmap.DebugLevel != 1 {
+		t.Errorf("configuration of the original changed")
+	}
+	if conv, _ := dbmap.scanConverter("rat"); conv != (RatConverter{}) {
+		t.Errorf("scan converter of the original changed: %v", conv)
+	}
+	if len(dbmap.tables) != 1 || len(clone.tables) != 2 {
+		t.Errorf("tables %d and %d", len(dbmap.tables), len(clone.tables))
+	}
+	if dbmap.tables[0] != clone.tables[0] {
+		t.Errorf("TableMaps are not shared")
+	}
+
+	// tables added to the original are not seen by the clone
+	dbmap.AddTableWithName(WithBits{}, "bits_test")
+	if _, err := clone.TableFor(reflect.TypeOf(WithBits{}), false); err == nil {
+		t.Errorf("table added to the original is seen by the clone")
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	tenantA := dbmap.WithSchema("tenant_a")
+	tenantB := dbmap.WithSchema("tenant_b")
+
+	for _, test := range []struct {
+		dbmap *DbMap
+		table string
+	}{
+		{dbmap, `"invoice_test"`},
+		{tenantA, `tenant_a."invoice_test"`},
+		{tenantB, `tenant_b."invoice_test"`},
+	} {
+		query, _, err := test.dbmap.Query(Invoice{}).Where("Memo = ?", "x").SQL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(query, " from "+test.table+" where") {
+			t.Errorf("expected table %s: %s", test.table, query)
+		}
+		table, err := test.dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan := table.bindGet(); !strings.Contains(plan.query, " from "+test.table+" where") {
+			t.Errorf("expected table %s: %s", test.table, plan.query)
+		}
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("schemas are only tested with postgres")
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	defer dbmap.Db.Close()
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		_rawexec(dbmap, "create schema if not exists "+schema)
+		defer _rawexec(dbmap, "drop schema "+schema+" cascade")
+		tenant := dbmap.WithSchema(schema)
+		err := tenant.CreateTablesIfNotExists()
+		if err != nil {
+			panic(err)
+		}
+		_insert(tenant, &Invoice{Memo: "invoice of " + schema})
+	}
+
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		var list []*Invoice
+		_, err := dbmap.WithSchema(schema).Query(Invoice{}).Select(&list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Memo != "invoice of "+schema {
+			t.Errorf("%s: unexpected rows %v", schema, list)
+		}
+	}
+}
+
+func TestPartitionBy(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(IdCreated{}, "partition_test").SetKeys(false, "Id", "Created").SetPartitionBy("created")
+	want := `create table "partition_test" ("id" bigint not null, "created" bigint not null, primary key ("id", "created")) partition by range (created) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	want = `create table "partition_test_1" partition of "partition_test" for values from (0) to (1000);`
+	if got := table.SqlForCreatePartition("partition_test_1", "0", "1000"); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+
+	if err := table.partitionError(); err != nil {
+		t.Errorf("partitionError = %v", err)
+	}
+	if err := table.SetPartitionBy("date_trunc('day', created), id").partitionError(); err != nil {
+		t.Errorf("partitionError = %v", err)
+	}
+	if err := table.SetPartitionBy("missing").partitionError(); err == nil {
+		t.Errorf("expected error for partitioning by a missing column")
+	}
+	mysql := &DbMap{Dialect: MySQLDialect{"InnoDB", "UTF8"}}
+	if err := mysql.AddTableWithName(IdCreated{}, "partition_test").SetPartitionBy("created").partitionError(); err == nil {
+		t.Errorf("expected error for SetPartitionBy on mysql")
+	}
+	if err := mysql.CreatePartition(IdCreated{}, "partition_test_1", "0", "1000"); err == nil {
+		t.Errorf("expected error for CreatePartition on mysql")
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("partitions are only tested with postgres")
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(IdCreated{}, "partition_test").SetKeys(false, "Id", "Created").SetPartitionBy("created")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	err = dbmap.CreatePartition(IdCreated{}, "partition_test_1", "0", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbmap.CreatePartition(IdCreated{}, "partition_test_2", "1000", "maxvalue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_insert(dbmap, &IdCreated{1, 10}, &IdCreated{2, 2000})
+	if count := selectInt(dbmap, "select count(*) from partition_test_2"); count != 1 {
+		t.Errorf("expected 1 row in partition_test_2, got %d", count)
+	}
+}
+
+func TestIndexInclude(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{PostgresDialect{}, `create unique index "ix_include_test_idx_email" on "include_test" ("email") include ("name", "age")`},
+		{SqlServerDialect{}, `create unique index [idx_email] on [include_test] ([Email]) include ([Name], [Age])`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create unique index `idx_email` on `include_test` (`Email`)"},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithIncludeIndex{}, "include_test")
+		if len(table.Indexes) != 1 || !reflect.DeepEqual(table.Indexes[0].Include, []string{"Name", "Age"}) {
+			t.Fatalf("%T: unexpected indexes %v", tt.dialect, table.Indexes)
+		}
+		if got := table.SqlForCreateIndex(table.Indexes[0]); got != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.want)
+		}
+	}
+}
+
+func TestAddIndex(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `create unique index "idx_person_created" on "invoice_test" ("PersonId","Created")`},
+		{PostgresDialect{}, `create unique index "ix_invoice_test_idx_person_created" on "invoice_test" ("personid","created")`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id").
+			AddIndex("idx_person_created", true, "PersonId", "created")
+		if got := table.SqlForCreateIndex(table.Indexes[0]); got != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.want)
+		}
+		for _, fields := range [][]string{{"Memo"}, {}, {"NoSuchField"}} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%T: expected panic for %v", tt.dialect, fields)
+					}
+				}()
+				table.AddIndex("idx_person_created", false, fields...)
+			}()
+		}
+	}
+
+	dbmap := newDbMap()
+	table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id").
+		AddIndex("idx_person_created", false, "PersonId", "Created")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	if err = dbmap.CreateIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	exists, matches, err := dbmap.checkIfIndexMatches(table, table.Indexes[0])
+	if err != nil || !exists || !matches {
+		t.Errorf("index exists %t, matches %t, %v", exists, matches, err)
+	}
+}
+
+func TestConcurrentIndex(t *testing.T) {
+	tests := []struct {
+		dialect     Dialect
+		want        string
+		ifNotExists string
+	}{
+		{PostgresDialect{},
+			`create index concurrently "ix_concurrent_test_idx_email" on "concurrent_test" ("email")`,
+			`create index concurrently if not exists "ix_concurrent_test_idx_email" on "concurrent_test" ("email")`},
+		{SqliteDialect{},
+			`create index "idx_email" on "concurrent_test" ("Email")`,
+			`create index if not exists "idx_email" on "concurrent_test" ("Email")`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithConcurrentIndex{}, "concurrent_test")
+		if len(table.Indexes) != 1 || !table.Indexes[0].Concurrently {
+			t.Fatalf("%T: unexpected indexes %v", tt.dialect, table.Indexes)
+		}
+		if got := table.SqlForCreateIndex(table.Indexes[0]); got != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.want)
+		}
+		if got := table.sqlForCreateIndex(table.Indexes[0], true); got != tt.ifNotExists {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.ifNotExists)
+		}
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("concurrent indexes are only tested with postgres")
+	}
+
+	dbmap := newDbMap()
+	dbmap.SetDDLTransaction(true)
+	table := dbmap.AddTableWithName(WithConcurrentIndex{}, "concurrent_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	// concurrently fails in a transaction, CreateIndexes must not use one
+	if err = dbmap.CreateIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	exists, matches, err := dbmap.checkIfIndexMatches(table, table.Indexes[0])
+	if err != nil || !exists || !matches {
+		t.Errorf("index exists %t, matches %t, %v", exists, matches, err)
+	}
+	if err = dbmap.CreateIndexesIfNotExists(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateIfNotExists(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		table   string
+		index   string
+	}{
+		{SqliteDialect{},
+			`create table if not exists "index_test"`,
+			`create index if not exists "idx_Email" on "index_test" ("Email")`},
+		{PostgresDialect{},
+			`create table if not exists "index_test"`,
+			`create index if not exists "ix_index_test_idx_email" on "index_test" ("email")`},
+		{SqlServerDialect{},
+			`if object_id('[index_test]') is null create table [index_test]`,
+			`if indexproperty(object_id('[index_test]'), 'idx_Email', 'IndexID') is null create index [idx_Email] on [index_test] ([Email])`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithIndex{}, "index_test")
+		if got := table.SqlForCreate(true); !strings.HasPrefix(got, tt.table+" (") {
+			t.Errorf("%T:\n got: %s\nwant: %s (...", tt.dialect, got, tt.table)
+		}
+		if got := table.sqlForCreateIndex(table.Indexes[0], true); got != tt.index {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.index)
+		}
+	}
+	want := `select column_name as ColumnName from all_ind_columns where index_name = 'IDX_EMAIL' and table_name = 'INDEX_TEST' and table_owner = 'S1' order by column_position`
+	if got := (OracleDialect{}).IfIndexExists("index_test", "idx_Email", "s1"); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithIndex{}, "index_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	// existing tables and indexes are left alone
+	for i := 0; i < 2; i++ {
+		if err = dbmap.CreateTablesIfNotExists(); err != nil {
+			t.Fatalf("CreateTablesIfNotExists #%d: %v", i+1, err)
+		}
+		if err = dbmap.CreateIndexesIfNotExists(); err != nil {
+			t.Fatalf("CreateIndexesIfNotExists #%d: %v", i+1, err)
+		}
+	}
+}
+
+func TestIndexNames(t *testing.T) {
+	tests := []struct {
+		dialect    Dialect
+		create     string
+		drop       string
+		dropSchema string
+	}{
+		{SqliteDialect{},
+			`create index "idx_Email" on "index_test" ("Email")`,
+			`drop index "idx_Email"`,
+			`drop index "idx_Email"`},
+		{PostgresDialect{},
+			`create index "ix_index_test_idx_email" on "index_test" ("email")`,
+			`drop index "ix_index_test_idx_email"`,
+			`drop index "s1"."ix_index_test_idx_email"`},
+		{MySQLDialect{"InnoDB", "UTF8"},
+			"create index `idx_Email` on `index_test` (`Email`)",
+			"drop index `idx_Email` on `index_test`",
+			"drop index `idx_Email` on s1.`index_test`"},
+		{SqlServerDialect{},
+			`create index [idx_Email] on [index_test] ([Email])`,
+			`drop index [idx_Email] on [index_test]`,
+			`drop index [idx_Email] on [s1].[index_test]`},
+		{OracleDialect{},
+			`create index "IDX_EMAIL" on "INDEX_TEST" ("EMAIL")`,
+			`drop index "IDX_EMAIL"`,
+			`drop index "S1"."IDX_EMAIL"`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithIndex{}, "index_test")
+		if got := table.SqlForCreateIndex(table.Indexes[0]); got != tt.create {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.create)
+		}
+		if got := tt.dialect.DropIndex(table, "idx_Email"); got != tt.drop {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.drop)
+		}
+		table.SchemaName = "s1"
+		if got := tt.dialect.DropIndex(table, "idx_Email"); got != tt.dropSchema {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, got, tt.dropSchema)
+		}
+	}
+
+	dbmap := newDbMap()
+	table := dbmap.AddTableWithName(WithIndex{}, "index_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	// the index can be dropped by the name it was created with, and is
+	// gone afterwards so it can be created again
+	for i := 0; i < 2; i++ {
+		if _, err = dbmap.Exec(table.SqlForCreateIndex(table.Indexes[0])); err != nil {
+			t.Fatal(err)
+		}
+		if err = dbmap.DropIndex(table, "idx_Email"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInsertIntoView(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(InvoiceView{}, "invoice_view_test").SetKeys(true, "Id").SetIsView(true)
+	bi, err := table.bindInsert(reflect.ValueOf(&InvoiceView{}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `insert into "invoice_view_test" ("created","updated","memo","personid","ispaid") values ($1,$2,$3,$4,$5);`
+	if bi.query != want || bi.autoIncrIdx != -1 {
+		t.Errorf("\n got: %s (%d)\nwant: %s", bi.query, bi.autoIncrIdx, want)
+	}
+
+	dbmap = initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(InvoiceView{}, "invoice_view_test").SetKeys(true, "Id").SetIsView(true)
+	_rawexec(dbmap, "create view invoice_view_test as select * from invoice_test")
+	defer dbmap.Exec("drop view invoice_view_test")
+	if _, ok := dbmap.Dialect.(SqliteDialect); ok {
+		_rawexec(dbmap, "create trigger invoice_view_insert instead of insert on invoice_view_test begin "+
+			"insert into invoice_test (Created, Updated, Memo, PersonId, IsPaid) values (new.Created, new.Updated, new.Memo, new.PersonId, new.IsPaid); end")
+	}
+	// CreateTables must not try to create the view
+	err = dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv := &InvoiceView{0, 100, 200, "view", 0, true}
+	_insert(dbmap, iv)
+	if iv.Id != 0 {
+		t.Errorf("expected no id read back from view, got %d", iv.Id)
+	}
+
+	var list []*InvoiceView
+	_rawselect(dbmap, &list, "select * from invoice_view_test where Memo = 'view'")
+	if len(list) != 1 {
+		t.Fatalf("expected 1 row in view, got %d", len(list))
+	}
+	obj := _get(dbmap, InvoiceView{}, list[0].Id)
+	if !reflect.DeepEqual(list[0], obj) {
+		t.Errorf("%v != %v", list[0], obj)
+	}
+}
+
+func TestInsertWithSequence(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(WithSequence{}, "sequence_test")
+	bi, err := table.bindInsert(reflect.ValueOf(&WithSequence{}).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `insert into "sequence_test" ("id","name") values (nextval('"gorp_test_seq"'),$1) returning "id";`
+	if bi.query != want {
+		t.Errorf("\n got: %s\nwant: %s", bi.query, want)
+	}
+	for _, tt := range []struct {
+		dialect SequenceDialect
+		want    string
+	}{
+		{PostgresDialect{}, `nextval('app."it''s"')`},
+		{OracleDialect{}, `app."IT'S".nextval`},
+	} {
+		if got := tt.dialect.NextValSql("app.it's"); got != tt.want {
+			t.Errorf("%T.NextValSql = %s, want %s", tt.dialect, got, tt.want)
+		}
+	}
+
+	dbmap = &DbMap{Dialect: MySQLDialect{"InnoDB", "UTF8"}}
+	table = dbmap.AddTableWithName(WithSequence{}, "sequence_test")
+	_, err = table.bindInsert(reflect.ValueOf(&WithSequence{}).Elem())
+	if err == nil {
+		t.Errorf("expected error for sequence on a dialect without sequences")
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("sequences are only tested with postgres")
+	}
+
+	dbmap = newDbMap()
+	defer dbmap.Db.Close()
+	_rawexec(dbmap, "create sequence if not exists gorp_test_seq start 1000")
+	defer dbmap.Exec("drop sequence if exists gorp_test_seq")
+	dbmap.AddTableWithName(WithSequence{}, "sequence_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dbmap.DropTablesIfExists()
+
+	ws1 := &WithSequence{Name: "first"}
+	ws2 := &WithSequence{Name: "second"}
+	_insert(dbmap, ws1, ws2)
+	if ws1.Id < 1000 || ws2.Id != ws1.Id+1 {
+		t.Errorf("unexpected sequence values %d, %d", ws1.Id, ws2.Id)
+	}
+	obj := _get(dbmap, WithSequence{}, ws2.Id)
+	if !reflect.DeepEqual(ws2, obj) {
+		t.Errorf("%v != %v", ws2, obj)
+	}
+}
+
+func TestUpdateBatchSQL(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(Person{}, "person_test").SetKeys(true, "Id")
+
+	p1 := &Person{1, 0, 0, "bob", "smith", 0}
+	p2 := &Person{2, 0, 0, "jane", "doe", 0}
+	bi, err := table.bindUpdateBatch([]reflect.Value{reflect.ValueOf(p1).Elem(), reflect.ValueOf(p2).Elem()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args := bi.query, bi.args
+	want := `update "person_test" set ` +
+		`"created" = case when "id" = $1 then $2 when "id" = $3 then $4 else "created" end, ` +
+		`"updated" = case when "id" = $5 then $6 when "id" = $7 then $8 else "updated" end, ` +
+		`"fname" = case when "id" = $9 then $10 when "id" = $11 then $12 else "fname" end, ` +
+		`"lname" = case when "id" = $13 then $14 when "id" = $15 then $16 else "lname" end, ` +
+		`"version" = case when "id" = $17 then $18 when "id" = $19 then $20 else "version" end ` +
+		`where "id" in ($21,$22);`
+	if query != want {
+		t.Errorf("\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 22 || args[8] != int64(1) || args[9] != "bob" || args[21] != int64(2) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestUpdateBatch(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 0, false}
+	inv2 := &Invoice{0, 100, 200, "b", 0, false}
+	inv3 := &Invoice{0, 100, 200, "c", 0, false}
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, inv1, inv2, inv3, p1)
+
+	inv1.Memo = "a2"
+	inv2.IsPaid = true
+	inv3.Updated = 300
+	p1.FName = "robert"
+	// p1 has a version column and is updated separately
+	count, err := dbmap.UpdateBatch(inv1, inv2, inv3, p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("update 4 != %d", count)
+	}
+
+	for _, inv := range []*Invoice{inv1, inv2, inv3} {
+		obj := _get(dbmap, Invoice{}, inv.Id)
+		if !reflect.DeepEqual(inv, obj) {
+			t.Errorf("%v != %v", inv, obj)
+		}
+	}
+	// Person.PreUpdate overwrites FName and PostUpdate overwrites LName
+	if p1.LName != "postupdate" {
+		t.Errorf("PostUpdate not run: %v", p1)
+	}
+	obj := _get(dbmap, Person{}, p1.Id).(*Person)
+	if obj.FName != "preupdate" || obj.Version != p1.Version {
+		t.Errorf("person not updated: %v", obj)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	if n := table.updateBatchParams(); n != 11 {
+		t.Errorf("updateBatchParams = %d", n)
+	}
+	for _, tt := range []struct {
+		dialect Dialect
+		perRow  int
+		want    int
+	}{
+		{SqliteDialect{}, 11, 90},
+		{PostgresDialect{}, 11, 5957},
+		{SqlServerDialect{}, 11, 190},
+		{SqliteDialect{}, 1000, 1},
+	} {
+		if n := batchSize(tt.dialect, tt.perRow); n != tt.want {
+			t.Errorf("%T batchSize(%d) = %d, want %d", tt.dialect, tt.perRow, n, tt.want)
+		}
+	}
+}
+
+func TestUpdateBatchChunked(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	// 11 bind params per row exceed sqlite's limit of 999 with 200 rows
+	var list []interface{}
+	for i := 0; i < 200; i++ {
+		list = append(list, &Invoice{0, 100, 200, fmt.Sprintf("m%d", i), 0, false})
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	err = trans.Insert(list...)
+	if err != nil {
+		panic(err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, inv := range list {
+		inv.(*Invoice).Updated = 300
+	}
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "", 0))
+	count, err := dbmap.UpdateBatch(list...)
+	dbmap.TraceOff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 200 {
+		t.Errorf("update 200 != %d", count)
+	}
+	size := batchSize(dbmap.Dialect, 11)
+	want := (200 + size - 1) / size
+	if n := strings.Count(logBuffer.String(), "update "); n != want {
+		t.Errorf("%d statements != %d", n, want)
+	}
+	if n := selectInt(dbmap, "select count(*) from invoice_test where Updated = 300"); n != 200 {
+		t.Errorf("updated rows %d", n)
+	}
+}
+
+func TestMultiple(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 0, false}
+	inv2 := &Invoice{0, 100, 200, "b", 0, true}
+	_insert(dbmap, inv1, inv2)
+
+	inv1.Memo = "c"
+	inv2.Memo = "d"
+	_update(dbmap, inv1, inv2)
+
+	count := _del(dbmap, inv1, inv2)
+	if count != 2 {
+		t.Errorf("%d != 2", count)
+	}
+}
+
+func TestCrud(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv := &Invoice{0, 100, 200, "first order", 0, true}
+	testCrudInternal(t, dbmap, inv)
+
+	invtag := &InvoiceTag{0, 300, 400, "some order", 33, false}
+	testCrudInternal(t, dbmap, invtag)
+
+	foo := &AliasTransientField{BarStr: "some bar"}
+	testCrudInternal(t, dbmap, foo)
+}
+
+func testCrudInternal(t *testing.T, dbmap *DbMap, val testable) {
+	table, _, err := dbmap.tableForPointer(val, false)
+	if err != nil {
+		t.Errorf("couldn't call TableFor: val=%v err=%v", val, err)
+	}
+
+	_, err = dbmap.Exec("delete from " + table.TableName)
+	if err != nil {
+		t.Errorf("couldn't delete rows from: val=%v err=%v", val, err)
+	}
+
+	// INSERT row
+	_insert(dbmap, val)
+	if val.GetId() == 0 {
+		t.Errorf("val.GetId() was not set on INSERT")
+		return
+	}
+
+	// SELECT row
+	val2 := _get(dbmap, val, val.GetId())
+	if !reflect.DeepEqual(val, val2) {
+		t.Errorf("%v != %v", val, val2)
+	}
+
+	// UPDATE row and SELECT
+	val.Rand()
+	count := _update(dbmap, val)
+	if count != 1 {
+		t.Errorf("update 1 != %d", count)
+	}
+	val2 = _get(dbmap, val, val.GetId())
+	if !reflect.DeepEqual(val, val2) {
+		t.Errorf("%v != %v", val, val2)
+	}
+
+	// Select *
+	rows, err := dbmap.Select(val, "select * from "+table.TableName)
+	if err != nil {
+		t.Errorf("couldn't select * from %s err=%v", table.TableName, err)
+	} else if len(rows) != 1 {
+		t.Errorf("unexpected row count in %s: %d", table.TableName, len(rows))
+	} else if !reflect.DeepEqual(val, rows[0]) {
+		t.Errorf("select * result: %v != %v", val, rows[0])
+	}
+
+	// DELETE row
+	deleted := _del(dbmap, val)
+	if deleted != 1 {
+		t.Errorf("Did not delete row with Id: %d", val.GetId())
+		return
+	}
+
+	// VERIFY deleted
+	val2 = _get(dbmap, val, val.GetId())
+	if val2 != nil {
+		t.Errorf("Found invoice with id: %d after Delete()", val.GetId())
+	}
+}
+
+func TestWithIgnoredColumn(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	ic := &WithIgnoredColumn{-1, 0, 1}
+	_insert(dbmap, ic)
+	expected := &WithIgnoredColumn{0, 1, 1}
+	ic2 := _get(dbmap, WithIgnoredColumn{}, ic.Id).(*WithIgnoredColumn)
+
+	if !reflect.DeepEqual(expected, ic2) {
+		t.Errorf("%v != %v", expected, ic2)
+	}
+	if _del(dbmap, ic) != 1 {
+		t.Errorf("Did not delete row with Id: %d", ic.Id)
+		return
+	}
+	if _get(dbmap, WithIgnoredColumn{}, ic.Id) != nil {
+		t.Errorf("Found id: %d after Delete()", ic.Id)
+	}
+}
+
+func TestWithUnexportedField(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "", 0))
+	table := dbmap.AddTableWithName(WithUnexportedField{}, "unexported_field_test").SetKeys(true, "Id")
+	for _, col := range table.Columns {
+		if col.fieldName == "secret" && !col.Transient {
+			t.Errorf("unexported field secret was mapped to column %s", col.ColumnName)
+		}
+	}
+	if !strings.Contains(logBuffer.String(), "skipping unexported field WithUnexportedField.secret") {
+		t.Errorf("skipped field was not logged to the trace logger: %q", logBuffer.String())
+	}
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dbmap.DropTablesIfExists()
+
+	wuf := &WithUnexportedField{0, "not stored", 1}
+	_insert(dbmap, wuf)
+
+	var list []*WithUnexportedField
+	_, err = dbmap.Select(&list, "select * from unexported_field_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Created != 1 || list[0].secret != "" {
+		t.Errorf("unexpected select result: %v", list)
+	}
+}
+
+func TestWithTaggedUnexportedFieldPanics(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("AddTable did not panic on tagged unexported field")
+		}
+		if !strings.Contains(fmt.Sprint(r), "WithTaggedUnexportedField.secret") {
+			t.Errorf("panic does not name the offending field: %v", r)
+		}
+	}()
+	dbmap.AddTable(WithTaggedUnexportedField{})
+}
+
+func TestTypeConversionExample(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p := Person{FName: "Bob", LName: "Smith"}
+	tc := &TypeConversionExample{-1, p, CustomStringType("hi")}
+	_insert(dbmap, tc)
+
+	expected := &TypeConversionExample{1, p, CustomStringType("hi")}
+	tc2 := _get(dbmap, TypeConversionExample{}, tc.Id).(*TypeConversionExample)
+	if !reflect.DeepEqual(expected, tc2) {
+		t.Errorf("tc2 %v != %v", expected, tc2)
+	}
+
+	tc2.Name = CustomStringType("hi2")
+	tc2.PersonJSON = Person{FName: "Jane", LName: "Doe"}
+	_update(dbmap, tc2)
+
+	expected = &TypeConversionExample{1, tc2.PersonJSON, CustomStringType("hi2")}
+	tc3 := _get(dbmap, TypeConversionExample{}, tc.Id).(*TypeConversionExample)
+	if !reflect.DeepEqual(expected, tc3) {
+		t.Errorf("tc3 %v != %v", expected, tc3)
+	}
+
+	if _del(dbmap, tc) != 1 {
+		t.Errorf("Did not delete row with Id: %d", tc.Id)
+	}
+
+}
+
+
+func TestTypeConversionPointer(t *testing.T) {
+	conv := testTypeConverter{}
+	name := CustomStringType("hi")
+	val, err := convertToDb(conv, &name)
+	if err != nil || val != "hi" {
+		t.Errorf("convertToDb(&name) = %v, %v", val, err)
+	}
+	val, err = convertToDb(conv, (*CustomStringType)(nil))
+	if err != nil || val != nil {
+		t.Errorf("convertToDb(nil) = %v, %v", val, err)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(TypeConversionPtrExample{}, "type_conv_ptr_test").SetKeys(true, "Id")
+	err = dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+
+	tc1 := &TypeConversionPtrExample{Name: &name}
+	tc2 := &TypeConversionPtrExample{}
+	_insert(dbmap, tc1, tc2)
+
+	obj := _get(dbmap, TypeConversionPtrExample{}, tc1.Id).(*TypeConversionPtrExample)
+	if obj.Name == nil || *obj.Name != "hi" {
+		t.Errorf("expected name hi, got %v", obj.Name)
+	}
+	obj = _get(dbmap, TypeConversionPtrExample{}, tc2.Id).(*TypeConversionPtrExample)
+	if obj.Name != nil {
+		t.Errorf("expected nil name, got %q", *obj.Name)
+	}
+
+	var list []*TypeConversionPtrExample
+	_rawselect(dbmap, &list, "select * from type_conv_ptr_test order by Id")
+	if len(list) != 2 || list[0].Name == nil || *list[0].Name != "hi" || list[1].Name != nil {
+		t.Errorf("unexpected select result %v", list)
+	}
+}
+func TestWithEmbeddedStruct(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	es := &WithEmbeddedStruct{-1, Names{FirstName: "Alice", LastName: "Smith"}}
+	_insert(dbmap, es)
+	expected := &WithEmbeddedStruct{1, Names{FirstName: "Alice", LastName: "Smith"}}
+	es2 := _get(dbmap, WithEmbeddedStruct{}, es.Id).(*WithEmbeddedStruct)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	es2.FirstName = "Bob"
+	expected.FirstName = "Bob"
+	_update(dbmap, es2)
+	es2 = _get(dbmap, WithEmbeddedStruct{}, es.Id).(*WithEmbeddedStruct)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	ess := _rawselect(dbmap, WithEmbeddedStruct{}, "select * from embedded_struct_test")
+	if !reflect.DeepEqual(es2, ess[0]) {
+		t.Errorf("%v != %v", es2, ess[0])
+	}
+}
+
+/*
+func TestWithEmbeddedStructConflictingEmbeddedMemberNames(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	es := &WithEmbeddedStructConflictingEmbeddedMemberNames{-1, Names{FirstName: "Alice", LastName: "Smith"}, NamesConflict{FirstName: "Andrew", Surname: "Wiggin"}}
+	_insert(dbmap, es)
+	expected := &WithEmbeddedStructConflictingEmbeddedMemberNames{-1, Names{FirstName: "Alice", LastName: "Smith"}, NamesConflict{FirstName: "Andrew", Surname: "Wiggin"}}
+	es2 := _get(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, es.Id).(*WithEmbeddedStructConflictingEmbeddedMemberNames)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	es2.Names.FirstName = "Bob"
+	expected.Names.FirstName = "Bob"
+	_update(dbmap, es2)
+	es2 = _get(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, es.Id).(*WithEmbeddedStructConflictingEmbeddedMemberNames)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	ess := _rawselect(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, "select * from embedded_struct_conflict_name_test")
+	if !reflect.DeepEqual(es2, ess[0]) {
+		t.Errorf("%v != %v", es2, ess[0])
+	}
+}
+
+func TestWithEmbeddedStructSameMemberName(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	es := &WithEmbeddedStructSameMemberName{-1, SameName{SameName: "Alice"}}
+	_insert(dbmap, es)
+	expected := &WithEmbeddedStructSameMemberName{-1, SameName{SameName: "Alice"}}
+	es2 := _get(dbmap, WithEmbeddedStructSameMemberName{}, es.Id).(*WithEmbeddedStructSameMemberName)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	es2.SameName = SameName{"Bob"}
+	expected.SameName = SameName{"Bob"}
+	_update(dbmap, es2)
+	es2 = _get(dbmap, WithEmbeddedStructSameMemberName{}, es.Id).(*WithEmbeddedStructSameMemberName)
+	if !reflect.DeepEqual(expected, es2) {
+		t.Errorf("%v != %v", expected, es2)
+	}
+
+	ess := _rawselect(dbmap, WithEmbeddedStructSameMemberName{}, "select * from embedded_struct_same_member_name_test")
+	if !reflect.DeepEqual(es2, ess[0]) {
+		t.Errorf("%v != %v", es2, ess[0])
+	}
+}
+//*/
+
+func TestWithEmbeddedStructBeforeAutoincr(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `insert into "embedded_struct_before_autoincr_test" ("FirstName","LastName","Id") values (?,?,null);`},
+		{PostgresDialect{}, `insert into "embedded_struct_before_autoincr_test" ("firstname","lastname","id") values ($1,$2,default) returning "id";`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "insert into `embedded_struct_before_autoincr_test` (`FirstName`,`LastName`,`Id`) values (?,?,null);"},
+	}
+	for _, tt := range tests {
+		m := &DbMap{Dialect: tt.dialect}
+		table := m.AddTableWithName(WithEmbeddedStructBeforeAutoincrField{}, "embedded_struct_before_autoincr_test").SetKeys(true, "Id")
+		elem := reflect.ValueOf(&WithEmbeddedStructBeforeAutoincrField{Names: Names{"Alice", "Smith"}}).Elem()
+		bi, err := table.bindInsert(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bi.query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, bi.query, tt.want)
+		}
+		if bi.autoIncrFieldName != "Id" || table.Columns[bi.autoIncrIdx].ColumnName != table.ColMap("Id").ColumnName {
+			t.Errorf("%T: autoincrement field %s at %d", tt.dialect, bi.autoIncrFieldName, bi.autoIncrIdx)
+		}
+		if !reflect.DeepEqual(bi.args, []interface{}{"Alice", "Smith"}) {
+			t.Errorf("%T: unexpected args %v", tt.dialect, bi.args)
+		}
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	esba := &WithEmbeddedStructBeforeAutoincrField{Names: Names{FirstName: "Alice", LastName: "Smith"}}
+	_insert(dbmap, esba)
+	var expectedAutoincrId int64 = 1
+	if esba.Id != expectedAutoincrId {
+		t.Errorf("%d != %d", expectedAutoincrId, esba.Id)
+	}
+}
+
+func TestWithEmbeddedAutoincr(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	esa := &WithEmbeddedAutoincr{
+		WithEmbeddedStruct: WithEmbeddedStruct{Names: Names{FirstName: "Alice", LastName: "Smith"}},
+		MiddleName:         "Rose",
+	}
+	_insert(dbmap, esa)
+	var expectedAutoincrId int64 = 1
+	if esa.Id != expectedAutoincrId {
+		t.Errorf("%d != %d", expectedAutoincrId, esa.Id)
+	}
+}
+
+func TestSelectVal(t *testing.T) {
+	dbmap := initDbMapNulls()
+	defer dropAndClose(dbmap)
+
+	bindVar := dbmap.Dialect.BindVar(0)
+
+	t1 := TableWithNull{Str: sql.NullString{"abc", true},
+		Int64:   sql.NullInt64{78, true},
+		Float64: sql.NullFloat64{32.2, true},
+		Bool:    sql.NullBool{true, true},
+		Bytes:   []byte("hi")}
+	_insert(dbmap, &t1)
+
+	// SelectInt
+	i64 := selectInt(dbmap, "select Int64 from TableWithNull where Str='abc'")
+	if i64 != 78 {
+		t.Errorf("int64 %d != 78", i64)
+	}
+	i64 = selectInt(dbmap, "select count(*) from TableWithNull")
+	if i64 != 1 {
+		t.Errorf("int64 count %d != 1", i64)
+	}
+	i64 = selectInt(dbmap, "select count(*) from TableWithNull where Str="+bindVar, "asdfasdf")
+	if i64 != 0 {
+		t.Errorf("int64 no rows %d != 0", i64)
+	}
+
+	// SelectNullInt
+	n := selectNullInt(dbmap, "select Int64 from TableWithNull where Str='notfound'")
+	if !reflect.DeepEqual(n, sql.NullInt64{0, false}) {
+		t.Errorf("nullint %v != 0,false", n)
+	}
+
+	n = selectNullInt(dbmap, "select Int64 from TableWithNull where Str='abc'")
+	if !reflect.DeepEqual(n, sql.NullInt64{78, true}) {
+		t.Errorf("nullint %v != 78, true", n)
+	}
+
+	// SelectFloat
+	f64 := selectFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
+	if f64 != 32.2 {
+		t.Errorf("float64 %d != 32.2", f64)
+	}
+	f64 = selectFloat(dbmap, "select min(Float64) from TableWithNull")
+	if f64 != 32.2 {
+		t.Errorf("float64 min %d != 32.2", f64)
+	}
+	f64 = selectFloat(dbmap, "select count(*) from TableWithNull where Str="+bindVar, "asdfasdf")
+	if f64 != 0 {
+		t.Errorf("float64 no rows %d != 0", f64)
+	}
+
+	// SelectNullFloat
+	nf := selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='notfound'")
+	if !reflect.DeepEqual(nf, sql.NullFloat64{0, false}) {
+		t.Errorf("nullfloat %v != 0,false", nf)
+	}
+
+	nf = selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
+	if !reflect.DeepEqual(nf, sql.NullFloat64{32.2, true}) {
+		t.Errorf("nullfloat %v != 32.2, true", nf)
+	}
+
+	// SelectStr
+	s := selectStr(dbmap, "select Str from TableWithNull where Int64="+bindVar, 78)
+	if s != "abc" {
+		t.Errorf("s %s != abc", s)
+	}
+	s = selectStr(dbmap, "select Str from TableWithNull where Str='asdfasdf'")
+	if s != "" {
+		t.Errorf("s no rows %s != ''", s)
+	}
+
+	// SelectNullStr
+	ns := selectNullStr(dbmap, "select Str from TableWithNull where Int64="+bindVar, 78)
+	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
+		t.Errorf("nullstr %v != abc,true", ns)
+	}
+	ns = selectNullStr(dbmap, "select Str from TableWithNull where Str='asdfasdf'")
+	if !reflect.DeepEqual(ns, sql.NullString{"", false}) {
+		t.Errorf("nullstr no rows %v != '',false", ns)
+	}
+
+	// SelectInt/Str with named parameters
+	i64 = selectInt(dbmap, "select Int64 from TableWithNull where Str=:abc", map[string]string{"abc": "abc"})
+	if i64 != 78 {
+		t.Errorf("int64 %d != 78", i64)
+	}
+	ns = selectNullStr(dbmap, "select Str from TableWithNull where Int64=:num", map[string]int{"num": 78})
+	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
+		t.Errorf("nullstr %v != abc,true", ns)
+	}
+}
+
+func TestSelectRow(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	p2 := &Person{0, 0, 0, "jane", "doe", 0}
+	_insert(dbmap, p1, p2)
+
+	var min, max int64
+	err := dbmap.SelectRow("select min(Id), max(Id) from person_test").Scan(&min, &max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min != p1.Id || max != p2.Id {
+		t.Errorf("min, max = %d, %d, want %d, %d", min, max, p1.Id, p2.Id)
+	}
+
+	var fname, lname string
+	err = dbmap.SelectRow("select FName, LName from person_test where Id = :Id", map[string]interface{}{"Id": p2.Id}).Scan(&fname, &lname)
+	if err != nil || fname != "jane" || lname != "doe" {
+		t.Errorf("named query: %s %s %v", fname, lname, err)
+	}
+
+	err = dbmap.SelectRow("select FName, LName from person_test where Id = -1").Scan(&fname, &lname)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	dbmap.SetQueryTimeout(time.Minute)
+	row := dbmap.SelectRow("select min(Id), max(Id) from person_test").(*timeoutRow)
+	if err = row.Scan(&min, &max); err != nil {
+		t.Fatal(err)
+	}
+	if row.ctx.Err() != context.Canceled {
+		t.Errorf("Scan did not release the timeout: %v", row.ctx.Err())
+	}
+}
+
+func TestPrepareSelect(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	m.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	plan := &selectPlan{}
+	typ := reflect.TypeOf(Invoice{})
+	first, err := plan.columnToFieldIndex(m, typ, []string{"id", "memo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := plan.columnToFieldIndex(m, typ, []string{"id", "memo"})
+	if &again[0] != &first[0] {
+		t.Errorf("field indexes were not reused")
+	}
+	other, _ := plan.columnToFieldIndex(m, typ, []string{"memo", "id"})
+	if !reflect.DeepEqual(other, [][]int{first[1], first[0]}) {
+		t.Errorf("field indexes %v for other columns", other)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 1, false}
+	inv2 := &Invoice{0, 200, 200, "b", 2, false}
+	_insert(dbmap, inv1, inv2)
+
+	stmt := dbmap.PrepareSelect(Invoice{}, "select * from invoice_test where PersonId = :p")
+	for _, inv := range []*Invoice{inv1, inv2} {
+		list, err := stmt.Select(map[string]interface{}{"p": inv.PersonId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || !reflect.DeepEqual(list[0], inv) {
+			t.Errorf("%v != %v", list, inv)
+		}
+	}
+}
+
+func TestSelectStrLimit1(t *testing.T) {
+	for _, tt := range []struct {
+		d     Dialect
+		query string
+		want  string
+	}{
+		{PostgresDialect{}, "select memo from t order by memo;", "select * from (select memo from t order by memo) as gorp_limit1 limit 1"},
+		{SqlServerDialect{}, "select memo from t order by memo;", "select memo from t order by memo offset 0 rows fetch next 1 rows only"},
+		{SqlServerDialect{}, "select memo from (select memo from t order by memo offset 0 rows) x", "select memo from (select memo from t order by memo offset 0 rows) x order by (select null) offset 0 rows fetch next 1 rows only"},
+		{SqlServerDialect{}, "select [order by] from t", "select [order by] from t order by (select null) offset 0 rows fetch next 1 rows only"},
+		{OracleDialect{}, "select memo from t where memo <> 'order by'", "select memo from t where memo <> 'order by' offset 0 rows fetch next 1 rows only"},
+	} {
+		if got := limitOneQuery(tt.d, tt.query); got != tt.want {
+			t.Errorf("%T: %q != %q", tt.d, got, tt.want)
+		}
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "first", 0, false}
+	inv2 := &Invoice{0, 100, 200, "second", 0, false}
+	_insert(dbmap, inv1, inv2)
+
+	// Use a buffer to hold the log to check the generated query
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "gorptest:", log.Lmicroseconds))
+
+	memo, err := dbmap.SelectStrLimit1("select memo from invoice_test order by memo;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memo != "first" {
+		t.Errorf("%q != %q", memo, "first")
+	}
+	if !strings.Contains(logBuffer.String(), "limit 1") {
+		t.Errorf("Expected limit clause in query but didn't find it: %s", logBuffer.String())
+	}
+}
+
+func TestSelectCount(t *testing.T) {
+	for _, tt := range []struct {
+		d     Dialect
+		query string
+		want  string
+	}{
+		{PostgresDialect{}, "select * from t where a = $1;", "select count(*) from (select * from t where a = $1) gorp_count;"},
+		{MySQLDialect{}, "select * from t ; ; ", "select count(*) from (select * from t) gorp_count;"},
+		{OracleDialect{}, "select * from t where a = :1", "select count(*) from (select * from t where a = :1) gorp_count"},
+		{SqlServerDialect{}, "select * from t where a in (select b from u order by b offset 0 rows) order by a desc;", "select count(*) from (select * from t where a in (select b from u order by b offset 0 rows)) gorp_count;"},
+		{PostgresDialect{}, "select * from t order by a limit 10", "select count(*) from (select * from t order by a limit 10) gorp_count;"},
+	} {
+		if got := countQuery(tt.d, tt.query); got != tt.want {
+			t.Errorf("%T: %q != %q", tt.d, got, tt.want)
+		}
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	_insert(dbmap, &Invoice{0, 100, 200, "first", 0, false},
+		&Invoice{0, 100, 200, "second", 0, false},
+		&Invoice{0, 300, 200, "third", 0, false})
+
+	query := "select * from invoice_test where " + dbmap.Dialect.QuoteField("Created") +
+		" = " + dbmap.Dialect.BindVar(0) + dbmap.Dialect.QuerySuffix()
+	count, err := dbmap.SelectCount(query, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count %d != 2", count)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Rollback()
+	count, err = trans.SelectCount("select memo from invoice_test where memo <> :memo",
+		map[string]interface{}{"memo": "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count %d != 2", count)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	m := &DbMap{Dialect: SqliteDialect{}}
+	table := m.AddTableWithName(Person{}, "person_test").SetKeys(true, "Id")
+	table.SetVersionCol("Version")
+	if err := m.Track(Person{}); err == nil {
+		t.Errorf("expected error for non pointer")
+	}
+
+	p := &Person{Id: 1, FName: "bob", LName: "smith", Version: 1}
+	err := m.Track(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.FName = "alice"
+	elem := reflect.ValueOf(p).Elem()
+	changed := table.changedColumns(elem, m.snapshotOf(p))
+	if len(changed) != 1 || !changed[table.ColMap("FName")] {
+		t.Errorf("unexpected changed columns %v", changed)
+	}
+	bi, err := table.bindUpdateChanged(elem, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `update "person_test" set "FName"=?, "Version"=? where "Id"=? and "Version"=?;`
+	if bi.query != want {
+		t.Errorf("\n got: %s\nwant: %s", bi.query, want)
+	}
+	if !reflect.DeepEqual(bi.args, []interface{}{"alice", int64(2), int64(1), int64(1)}) {
+		t.Errorf("unexpected args %v", bi.args)
+	}
+	m.Untrack(p)
+	if m.snapshotOf(p) != nil {
+		t.Errorf("snapshot kept after Untrack")
+	}
+
+	b := []byte("abc")
+	c := copyValue(reflect.ValueOf(b)).([]byte)
+	b[0] = 'x'
+	if string(c) != "abc" {
+		t.Errorf("slice not copied: %s", c)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	inv := &Invoice{0, 100, 200, "a", 1, false}
+	_insert(dbmap, inv)
+	obj := _get(dbmap, Invoice{}, inv.Id).(*Invoice)
+	err = dbmap.Track(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a concurrent update of the unchanged Created is not overwritten
+	_, err = dbmap.Exec("update invoice_test set Created = 999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Memo = "b"
+	if count := _update(dbmap, obj); count != 1 {
+		t.Errorf("updated %d rows", count)
+	}
+	if count := _update(dbmap, obj); count != 0 {
+		t.Errorf("unchanged update updated %d rows", count)
+	}
+	inv2 := _get(dbmap, Invoice{}, inv.Id).(*Invoice)
+	if inv2.Memo != "b" || inv2.Created != 999 || inv2.Updated != 200 {
+		t.Errorf("unexpected %v", inv2)
+	}
+}
+
+func TestSelectToMap(t *testing.T) {
+	m := &DbMap{Dialect: SqliteDialect{}}
+	if err := m.SelectToMap(map[int64]string{}, "Id", "select Id, Memo from invoice_test"); err == nil {
+		t.Errorf("expected error for non pointer dest")
+	}
+	invoices := map[int64]Invoice{}
+	if err := m.SelectToMap(&invoices, "Nope", "select * from invoice_test"); err == nil || !strings.Contains(err.Error(), "Nope") {
+		t.Errorf("expected error for unmapped key column, got %v", err)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 0, false}
+	inv2 := &Invoice{0, 100, 300, "b", 0, false}
+	_insert(dbmap, inv1, inv2)
+
+	var memos map[int64]string
+	err := dbmap.SelectToMap(&memos, "Id", "select Id, Memo from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int64]string{inv1.Id: "a", inv2.Id: "b"}; !reflect.DeepEqual(memos, want) {
+		t.Errorf("got %v, want %v", memos, want)
+	}
+	ids := map[string]int64{}
+	err = dbmap.SelectToMap(&ids, "Memo", "select Id, Memo from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{"a": inv1.Id, "b": inv2.Id}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+
+	err = dbmap.SelectToMap(&invoices, "Id", "select * from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 || !reflect.DeepEqual(invoices[inv1.Id], *inv1) || !reflect.DeepEqual(invoices[inv2.Id], *inv2) {
+		t.Errorf("unexpected %v", invoices)
+	}
+	pointers := map[int64]*Invoice{}
+	err = dbmap.SelectToMap(&pointers, "Id", "select * from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pointers) != 2 || !reflect.DeepEqual(pointers[inv2.Id], inv2) {
+		t.Errorf("unexpected %v", pointers)
+	}
+
+	byCreated := map[int64]*Invoice{}
+	err = dbmap.SelectToMap(&byCreated, "Created", "select * from invoice_test")
+	if err == nil || !strings.Contains(err.Error(), "duplicate key 100") {
+		t.Errorf("expected duplicate key error, got %v", err)
+	}
+	dbmap.MapKeysLastWins = true
+	byCreated = map[int64]*Invoice{}
+	err = dbmap.SelectToMap(&byCreated, "Created", "select * from invoice_test order by Memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byCreated) != 1 || !reflect.DeepEqual(byCreated[100], inv2) {
+		t.Errorf("unexpected %v", byCreated)
+	}
+}
+
+func TestSelectInto(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 0, false}
+	inv2 := &Invoice{0, 100, 200, "b", 0, false}
+	_insert(dbmap, inv1, inv2)
+
+	list := make([]*Invoice, 0, 10)
+	err := dbmap.SelectInto(&list, "select * from invoice_test order by memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || cap(list) != 10 {
+		t.Fatalf("unexpected len %d / cap %d", len(list), cap(list))
+	}
+	first := list[0]
+
+	_del(dbmap, inv1)
+	err = dbmap.SelectInto(&list, "select * from invoice_test order by memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || cap(list) != 10 {
+		t.Fatalf("unexpected len %d / cap %d", len(list), cap(list))
+	}
+	if list[0] != first {
+		t.Errorf("struct was not reused")
+	}
+	if !reflect.DeepEqual(list[0], inv2) {
+		t.Errorf("%v != %v", list[0], inv2)
+	}
+
+	var values []Invoice
+	err = dbmap.SelectInto(&values, "select * from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || !reflect.DeepEqual(&values[0], inv2) {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	err = dbmap.SelectInto(values, "select * from invoice_test")
+	if err == nil {
+		t.Errorf("expected error for non-pointer slice")
+	}
+}
+
+func TestScanInterceptor(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "bob   ", "smith  ", 0}
+	_insert(dbmap, p1)
+
+	var seen []string
+	dbmap.SetScanInterceptor(func(col *ColumnMap, value interface{}) interface{} {
+		seen = append(seen, col.ColumnName)
+		if s, ok := value.(string); ok {
+			return strings.TrimRight(s, " ")
+		}
+		return value
+	})
+
+	obj := _get(dbmap, Person{}, p1.Id)
+	p2 := obj.(*Person)
+	// LName is overwritten by Person.PostGet, so only FName is checked
+	if p2.FName != "bob" {
+		t.Errorf("Get: padding not trimmed: %q", p2.FName)
+	}
+	if len(seen) != 6 {
+		t.Errorf("Get: interceptor called for %v", seen)
+	}
+
+	var fnames []FNameOnly
+	_rawselect(dbmap, &fnames, "select FName from person_test")
+	if len(fnames) != 1 || fnames[0].FName != "bob" {
+		t.Errorf("Select: padding not trimmed: %v", fnames)
+	}
+
+	fname := selectStr(dbmap, "select FName from person_test")
+	if fname != "bob   " {
+		t.Errorf("SelectStr should not be intercepted: %q", fname)
+	}
+
+	dbmap.SetScanInterceptor(func(col *ColumnMap, value interface{}) interface{} {
+		return 42
+	})
+	_, err := dbmap.Get(Person{}, p1.Id)
+	if err == nil {
+		t.Errorf("expected error for value not assignable to string field")
+	}
+}
+
+func TestExpandedField(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(WithLatLng{}, "latlng_test")
+	want := `create table "latlng_test" ("Id" integer not null primary key autoincrement, "Name" varchar(255), "lat" real, "lng" real) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	if col := table.ColMap("Pos.Lat"); col.ColumnName != "lat" {
+		t.Errorf("unexpected column %s for Pos.Lat", col.ColumnName)
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(WithLatLng{}, "latlng_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	p1 := &WithLatLng{Name: "home", Pos: LatLng{48.25, 11.5}}
+	_insert(dbmap, p1)
+	obj := _get(dbmap, WithLatLng{}, p1.Id)
+	if !reflect.DeepEqual(p1, obj) {
+		t.Errorf("%v != %v", p1, obj)
+	}
+
+	p1.Pos.Lng = -0.125
+	_update(dbmap, p1)
+
+	var list []*WithLatLng
+	_rawselect(dbmap, &list, "select * from latlng_test")
+	if len(list) != 1 || !reflect.DeepEqual(p1, list[0]) {
+		t.Errorf("%v != %v", p1, list)
+	}
+}
+
+func TestColumnHolders(t *testing.T) {
+	typ := reflect.TypeOf(PlaceRow{})
+	cols := []string{"Id", "Pos", "lng", "Other"}
+	index := [][]int{{0}, {1}, nil, nil}
+	err := missingColumns(typ, cols, index, []int{0, 2, 0, 0})
+	if e, ok := err.(*NoFieldInTypeError); !ok || !reflect.DeepEqual(e.MissingColNames, []string{"other"}) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = missingColumns(typ, cols[:3], index[:3], []int{0, 2, 0}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithLatLng{}, "latlng_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	p1 := &WithLatLng{Name: "home", Pos: LatLng{48.25, 11.5}}
+	_insert(dbmap, p1)
+
+	dbmap.TypeConverter = latLngConverter{}
+	var list []PlaceRow
+	_, err = dbmap.Select(&list, "select Id, lat as Pos, lng from latlng_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Id != p1.Id || list[0].Pos != p1.Pos {
+		t.Errorf("%v != %v", list, p1)
+	}
+
+	_, err = dbmap.Select(&list, "select lat as Pos from latlng_test")
+	if err == nil {
+		t.Errorf("expected error for missing second column")
+	}
+	_, err = dbmap.Select(&list, "select lat as Pos, Id from latlng_test")
+	if err == nil {
+		t.Errorf("expected error for claimed column mapped to Id")
+	}
+}
+
+func TestAutoJSON(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}, AutoJSON: true}
+	table := dbmap.AddTableWithName(WithJSON{}, "json_test")
+	for _, field := range []string{"Attrs", "Items", "Tags"} {
+		if conv, _ := table.converterFor(field); conv != (jsonConverter{}) {
+			t.Errorf("%s is not stored as JSON", field)
+		}
+	}
+	if conv, _ := table.converterFor("Id"); conv != nil {
+		t.Errorf("Id should not be stored as JSON")
+	}
+	if dbmap.autoJSON(reflect.TypeOf([]byte{})) || dbmap.autoJSON(reflect.TypeOf([]string{})) {
+		t.Errorf("[]byte and []string should not be stored as JSON")
+	}
+	val, err := jsonConverter{}.ToDb([]JSONItem{{"a", 1}})
+	if err != nil || val != `[{"Name":"a","Count":1}]` {
+		t.Errorf("ToDb = %v, %v", val, err)
+	}
+
+	dbmap = newDbMap()
+	dbmap.AutoJSON = true
+	dbmap.AddTableWithName(WithJSON{}, "json_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	j1 := &WithJSON{
+		Attrs: map[string]interface{}{"color": "red", "size": 42.5},
+		Items: []JSONItem{{"apple", 3}, {"pear", 1}},
+	}
+	_insert(dbmap, j1)
+
+	obj := _get(dbmap, WithJSON{}, j1.Id)
+	if !reflect.DeepEqual(j1, obj) {
+		t.Errorf("Get: %v != %v", j1, obj)
+	}
+
+	j1.Tags = map[string]string{"k": "v"}
+	_update(dbmap, j1)
+
+	var list []*WithJSON
+	_rawselect(dbmap, &list, "select * from json_test")
+	if len(list) != 1 || !reflect.DeepEqual(j1, list[0]) {
+		t.Errorf("Select: %v != %v", j1, list)
+	}
+}
+
+func TestParseComposite(t *testing.T) {
+	null := sql.NullString{}
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	tests := []struct {
+		in   string
+		want []sql.NullString
+	}{
+		{`(1,abc)`, []sql.NullString{str("1"), str("abc")}},
+		{`(1,,3)`, []sql.NullString{str("1"), null, str("3")}},
+		{`(,)`, []sql.NullString{null, null}},
+		{`()`, []sql.NullString{null}},
+		{`("",x)`, []sql.NullString{str(""), str("x")}},
+		{`("a,b","(c)")`, []sql.NullString{str("a,b"), str("(c)")}},
+		{`("say ""hi""",\"x)`, []sql.NullString{str(`say "hi"`), str(`"x`)}},
+		{`("back\\slash","q\"uote")`, []sql.NullString{str(`back\slash`), str(`q"uote`)}},
+		{`( a , b )`, []sql.NullString{str(" a "), str(" b ")}},
+		{`("2001-02-03 04:05:06+00",t)`, []sql.NullString{str("2001-02-03 04:05:06+00"), str("t")}},
+	}
+	for _, test := range tests {
+		got, err := ParseComposite(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{``, `1,2`, `(1,2`, `("a,b)`, `(a\)`} {
+		if _, err := ParseComposite(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestCompositeScanner(t *testing.T) {
+	type composite struct {
+		Id      int64
+		Name    string
+		Note    *string
+		Price   float64
+		Active  bool
+		Created time.Time
+		Code    sql.NullString
+	}
+	var c composite
+	s := CompositeScanner(&c)
+	err := s.Holder.(*sql.NullString).Scan(`(7,"a, b",,1.5,t,"2001-02-03 04:05:06+00",x)`)
+	if err == nil {
+		err = s.Bind()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if c.Id != 7 || c.Name != "a, b" || c.Note != nil || c.Price != 1.5 || !c.Active ||
+		!c.Created.Equal(created) || c.Code != (sql.NullString{String: "x", Valid: true}) {
+		t.Errorf("unexpected %+v", c)
+	}
+
+	var c2 composite
+	s = CompositeScanner(&c2, "Name", "Note")
+	err = s.Holder.(*sql.NullString).Scan(`(abc,def)`)
+	if err == nil {
+		err = s.Bind()
+	}
+	if err != nil || c2.Name != "abc" || c2.Note == nil || *c2.Note != "def" {
+		t.Errorf("unexpected %+v %v", c2, err)
+	}
+
+	s = CompositeScanner(&c2, "Name")
+	s.Holder.(*sql.NullString).Scan(`(abc,def)`)
+	if err = s.Bind(); err == nil {
+		t.Errorf("expected error for attribute count mismatch")
+	}
+}
+
+func TestEpochConverter(t *testing.T) {
+	tm := time.Date(2015, 5, 29, 10, 11, 12, 345678901, time.UTC)
+	if got, _ := (EpochConverter{}).ToDb(tm); got != int64(1432894272) {
+		t.Errorf("epoch_seconds ToDb = %v", got)
+	}
+	if got, _ := (EpochConverter{Millis: true}).ToDb(tm); got != int64(1432894272345) {
+		t.Errorf("epoch_millis ToDb = %v", got)
+	}
+
+	var got time.Time
+	scanner, ok := EpochConverter{Millis: true}.FromDb(&got)
+	if !ok {
+		t.Fatal("FromDb(*time.Time) not handled")
+	}
+	scanner.Holder.(*sql.NullInt64).Scan(int64(1432894272345))
+	want := time.Date(2015, 5, 29, 10, 11, 12, 345000000, time.UTC)
+	if err := scanner.Bind(); err != nil || !got.Equal(want) {
+		t.Errorf("Bind = %v, %v, want %v", got, err, want)
+	}
+	if _, ok = (EpochConverter{}).FromDb(new(int64)); ok {
+		t.Errorf("FromDb(*int64) should not be handled")
+	}
+
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(WithEpoch{}, "epoch_test")
+	wantSql := `create table "epoch_test" ("id" bigserial not null primary key , "created" bigint, "updated" bigint) ;`
+	if sql := table.SqlForCreate(false); sql != wantSql {
+		t.Errorf("\n got: %s\nwant: %s", sql, wantSql)
+	}
+	if col := table.ColMap("Created"); col.ScanAs != "epoch_millis" {
+		t.Errorf("ScanAs = %q", col.ScanAs)
+	}
+}
+
+func TestEpochRoundTrip(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithEpoch{}, "epoch_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	updated := time.Date(2015, 5, 29, 10, 11, 12, 0, time.UTC)
+	row := &WithEpoch{Created: time.Date(2015, 5, 16, 1, 2, 3, 456000000, time.UTC), Updated: &updated}
+	_insert(dbmap, row)
+	if n := selectInt(dbmap, "select Created from epoch_test"); n != 1431738123456 {
+		t.Errorf("stored %d", n)
+	}
+	obj := _get(dbmap, WithEpoch{}, row.Id).(*WithEpoch)
+	if !obj.Created.Equal(row.Created) || obj.Updated == nil || !obj.Updated.Equal(updated) {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	row.Updated = nil
+	_update(dbmap, row)
+	var list []*WithEpoch
+	_, err = dbmap.Select(&list, "select * from epoch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !list[0].Created.Equal(row.Created) || list[0].Updated != nil {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestDateConverter(t *testing.T) {
+	tm := time.Date(2015, 5, 29, 23, 11, 12, 0, time.FixedZone("JST", 9*3600))
+	if got, _ := (DateConverter{}).ToDb(tm); got != "2015-05-29" {
+		t.Errorf("ToDb = %v", got)
+	}
+
+	want := time.Date(2015, 5, 29, 0, 0, 0, 0, time.UTC)
+	for _, src := range []interface{}{tm, []byte("2015-05-29"), "2015-05-29 00:00:00"} {
+		var got time.Time
+		scanner, ok := DateConverter{}.FromDb(&got)
+		if !ok {
+			t.Fatal("FromDb(*time.Time) not handled")
+		}
+		if err := scanner.Holder.(sql.Scanner).Scan(src); err != nil {
+			t.Fatal(err)
+		}
+		if err := scanner.Bind(); err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("Bind(%v) = %v, %v, want %v", src, got, err, want)
+		}
+	}
+	if _, ok := (DateConverter{}).FromDb(new(string)); ok {
+		t.Errorf("FromDb(*string) should not be handled")
+	}
+
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{PostgresDialect{}, `create table "date_test" ("id" bigserial not null primary key , "birthday" date, "due" date) ;`},
+		{MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}, "create table `date_test` (`Id` bigint not null primary key auto_increment, `Birthday` date, `Due` date)  engine=InnoDB charset=UTF8;"},
+		{SqliteDialect{}, `create table "date_test" ("Id" integer not null primary key autoincrement, "Birthday" date, "Due" date) ;`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithDate{}, "date_test")
+		if sql := table.SqlForCreate(false); sql != tt.want {
+			t.Errorf("\n got: %s\nwant: %s", sql, tt.want)
+		}
+		if col := table.ColMap("Due"); col.ScanAs != "date" {
+			t.Errorf("ScanAs = %q", col.ScanAs)
+		}
+	}
+}
+
+func TestDateRoundTrip(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithDate{}, "date_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	due := time.Date(2015, 6, 1, 17, 30, 0, 0, time.UTC)
+	row := &WithDate{Birthday: time.Date(1980, 2, 29, 8, 9, 10, 0, time.UTC), Due: &due}
+	_insert(dbmap, row)
+	obj := _get(dbmap, WithDate{}, row.Id).(*WithDate)
+	if !obj.Birthday.Equal(time.Date(1980, 2, 29, 0, 0, 0, 0, time.UTC)) ||
+		obj.Due == nil || !obj.Due.Equal(time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("%v, %v", obj.Birthday, obj.Due)
+	}
+
+	row.Due = nil
+	_update(dbmap, row)
+	var list []*WithDate
+	_, err = dbmap.Select(&list, "select * from date_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Due != nil {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestBitConverter(t *testing.T) {
+	pg := BitConverter{Size: 12, String: true}
+	my := BitConverter{Size: 12}
+	if got, err := pg.ToDb(uint16(0xa05)); err != nil || got != "101000000101" {
+		t.Errorf("ToDb(0xa05) = %v, %v", got, err)
+	}
+	if got, err := my.ToDb([]byte{0x0a, 0x05}); err != nil || !bytes.Equal(got.([]byte), []byte{0x0a, 0x05}) {
+		t.Errorf("ToDb([0a 05]) = %v, %v", got, err)
+	}
+	if got, err := pg.ToDb([]byte{0x05}); err != nil || got != "000000000101" {
+		t.Errorf("ToDb([05]) = %v, %v", got, err)
+	}
+	if _, err := my.ToDb(uint16(0x1000)); err == nil {
+		t.Errorf("expected error for a value exceeding 12 bits")
+	}
+
+	var n uint16
+	scanner, ok := pg.FromDb(&n)
+	if !ok {
+		t.Fatal("FromDb(*uint16) not handled")
+	}
+	*scanner.Holder.(*[]byte) = []byte("101000000101")
+	if err := scanner.Bind(); err != nil || n != 0xa05 {
+		t.Errorf("Bind = %x, %v", n, err)
+	}
+	var b []byte
+	scanner, _ = my.FromDb(&b)
+	*scanner.Holder.(*[]byte) = []byte{0x0a, 0x05}
+	if err := scanner.Bind(); err != nil || !bytes.Equal(b, []byte{0x0a, 0x05}) {
+		t.Errorf("Bind = %x, %v", b, err)
+	}
+	var small uint8
+	scanner, _ = my.FromDb(&small)
+	*scanner.Holder.(*[]byte) = []byte{0x0a, 0x05}
+	if err := scanner.Bind(); err == nil {
+		t.Errorf("expected overflow error, got %x", small)
+	}
+	if _, ok = my.FromDb(new(int64)); ok {
+		t.Errorf("FromDb(*int64) should not be handled")
+	}
+
+	for _, tt := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{PostgresDialect{}, `create table "bits_test" ("id" bigserial not null primary key , "flags" bit(8), "mask" bit(12)) ;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create table `bits_test` (`Id` bigint not null primary key auto_increment, `Flags` bit(8), `Mask` bit(12))  engine=InnoDB charset=UTF8;"},
+		{SqliteDialect{}, `create table "bits_test" ("Id" integer not null primary key autoincrement, "Flags" integer, "Mask" blob) ;`},
+	} {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithBits{}, "bits_test")
+		if sql := table.SqlForCreate(false); sql != tt.want {
+			t.Errorf("%T\n got: %s\nwant: %s", tt.dialect, sql, tt.want)
+		}
+	}
+}
+
+func TestBitRoundTrip(t *testing.T) {
+	switch dialectFromEnv().(type) {
+	case PostgresDialect, MySQLDialect:
+	default:
+		t.Skip("bit columns are only tested with postgres and mysql")
+	}
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithBits{}, "bits_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	row := &WithBits{Flags: 0x81, Mask: []byte{0x0f, 0xf0}}
+	_insert(dbmap, row)
+	obj := _get(dbmap, WithBits{}, row.Id).(*WithBits)
+	if obj.Flags != 0x81 || !bytes.Equal(obj.Mask, row.Mask) {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	row.Flags = 0
+	row.Mask = nil
+	_update(dbmap, row)
+	var list []*WithBits
+	_, err = dbmap.Select(&list, "select * from bits_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Flags != 0 || list[0].Mask != nil {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestNetConverter(t *testing.T) {
+	v4 := net.ParseIP("192.168.1.10")
+	v6 := net.ParseIP("2001:db8::1")
+	mac, _ := net.ParseMAC("08:00:2b:01:02:03")
+	text := NetConverter{Text: true}
+	for _, tt := range []struct {
+		conv NetConverter
+		in   interface{}
+		want interface{}
+	}{
+		{text, v4, "192.168.1.10"},
+		{text, mac, "08:00:2b:01:02:03"},
+		{NetConverter{}, v4, []byte{192, 168, 1, 10}},
+		{NetConverter{}, v6, []byte(v6)},
+		{NetConverter{}, mac, []byte(mac)},
+		{NetConverter{}, net.IP(nil), nil},
+	} {
+		got, err := tt.conv.ToDb(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToDb(%v) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	var ip net.IP
+	scanner, ok := text.FromDb(&ip)
+	if !ok {
+		t.Fatal("FromDb(*net.IP) not handled")
+	}
+	*scanner.Holder.(*[]byte) = []byte("192.168.1.10/24")
+	if err := scanner.Bind(); err != nil || !reflect.DeepEqual(ip, v4.To4()) {
+		t.Errorf("Bind = %v, %v", ip, err)
+	}
+	var hw net.HardwareAddr
+	scanner, _ = text.FromDb(&hw)
+	*scanner.Holder.(*[]byte) = []byte("08:00:2b:01:02:03")
+	if err := scanner.Bind(); err != nil || !reflect.DeepEqual(hw, mac) {
+		t.Errorf("Bind = %v, %v", hw, err)
+	}
+	scanner, _ = NetConverter{}.FromDb(&ip)
+	*scanner.Holder.(*[]byte) = []byte(v6)
+	if err := scanner.Bind(); err != nil || !ip.Equal(v6) {
+		t.Errorf("Bind = %v, %v", ip, err)
+	}
+	if _, ok = text.FromDb(new([]byte)); ok {
+		t.Errorf("FromDb(*[]byte) should not be handled")
+	}
+
+	for _, tt := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{PostgresDialect{}, `create table "net_test" ("id" bigserial not null primary key , "addr" inet, "mac" macaddr, "gateway" inet) ;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "create table `net_test` (`Id` bigint not null primary key auto_increment, `Addr` varbinary(16), `Mac` varbinary(6), `Gateway` varbinary(16))  engine=InnoDB charset=UTF8;"},
+		{SqliteDialect{}, `create table "net_test" ("Id" integer not null primary key autoincrement, "Addr" blob, "Mac" blob, "Gateway" blob) ;`},
+	} {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		table := dbmap.AddTableWithName(WithNet{}, "net_test")
+		if sql := table.SqlForCreate(false); sql != tt.want {
+			t.Errorf("%T\n got: %s\nwant: %s", tt.dialect, sql, tt.want)
+		}
+		if col := table.ColMap("Gateway"); col.ScanAs != "inet" {
+			t.Errorf("ScanAs = %q", col.ScanAs)
+		}
+	}
+}
+
+func TestValuerScanner(t *testing.T) {
+	uid := UUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	const text = "123e4567-e89b-12d3-a456-426614174000"
+
+	// the TypeConverter of the DbMap is not used for UUID fields
+	dbmap := &DbMap{Dialect: SqliteDialect{}, TypeConverter: testTypeConverter{}}
+	table := dbmap.AddTableWithName(WithUUID{}, "uuid_test")
+	want := `create table "uuid_test" ("Id" integer not null primary key autoincrement, "Uid" varchar(255), "Parent" varchar(255)) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	for _, field := range []string{"Uid", "Parent"} {
+		conv, err := table.converterFor(field)
+		if err != nil || conv != (valuerConverter{}) {
+			t.Errorf("converterFor(%s) = %v, %v", field, conv, err)
+		}
+	}
+	conv := valuerConverter{}
+	for _, tt := range []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{uid, text},
+		{&uid, text},
+		{(*UUID)(nil), nil},
+	} {
+		got, err := convertToDb(conv, tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ToDb(%v) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	dbmap = newDbMap()
+	dbmap.TypeConverter = testTypeConverter{}
+	dbmap.AddTableWithName(WithUUID{}, "uuid_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	row := &WithUUID{Uid: uid}
+	_insert(dbmap, row)
+	s, err := dbmap.SelectStr("select uid from uuid_test")
+	if err != nil || s != text {
+		t.Errorf("stored %q, %v, want %q", s, err, text)
+	}
+	obj := _get(dbmap, WithUUID{}, row.Id).(*WithUUID)
+	if obj.Uid != uid || obj.Parent != nil {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	parent := UUID{1, 2, 3}
+	row.Parent = &parent
+	_update(dbmap, row)
+	var list []WithUUID
+	_, err = dbmap.Select(&list, "select * from uuid_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Uid != uid || list[0].Parent == nil || *list[0].Parent != parent {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestNetRoundTrip(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithNet{}, "net_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	gw := net.ParseIP("fe80::1")
+	mac, _ := net.ParseMAC("08:00:2b:01:02:03")
+	row := &WithNet{Addr: net.ParseIP("10.0.0.1"), Mac: mac, Gateway: &gw}
+	_insert(dbmap, row)
+	obj := _get(dbmap, WithNet{}, row.Id).(*WithNet)
+	if !obj.Addr.Equal(row.Addr) || obj.Mac.String() != mac.String() || obj.Gateway == nil || !obj.Gateway.Equal(gw) {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	row.Addr = nil
+	row.Gateway = nil
+	_update(dbmap, row)
+	var list []*WithNet
+	_, err = dbmap.Select(&list, "select * from net_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Addr != nil || list[0].Gateway != nil || list[0].Mac.String() != mac.String() {
+		t.Errorf("unexpected %v", list)
+	}
+}
+
+func TestDecimalScanner(t *testing.T) {
+	for _, name := range []string{"NUMERIC", "numeric(10,2)", "DECIMAL", "NEWDECIMAL"} {
+		if !isDecimalType(name) {
+			t.Errorf("%s is a decimal type", name)
+		}
+	}
+	if isDecimalType("INT8") || decimalField(reflect.TypeOf("")) || decimalField(reflect.TypeOf(sql.NullInt64{})) {
+		t.Errorf("unexpected decimal column")
+	}
+
+	var row WithDecimal
+	for _, tt := range []struct {
+		target interface{}
+		value  string
+		ok     bool
+	}{
+		{&row.Price, "12.50", true},
+		{&row.Qty, "3.00", true},
+		{&row.Qty, "3.50", false},
+		{&row.Limit, "4000000000", true},
+		{&row.Limit, "-1", false},
+		{&row.Limit, "5000000000", false},
+	} {
+		scanner := decimalScanner(tt.target)
+		scanner.Holder.(*sql.NullString).Scan(tt.value)
+		if err := scanner.Bind(); (err == nil) != tt.ok {
+			t.Errorf("Bind(%s) = %v", tt.value, err)
+		}
+	}
+	if row.Price != 12.5 || row.Qty != 3 || row.Limit == nil || *row.Limit != 4000000000 {
+		t.Errorf("unexpected %v", row)
+	}
+
+	scanner := decimalScanner(&row.Limit)
+	scanner.Holder.(*sql.NullString).Scan(nil)
+	if err := scanner.Bind(); err != nil || row.Limit != nil {
+		t.Errorf("NULL not scanned into nil: %v", err)
+	}
+	scanner = decimalScanner(&row.Qty)
+	scanner.Holder.(*sql.NullString).Scan(nil)
+	if err := scanner.Bind(); err == nil {
+		t.Errorf("expected error for NULL into int64")
+	}
+}
+
+type InvoiceTotal struct {
+	PersonId int64
+	Created  int64
+	Memo     string
+}
+
+func TestNullAsZero(t *testing.T) {
+	var n int64 = 7
+	scanner := nullAsZeroScanner(&n)
+	if err := scanner.Bind(); err != nil || n != 0 {
+		t.Errorf("NULL = %d, %v", n, err)
+	}
+	v := int64(3)
+	*scanner.Holder.(**int64) = &v
+	if err := scanner.Bind(); err != nil || n != 3 {
+		t.Errorf("3 = %d, %v", n, err)
+	}
+
+	var row WithDecimal
+	row.Qty = 5
+	scanner = zeroOnNull(decimalScanner(&row.Qty))
+	scanner.Holder.(sql.Scanner).Scan(nil)
+	if err := scanner.Bind(); err != nil || row.Qty != 0 {
+		t.Errorf("decimal NULL = %d, %v", row.Qty, err)
+	}
+	scanner.Holder.(sql.Scanner).Scan("4.00")
+	if err := scanner.Bind(); err != nil || row.Qty != 4 {
+		t.Errorf("decimal 4 = %d, %v", row.Qty, err)
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	_insert(dbmap, &Invoice{Created: 10, Memo: "paid", PersonId: 1},
+		&Invoice{Created: 20, Memo: "open", PersonId: 2})
+
+	query := "select PersonId, sum(case when Memo = 'paid' then Created end) as Created, " +
+		"max(case when Memo = 'paid' then Memo end) as Memo from invoice_test group by PersonId order by PersonId"
+	var totals []InvoiceTotal
+	if _, err := dbmap.Select(&totals, query); err == nil {
+		t.Errorf("NULL scanned into int64 without NullAsZero")
+	}
+	totals = nil
+	dbmap.NullAsZero = true
+	if _, err := dbmap.Select(&totals, query); err != nil {
+		t.Fatal(err)
+	}
+	want := []InvoiceTotal{{1, 10, "paid"}, {2, 0, ""}}
+	if !reflect.DeepEqual(totals, want) {
+		t.Errorf("got %v, want %v", totals, want)
+	}
+}
+
+func TestDecimalRoundTrip(t *testing.T) {
+	dbmap := newDbMap()
+	table := dbmap.AddTableWithName(WithDecimal{}, "decimal_test")
+	for _, c := range []struct{ name, dbType string }{
+		{"Price", "numeric(10,2)"}, {"Qty", "decimal(10,2)"}, {"Limit", "numeric(12,0)"},
+	} {
+		if col := table.ColMap(c.name); col.DbType != c.dbType {
+			t.Errorf("%s: DbType = %q, want %q", c.name, col.DbType, c.dbType)
+		}
+	}
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	limit := uint32(100)
+	row := &WithDecimal{Price: 12.25, Qty: 7, Limit: &limit}
+	_insert(dbmap, row)
+	obj := _get(dbmap, WithDecimal{}, row.Id).(*WithDecimal)
+	if obj.Price != 12.25 || obj.Qty != 7 || obj.Limit == nil || *obj.Limit != 100 {
+		t.Errorf("%v != %v", obj, row)
+	}
+
+	var list []*WithDecimal
+	_, err = dbmap.Select(&list, "select * from decimal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Price != 12.25 || list[0].Qty != 7 {
+		t.Errorf("unexpected %v", list)
+	}
+
+	// the column types of unmapped structs are taken from the driver
+	var prices []struct{ Price float64 }
+	_, err = dbmap.Select(&prices, "select Price from decimal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 1 || prices[0].Price != 12.25 {
+		t.Errorf("unexpected %v", prices)
+	}
+}
+
+func TestRatConverter(t *testing.T) {
+	conv := RatConverter{}
+	for in, want := range map[string]string{"12": "12", "-1/8": "-0.125", "123456789/100": "1234567.89", "1/20": "0.05"} {
+		r, _ := new(big.Rat).SetString(in)
+		got, err := conv.ToDb(r)
+		if err != nil || got != want {
+			t.Errorf("ToDb(%s) = %v, %v, want %s", in, got, err, want)
+		}
+	}
+	_, err := conv.ToDb(big.NewRat(1, 3))
+	if err == nil {
+		t.Errorf("expected error for 1/3")
+	}
+
+	var r big.Rat
+	scanner, ok := conv.FromDb(&r)
+	if !ok {
+		t.Fatal("FromDb(*big.Rat) not handled")
+	}
+	scanner.Holder.(*sql.NullString).Scan([]byte("0.100000"))
+	if err = scanner.Bind(); err != nil || r.Cmp(big.NewRat(1, 10)) != 0 {
+		t.Errorf("Bind = %s, %v", r.String(), err)
+	}
+	if _, ok = conv.FromDb(new(float64)); ok {
+		t.Errorf("FromDb(*float64) should not be handled")
+	}
+
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	if _, err = dbmap.scanConverter("money"); err == nil {
+		t.Errorf("expected error for unregistered scan converter")
+	}
+	dbmap.AddScanConverter("money", testTypeConverter{})
+	if conv, _ := dbmap.scanConverter("money"); conv != (testTypeConverter{}) {
+		t.Errorf("registered scan converter not returned: %v", conv)
+	}
+}
+
+func TestParseTagNumericType(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	pt := m.ParseTag(reflect.StructTag(`db:"amount, type:numeric(20,6), scan:rat"`))
+	if pt.ColumnName != "amount" || pt.DbType != "numeric(20,6)" || pt.ScanAs != "rat" {
+		t.Errorf("ParseTag = %q %q %q", pt.ColumnName, pt.DbType, pt.ScanAs)
+	}
+	pt = m.ParseTag(reflect.StructTag(`db:"type:numeric(10,2)"`))
+	if pt.ColumnName != "" || pt.DbType != "numeric(10,2)" {
+		t.Errorf("ParseTag = %q %q", pt.ColumnName, pt.DbType)
+	}
+}
+
+func TestRatRoundTrip(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithRat{}, "rat_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	r1 := &WithRat{}
+	r1.Amount.SetString("12345678.901234")
+	r2 := &WithRat{Fee: big.NewRat(-1, 1000)}
+	_insert(dbmap, r1, r2)
+
+	obj := _get(dbmap, WithRat{}, r1.Id).(*WithRat)
+	if obj.Amount.String() != "6172839450617/500000" || obj.Fee != nil {
+		t.Errorf("Get: %s %v", obj.Amount.String(), obj.Fee)
+	}
+
+	var list []*WithRat
+	_rawselect(dbmap, &list, "select * from rat_test order by Id")
+	if len(list) != 2 || list[0].Amount.Cmp(&r1.Amount) != 0 || list[1].Fee.Cmp(r2.Fee) != 0 {
+		t.Errorf("Select: %v", list)
+	}
+}
+
+func TestArgRedactor(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(Person{}, "person_test").SetKeys(true, "Id")
+	table.SetVersionCol("Version")
+	p := &Person{1, 2, 3, "secret", "smith", 1}
+	bi, err := table.bindUpdate(reflect.ValueOf(p).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "", 0))
+	var names []string
+	dbmap.SetArgRedactor(func(colName string, value interface{}) interface{} {
+		names = append(names, colName)
+		if colName == "FName" {
+			return "***"
+		}
+		return value
+	})
+	dbmap.traceArgs(time.Now(), bi.query, bi.boundArgs(), bi.args)
+	dbmap.trace(time.Now(), "select * from person_test where FName = ?", "secret")
+
+	out := logBuffer.String()
+	if !strings.Contains(out, `[1:"secret"]`) {
+		t.Errorf("ad hoc query args should not be masked: %s", out)
+	}
+	out = strings.SplitN(out, "\n", 2)[0]
+	if strings.Contains(out, "secret") || !strings.Contains(out, `"***"`) || !strings.Contains(out, `"smith"`) {
+		t.Errorf("unexpected log output %s", out)
+	}
+	want := []string{"Created", "Updated", "FName", "LName", "Version", "Id", "Version", ""}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("column names %v != %v", names, want)
+	}
+}
+
+func TestArgRedactorColumns(t *testing.T) {
+	drv := &execTestDriver{
+		columns: []string{"id", "email", "name", "deleted"},
+		row:     []driver.Value{int64(1), "bob@example.com", "Bob", false},
+	}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	m := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	m.AddTableWithName(Subscriber{}, "subscriber_test").SetKeys(false, "Id")
+
+	logBuffer := &bytes.Buffer{}
+	m.TraceOn("", log.New(logBuffer, "", 0))
+	m.SetArgRedactor(func(colName string, value interface{}) interface{} {
+		if colName == "Email" || colName == "Id" {
+			return "***"
+		}
+		return value
+	})
+
+	bob := &Subscriber{1, "bob@example.com", "Bob", false}
+	eve := &Subscriber{2, "eve@example.com", "Eve", false}
+	if err = m.Upsert(ConflictTarget{Columns: []string{"Email"}}, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.UpdateBatch(bob, eve); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Get(Subscriber{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Delete(eve); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logBuffer.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 statements, got %q", lines)
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "example.com") || !strings.Contains(line, `"***"`) {
+			t.Errorf("args not redacted: %s", line)
+		}
+	}
+	if !strings.Contains(lines[0], `"Bob"`) || !strings.Contains(lines[1], `"Eve"`) {
+		t.Errorf("other args should not be masked: %q", lines[:2])
+	}
+
+	// the auto increment inserter of the dialect gets the args unchanged
+	d := &paramsDialect{}
+	m = &DbMap{Db: db, Dialect: d}
+	m.AddTableWithName(Subscriber{}, "subscriber_test").SetKeys(true, "Id")
+	logBuffer.Reset()
+	m.TraceOn("", log.New(logBuffer, "", 0))
+	m.SetArgRedactor(func(colName string, value interface{}) interface{} {
+		if colName == "Email" {
+			return "***"
+		}
+		return value
+	})
+	if err = m.Insert(&Subscriber{0, "bob@example.com", "Bob", false}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"bob@example.com", "Bob", false}; !reflect.DeepEqual(d.params, want) {
+		t.Errorf("inserter params %#v != %#v", d.params, want)
+	}
+	if out := logBuffer.String(); strings.Contains(out, "example.com") || !strings.Contains(out, `"***"`) {
+		t.Errorf("args not redacted: %s", out)
+	}
+}
+
+// paramsDialect records the params passed to InsertAutoIncr
+type paramsDialect struct {
+	SqliteDialect
+	params []interface{}
+}
+
+func (d *paramsDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error) {
+	d.params = params
+	return d.SqliteDialect.InsertAutoIncr(exec, insertSql, params...)
+}
+
+func TestMetricsCallback(t *testing.T) {
+	for query, want := range map[string]string{"select 1": "select", " (SELECT 1) union (select 2)": "select", "Insert into t values (1)": "insert", "commit;": "commit", "": ""} {
+		if got := queryOp(query); got != want {
+			t.Errorf("queryOp(%q) = %q, want %q", query, got, want)
+		}
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	counts := make(map[string]int)
+	errs := 0
+	dbmap.SetMetricsCallback(func(op string, dur time.Duration, err error) {
+		counts[op]++
+		if err != nil {
+			errs++
+		}
+	})
+
+	inv := &Invoice{0, 100, 200, "a", 0, false}
+	_insert(dbmap, inv)
+	inv.Memo = "b"
+	_update(dbmap, inv)
+	_get(dbmap, Invoice{}, inv.Id)
+	_del(dbmap, inv)
+	dbmap.Exec("select * from no_such_table")
+	dbmap.SetMetricsCallback(nil)
+	_get(dbmap, Invoice{}, inv.Id)
+
+	want := map[string]int{"insert": 1, "update": 1, "select": 2, "delete": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts %v != %v", counts, want)
+	}
+	if errs != 1 {
+		t.Errorf("expected 1 error, got %d", errs)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	var sleep string
+	switch dialectFromEnv().(type) {
+	case PostgresDialect:
+		sleep = "select pg_sleep(2)"
+	case MySQLDialect:
+		sleep = "select sleep(2)"
+	default:
+		t.Skip("query timeout is only tested with postgres and mysql")
+	}
+
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.SetQueryTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	_, err := dbmap.Exec(sleep)
+	if _, ok := err.(*QueryTimeoutError); !ok {
+		t.Errorf("Exec: expected *QueryTimeoutError, got %v", err)
+	}
+	var list []int64
+	_, err = dbmap.Select(&list, sleep)
+	if err == nil {
+		t.Errorf("Select: expected timeout error")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("statements were not cancelled after %v", time.Since(start))
+	}
+
+	// fast statements are not affected
+	_insert(dbmap, &Invoice{0, 100, 200, "a", 0, false})
+	if count := selectInt(dbmap, "select count(*) from invoice_test"); count != 1 {
+		t.Errorf("expected 1 invoice, got %d", count)
+	}
+}
+
+func TestQueryTimeoutRelease(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.SetQueryTimeout(time.Minute)
+
+	rows, err := dbmap.query("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if rows.ctx.Err() != context.Canceled {
+		t.Errorf("query: context not released by Close: %v", rows.ctx.Err())
+	}
+
+	var n int64
+	row := dbmap.queryRow("select 1")
+	if err = row.Scan(&n); err != nil || n != 1 {
+		t.Errorf("queryRow: %d, %v", n, err)
+	}
+	if row.ctx.Err() != context.Canceled {
+		t.Errorf("queryRow: context not released by Scan: %v", row.ctx.Err())
+	}
+
+	// errors of reading the results after the deadline are timeouts too
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	row = &timeoutRow{dbmap.Db.QueryRowContext(ctx, "select 1"), ctx, cancel, dbmap, "select 1"}
+	if _, ok := row.Scan(&n).(*QueryTimeoutError); !ok {
+		t.Errorf("Scan: expected *QueryTimeoutError")
+	}
+}
+
+func TestMustFunctions(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p := &Person{0, 0, 0, "bob", "smith", 0}
+	dbmap.MustInsert(p)
+	obj := dbmap.MustGet(Person{}, p.Id).(*Person)
+	if obj.FName != "bob" {
+		t.Errorf("MustGet returned %v", obj)
+	}
+	if obj := dbmap.MustGet(Person{}, -1); obj != nil {
+		t.Errorf("MustGet of missing row returned %v", obj)
+	}
+	list := dbmap.MustSelect(Person{}, "select * from person_test")
+	if len(list) != 1 {
+		t.Errorf("MustSelect returned %d rows", len(list))
+	}
+	res := dbmap.MustExec("delete from person_test")
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Errorf("MustExec deleted %d rows", n)
+	}
+
+	mustPanic := func(name string, f func()) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("%s did not panic", name)
+			} else if _, ok := r.(error); !ok {
+				t.Errorf("%s panicked with %v, expected an error", name, r)
+			}
+		}()
+		f()
+	}
+	mustPanic("MustExec", func() { dbmap.MustExec("not a statement") })
+	mustPanic("MustInsert", func() { dbmap.MustInsert(Person{}) })
+	mustPanic("MustGet", func() { dbmap.MustGet(Person{}) })
+	mustPanic("MustSelect", func() { dbmap.MustSelect(Person{}, "select * from no_such_table") })
+}
+
+func TestNotify(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	if err := dbmap.Notify("gorp_test", "x"); err == nil {
+		t.Errorf("expected error for Notify on sqlite")
+	}
+
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("notifications are only tested with postgres")
+	}
+
+	listener := pq.NewListener(os.Getenv("GORP_TEST_DSN"), time.Second, time.Minute, nil)
+	defer listener.Close()
+	err := listener.Listen("gorp_test")
 	if err != nil {
-		t.Errorf("Failed to select: %s", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	if len(puArr) != 1 {
-		t.Errorf("Expected one persistentuser, found none")
+
+	dbmap = newDbMap()
+	defer dbmap.Db.Close()
+	err = dbmap.Notify("gorp_test", "hello")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(pu, puArr[0]) {
-		t.Errorf("%v!=%v", pu, puArr[0])
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = trans.Notify("gorp_test", "committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// Test delete self.
-	result, err := dbmap.Exec(`
-delete from PersistentUser
- where mykey = :Key
-   and PassedTraining = :PassedTraining
-   and Id = :Id`, pu)
-	count, err := result.RowsAffected()
+	for _, want := range []string{"hello", "committed"} {
+		select {
+		case n := <-listener.Notify:
+			if n.Channel != "gorp_test" || n.Extra != want {
+				t.Errorf("got notification %v, want %s", n, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification %s received", want)
+		}
+	}
+}
+
+func TestExecMulti(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+	dbmap.Exec("drop table if exists multi_test")
+	defer dbmap.Exec("drop table multi_test")
+
+	err := dbmap.ExecMulti([]string{
+		"create table multi_test (id int)",
+		"insert into no_such_table values (1)",
+		"insert into multi_test values (1)",
+	})
+	serr, ok := err.(*StatementError)
+	if !ok {
+		t.Fatalf("expected *StatementError, got %v", err)
+	}
+	if serr.Index != 1 || serr.Statement != "insert into no_such_table values (1)" {
+		t.Errorf("wrong failed statement %d: %s", serr.Index, serr.Statement)
+	}
+	if count := selectInt(dbmap, "select count(*) from multi_test"); count != 0 {
+		t.Errorf("statements after the failed one were run")
+	}
+
+	err = dbmap.ExecMulti([]string{
+		"insert into multi_test values (1)",
+		"insert into multi_test values (2)",
+	})
 	if err != nil {
-		t.Errorf("Failed to exec: %s", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	if count != 1 {
-		t.Errorf("Expected 1 persistentuser to be deleted, but %d deleted", count)
+	if count := selectInt(dbmap, "select count(*) from multi_test"); count != 2 {
+		t.Errorf("expected 2 rows, got %d", count)
 	}
 }
 
-// Ensure that the slices containing SQL results are non-nil when the result set is empty.
-func TestReturnsNonNilSlice(t *testing.T) {
+func TestVersionMultipleRows(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
-	noResultsSQL := "select * from invoice_test where id=99999"
-	var r1 []*Invoice
-	_rawselect(dbmap, &r1, noResultsSQL)
-	if r1 == nil {
-		t.Errorf("r1==nil")
+
+	persons := []*Person{
+		&Person{0, 0, 0, "Bob", "Smith", 0},
+		&Person{0, 0, 0, "Jane", "Smith", 0},
+		&Person{0, 0, 0, "Mike", "Smith", 0},
 	}
 
-	r2 := _rawselect(dbmap, Invoice{}, noResultsSQL)
-	if r2 == nil {
-		t.Errorf("r2==nil")
+	_insert(dbmap, persons[0], persons[1], persons[2])
+
+	for x, p := range persons {
+		if p.Version != 1 {
+			t.Errorf("person[%d].Version != 1: %d", x, p.Version)
+		}
 	}
 }
 
-func TestOverrideVersionCol(t *testing.T) {
+func TestWithStringPk(t *testing.T) {
 	dbmap := newDbMap()
-	t1 := dbmap.AddTable(InvoicePersonView{}).SetKeys(false, "InvoiceId", "PersonId")
-	err := dbmap.CreateTables()
+	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(true, "Id")
+	_, err := dbmap.Exec("create table string_pk_test (Id varchar(255), Name varchar(255));")
+	if err != nil {
+		t.Errorf("couldn't create string_pk_test: %v", err)
+	}
+	defer dropAndClose(dbmap)
+
+	row := &WithStringPk{"1", "foo"}
+	err = dbmap.Insert(row)
+	if err == nil {
+		t.Errorf("Expected error when inserting into table w/non Int PK and autoincr set true")
+	}
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() interface{} {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func TestKeyGenerator(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	table := m.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(false, "Id").SetKeyGenerator(newUUID)
+	row := &WithStringPk{Name: "foo"}
+	_, args, err := m.InsertSQL(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := args[0].(string); len(id) != 36 || row.Id != "" {
+		t.Errorf("args %v, id %q", args, row.Id)
+	}
+	table.SetKeyGenerator(func() interface{} { return 1 })
+	if _, _, err := m.InsertSQL(&WithStringPk{Name: "foo"}); err == nil {
+		t.Errorf("expected error for key of wrong type")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic for auto-increment key")
+			}
+		}()
+		m.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id").SetKeyGenerator(newUUID)
+	}()
+
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(false, "Id").SetKeyGenerator(newUUID)
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
 	defer dropAndClose(dbmap)
-	c1 := t1.SetVersionCol("LegacyVersion")
-	if c1.ColumnName != "LegacyVersion" {
-		t.Errorf("Wrong col returned: %v", c1)
+
+	row = &WithStringPk{Name: "foo"}
+	preset := &WithStringPk{Id: "preset", Name: "bar"}
+	_insert(dbmap, row, preset)
+	if len(row.Id) != 36 || preset.Id != "preset" {
+		t.Errorf("ids %q, %q", row.Id, preset.Id)
+	}
+	obj, err := dbmap.Get(WithStringPk{}, row.Id)
+	if err != nil || obj == nil || obj.(*WithStringPk).Name != "foo" {
+		t.Errorf("Get(%q) = %v, %v", row.Id, obj, err)
 	}
+}
 
-	ipv := &InvoicePersonView{1, 2, "memo", "fname", 0}
-	_update(dbmap, ipv)
-	if ipv.LegacyVersion != 1 {
-		t.Errorf("LegacyVersion not updated: %d", ipv.LegacyVersion)
+// TestSqlExecutorInterfaceSelects ensures that all DbMap methods starting with Select...
+// are also exposed in the SqlExecutor interface. Select...  functions can always
+// run on Pre/Post hooks.
+func TestSqlExecutorInterfaceSelects(t *testing.T) {
+	dbMapType := reflect.TypeOf(&DbMap{})
+	sqlExecutorType := reflect.TypeOf((*SqlExecutor)(nil)).Elem()
+	numDbMapMethods := dbMapType.NumMethod()
+	for i := 0; i < numDbMapMethods; i += 1 {
+		dbMapMethod := dbMapType.Method(i)
+		if !strings.HasPrefix(dbMapMethod.Name, "Select") {
+			continue
+		}
+		if _, found := sqlExecutorType.MethodByName(dbMapMethod.Name); !found {
+			t.Errorf("Method %s is defined on DbMap but not implemented in SqlExecutor",
+				dbMapMethod.Name)
+		}
 	}
 }
 
-func TestOptimisticLocking(t *testing.T) {
+func TestNullTime(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	p1 := &Person{0, 0, 0, "Bob", "Smith", 0}
-	dbmap.Insert(p1) // Version is now 1
-	if p1.Version != 1 {
-		t.Errorf("Insert didn't incr Version: %d != %d", 1, p1.Version)
-		return
+	// if time is null
+	ent := &WithNullTime{
+		Id: 0,
+		Time: NullTime{
+			Valid: false,
+		}}
+	err := dbmap.Insert(ent)
+	if err != nil {
+		t.Error("failed insert on %s", err.Error())
 	}
-	if p1.Id == 0 {
-		t.Errorf("Insert didn't return a generated PK")
-		return
+	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
+		"Id": ent.Id,
+	})
+	if err != nil {
+		t.Error("failed select on %s", err.Error())
+	}
+	if ent.Time.Valid {
+		t.Error("NullTime returns valid but expected null.")
 	}
 
-	obj, err := dbmap.Get(Person{}, p1.Id)
+	// if time is not null
+	ts, err := time.Parse(time.Stamp, "Jan 2 15:04:05")
+	ent = &WithNullTime{
+		Id: 1,
+		Time: NullTime{
+			Valid: true,
+			Time:  ts,
+		}}
+	err = dbmap.Insert(ent)
 	if err != nil {
-		panic(err)
+		t.Error("failed insert on %s", err.Error())
 	}
-	p2 := obj.(*Person)
-	p2.LName = "Edwards"
-	dbmap.Update(p2) // Version is now 2
-	if p2.Version != 2 {
-		t.Errorf("Update didn't incr Version: %d != %d", 2, p2.Version)
+	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
+		"Id": ent.Id,
+	})
+	if err != nil {
+		t.Error("failed select on %s", err.Error())
 	}
-
-	p1.LName = "Howard"
-	count, err := dbmap.Update(p1)
-	if _, ok := err.(OptimisticLockError); !ok {
-		t.Errorf("update - Expected OptimisticLockError, got: %v", err)
+	if !ent.Time.Valid {
+		t.Error("NullTime returns invalid but expected valid.")
 	}
-	if count != -1 {
-		t.Errorf("update - Expected -1 count, got: %d", count)
+	if ent.Time.Time.UTC() != ts.UTC() {
+		t.Errorf("expect %v but got %v.", ts, ent.Time.Time)
 	}
 
-	count, err = dbmap.Delete(p1)
-	if _, ok := err.(OptimisticLockError); !ok {
-		t.Errorf("delete - Expected OptimisticLockError, got: %v", err)
+	return
+}
+
+type WithTime struct {
+	Id   int64
+	Time time.Time
+}
+
+type Times struct {
+	One time.Time
+	Two time.Time
+}
+
+type EmbeddedTime struct {
+	Id string
+	Times
+}
+
+func parseTimeOrPanic(format, date string) time.Time {
+	t1, err := time.Parse(format, date)
+	if err != nil {
+		panic(err)
 	}
-	if count != -1 {
-		t.Errorf("delete - Expected -1 count, got: %d", count)
+	return t1
+}
+
+// TODO: re-enable next two tests when this is merged:
+// https://github.com/ziutek/mymysql/pull/77
+//
+// This test currently fails w/MySQL b/c tz info is lost
+func testWithTime(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	t1 := parseTimeOrPanic("2006-01-02 15:04:05 -0700 MST",
+		"2013-08-09 21:30:43 +0800 CST")
+	w1 := WithTime{1, t1}
+	_insert(dbmap, &w1)
+
+	obj := _get(dbmap, WithTime{}, w1.Id)
+	w2 := obj.(*WithTime)
+	if w1.Time.UnixNano() != w2.Time.UnixNano() {
+		t.Errorf("%v != %v", w1, w2)
 	}
 }
 
-// what happens if a legacy table has a null value?
-func TestDoubleAddTable(t *testing.T) {
+// See: https://github.com/go-gorp/gorp/issues/86
+func testEmbeddedTime(t *testing.T) {
 	dbmap := newDbMap()
-	t1 := dbmap.AddTable(TableWithNull{}).SetKeys(false, "Id")
-	t2 := dbmap.AddTable(TableWithNull{})
-	if t1 != t2 {
+	dbmap.AddTable(EmbeddedTime{}).SetKeys(false, "Id")
+	defer dropAndClose(dbmap)
+	err := dbmap.CreateTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time1 := parseTimeOrPanic("2006-01-02 15:04:05", "2013-08-09 21:30:43")
+
+	t1 := &EmbeddedTime{Id: "abc", Times: Times{One: time1, Two: time1.Add(10 * time.Second)}}
+	_insert(dbmap, t1)
+
+	x := _get(dbmap, EmbeddedTime{}, t1.Id)
+	t2, _ := x.(*EmbeddedTime)
+	if t1.One.UnixNano() != t2.One.UnixNano() || t1.Two.UnixNano() != t2.Two.UnixNano() {
 		t.Errorf("%v != %v", t1, t2)
 	}
 }
 
-// what happens if a legacy table has a null value?
-func TestNullValues(t *testing.T) {
-	dbmap := initDbMapNulls()
+func TestWithTimeSelect(t *testing.T) {
+	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	// insert a row directly
-	_rawexec(dbmap, "insert into TableWithNull values (10, null, "+
-		"null, null, null, null)")
+	halfhourago := time.Now().UTC().Add(-30 * time.Minute)
 
-	// try to load it
-	expected := &TableWithNull{Id: 10}
-	obj := _get(dbmap, TableWithNull{}, 10)
-	t1 := obj.(*TableWithNull)
-	if !reflect.DeepEqual(expected, t1) {
-		t.Errorf("%v != %v", expected, t1)
-	}
+	w1 := WithTime{1, halfhourago.Add(time.Minute * -1)}
+	w2 := WithTime{2, halfhourago.Add(time.Second)}
+	_insert(dbmap, &w1, &w2)
 
-	// update it
-	t1.Str = sql.NullString{"hi", true}
-	expected.Str = t1.Str
-	t1.Int64 = sql.NullInt64{999, true}
-	expected.Int64 = t1.Int64
-	t1.Float64 = sql.NullFloat64{53.33, true}
-	expected.Float64 = t1.Float64
-	t1.Bool = sql.NullBool{true, true}
-	expected.Bool = t1.Bool
-	t1.Bytes = []byte{1, 30, 31, 33}
-	expected.Bytes = t1.Bytes
-	_update(dbmap, t1)
+	var caseIds []int64
+	_, err := dbmap.Select(&caseIds, "SELECT id FROM time_test WHERE Time < "+dbmap.Dialect.BindVar(0), halfhourago)
 
-	obj = _get(dbmap, TableWithNull{}, 10)
-	t1 = obj.(*TableWithNull)
-	if t1.Str.String != "hi" {
-		t.Errorf("%s != hi", t1.Str.String)
+	if err != nil {
+		t.Error(err)
 	}
-	if !reflect.DeepEqual(expected, t1) {
-		t.Errorf("%v != %v", expected, t1)
+	if len(caseIds) != 1 {
+		t.Errorf("%d != 1", len(caseIds))
+	}
+	if caseIds[0] != w1.Id {
+		t.Errorf("%d != %d", caseIds[0], w1.Id)
 	}
 }
 
-func TestScannerValuer(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.AddTableWithName(PersonValuerScanner{}, "person_test").SetKeys(true, "Id")
-	dbmap.AddTableWithName(InvoiceWithValuer{}, "invoice_test").SetKeys(true, "Id")
-	err := dbmap.CreateTables()
-	if err != nil {
-		panic(err)
-	}
+func TestInvoicePersonView(t *testing.T) {
+	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	pv := PersonValuerScanner{}
-	pv.FName = "foo"
-	pv.LName = "bar"
-	err = dbmap.Insert(&pv)
+	// Create some rows
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	dbmap.Insert(p1)
+
+	// notice how we can wire up p1.Id to the invoice easily
+	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, false}
+	dbmap.Insert(inv1)
+
+	// Run your query
+	query := "select i.Id InvoiceId, p.Id PersonId, i.Memo, p.FName " +
+		"from invoice_test i, person_test p " +
+		"where i.PersonId = p.Id"
+
+	// pass a slice of pointers to Select()
+	// this avoids the need to type assert after the query is run
+	var list []*InvoicePersonView
+	_, err := dbmap.Select(&list, query)
 	if err != nil {
-		t.Errorf("Could not insert PersonValuerScanner using Person table: %v", err)
-		t.FailNow()
+		panic(err)
 	}
 
-	inv := InvoiceWithValuer{}
-	inv.Memo = "foo"
-	inv.Person = pv
-	err = dbmap.Insert(&inv)
-	if err != nil {
-		t.Errorf("Could not insert InvoiceWithValuer using Invoice table: %v", err)
-		t.FailNow()
+	// this should test true
+	expected := &InvoicePersonView{inv1.Id, p1.Id, inv1.Memo, p1.FName, 0}
+	if !reflect.DeepEqual(list[0], expected) {
+		t.Errorf("%v != %v", list[0], expected)
 	}
+}
 
-	res, err := dbmap.Get(InvoiceWithValuer{}, inv.Id)
+func TestCryptoTransform(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	table := m.AddTableWithName(Secret{}, "secret_test").SetKeys(true, "Id")
+	table.ColMap("Card").SetCryptoTransform(xorCipher, xorCipher)
+	want := `create table "secret_test" ("id" bigserial not null primary key , "name" varchar(255), "card" bytea) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	_, args, err := m.InsertSQL(&Secret{Name: "bob", Card: "4111"})
 	if err != nil {
-		t.Errorf("Could not get InvoiceWithValuer: %v", err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	dbInv := res.(*InvoiceWithValuer)
-
-	if dbInv.Person.Id != pv.Id {
-		t.Errorf("InvoiceWithValuer got wrong person ID: %d (expected) != %d (actual)", pv.Id, dbInv.Person.Id)
+	cipher, _ := xorCipher([]byte("4111"))
+	if !reflect.DeepEqual(args, []interface{}{"bob", cipher}) {
+		t.Errorf("args %v", args)
 	}
-}
 
-func TestColumnProps(t *testing.T) {
 	dbmap := newDbMap()
-	t1 := dbmap.AddTable(Invoice{}).SetKeys(true, "Id")
-	t1.ColMap("Created").Rename("date_created")
-	t1.ColMap("Updated").SetTransient(true)
-	t1.ColMap("Memo").SetMaxSize(10)
-	t1.ColMap("PersonId").SetUnique(true)
-
-	err := dbmap.CreateTables()
+	dbmap.AddTableWithName(Secret{}, "secret_test").SetKeys(true, "Id").
+		ColMap("Card").SetCryptoTransform(xorCipher, xorCipher)
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
 	defer dropAndClose(dbmap)
 
-	// test transient
-	inv := &Invoice{0, 0, 1, "my invoice", 0, true}
-	_insert(dbmap, inv)
-	obj := _get(dbmap, Invoice{}, inv.Id)
-	inv = obj.(*Invoice)
-	if inv.Updated != 0 {
-		t.Errorf("Saved transient column 'Updated'")
-	}
+	s := &Secret{Name: "bob", Card: "4111 1111 1111 1111"}
+	_insert(dbmap, s)
 
-	// test max size
-	inv.Memo = "this memo is too long"
-	err = dbmap.Insert(inv)
-	if err == nil {
-		t.Errorf("max size exceeded, but Insert did not fail.")
+	// the database holds the ciphertext
+	var stored []byte
+	if err = dbmap.SelectRow("select card from secret_test").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if cipher, _ := xorCipher([]byte(s.Card)); !bytes.Equal(stored, cipher) {
+		t.Errorf("stored %q", stored)
 	}
 
-	// test unique - same person id
-	inv = &Invoice{0, 0, 1, "my invoice2", 0, false}
-	err = dbmap.Insert(inv)
-	if err == nil {
-		t.Errorf("same PersonId inserted, but Insert did not fail.")
+	obj, err := dbmap.Get(Secret{}, s.Id)
+	if err != nil || obj == nil || obj.(*Secret).Card != s.Card {
+		t.Errorf("Get = %v, %v", obj, err)
+	}
+	var list []*Secret
+	if _, err = dbmap.Select(&list, "select * from secret_test"); err != nil || len(list) != 1 || list[0].Card != s.Card {
+		t.Errorf("Select = %v, %v", list, err)
 	}
 }
 
-func TestRawSelect(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
-
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	_insert(dbmap, p1)
-
-	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, true}
-	_insert(dbmap, inv1)
-
-	expected := &InvoicePersonView{inv1.Id, p1.Id, inv1.Memo, p1.FName, 0}
+func TestRefresh(t *testing.T) {
+	drv := &execTestDriver{columns: []string{"Id", "Created"}, row: []driver.Value{int64(1), int64(20)}}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	m := &DbMap{Db: db, Dialect: SqliteDialect{}}
+	m.AddTableWithName(IdCreatedExternal{}, "id_created_test").SetKeys(true, "Id").
+		ColMap("External").SetTransient(true)
 
-	query := "select i.Id InvoiceId, p.Id PersonId, i.Memo, p.FName " +
-		"from invoice_test i, person_test p " +
-		"where i.PersonId = p.Id"
-	list := _rawselect(dbmap, InvoicePersonView{}, query)
-	if len(list) != 1 {
-		t.Errorf("len(list) != 1: %d", len(list))
-	} else if !reflect.DeepEqual(expected, list[0]) {
-		t.Errorf("%v != %v", expected, list[0])
+	obj := &IdCreatedExternal{IdCreated: IdCreated{Id: 1, Created: 3}, External: 7}
+	if err = m.Refresh(obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Created != 20 || obj.External != 7 {
+		t.Errorf("refreshed %+v", obj)
+	}
+	want := []string{`select "Id","Created" from "id_created_test" where "Id"=?;`}
+	if !reflect.DeepEqual(drv.queried, want) {
+		t.Errorf("queried %q", drv.queried)
+	}
+	drv.err = errors.New("boom")
+	if err = m.Refresh(obj); err == nil || obj.Created != 20 {
+		t.Errorf("Refresh = %v, %+v", err, obj)
+	}
+	if err = m.Refresh(IdCreatedExternal{}); err == nil {
+		t.Errorf("expected error for non pointer")
 	}
-}
 
-func TestHooks(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
-
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	_insert(dbmap, p1)
-	if p1.Created == 0 || p1.Updated == 0 {
-		t.Errorf("p1.PreInsert() didn't run: %v", p1)
-	} else if p1.LName != "postinsert" {
-		t.Errorf("p1.PostInsert() didn't run: %v", p1)
+	p := &Person{FName: "bob", LName: "smith"}
+	_insert(dbmap, p)
+	_, err = dbmap.Exec("update person_test set FName = 'robert' where Id = "+dbmap.Dialect.BindVar(0), p.Id)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	obj := _get(dbmap, Person{}, p1.Id)
-	p1 = obj.(*Person)
-	if p1.LName != "postget" {
-		t.Errorf("p1.PostGet() didn't run: %v", p1)
+	if err = dbmap.Refresh(p); err != nil {
+		t.Fatal(err)
 	}
-
-	_update(dbmap, p1)
-	if p1.FName != "preupdate" {
-		t.Errorf("p1.PreUpdate() didn't run: %v", p1)
-	} else if p1.LName != "postupdate" {
-		t.Errorf("p1.PostUpdate() didn't run: %v", p1)
+	if p.FName != "robert" || p.LName != "postget" {
+		t.Errorf("refreshed %+v", p)
 	}
 
-	var persons []*Person
-	bindVar := dbmap.Dialect.BindVar(0)
-	_rawselect(dbmap, &persons, "select * from person_test where id = "+bindVar, p1.Id)
-	if persons[0].LName != "postget" {
-		t.Errorf("p1.PostGet() didn't run after select: %v", p1)
+	_del(dbmap, p)
+	if err = dbmap.Refresh(p); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Refresh of deleted row = %v", err)
 	}
+}
 
-	_del(dbmap, p1)
-	if p1.FName != "predelete" {
-		t.Errorf("p1.PreDelete() didn't run: %v", p1)
-	} else if p1.LName != "postdelete" {
-		t.Errorf("p1.PostDelete() didn't run: %v", p1)
+func TestRawSQLType(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	table := m.AddTableWithName(Document{}, "document_test").SetKeys(true, "Id")
+	if col := table.ColMap("Search"); col.RawSQLType != "tsvector" || col.DbType != "" {
+		t.Errorf("Search RawSQLType %q, DbType %q", col.RawSQLType, col.DbType)
 	}
-
-	// Test error case
-	p2 := &Person{0, 0, 0, "badname", "", 0}
-	err := dbmap.Insert(p2)
-	if err == nil {
-		t.Errorf("p2.PreInsert() didn't return an error")
+	want := `create table "document_test" ("id" bigserial not null primary key , "title" varchar(100), "search" tsvector) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
 	}
-}
-
-func TestTransaction(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
-
-	inv1 := &Invoice{0, 100, 200, "t1", 0, true}
-	inv2 := &Invoice{0, 100, 200, "t2", 0, false}
 
-	trans, err := dbmap.Begin()
-	if err != nil {
-		panic(err)
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("tsvector columns are only supported by PostgreSQL")
 	}
-	trans.Insert(inv1, inv2)
-	err = trans.Commit()
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Document{}, "document_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
 	if err != nil {
 		panic(err)
 	}
-
-	obj, err := dbmap.Get(Invoice{}, inv1.Id)
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
-	if !reflect.DeepEqual(inv1, obj) {
-		t.Errorf("%v != %v", inv1, obj)
-	}
-	obj, err = dbmap.Get(Invoice{}, inv2.Id)
+	defer dropAndClose(dbmap)
+
+	_insert(dbmap, &Document{Title: "fox", Search: "quick brown fox"}, &Document{Title: "dog", Search: "lazy dog"})
+	var docs []*Document
+	_, err = dbmap.Select(&docs, "select * from document_test where search @@ to_tsquery('fox')")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(inv2, obj) {
-		t.Errorf("%v != %v", inv2, obj)
+	if len(docs) != 1 || docs[0].Title != "fox" || docs[0].Search != "'brown' 'fox' 'quick'" {
+		t.Errorf("docs %+v", docs)
 	}
 }
 
-func TestSavepoint(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
-
-	inv1 := &Invoice{0, 100, 200, "unpaid", 0, false}
+func TestNullSentinel(t *testing.T) {
+	legacy := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	mapLegacy := func(m *DbMap) *TableMap {
+		table := m.AddTableWithName(LegacyRow{}, "legacy_test").SetKeys(true, "Id")
+		table.ColMap("Count").SetNullSentinel(-1)
+		table.ColMap("Closed").SetNullSentinel(legacy)
+		table.ColMap("Note").SetNullSentinel("")
+		return table
+	}
 
-	trans, err := dbmap.Begin()
+	m := &DbMap{Dialect: PostgresDialect{}}
+	table := mapLegacy(m)
+	_, args, err := m.InsertSQL(&LegacyRow{})
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	trans.Insert(inv1)
-
-	var checkMemo = func(want string) {
-		memo, err := trans.SelectStr("select memo from invoice_test")
-		if err != nil {
-			panic(err)
-		}
-		if memo != want {
-			t.Errorf("%q != %q", want, memo)
-		}
+	if !reflect.DeepEqual(args, []interface{}{-1, legacy, ""}) {
+		t.Errorf("args %v", args)
 	}
-	checkMemo("unpaid")
-
-	err = trans.Savepoint("foo")
+	note := "x"
+	closed := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	_, args, err = m.InsertSQL(&LegacyRow{Count: 3, Closed: closed, Note: &note})
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(3), closed, "x"}) {
+		t.Errorf("args %v", args)
 	}
-	checkMemo("unpaid")
 
-	inv1.Memo = "paid"
-	_, err = trans.Update(inv1)
+	// a sentinel scanned in another time zone is still the sentinel
+	conv, err := table.converterFor("Closed")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	var got time.Time
+	scanner, ok := conv.FromDb(&got)
+	if !ok {
+		t.Fatal("no scanner for Closed")
+	}
+	*scanner.Holder.(*time.Time) = legacy.In(time.FixedZone("x", 3600))
+	if err = scanner.Bind(); err != nil || !got.IsZero() {
+		t.Errorf("Closed = %v, %v", got, err)
 	}
-	checkMemo("paid")
 
-	err = trans.RollbackToSavepoint("foo")
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic for a string sentinel of an int field")
+			}
+		}()
+		table.ColMap("Count").SetNullSentinel("none")
+	}()
+
+	dbmap := newDbMap()
+	mapLegacy(dbmap)
+	err = dbmap.DropTablesIfExists()
 	if err != nil {
 		panic(err)
 	}
-	checkMemo("unpaid")
-
-	err = trans.Rollback()
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
-}
-
-func TestMultiple(t *testing.T) {
-	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	inv1 := &Invoice{0, 100, 200, "a", 0, false}
-	inv2 := &Invoice{0, 100, 200, "b", 0, true}
-	_insert(dbmap, inv1, inv2)
+	empty := &LegacyRow{}
+	full := &LegacyRow{Count: 3, Closed: closed, Note: &note}
+	_insert(dbmap, empty, full)
 
-	inv1.Memo = "c"
-	inv2.Memo = "d"
-	_update(dbmap, inv1, inv2)
+	// the database holds the sentinels
+	count, err := dbmap.SelectInt("select count(*) from legacy_test where " +
+		dbmap.Dialect.QuoteField("Count") + " = -1 and " + dbmap.Dialect.QuoteField("Note") + " = ''")
+	if err != nil || count != 1 {
+		t.Errorf("sentinel rows = %d, %v", count, err)
+	}
 
-	count := _del(dbmap, inv1, inv2)
-	if count != 2 {
-		t.Errorf("%d != 2", count)
+	obj := _get(dbmap, LegacyRow{}, empty.Id).(*LegacyRow)
+	if obj.Count != 0 || !obj.Closed.IsZero() || obj.Note != nil {
+		t.Errorf("empty row read as %+v", obj)
+	}
+	obj = _get(dbmap, LegacyRow{}, full.Id).(*LegacyRow)
+	if obj.Count != 3 || !obj.Closed.Equal(closed) || obj.Note == nil || *obj.Note != "x" {
+		t.Errorf("full row read as %+v", obj)
 	}
 }
 
-func TestCrud(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
-
-	inv := &Invoice{0, 100, 200, "first order", 0, true}
-	testCrudInternal(t, dbmap, inv)
+func TestUpsert(t *testing.T) {
+	drv := &execTestDriver{}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	m := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	m.AddTableWithName(Subscriber{}, "subscriber_test").SetKeys(false, "Id")
 
-	invtag := &InvoiceTag{0, 300, 400, "some order", 33, false}
-	testCrudInternal(t, dbmap, invtag)
+	target := ConflictTarget{Columns: []string{"Email"}, Where: "deleted = false"}
+	if err = m.Upsert(target, &Subscriber{1, "bob@example.com", "Bob", false}); err != nil {
+		t.Fatal(err)
+	}
+	want := `insert into "subscriber_test" ("id","email","name","deleted") values ($1,$2,$3,$4) on conflict ("email") where deleted = false do update set "name" = excluded."name", "deleted" = excluded."deleted";`
+	if len(drv.queries) != 1 || drv.queries[0] != want {
+		t.Errorf("\n got: %q\nwant: %s", drv.queries, want)
+	}
+	changed := ConflictTarget{Columns: []string{"Email"}, UpdateWhere: "subscriber_test.name <> excluded.name"}
+	if err = m.Upsert(changed, &Subscriber{1, "bob@example.com", "Bob", false}); err != nil {
+		t.Fatal(err)
+	}
+	want = `insert into "subscriber_test" ("id","email","name","deleted") values ($1,$2,$3,$4) on conflict ("email") do update set "name" = excluded."name", "deleted" = excluded."deleted" where subscriber_test.name <> excluded.name;`
+	if got := drv.queries[len(drv.queries)-1]; got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	if err = m.Upsert(ConflictTarget{Columns: []string{"NoSuchField"}}, &Subscriber{}); err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+	if err = m.Insert(&Subscriber{2, "eve@example.com", "Eve", false}); err != nil || strings.Contains(drv.queries[len(drv.queries)-1], "conflict") {
+		t.Errorf("the insert plan has the conflict clause: %v", err)
+	}
+	other := &DbMap{Dialect: MySQLDialect{"InnoDB", "UTF8"}}
+	other.AddTableWithName(Subscriber{}, "subscriber_test").SetKeys(true, "Id")
+	if err = other.Upsert(target, &Subscriber{}); err == nil {
+		t.Errorf("expected error for MySQLDialect")
+	}
 
-	foo := &AliasTransientField{BarStr: "some bar"}
-	testCrudInternal(t, dbmap, foo)
-}
+	if _, ok := dialectFromEnv().(PostgresDialect); !ok {
+		t.Skip("upserts are only tested with postgres")
+	}
 
-func testCrudInternal(t *testing.T, dbmap *DbMap, val testable) {
-	table, _, err := dbmap.tableForPointer(val, false)
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Subscriber{}, "subscriber_test").SetKeys(true, "Id")
+	err = dbmap.DropTablesIfExists()
 	if err != nil {
-		t.Errorf("couldn't call TableFor: val=%v err=%v", val, err)
+		panic(err)
 	}
-
-	_, err = dbmap.Exec("delete from " + table.TableName)
+	err = dbmap.CreateTables()
 	if err != nil {
-		t.Errorf("couldn't delete rows from: val=%v err=%v", val, err)
+		panic(err)
 	}
+	defer dropAndClose(dbmap)
+	_rawexec(dbmap, "create unique index subscriber_email on subscriber_test (email) where deleted = false")
 
-	// INSERT row
-	_insert(dbmap, val)
-	if val.GetId() == 0 {
-		t.Errorf("val.GetId() was not set on INSERT")
-		return
+	deleted := &Subscriber{0, "bob@example.com", "Old Bob", true}
+	bob := &Subscriber{0, "bob@example.com", "Bob", false}
+	_insert(dbmap, deleted, bob)
+
+	// the deleted row is not in the partial index and stays untouched
+	again := &Subscriber{0, "bob@example.com", "Robert", false}
+	if err = dbmap.Upsert(target, again); err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != bob.Id {
+		t.Errorf("upserted id %d != %d", again.Id, bob.Id)
+	}
+	if n := selectInt(dbmap, "select count(*) from subscriber_test"); n != 2 {
+		t.Errorf("%d rows", n)
+	}
+	obj, err := dbmap.Get(Subscriber{}, bob.Id)
+	if err != nil || obj.(*Subscriber).Name != "Robert" {
+		t.Errorf("Get = %v, %v", obj, err)
+	}
+	obj, err = dbmap.Get(Subscriber{}, deleted.Id)
+	if err != nil || obj.(*Subscriber).Name != "Old Bob" {
+		t.Errorf("Get = %v, %v", obj, err)
 	}
 
-	// SELECT row
-	val2 := _get(dbmap, val, val.GetId())
-	if !reflect.DeepEqual(val, val2) {
-		t.Errorf("%v != %v", val, val2)
+	// the update is skipped if the name is unchanged
+	same := &Subscriber{0, "bob@example.com", "Robert", true}
+	changed.Where = "deleted = false"
+	if err = dbmap.Upsert(changed, same); err != nil {
+		t.Fatal(err)
+	}
+	if same.Id != 0 {
+		t.Errorf("skipped upsert set id %d", same.Id)
+	}
+	obj, err = dbmap.Get(Subscriber{}, bob.Id)
+	if err != nil || obj.(*Subscriber).Deleted {
+		t.Errorf("Get = %v, %v", obj, err)
 	}
+}
 
-	// UPDATE row and SELECT
-	val.Rand()
-	count := _update(dbmap, val)
-	if count != 1 {
-		t.Errorf("update 1 != %d", count)
+func TestIntEnum(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	m.RegisterIntEnum(reflect.TypeOf(Red), int64(Red), int64(Blue))
+	table := m.AddTableWithName(Paint{}, "paint_test").SetKeys(true, "Id")
+	want := `create table "paint_test" ("id" bigserial not null primary key , "color" integer check ("color" between 0 and 2)) ;`
+	if got := table.SqlForCreate(false); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
 	}
-	val2 = _get(dbmap, val, val.GetId())
-	if !reflect.DeepEqual(val, val2) {
-		t.Errorf("%v != %v", val, val2)
+
+	var c Color
+	scanner := enumScanner(&c, intEnum{0, 2}, false)
+	*scanner.Holder.(*sql.NullInt64) = sql.NullInt64{Int64: 2, Valid: true}
+	if err := scanner.Bind(); err != nil || c != Blue {
+		t.Errorf("Bind = %v, %v", c, err)
+	}
+	*scanner.Holder.(*sql.NullInt64) = sql.NullInt64{Int64: 3, Valid: true}
+	if err := scanner.Bind(); err == nil {
+		t.Errorf("expected error for value out of range")
+	}
+	*scanner.Holder.(*sql.NullInt64) = sql.NullInt64{}
+	if err := scanner.Bind(); err == nil {
+		t.Errorf("expected error for NULL")
 	}
 
-	// Select *
-	rows, err := dbmap.Select(val, "select * from "+table.TableName)
+	dbmap := newDbMap()
+	dbmap.RegisterIntEnum(reflect.TypeOf(Red), int64(Red), int64(Blue))
+	dbmap.AddTableWithName(Paint{}, "paint_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
 	if err != nil {
-		t.Errorf("couldn't select * from %s err=%v", table.TableName, err)
-	} else if len(rows) != 1 {
-		t.Errorf("unexpected row count in %s: %d", table.TableName, len(rows))
-	} else if !reflect.DeepEqual(val, rows[0]) {
-		t.Errorf("select * result: %v != %v", val, rows[0])
+		panic(err)
 	}
-
-	// DELETE row
-	deleted := _del(dbmap, val)
-	if deleted != 1 {
-		t.Errorf("Did not delete row with Id: %d", val.GetId())
-		return
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
 	}
+	defer dropAndClose(dbmap)
 
-	// VERIFY deleted
-	val2 = _get(dbmap, val, val.GetId())
-	if val2 != nil {
-		t.Errorf("Found invoice with id: %d after Delete()", val.GetId())
+	p := &Paint{Color: Green}
+	_insert(dbmap, p)
+	var list []*Paint
+	if _, err = dbmap.Select(&list, "select * from paint_test"); err != nil || len(list) != 1 || list[0].Color != Green {
+		t.Errorf("Select = %v, %v", list, err)
+	}
+	list = nil
+	if _, err = dbmap.Select(&list, "select Id, 7 as Color from paint_test"); err == nil {
+		t.Errorf("expected error for value out of range")
 	}
 }
 
-func TestWithIgnoredColumn(t *testing.T) {
+func TestSelectTag(t *testing.T) {
+	m := &DbMap{Dialect: PostgresDialect{}}
+	if pt := m.ParseTag(reflect.StructTag(`db:"Id, select:invoice_id"`)); pt.SelectAs != "invoice_id" {
+		t.Errorf("SelectAs = %q", pt.SelectAs)
+	}
+	index, err := columnToFieldIndex(m, reflect.TypeOf(InvoiceAliasView{}), []string{"invoice_memo", "Id", "person_name"})
+	if err != nil || !reflect.DeepEqual(index, [][]int{{1}, {0}, {2}}) {
+		t.Errorf("columnToFieldIndex = %v, %v", index, err)
+	}
+
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	ic := &WithIgnoredColumn{-1, 0, 1}
-	_insert(dbmap, ic)
-	expected := &WithIgnoredColumn{0, 1, 1}
-	ic2 := _get(dbmap, WithIgnoredColumn{}, ic.Id).(*WithIgnoredColumn)
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, false}
+	_insert(dbmap, inv1)
 
-	if !reflect.DeepEqual(expected, ic2) {
-		t.Errorf("%v != %v", expected, ic2)
+	query := "select i.Id invoice_id, i.Memo invoice_memo, p.FName person_name " +
+		"from invoice_test i, person_test p " +
+		"where i.PersonId = p.Id"
+	var list []*InvoiceAliasView
+	_, err = dbmap.Select(&list, query)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if _del(dbmap, ic) != 1 {
-		t.Errorf("Did not delete row with Id: %d", ic.Id)
-		return
+	expected := &InvoiceAliasView{inv1.Id, inv1.Memo, p1.FName}
+	if len(list) != 1 || !reflect.DeepEqual(list[0], expected) {
+		t.Errorf("%v != %v", list, expected)
 	}
-	if _get(dbmap, WithIgnoredColumn{}, ic.Id) != nil {
-		t.Errorf("Found id: %d after Delete()", ic.Id)
+}
+
+func TestQuoteTableNames(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	quotedTableName := dbmap.Dialect.QuoteField("person_test")
+
+	// Use a buffer to hold the log to check generated queries
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "gorptest:", log.Lmicroseconds))
+
+	// Create some rows
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	errorTemplate := "Expected quoted table name %v in query but didn't find it"
+
+	// Check if Insert quotes the table name
+	id := dbmap.Insert(p1)
+	if !bytes.Contains(logBuffer.Bytes(), []byte(quotedTableName)) {
+		t.Errorf(errorTemplate, quotedTableName)
+	}
+	logBuffer.Reset()
+
+	// Check if Get quotes the table name
+	dbmap.Get(Person{}, id)
+	if !bytes.Contains(logBuffer.Bytes(), []byte(quotedTableName)) {
+		t.Errorf(errorTemplate, quotedTableName)
 	}
+	logBuffer.Reset()
 }
 
-func TestTypeConversionExample(t *testing.T) {
+func TestSelectTooManyCols(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	p := Person{FName: "Bob", LName: "Smith"}
-	tc := &TypeConversionExample{-1, p, CustomStringType("hi")}
-	_insert(dbmap, tc)
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	p2 := &Person{0, 0, 0, "jane", "doe", 0}
+	_insert(dbmap, p1)
+	_insert(dbmap, p2)
 
-	expected := &TypeConversionExample{1, p, CustomStringType("hi")}
-	tc2 := _get(dbmap, TypeConversionExample{}, tc.Id).(*TypeConversionExample)
-	if !reflect.DeepEqual(expected, tc2) {
-		t.Errorf("tc2 %v != %v", expected, tc2)
+	obj := _get(dbmap, Person{}, p1.Id)
+	p1 = obj.(*Person)
+	obj = _get(dbmap, Person{}, p2.Id)
+	p2 = obj.(*Person)
+
+	params := map[string]interface{}{
+		"Id": p1.Id,
 	}
 
-	tc2.Name = CustomStringType("hi2")
-	tc2.PersonJSON = Person{FName: "Jane", LName: "Doe"}
-	_update(dbmap, tc2)
+	var p3 FNameOnly
+	err := dbmap.SelectOne(&p3, "select * from person_test where Id=:Id", params)
+	if err != nil {
+		if !NonFatalError(err) {
+			t.Error(err)
+		}
+	} else {
+		t.Errorf("Non-fatal error expected")
+	}
 
-	expected = &TypeConversionExample{1, tc2.PersonJSON, CustomStringType("hi2")}
-	tc3 := _get(dbmap, TypeConversionExample{}, tc.Id).(*TypeConversionExample)
-	if !reflect.DeepEqual(expected, tc3) {
-		t.Errorf("tc3 %v != %v", expected, tc3)
+	if p1.FName != p3.FName {
+		t.Errorf("%v != %v", p1.FName, p3.FName)
 	}
 
-	if _del(dbmap, tc) != 1 {
-		t.Errorf("Did not delete row with Id: %d", tc.Id)
+	var pSlice []FNameOnly
+	_, err = dbmap.Select(&pSlice, "select * from person_test order by fname asc")
+	if err != nil {
+		if !NonFatalError(err) {
+			t.Error(err)
+		}
+	} else {
+		t.Errorf("Non-fatal error expected")
 	}
 
+	if p1.FName != pSlice[0].FName {
+		t.Errorf("%v != %v", p1.FName, pSlice[0].FName)
+	}
+	if p2.FName != pSlice[1].FName {
+		t.Errorf("%v != %v", p2.FName, pSlice[1].FName)
+	}
 }
 
-func TestWithEmbeddedStruct(t *testing.T) {
+func TestSelectSingleVal(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	es := &WithEmbeddedStruct{-1, Names{FirstName: "Alice", LastName: "Smith"}}
-	_insert(dbmap, es)
-	expected := &WithEmbeddedStruct{1, Names{FirstName: "Alice", LastName: "Smith"}}
-	es2 := _get(dbmap, WithEmbeddedStruct{}, es.Id).(*WithEmbeddedStruct)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+
+	obj := _get(dbmap, Person{}, p1.Id)
+	p1 = obj.(*Person)
+
+	params := map[string]interface{}{
+		"Id": p1.Id,
 	}
 
-	es2.FirstName = "Bob"
-	expected.FirstName = "Bob"
-	_update(dbmap, es2)
-	es2 = _get(dbmap, WithEmbeddedStruct{}, es.Id).(*WithEmbeddedStruct)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	var p2 Person
+	err := dbmap.SelectOne(&p2, "select * from person_test where Id=:Id", params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(p1, &p2) {
+		t.Errorf("%v != %v", p1, &p2)
 	}
 
-	ess := _rawselect(dbmap, WithEmbeddedStruct{}, "select * from embedded_struct_test")
-	if !reflect.DeepEqual(es2, ess[0]) {
-		t.Errorf("%v != %v", es2, ess[0])
+	// verify SelectOne allows non-struct holders
+	var s string
+	err = dbmap.SelectOne(&s, "select FName from person_test where Id=:Id", params)
+	if err != nil {
+		t.Error(err)
+	}
+	if s != "bob" {
+		t.Error("Expected bob but got: " + s)
 	}
-}
-
-/*
-func TestWithEmbeddedStructConflictingEmbeddedMemberNames(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
 
-	es := &WithEmbeddedStructConflictingEmbeddedMemberNames{-1, Names{FirstName: "Alice", LastName: "Smith"}, NamesConflict{FirstName: "Andrew", Surname: "Wiggin"}}
-	_insert(dbmap, es)
-	expected := &WithEmbeddedStructConflictingEmbeddedMemberNames{-1, Names{FirstName: "Alice", LastName: "Smith"}, NamesConflict{FirstName: "Andrew", Surname: "Wiggin"}}
-	es2 := _get(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, es.Id).(*WithEmbeddedStructConflictingEmbeddedMemberNames)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	// verify SelectOne requires pointer receiver
+	err = dbmap.SelectOne(s, "select FName from person_test where Id=:Id", params)
+	if err == nil {
+		t.Error("SelectOne should have returned error for non-pointer holder")
 	}
 
-	es2.Names.FirstName = "Bob"
-	expected.Names.FirstName = "Bob"
-	_update(dbmap, es2)
-	es2 = _get(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, es.Id).(*WithEmbeddedStructConflictingEmbeddedMemberNames)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	// verify SelectOne works with uninitialized pointers
+	var p3 *Person
+	err = dbmap.SelectOne(&p3, "select * from person_test where Id=:Id", params)
+	if err != nil {
+		t.Error(err)
 	}
 
-	ess := _rawselect(dbmap, WithEmbeddedStructConflictingEmbeddedMemberNames{}, "select * from embedded_struct_conflict_name_test")
-	if !reflect.DeepEqual(es2, ess[0]) {
-		t.Errorf("%v != %v", es2, ess[0])
+	if !reflect.DeepEqual(p1, p3) {
+		t.Errorf("%v != %v", p1, p3)
 	}
-}
 
-func TestWithEmbeddedStructSameMemberName(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
+	// verify that the receiver is still nil if nothing was found
+	var p4 *Person
+	dbmap.SelectOne(&p3, "select * from person_test where 2<1 AND Id=:Id", params)
+	if p4 != nil {
+		t.Error("SelectOne should not have changed a nil receiver when no rows were found")
+	}
 
-	es := &WithEmbeddedStructSameMemberName{-1, SameName{SameName: "Alice"}}
-	_insert(dbmap, es)
-	expected := &WithEmbeddedStructSameMemberName{-1, SameName{SameName: "Alice"}}
-	es2 := _get(dbmap, WithEmbeddedStructSameMemberName{}, es.Id).(*WithEmbeddedStructSameMemberName)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	// verify that the error is set to sql.ErrNoRows if not found
+	err = dbmap.SelectOne(&p2, "select * from person_test where Id=:Id", map[string]interface{}{
+		"Id": -2222,
+	})
+	if err == nil || err != sql.ErrNoRows {
+		t.Error("SelectOne should have returned an sql.ErrNoRows")
 	}
 
-	es2.SameName = SameName{"Bob"}
-	expected.SameName = SameName{"Bob"}
-	_update(dbmap, es2)
-	es2 = _get(dbmap, WithEmbeddedStructSameMemberName{}, es.Id).(*WithEmbeddedStructSameMemberName)
-	if !reflect.DeepEqual(expected, es2) {
-		t.Errorf("%v != %v", expected, es2)
+	_insert(dbmap, &Person{0, 0, 0, "bob", "smith", 0})
+	err = dbmap.SelectOne(&p2, "select * from person_test where Fname='bob'")
+	if err == nil {
+		t.Error("Expected error when two rows found")
 	}
 
-	ess := _rawselect(dbmap, WithEmbeddedStructSameMemberName{}, "select * from embedded_struct_same_member_name_test")
-	if !reflect.DeepEqual(es2, ess[0]) {
-		t.Errorf("%v != %v", es2, ess[0])
+	// tests for #150
+	var tInt int64
+	var tStr string
+	var tBool bool
+	var tFloat float64
+	primVals := []interface{}{tInt, tStr, tBool, tFloat}
+	for _, prim := range primVals {
+		err = dbmap.SelectOne(&prim, "select * from person_test where Id=-123")
+		if err == nil || err != sql.ErrNoRows {
+			t.Error("primVals: SelectOne should have returned sql.ErrNoRows")
+		}
 	}
 }
-//*/
 
-func TestWithEmbeddedStructBeforeAutoincr(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
+func TestSelectJoin(t *testing.T) {
+	drv := &execTestDriver{
+		columns: []string{"i_Id", "i_Memo", "p_Id", "p_FName", "p_LName"},
+		row:     []driver.Value{int64(7), "join memo", int64(3), "bob", "smith"},
+	}
+	connector, err := NewInitConnector(drv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	m := &DbMap{Db: db, Dialect: PostgresDialect{}}
 
-	esba := &WithEmbeddedStructBeforeAutoincrField{Names: Names{FirstName: "Alice", LastName: "Smith"}}
-	_insert(dbmap, esba)
-	var expectedAutoincrId int64 = 1
-	if esba.Id != expectedAutoincrId {
-		t.Errorf("%d != %d", expectedAutoincrId, esba.Id)
+	var inv Invoice
+	var p Person
+	if err = m.SelectJoin([]JoinHolder{{&inv, "i_"}, {&p, "P_"}}, "select ..."); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Id != 7 || inv.Memo != "join memo" || p.Id != 3 || p.FName != "bob" || p.LName != "postget" {
+		t.Errorf("SelectJoin = %+v, %+v", inv, p)
+	}
+	var invs []Invoice
+	var people []*Person
+	if err = m.SelectJoin([]JoinHolder{{&invs, "i_"}, {&people, "p_"}}, "select ..."); err != nil {
+		t.Fatal(err)
+	}
+	if len(invs) != 1 || len(people) != 1 || invs[0].Memo != "join memo" || people[0].FName != "bob" {
+		t.Errorf("SelectJoin = %+v, %+v", invs, people)
+	}
+	if err = m.SelectJoin([]JoinHolder{{&inv, "i_"}}, "select ..."); err == nil {
+		t.Errorf("expected error for columns without holder")
+	}
+	if err = m.SelectJoin([]JoinHolder{{&inv, "i_"}, {&people, "p_"}}, "select ..."); err == nil {
+		t.Errorf("expected error for struct and slice holders")
 	}
-}
 
-func TestWithEmbeddedAutoincr(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	esa := &WithEmbeddedAutoincr{
-		WithEmbeddedStruct: WithEmbeddedStruct{Names: Names{FirstName: "Alice", LastName: "Smith"}},
-		MiddleName:         "Rose",
+	p1 := &Person{0, 0, 0, "alice", "jones", 0}
+	_insert(dbmap, p1)
+	i1 := &Invoice{0, 100, 200, "first order", p1.Id, false}
+	_insert(dbmap, i1)
+
+	var inv2 Invoice
+	var p2 Person
+	err = dbmap.SelectJoin([]JoinHolder{{&inv2, "i_"}, {&p2, "p_"}},
+		"select i.Id as i_Id, i.Memo as i_Memo, i.PersonId as i_PersonId, p.Id as p_Id, p.FName as p_FName"+
+			" from invoice_test i join person_test p on p.Id = i.PersonId where i.Id = :Id",
+		map[string]interface{}{"Id": i1.Id})
+	if err != nil {
+		t.Fatal(err)
 	}
-	_insert(dbmap, esa)
-	var expectedAutoincrId int64 = 1
-	if esa.Id != expectedAutoincrId {
-		t.Errorf("%d != %d", expectedAutoincrId, esa.Id)
+	if inv2.Id != i1.Id || inv2.Memo != "first order" || inv2.PersonId != p1.Id || p2.Id != p1.Id || p2.FName != "alice" {
+		t.Errorf("SelectJoin = %+v, %+v", inv2, p2)
+	}
+	err = dbmap.SelectJoin([]JoinHolder{{&inv2, "i_"}, {&p2, "p_"}},
+		"select i.Id as i_Id, p.Id as p_Id from invoice_test i join person_test p on p.Id = i.PersonId where i.Id = -1")
+	if err != sql.ErrNoRows {
+		t.Errorf("SelectJoin should have returned sql.ErrNoRows, got %v", err)
 	}
 }
 
-func TestSelectVal(t *testing.T) {
-	dbmap := initDbMapNulls()
+func TestSelectOneTo(t *testing.T) {
+	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	bindVar := dbmap.Dialect.BindVar(0)
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
 
-	t1 := TableWithNull{Str: sql.NullString{"abc", true},
-		Int64:   sql.NullInt64{78, true},
-		Float64: sql.NullFloat64{32.2, true},
-		Bool:    sql.NullBool{true, true},
-		Bytes:   []byte("hi")}
-	_insert(dbmap, &t1)
+	obj := _get(dbmap, Person{}, p1.Id)
+	p1 = obj.(*Person)
 
-	// SelectInt
-	i64 := selectInt(dbmap, "select Int64 from TableWithNull where Str='abc'")
-	if i64 != 78 {
-		t.Errorf("int64 %d != 78", i64)
-	}
-	i64 = selectInt(dbmap, "select count(*) from TableWithNull")
-	if i64 != 1 {
-		t.Errorf("int64 count %d != 1", i64)
+	p2 := Person{FName: "stale", Version: 99}
+	err := dbmap.SelectOneTo(&p2, "select * from person_test where Id=:Id", map[string]interface{}{
+		"Id": p1.Id,
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	i64 = selectInt(dbmap, "select count(*) from TableWithNull where Str="+bindVar, "asdfasdf")
-	if i64 != 0 {
-		t.Errorf("int64 no rows %d != 0", i64)
+	if !reflect.DeepEqual(*p1, p2) {
+		t.Errorf("%v != %v", *p1, p2)
 	}
 
-	// SelectNullInt
-	n := selectNullInt(dbmap, "select Int64 from TableWithNull where Str='notfound'")
-	if !reflect.DeepEqual(n, sql.NullInt64{0, false}) {
-		t.Errorf("nullint %v != 0,false", n)
+	// verify that the holder is unchanged if nothing was found
+	p3 := Person{FName: "unchanged"}
+	err = dbmap.SelectOneTo(&p3, "select * from person_test where Id=-2222")
+	if err != sql.ErrNoRows {
+		t.Errorf("SelectOneTo should have returned sql.ErrNoRows, got %v", err)
 	}
-
-	n = selectNullInt(dbmap, "select Int64 from TableWithNull where Str='abc'")
-	if !reflect.DeepEqual(n, sql.NullInt64{78, true}) {
-		t.Errorf("nullint %v != 78, true", n)
+	if p3.FName != "unchanged" {
+		t.Errorf("SelectOneTo changed the holder: %v", p3)
 	}
 
-	// SelectFloat
-	f64 := selectFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
-	if f64 != 32.2 {
-		t.Errorf("float64 %d != 32.2", f64)
-	}
-	f64 = selectFloat(dbmap, "select min(Float64) from TableWithNull")
-	if f64 != 32.2 {
-		t.Errorf("float64 min %d != 32.2", f64)
+	_insert(dbmap, &Person{0, 0, 0, "bob", "jones", 0})
+	err = dbmap.SelectOneTo(&p3, "select * from person_test where FName='bob'")
+	if _, ok := err.(*MultipleRowsError); !ok {
+		t.Errorf("Expected *MultipleRowsError when two rows found, got %v", err)
 	}
-	f64 = selectFloat(dbmap, "select count(*) from TableWithNull where Str="+bindVar, "asdfasdf")
-	if f64 != 0 {
-		t.Errorf("float64 no rows %d != 0", f64)
+	err = dbmap.SelectOne(&p3, "select * from person_test where FName='bob'")
+	if _, ok := err.(*MultipleRowsError); !ok {
+		t.Errorf("SelectOne: expected *MultipleRowsError when two rows found, got %v", err)
 	}
 
-	// SelectNullFloat
-	nf := selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='notfound'")
-	if !reflect.DeepEqual(nf, sql.NullFloat64{0, false}) {
-		t.Errorf("nullfloat %v != 0,false", nf)
+	// verify SelectOneTo requires a struct pointer
+	var s string
+	if err = dbmap.SelectOneTo(&s, "select FName from person_test where Id=?", p1.Id); err == nil {
+		t.Error("SelectOneTo should have returned error for non-struct holder")
 	}
-
-	nf = selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
-	if !reflect.DeepEqual(nf, sql.NullFloat64{32.2, true}) {
-		t.Errorf("nullfloat %v != 32.2, true", nf)
+	if err = dbmap.SelectOneTo(p2, "select * from person_test where Id=?", p1.Id); err == nil {
+		t.Error("SelectOneTo should have returned error for non-pointer holder")
 	}
+}
 
-	// SelectStr
-	s := selectStr(dbmap, "select Str from TableWithNull where Int64="+bindVar, 78)
-	if s != "abc" {
-		t.Errorf("s %s != abc", s)
+func TestScanError(t *testing.T) {
+	err := errors.New(`sql: Scan error on column index 2, name "Price": converting driver.Value type string ("abc") to a int64: invalid syntax`)
+	if x := scanErrorColumn(err); x != 2 {
+		t.Errorf("scanErrorColumn = %d", x)
 	}
-	s = selectStr(dbmap, "select Str from TableWithNull where Str='asdfasdf'")
-	if s != "" {
-		t.Errorf("s no rows %s != ''", s)
+	if x := scanErrorColumn(errors.New("bad connection")); x != -1 {
+		t.Errorf("scanErrorColumn = %d", x)
 	}
 
-	// SelectNullStr
-	ns := selectNullStr(dbmap, "select Str from TableWithNull where Int64="+bindVar, 78)
-	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
-		t.Errorf("nullstr %v != abc,true", ns)
-	}
-	ns = selectNullStr(dbmap, "select Str from TableWithNull where Str='asdfasdf'")
-	if !reflect.DeepEqual(ns, sql.NullString{"", false}) {
-		t.Errorf("nullstr no rows %v != '',false", ns)
-	}
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
 
-	// SelectInt/Str with named parameters
-	i64 = selectInt(dbmap, "select Int64 from TableWithNull where Str=:abc", map[string]string{"abc": "abc"})
-	if i64 != 78 {
-		t.Errorf("int64 %d != 78", i64)
+	_insert(dbmap, &Person{0, 0, 0, "bob", "smith", 0})
+	var list []*Person
+	_, err = dbmap.Select(&list, "select 'abc' as Id, Created, Updated, FName, LName, Version from person_test")
+	se, ok := err.(*ScanError)
+	if !ok {
+		t.Fatalf("expected *ScanError, got %v", err)
 	}
-	ns = selectNullStr(dbmap, "select Str from TableWithNull where Int64=:num", map[string]int{"num": 78})
-	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
-		t.Errorf("nullstr %v != abc,true", ns)
+	if se.TableName != "person_test" || !strings.EqualFold(se.ColumnName, "Id") || se.FieldName != "Id" {
+		t.Errorf("unexpected %#v", se)
+	}
+	if !strings.Contains(err.Error(), "of table person_test into field Id failed") {
+		t.Errorf("unexpected message: %s", err.Error())
 	}
 }
 
-func TestVersionMultipleRows(t *testing.T) {
+func TestSelectDynamicTypes(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
+	dbmap.DynamicTypes = true
 
-	persons := []*Person{
-		&Person{0, 0, 0, "Bob", "Smith", 0},
-		&Person{0, 0, 0, "Jane", "Smith", 0},
-		&Person{0, 0, 0, "Mike", "Smith", 0},
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	_insert(dbmap, p1)
+
+	var list []PersonDynamic
+	_, err := dbmap.Select(&list, "select Id, FName, LName from person_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(list))
 	}
 
-	_insert(dbmap, persons[0], persons[1], persons[2])
+	switch reflect.ValueOf(list[0].Id).Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+	default:
+		t.Errorf("Id: expected an integer, got %T", list[0].Id)
+	}
+	// compare with the inserted values, Person.PostInsert overwrites p1.LName
+	if fname, ok := list[0].FName.(string); !ok || fname != "bob" {
+		t.Errorf("FName: expected string %q, got %#v", "bob", list[0].FName)
+	}
+	if lname, ok := list[0].LName.(string); !ok || lname != "smith" {
+		t.Errorf("LName: expected string %q, got %#v", "smith", list[0].LName)
+	}
+}
 
-	for x, p := range persons {
-		if p.Version != 1 {
-			t.Errorf("person[%d].Version != 1: %d", x, p.Version)
+func TestIdentifierCase(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `"MixedCase"`},
+		{SqliteDialect{IdentifierCase: LowerCase}, `"mixedcase"`},
+		{PostgresDialect{}, `"mixedcase"`},
+		{PostgresDialect{IdentifierCase: PreserveCase}, `"MixedCase"`},
+		{PostgresDialect{IdentifierCase: UpperCase}, `"MIXEDCASE"`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "`MixedCase`"},
+		{SqlServerDialect{}, `[MixedCase]`},
+		{OracleDialect{}, `"MIXEDCASE"`},
+		{OracleDialect{IdentifierCase: PreserveCase}, `"MixedCase"`},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.QuoteField("MixedCase"); got != tt.want {
+			t.Errorf("%#v: %s != %s", tt.dialect, got, tt.want)
 		}
 	}
-}
 
-func TestWithStringPk(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(true, "Id")
-	_, err := dbmap.Exec("create table string_pk_test (Id varchar(255), Name varchar(255));")
-	if err != nil {
-		t.Errorf("couldn't create string_pk_test: %v", err)
+	d := PostgresDialect{IdentifierCase: PreserveCase}
+	if got := d.QuotedTableForQuery("MySchema", "MixedCase"); got != `MySchema."MixedCase"` {
+		t.Errorf("QuotedTableForQuery: %s", got)
 	}
-	defer dropAndClose(dbmap)
+}
 
-	row := &WithStringPk{"1", "foo"}
-	err = dbmap.Insert(row)
-	if err == nil {
-		t.Errorf("Expected error when inserting into table w/non Int PK and autoincr set true")
+func TestTableNameCase(t *testing.T) {
+	tests := []struct {
+		c    IdentifierCase
+		want string
+	}{
+		{DialectCase, "InvoiceTag"},
+		{PreserveCase, "InvoiceTag"},
+		{LowerCase, "invoicetag"},
+		{UpperCase, "INVOICETAG"},
+	}
+	for _, tt := range tests {
+		m := &DbMap{Dialect: PostgresDialect{IdentifierCase: PreserveCase}}
+		m.SetTableNameCase(tt.c)
+		if got := m.AddTable(InvoiceTag{}).TableName; got != tt.want {
+			t.Errorf("%d: %s != %s", tt.c, got, tt.want)
+		}
+		if got := m.AddTableWithName(Person{}, "MixedCase").TableName; got != "MixedCase" {
+			t.Errorf("%d: AddTableWithName: %s", tt.c, got)
+		}
 	}
 }
 
-// TestSqlExecutorInterfaceSelects ensures that all DbMap methods starting with Select...
-// are also exposed in the SqlExecutor interface. Select...  functions can always
-// run on Pre/Post hooks.
-func TestSqlExecutorInterfaceSelects(t *testing.T) {
-	dbMapType := reflect.TypeOf(&DbMap{})
-	sqlExecutorType := reflect.TypeOf((*SqlExecutor)(nil)).Elem()
-	numDbMapMethods := dbMapType.NumMethod()
-	for i := 0; i < numDbMapMethods; i += 1 {
-		dbMapMethod := dbMapType.Method(i)
-		if !strings.HasPrefix(dbMapMethod.Name, "Select") {
+func TestQueryBuilderSQL(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where (PersonId = ?) and (Memo <> ? and Memo <> '?') order by "Created" desc limit 10 offset 5;`},
+		{PostgresDialect{}, `select "id","created","updated","memo","personid","ispaid" from "invoice_test" where (PersonId = $1) and (Memo <> $2 and Memo <> '?') order by "created" desc limit 10 offset 5;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "select `Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid` from `invoice_test` where (PersonId = ?) and (Memo <> ? and Memo <> '?') order by `Created` desc limit 10 offset 5;"},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] where (PersonId = ?) and (Memo <> ? and Memo <> '?') order by [Created] desc offset 5 rows fetch next 10 rows only;`},
+		{OracleDialect{}, `select "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" where (PersonId = :1) and (Memo <> :2 and Memo <> '?') order by "CREATED" desc offset 5 rows fetch next 10 rows only`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		query, args, err := dbmap.Query(Invoice{}).
+			Where("PersonId = ?", 5).
+			Where("Memo <> ? and Memo <> '?'", "x").
+			OrderBy("Created", true).
+			Limit(10).
+			Offset(5).
+			SQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
 			continue
 		}
-		if _, found := sqlExecutorType.MethodByName(dbMapMethod.Name); !found {
-			t.Errorf("Method %s is defined on DbMap but not implemented in SqlExecutor",
-				dbMapMethod.Name)
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{5, "x"}) {
+			t.Errorf("%T: unexpected args %v", tt.dialect, args)
 		}
 	}
 }
 
-func TestNullTime(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
+func TestUpdateKeyless(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Keyless{}, "keyless_test")
+	_, err := dbmap.Update(&Keyless{Code: "A1"})
+	if err == nil || !strings.Contains(err.Error(), "keyless_test") || !strings.Contains(err.Error(), "UpdateWhere") {
+		t.Errorf("Update error = %v", err)
+	}
+	_, err = dbmap.UpdateWhere(&Keyless{}, "code = :a", 1, 2)
+	if err == nil {
+		t.Errorf("UpdateWhere with two args should fail")
+	}
 
-	// if time is null
-	ent := &WithNullTime{
-		Id: 0,
-		Time: NullTime{
-			Valid: false,
-		}}
-	err := dbmap.Insert(ent)
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(Keyless{}, "keyless_test")
+	err = dbmap.DropTablesIfExists()
 	if err != nil {
-		t.Error("failed insert on %s", err.Error())
+		panic(err)
 	}
-	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
-		"Id": ent.Id,
-	})
+	err = dbmap.CreateTables()
 	if err != nil {
-		t.Error("failed select on %s", err.Error())
+		panic(err)
 	}
-	if ent.Time.Valid {
-		t.Error("NullTime returns valid but expected null.")
+	defer dropAndClose(dbmap)
+
+	_insert(dbmap, &Keyless{"A1", "apple", 1}, &Keyless{"B2", "banana", 2})
+	n, err := dbmap.UpdateWhere(&Keyless{"A1", "apricot", 3}, dbmap.Dialect.QuoteField("Code")+" = :Code")
+	if err != nil || n != 1 {
+		t.Fatalf("UpdateWhere = %d, %v", n, err)
+	}
+	n, err = dbmap.UpdateWhere(&Keyless{"C3", "cherry", 4}, dbmap.Dialect.QuoteField("Code")+" = :old",
+		map[string]interface{}{"old": "B2"})
+	if err != nil || n != 1 {
+		t.Fatalf("UpdateWhere = %d, %v", n, err)
 	}
 
-	// if time is not null
-	ts, err := time.Parse(time.Stamp, "Jan 2 15:04:05")
-	ent = &WithNullTime{
-		Id: 1,
-		Time: NullTime{
-			Valid: true,
-			Time:  ts,
-		}}
-	err = dbmap.Insert(ent)
+	var rows []Keyless
+	_, err = dbmap.Select(&rows, "select * from keyless_test order by "+dbmap.Dialect.QuoteField("Code"))
 	if err != nil {
-		t.Error("failed insert on %s", err.Error())
+		t.Fatal(err)
 	}
-	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
-		"Id": ent.Id,
-	})
-	if err != nil {
-		t.Error("failed select on %s", err.Error())
+	want := []Keyless{{"A1", "apricot", 3}, {"C3", "cherry", 4}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
 	}
-	if !ent.Time.Valid {
-		t.Error("NullTime returns invalid but expected valid.")
+}
+
+func TestInsertSQLer(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(HintedInvoice{}, "hinted_test")
+	query, args, err := dbmap.InsertSQL(&HintedInvoice{Memo: "paid"})
+	want := `insert /* hint */ into "hinted_test" ("memo") values ($1) returning "id";`
+	if err != nil || query != want || !reflect.DeepEqual(args, []interface{}{"PAID"}) {
+		t.Errorf("InsertSQL = %s %v, %v\nwant: %s", query, args, err, want)
 	}
-	if ent.Time.Time.UTC() != ts.UTC() {
-		t.Errorf("expect %v but got %v.", ts, ent.Time.Time)
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(HintedInvoice{}, "hinted_test")
+	err = dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
 	}
+	defer dropAndClose(dbmap)
 
-	return
+	row := &HintedInvoice{Memo: "paid"}
+	_insert(dbmap, row)
+	if row.Id == 0 {
+		t.Errorf("Id not set")
+	}
+	obj := _get(dbmap, HintedInvoice{}, row.Id).(*HintedInvoice)
+	if obj.Memo != "PAID" {
+		t.Errorf("Memo = %q", obj.Memo)
+	}
 }
 
-type WithTime struct {
-	Id   int64
-	Time time.Time
-}
+func TestInsertSQLerArgRedactor(t *testing.T) {
+	connector, err := NewInitConnector(&execTestDriver{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	dbmap := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(HintedSecret{}, "hinted_test")
 
-type Times struct {
-	One time.Time
-	Two time.Time
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "", 0))
+	var names []string
+	dbmap.SetArgRedactor(func(colName string, value interface{}) interface{} {
+		names = append(names, colName)
+		if colName == "Secret" {
+			return "***"
+		}
+		return value
+	})
+	if err = dbmap.Insert(&HintedSecret{Secret: "s3cret", Memo: "paid"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{""}) {
+		t.Errorf("column names of the generated insert: %q", names)
+	}
+	if out := logBuffer.String(); !strings.Contains(out, `"paid"`) {
+		t.Errorf("memo is not bound to the secret column: %s", out)
+	}
 }
 
-type EmbeddedTime struct {
-	Id string
-	Times
-}
+func TestGetByExample(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(IdCreated{}, "example_test").SetKeys(false, "Id", "Created")
+	if _, err := dbmap.GetByExample(&IdCreated{}); err == nil {
+		t.Errorf("GetByExample without key should fail")
+	}
 
-func parseTimeOrPanic(format, date string) time.Time {
-	t1, err := time.Parse(format, date)
+	dbmap = initDbMap()
+	defer dropAndClose(dbmap)
+	inv := &Invoice{Created: 100, Memo: "example"}
+	_insert(dbmap, inv)
+	obj, err := dbmap.GetByExample(Invoice{Id: inv.Id})
+	if err != nil || obj == nil || obj.(*Invoice).Memo != "example" {
+		t.Errorf("GetByExample = %v, %v", obj, err)
+	}
+	obj, err = dbmap.GetByExample(&Invoice{Id: inv.Id + 1})
+	if err != nil || obj != nil {
+		t.Errorf("GetByExample of missing row = %v, %v", obj, err)
+	}
+
+	dbmap.AddTableWithName(IdCreated{}, "example_test").SetKeys(false, "Id", "Created")
+	err = dbmap.CreateTablesIfNotExists()
 	if err != nil {
 		panic(err)
 	}
-	return t1
+	_insert(dbmap, &IdCreated{1, 10}, &IdCreated{1, 20}, &IdCreated{2, 10})
+	obj, err = dbmap.GetByExample(&IdCreated{1, 20})
+	if err != nil || !reflect.DeepEqual(obj, &IdCreated{1, 20}) {
+		t.Errorf("GetByExample = %v, %v", obj, err)
+	}
+	obj, err = dbmap.GetByExample(&IdCreated{Id: 2})
+	if err != nil || !reflect.DeepEqual(obj, &IdCreated{2, 10}) {
+		t.Errorf("GetByExample = %v, %v", obj, err)
+	}
+	if _, err = dbmap.GetByExample(&IdCreated{Created: 10}); err == nil {
+		t.Errorf("GetByExample of two rows should fail")
+	}
 }
 
-// TODO: re-enable next two tests when this is merged:
-// https://github.com/ziutek/mymysql/pull/77
-//
-// This test currently fails w/MySQL b/c tz info is lost
-func testWithTime(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
-
-	t1 := parseTimeOrPanic("2006-01-02 15:04:05 -0700 MST",
-		"2013-08-09 21:30:43 +0800 CST")
-	w1 := WithTime{1, t1}
-	_insert(dbmap, &w1)
-
-	obj := _get(dbmap, WithTime{}, w1.Id)
-	w2 := obj.(*WithTime)
-	if w1.Time.UnixNano() != w2.Time.UnixNano() {
-		t.Errorf("%v != %v", w1, w2)
+func TestSelectChan(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	wrong := make(chan *Person)
+	if err := dbmap.SelectChan(Invoice{}, wrong, "select * from invoice_test"); err == nil {
+		t.Errorf("SelectChan into chan *Person should fail")
+	}
+	if _, ok := <-wrong; ok {
+		t.Errorf("channel not closed")
+	}
+	if err := dbmap.SelectChan(Invoice{}, []*Invoice{}, "select * from invoice_test"); err == nil {
+		t.Errorf("SelectChan into a slice should fail")
 	}
-}
 
-// See: https://github.com/go-gorp/gorp/issues/86
-func testEmbeddedTime(t *testing.T) {
-	dbmap := newDbMap()
-	dbmap.AddTable(EmbeddedTime{}).SetKeys(false, "Id")
-	defer dropAndClose(dbmap)
-	err := dbmap.CreateTables()
+	connector, err := NewInitConnector(&execTestDriver{}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	time1 := parseTimeOrPanic("2006-01-02 15:04:05", "2013-08-09 21:30:43")
-
-	t1 := &EmbeddedTime{Id: "abc", Times: Times{One: time1, Two: time1.Add(10 * time.Second)}}
-	_insert(dbmap, t1)
-
-	x := _get(dbmap, EmbeddedTime{}, t1.Id)
-	t2, _ := x.(*EmbeddedTime)
-	if t1.One.UnixNano() != t2.One.UnixNano() || t1.Two.UnixNano() != t2.Two.UnixNano() {
-		t.Errorf("%v != %v", t1, t2)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	fake := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	fake.AddTableWithName(OpenRowsInvoice{}, "invoice_test")
+	hooked := make(chan *OpenRowsInvoice, 1)
+	if err = fake.SelectChan(OpenRowsInvoice{}, hooked, "select id from invoice_test"); err != nil {
+		t.Fatal(err)
+	}
+	if inv := <-hooked; inv == nil || inv.Id != 42 || !inv.Hooked || inv.Open != 0 {
+		t.Errorf("PostGet did not run after the rows were closed: %v", inv)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	unread := make(chan *OpenRowsInvoice)
+	if err = fake.SelectChanContext(ctx, OpenRowsInvoice{}, unread, "select id from invoice_test"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, ok := <-unread; ok {
+		t.Errorf("channel not closed")
 	}
-}
 
-func TestWithTimeSelect(t *testing.T) {
-	dbmap := initDbMap()
+	dbmap = initDbMap()
 	defer dropAndClose(dbmap)
+	_insert(dbmap, &Invoice{Created: 1, Memo: "a"}, &Invoice{Created: 2, Memo: "b"}, &Invoice{Created: 3, Memo: "c"})
 
-	halfhourago := time.Now().UTC().Add(-30 * time.Minute)
-
-	w1 := WithTime{1, halfhourago.Add(time.Minute * -1)}
-	w2 := WithTime{2, halfhourago.Add(time.Second)}
-	_insert(dbmap, &w1, &w2)
+	ch := make(chan *Invoice)
+	errc := make(chan error, 1)
+	go func() { errc <- dbmap.SelectChan(Invoice{}, ch, "select * from invoice_test order by Created") }()
+	var memos []string
+	for inv := range ch {
+		memos = append(memos, inv.Memo)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(memos, []string{"a", "b", "c"}) {
+		t.Errorf("got %v", memos)
+	}
 
-	var caseIds []int64
-	_, err := dbmap.Select(&caseIds, "SELECT id FROM time_test WHERE Time < "+dbmap.Dialect.BindVar(0), halfhourago)
+	values := make(chan Invoice, 3)
+	if err := dbmap.SelectChan(&Invoice{}, values, "select * from invoice_test where Created > 1"); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range values {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("got %d rows", n)
+	}
+}
 
+func TestSelectBatchIter(t *testing.T) {
+	drv := &execTestDriver{}
+	connector, err := NewInitConnector(drv, "")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	if len(caseIds) != 1 {
-		t.Errorf("%d != 1", len(caseIds))
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	fake := &DbMap{Db: db, Dialect: PostgresDialect{}}
+	fake.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	var batches [][]interface{}
+	collect := func(batch []interface{}) error {
+		batches = append(batches, batch)
+		return nil
 	}
-	if caseIds[0] != w1.Id {
-		t.Errorf("%d != %d", caseIds[0], w1.Id)
+	if err = fake.SelectBatchIter(Invoice{}, 0, collect, "select id from invoice_test"); err == nil {
+		t.Errorf("expected error for batch size 0")
+	}
+	if err = fake.SelectBatchIter(0, 10, collect, "select id from invoice_test"); err == nil {
+		t.Errorf("expected error for non struct holder")
+	}
+	if err = fake.SelectBatchIter(Invoice{}, 10, collect, "select id from invoice_test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 1 || batches[0][0].(*Invoice).Id != 42 {
+		t.Errorf("batches = %v", batches)
+	}
+	fake.AddTableWithName(OpenRowsInvoice{}, "invoice_test")
+	batches = nil
+	if err = fake.SelectBatchIter(OpenRowsInvoice{}, 10, collect, "select id from invoice_test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 1 || !batches[0][0].(*OpenRowsInvoice).Hooked || batches[0][0].(*OpenRowsInvoice).Open != 0 {
+		t.Errorf("PostGet did not run after the rows were closed: %v", batches)
 	}
-}
 
-func TestInvoicePersonView(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
+	for i := 0; i < 250; i++ {
+		_insert(dbmap, &Invoice{Created: int64(i), Memo: "batch"})
+	}
 
-	// Create some rows
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	dbmap.Insert(p1)
+	var sizes []int
+	var next int64
+	err = dbmap.SelectBatchIter(Invoice{}, 100, func(batch []interface{}) error {
+		sizes = append(sizes, len(batch))
+		for _, row := range batch {
+			if inv := row.(*Invoice); inv.Created != next {
+				return fmt.Errorf("row %d has Created %d", next, inv.Created)
+			}
+			next++
+		}
+		return nil
+	}, "select * from invoice_test order by Created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, []int{100, 100, 50}) {
+		t.Errorf("batch sizes = %v", sizes)
+	}
 
-	// notice how we can wire up p1.Id to the invoice easily
-	inv1 := &Invoice{0, 0, 0, "xmas order", p1.Id, false}
-	dbmap.Insert(inv1)
+	stop := errors.New("stop")
+	calls := 0
+	err = dbmap.SelectBatchIter(Invoice{}, 100, func(batch []interface{}) error {
+		calls++
+		return stop
+	}, "select * from invoice_test")
+	if err != stop || calls != 1 {
+		t.Errorf("SelectBatchIter = %v after %d calls", err, calls)
+	}
+}
 
-	// Run your query
-	query := "select i.Id InvoiceId, p.Id PersonId, i.Memo, p.FName " +
-		"from invoice_test i, person_test p " +
-		"where i.PersonId = p.Id"
+func TestHasMany(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	table := dbmap.AddTableWithName(Author{}, "author_test").SetKeys(true, "Id")
+	if len(table.Relations) != 1 {
+		t.Fatalf("%d relations", len(table.Relations))
+	}
+	r := table.Relations[0]
+	if r.DetailTable.TableName != "book_test" || r.ForeignKeyFieldName != "AuthorId" || r.MasterFieldName != "Books" {
+		t.Errorf("unexpected relation %s", r)
+	}
+	want := `create table "author_test" ("id" bigserial not null primary key , "name" varchar(255)) ;`
+	if sql := table.SqlForCreate(false); sql != want {
+		t.Errorf("\n got: %s\nwant: %s", sql, want)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("unknown foreign key column should panic")
+			}
+		}()
+		dbmap.AddTableWithName(AuthorWithoutFk{}, "author_without_fk_test")
+	}()
 
-	// pass a slice of pointers to Select()
-	// this avoids the need to type assert after the query is run
-	var list []*InvoicePersonView
-	_, err := dbmap.Select(&list, query)
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(Author{}, "author_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
 	if err != nil {
 		panic(err)
 	}
+	defer dropAndClose(dbmap)
 
-	// this should test true
-	expected := &InvoicePersonView{inv1.Id, p1.Id, inv1.Memo, p1.FName, 0}
-	if !reflect.DeepEqual(list[0], expected) {
-		t.Errorf("%v != %v", list[0], expected)
+	author := &Author{Name: "Lem", Books: []*Book{{Title: "Solaris"}, {Title: "Fiasco"}}}
+	if err = dbmap.InsertWithChilds(author); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range author.Books {
+		if b.Id == 0 || b.AuthorId != author.Id {
+			t.Errorf("child not inserted: %+v", b)
+		}
+	}
+	_insert(dbmap, &Author{Name: "Other"})
+
+	obj, err := dbmap.GetWithChilds(Author{}, -1, -1, author.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := obj.(*Author)
+	if got.Name != "Lem" || len(got.Books) != 2 || got.Books[0].AuthorId != author.Id {
+		t.Errorf("unexpected %+v", got)
 	}
 }
 
-func TestQuoteTableNames(t *testing.T) {
-	dbmap := initDbMap()
+func TestFullTableGuard(t *testing.T) {
+	dbmap := &DbMap{Dialect: PostgresDialect{}}
+	dbmap.AddTableWithName(Keyless{}, "keyless_test")
+	if _, err := dbmap.UpdateWhere(&Keyless{}, ""); err == nil || !strings.Contains(err.Error(), "AllowFullTable") {
+		t.Errorf("UpdateWhere without where = %v", err)
+	}
+	if _, err := dbmap.DeleteWhere(Keyless{}, " "); err == nil || !strings.Contains(err.Error(), "AllowFullTable") {
+		t.Errorf("DeleteWhere without where = %v", err)
+	}
+	if _, err := dbmap.DeleteWhere(Keyless{}, "code = :a", map[string]interface{}{}, 1); err == nil {
+		t.Errorf("DeleteWhere with two args should fail")
+	}
+
+	dbmap = newDbMap()
+	dbmap.AddTableWithName(Keyless{}, "keyless_test")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
 	defer dropAndClose(dbmap)
 
-	quotedTableName := dbmap.Dialect.QuoteField("person_test")
+	_insert(dbmap, &Keyless{"A1", "apple", 1}, &Keyless{"B2", "banana", 2}, &Keyless{"C3", "cherry", 3})
+	code := dbmap.Dialect.QuoteField("Code")
+	n, err := dbmap.DeleteWhere(&Keyless{Code: "A1"}, code+" = :Code")
+	if err != nil || n != 1 {
+		t.Errorf("DeleteWhere = %d, %v", n, err)
+	}
+	n, err = dbmap.UpdateWhere(&Keyless{"X", "any", 0}, "", AllowFullTable)
+	if err != nil || n != 2 {
+		t.Errorf("UpdateWhere of all rows = %d, %v", n, err)
+	}
+	n, err = dbmap.DeleteWhere(Keyless{}, code+" = :code", map[string]interface{}{"code": "Y"}, AllowFullTable)
+	if err != nil || n != 0 {
+		t.Errorf("DeleteWhere = %d, %v", n, err)
+	}
+	n, err = dbmap.DeleteWhere(Keyless{}, "", AllowFullTable)
+	if err != nil || n != 2 {
+		t.Errorf("DeleteWhere of all rows = %d, %v", n, err)
+	}
+}
 
-	// Use a buffer to hold the log to check generated queries
-	logBuffer := &bytes.Buffer{}
-	dbmap.TraceOn("", log.New(logBuffer, "gorptest:", log.Lmicroseconds))
+func TestCrudSQL(t *testing.T) {
+	tests := []struct {
+		dialect                Dialect
+		insert, update, delete string
+		get                    string
+	}{
+		{SqliteDialect{},
+			`insert into "invoice_test" ("Id","Created","Updated","Memo","PersonId","IsPaid") values (null,?,?,?,?,?);`,
+			`update "invoice_test" set "Created"=?, "Updated"=?, "Memo"=?, "PersonId"=?, "IsPaid"=? where "Id"=?;`,
+			`delete from "invoice_test" where "Id"=?;`,
+			`select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where "Id"=?;`},
+		{PostgresDialect{},
+			`insert into "invoice_test" ("id","created","updated","memo","personid","ispaid") values (default,$1,$2,$3,$4,$5) returning "id";`,
+			`update "invoice_test" set "created"=$1, "updated"=$2, "memo"=$3, "personid"=$4, "ispaid"=$5 where "id"=$6;`,
+			`delete from "invoice_test" where "id"=$1;`,
+			`select "id","created","updated","memo","personid","ispaid" from "invoice_test" where "id"=$1;`},
+		{MySQLDialect{"InnoDB", "UTF8"},
+			"insert into `invoice_test` (`Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid`) values (null,?,?,?,?,?);",
+			"update `invoice_test` set `Created`=?, `Updated`=?, `Memo`=?, `PersonId`=?, `IsPaid`=? where `Id`=?;",
+			"delete from `invoice_test` where `Id`=?;",
+			"select `Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid` from `invoice_test` where `Id`=?;"},
+		{SqlServerDialect{},
+			`insert into [invoice_test] ([Created],[Updated],[Memo],[PersonId],[IsPaid]) output inserted.[Id] values (?,?,?,?,?);`,
+			`update [invoice_test] set [Created]=?, [Updated]=?, [Memo]=?, [PersonId]=?, [IsPaid]=? where [Id]=?;`,
+			`delete from [invoice_test] where [Id]=?;`,
+			`select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] where [Id]=?;`},
+		{OracleDialect{},
+			`insert into "INVOICE_TEST" ("ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID") values (default,:1,:2,:3,:4,:5) returning Id`,
+			`update "INVOICE_TEST" set "CREATED"=:1, "UPDATED"=:2, "MEMO"=:3, "PERSONID"=:4, "ISPAID"=:5 where "ID"=:6`,
+			`delete from "INVOICE_TEST" where "ID"=:1`,
+			`select "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" where "ID"=:1`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+		inv := &Invoice{Id: 7, Created: 100, Updated: 200, Memo: "memo", PersonId: 3, IsPaid: true}
+
+		query, args, err := dbmap.InsertSQL(inv)
+		if err != nil || query != tt.insert || !reflect.DeepEqual(args, []interface{}{int64(100), int64(200), "memo", int64(3), true}) {
+			t.Errorf("%T InsertSQL:\n got: %s %v %v\nwant: %s", tt.dialect, query, args, err, tt.insert)
+		}
+		query, args, err = dbmap.UpdateSQL(inv)
+		if err != nil || query != tt.update || !reflect.DeepEqual(args, []interface{}{int64(100), int64(200), "memo", int64(3), true, int64(7)}) {
+			t.Errorf("%T UpdateSQL:\n got: %s %v %v\nwant: %s", tt.dialect, query, args, err, tt.update)
+		}
+		query, args, err = dbmap.DeleteSQL(inv)
+		if err != nil || query != tt.delete || !reflect.DeepEqual(args, []interface{}{int64(7)}) {
+			t.Errorf("%T DeleteSQL:\n got: %s %v %v\nwant: %s", tt.dialect, query, args, err, tt.delete)
+		}
+		query, args, err = dbmap.GetSQL(Invoice{}, 7)
+		if err != nil || query != tt.get || !reflect.DeepEqual(args, []interface{}{7}) {
+			t.Errorf("%T GetSQL:\n got: %s %v %v\nwant: %s", tt.dialect, query, args, err, tt.get)
+		}
+	}
+}
 
-	// Create some rows
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	errorTemplate := "Expected quoted table name %v in query but didn't find it"
+func TestQueryBuilderCountSQL(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select count(*) from "invoice_test" where (PersonId = ?);`},
+		{PostgresDialect{}, `select count(*) from "invoice_test" where (PersonId = $1);`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "select count(*) from `invoice_test` where (PersonId = ?);"},
+		{SqlServerDialect{}, `select count(*) from [invoice_test] where (PersonId = ?);`},
+		{OracleDialect{}, `select count(*) from "INVOICE_TEST" where (PersonId = :1)`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		query, args, err := dbmap.Query(Invoice{}).
+			Where("PersonId = ?", 5).
+			OrderBy("Created", true).
+			Limit(10).
+			Offset(5).
+			CountSQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{5}) {
+			t.Errorf("%T: unexpected args %v", tt.dialect, args)
+		}
+	}
+}
 
-	// Check if Insert quotes the table name
-	id := dbmap.Insert(p1)
-	if !bytes.Contains(logBuffer.Bytes(), []byte(quotedTableName)) {
-		t.Errorf(errorTemplate, quotedTableName)
+type fakeDialect struct {
+	SqliteDialect
+}
+
+func TestRegisterDialect(t *testing.T) {
+	if _, err := DialectForDriver("fakedb"); err == nil {
+		t.Errorf("fakedb should not have a dialect yet")
+	}
+	RegisterDialect("fakedb", fakeDialect{})
+	d, err := DialectForDriver("fakedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.(fakeDialect); !ok {
+		t.Errorf("got %T, want fakeDialect", d)
 	}
-	logBuffer.Reset()
 
-	// Check if Get quotes the table name
-	dbmap.Get(Person{}, id)
-	if !bytes.Contains(logBuffer.Bytes(), []byte(quotedTableName)) {
-		t.Errorf(errorTemplate, quotedTableName)
+	d, err = DialectForDriver("mysql")
+	if my, ok := d.(MySQLDialect); err != nil || !ok || my.Engine != "InnoDB" {
+		t.Errorf("mysql = %#v, %v", d, err)
 	}
-	logBuffer.Reset()
 }
 
-func TestSelectTooManyCols(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
+func TestBoolLiteral(t *testing.T) {
+	tests := []struct {
+		dialect     Dialect
+		true, false string
+		want        string
+	}{
+		{SqliteDialect{}, "1", "0", `select count(*) from "invoice_test" where ("IsPaid" = 1) and ("IsPaid" = 0);`},
+		{PostgresDialect{}, "true", "false", `select count(*) from "invoice_test" where ("ispaid" = true) and ("ispaid" = false);`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "true", "false", "select count(*) from `invoice_test` where (`IsPaid` = true) and (`IsPaid` = false);"},
+		{SqlServerDialect{}, "1", "0", `select count(*) from [invoice_test] where ([IsPaid] = 1) and ([IsPaid] = 0);`},
+		{OracleDialect{}, "1", "0", `select count(*) from "INVOICE_TEST" where ("ISPAID" = 1) and ("ISPAID" = 0)`},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.BoolLiteral(true); got != tt.true {
+			t.Errorf("%T: BoolLiteral(true) = %s", tt.dialect, got)
+		}
+		if got := tt.dialect.BoolLiteral(false); got != tt.false {
+			t.Errorf("%T: BoolLiteral(false) = %s", tt.dialect, got)
+		}
 
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	p2 := &Person{0, 0, 0, "jane", "doe", 0}
-	_insert(dbmap, p1)
-	_insert(dbmap, p2)
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+		query, args, err := dbmap.Query(Invoice{}).WhereBool("IsPaid", true).WhereBool("ispaid", false).CountSQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want || len(args) != 0 {
+			t.Errorf("%T:\n got: %s %v\nwant: %s", tt.dialect, query, args, tt.want)
+		}
+	}
 
-	obj := _get(dbmap, Person{}, p1.Id)
-	p1 = obj.(*Person)
-	obj = _get(dbmap, Person{}, p2.Id)
-	p2 = obj.(*Person)
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	if _, _, err := dbmap.Query(Invoice{}).WhereBool("Paid", true).SQL(); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+}
 
-	params := map[string]interface{}{
-		"Id": p1.Id,
+func TestQueryBuilderUseIndex(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" indexed by "idx_memo" where (Memo = ?);`},
+		{PostgresDialect{}, `select /*+ IndexScan("invoice_test" idx_memo) */ "id","created","updated","memo","personid","ispaid" from "invoice_test" where (Memo = $1);`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "select `Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid` from `invoice_test` use index (`idx_memo`) where (Memo = ?);"},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] with (index([idx_memo])) where (Memo = ?);`},
+		{OracleDialect{}, `select /*+ INDEX("INVOICE_TEST" idx_memo) */ "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" where (Memo = :1)`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		query, _, err := dbmap.Query(Invoice{}).Where("Memo = ?", "x").UseIndex("idx_memo").SQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
+		}
 	}
+}
 
-	var p3 FNameOnly
-	err := dbmap.SelectOne(&p3, "select * from person_test where Id=:Id", params)
-	if err != nil {
-		if !NonFatalError(err) {
-			t.Error(err)
+func TestQueryBuilderSample(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where (Memo = ?) order by random() limit 10;`},
+		{PostgresDialect{}, `select "id","created","updated","memo","personid","ispaid" from "invoice_test" tablesample system (1.5) where (Memo = $1) order by "created" desc limit 10;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "select `Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid` from `invoice_test` where (Memo = ?) order by rand() limit 10;"},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] tablesample (1.5 percent) where (Memo = ?) order by [Created] desc offset 0 rows fetch next 10 rows only;`},
+		{OracleDialect{}, `select "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" sample (1.5) where (Memo = :1) order by "CREATED" desc offset 0 rows fetch next 10 rows only`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		query, _, err := dbmap.Query(Invoice{}).Where("Memo = ?", "x").OrderBy("Created", true).Sample(1.5).Limit(10).SQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
 		}
-	} else {
-		t.Errorf("Non-fatal error expected")
 	}
 
-	if p1.FName != p3.FName {
-		t.Errorf("%v != %v", p1.FName, p3.FName)
+	dbmap := &DbMap{Dialect: SqlServerDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	query, _, err := dbmap.Query(Invoice{}).UseIndex("idx_memo").Sample(10).CountSQL()
+	want := `select count(*) from [invoice_test] tablesample (10 percent) with (index([idx_memo]));`
+	if err != nil || query != want {
+		t.Errorf("CountSQL = %s, %v", query, err)
+	}
+	for _, percent := range []float64{0, -1, 101} {
+		if _, _, err := dbmap.Query(Invoice{}).Sample(percent).SQL(); err == nil {
+			t.Errorf("expected error for percent %g", percent)
+		}
 	}
+}
 
-	var pSlice []FNameOnly
-	_, err = dbmap.Select(&pSlice, "select * from person_test order by fname asc")
-	if err != nil {
-		if !NonFatalError(err) {
-			t.Error(err)
+func TestQueryBuilderOrderByRandom(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where (IsPaid = ?) order by random() limit 3;`},
+		{PostgresDialect{}, `select "id","created","updated","memo","personid","ispaid" from "invoice_test" where (IsPaid = $1) order by random() limit 3;`},
+		{MySQLDialect{"InnoDB", "UTF8"}, "select `Id`,`Created`,`Updated`,`Memo`,`PersonId`,`IsPaid` from `invoice_test` where (IsPaid = ?) order by rand() limit 3;"},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] where (IsPaid = ?) order by newid() offset 0 rows fetch next 3 rows only;`},
+		{OracleDialect{}, `select "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" where (IsPaid = :1) order by dbms_random.value offset 0 rows fetch next 3 rows only`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		q := dbmap.Query(Invoice{}).Where("IsPaid = ?", false).OrderByRandom().Limit(3)
+		query, _, err := q.SQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
 		}
-	} else {
-		t.Errorf("Non-fatal error expected")
 	}
+}
 
-	if p1.FName != pSlice[0].FName {
-		t.Errorf("%v != %v", p1.FName, pSlice[0].FName)
+func TestQueryBuilderErrors(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+	if _, _, err := dbmap.Query(Person{}).SQL(); err == nil {
+		t.Errorf("expected error for unmapped type")
 	}
-	if p2.FName != pSlice[1].FName {
-		t.Errorf("%v != %v", p2.FName, pSlice[1].FName)
+	if _, _, err := dbmap.Query(Invoice{}).Where("Id = ? or Id = ?", 1).SQL(); err == nil {
+		t.Errorf("expected error for placeholder count mismatch")
+	}
+	if _, _, err := dbmap.Query(Invoice{}).OrderBy("NoSuchField", false).SQL(); err == nil {
+		t.Errorf("expected error for unknown order by field")
+	}
+	if _, _, err := dbmap.Query(Invoice{}).After("NoSuchField", 1).SQL(); err == nil {
+		t.Errorf("expected error for unknown after field")
 	}
 }
 
-func TestSelectSingleVal(t *testing.T) {
+func TestQueryBuilderAfter(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SqliteDialect{}, `select "Id","Created","Updated","Memo","PersonId","IsPaid" from "invoice_test" where (PersonId = ?) and ("Id" > ?) order by "Id", "Created" limit 10;`},
+		{PostgresDialect{}, `select "id","created","updated","memo","personid","ispaid" from "invoice_test" where (PersonId = $1) and ("id" > $2) order by "id", "created" limit 10;`},
+		{SqlServerDialect{}, `select [Id],[Created],[Updated],[Memo],[PersonId],[IsPaid] from [invoice_test] where (PersonId = ?) and ([Id] > ?) order by [Id], [Created] offset 0 rows fetch next 10 rows only;`},
+		{OracleDialect{}, `select "ID","CREATED","UPDATED","MEMO","PERSONID","ISPAID" from "INVOICE_TEST" where (PersonId = :1) and ("ID" > :2) order by "ID", "CREATED" offset 0 rows fetch next 10 rows only`},
+	}
+	for _, tt := range tests {
+		dbmap := &DbMap{Dialect: tt.dialect}
+		dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+
+		q := dbmap.Query(Invoice{}).Where("PersonId = ?", 5).After("Id", 0).OrderBy("Created", false).After("Id", 42).Limit(10)
+		query, args, err := q.SQL()
+		if err != nil {
+			t.Errorf("%T: %s", tt.dialect, err)
+			continue
+		}
+		if query != tt.want {
+			t.Errorf("%T:\n got: %s\nwant: %s", tt.dialect, query, tt.want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{5, 42}) {
+			t.Errorf("%T: args %v", tt.dialect, args)
+		}
+		if _, args, _ := q.CountSQL(); len(args) != 1 {
+			t.Errorf("%T: count args %v", tt.dialect, args)
+		}
+	}
+
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
 
-	p1 := &Person{0, 0, 0, "bob", "smith", 0}
-	_insert(dbmap, p1)
-
-	obj := _get(dbmap, Person{}, p1.Id)
-	p1 = obj.(*Person)
-
-	params := map[string]interface{}{
-		"Id": p1.Id,
-	}
-
-	var p2 Person
-	err := dbmap.SelectOne(&p2, "select * from person_test where Id=:Id", params)
-	if err != nil {
-		t.Error(err)
+	for i := 0; i < 5; i++ {
+		_insert(dbmap, &Invoice{0, int64(i), 0, strconv.Itoa(i), 0, false})
 	}
 
-	if !reflect.DeepEqual(p1, &p2) {
-		t.Errorf("%v != %v", p1, &p2)
+	// page through all rows two at a time
+	var ids []int64
+	last := int64(0)
+	for page := 0; page < 5; page++ {
+		var list []*Invoice
+		_, err := dbmap.Query(Invoice{}).After("Id", last).Limit(2).Select(&list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 {
+			break
+		}
+		for _, inv := range list {
+			ids = append(ids, inv.Id)
+		}
+		last = list[len(list)-1].Id
 	}
-
-	// verify SelectOne allows non-struct holders
-	var s string
-	err = dbmap.SelectOne(&s, "select FName from person_test where Id=:Id", params)
-	if err != nil {
-		t.Error(err)
+	if len(ids) != 5 {
+		t.Fatalf("paged ids %v", ids)
 	}
-	if s != "bob" {
-		t.Error("Expected bob but got: " + s)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Errorf("ids not ascending: %v", ids)
+		}
 	}
+}
 
-	// verify SelectOne requires pointer receiver
-	err = dbmap.SelectOne(s, "select FName from person_test where Id=:Id", params)
-	if err == nil {
-		t.Error("SelectOne should have returned error for non-pointer holder")
-	}
+func TestQueryBuilderSelect(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
 
-	// verify SelectOne works with uninitialized pointers
-	var p3 *Person
-	err = dbmap.SelectOne(&p3, "select * from person_test where Id=:Id", params)
+	inv1 := &Invoice{0, 100, 200, "a", 1, false}
+	inv2 := &Invoice{0, 200, 200, "b", 1, false}
+	inv3 := &Invoice{0, 300, 200, "c", 2, false}
+	_insert(dbmap, inv1, inv2, inv3)
+
+	var list []*Invoice
+	_, err := dbmap.Query(Invoice{}).Where("PersonId = ?", 1).OrderBy("Created", true).Limit(10).Select(&list)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-
-	if !reflect.DeepEqual(p1, p3) {
-		t.Errorf("%v != %v", p1, p3)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(list))
 	}
-
-	// verify that the receiver is still nil if nothing was found
-	var p4 *Person
-	dbmap.SelectOne(&p3, "select * from person_test where 2<1 AND Id=:Id", params)
-	if p4 != nil {
-		t.Error("SelectOne should not have changed a nil receiver when no rows were found")
+	if !reflect.DeepEqual(list[0], inv2) || !reflect.DeepEqual(list[1], inv1) {
+		t.Errorf("unexpected rows %v, %v", list[0], list[1])
 	}
 
-	// verify that the error is set to sql.ErrNoRows if not found
-	err = dbmap.SelectOne(&p2, "select * from person_test where Id=:Id", map[string]interface{}{
-		"Id": -2222,
-	})
-	if err == nil || err != sql.ErrNoRows {
-		t.Error("SelectOne should have returned an sql.ErrNoRows")
+	count, err := dbmap.Query(Invoice{}).Where("PersonId = ?", 1).OrderBy("Created", true).Limit(1).Offset(1).Count()
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	_insert(dbmap, &Person{0, 0, 0, "bob", "smith", 0})
-	err = dbmap.SelectOne(&p2, "select * from person_test where Fname='bob'")
-	if err == nil {
-		t.Error("Expected error when two rows found")
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
 	}
 
-	// tests for #150
-	var tInt int64
-	var tStr string
-	var tBool bool
-	var tFloat float64
-	primVals := []interface{}{tInt, tStr, tBool, tFloat}
-	for _, prim := range primVals {
-		err = dbmap.SelectOne(&prim, "select * from person_test where Id=-123")
-		if err == nil || err != sql.ErrNoRows {
-			t.Error("primVals: SelectOne should have returned sql.ErrNoRows")
-		}
+	count, err = dbmap.Query(Invoice{}).WhereBool("IsPaid", false).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("unpaid count = %d, want 3", count)
 	}
 }
 
@@ -1925,6 +7579,34 @@ func TestSelectAlias(t *testing.T) {
 	}
 }
 
+func TestCopyIn(t *testing.T) {
+	want := `copy s1."invoice_test" ("created", "memo") from stdin`
+	if got := (PostgresDialect{}).CopyInSql("s1", "invoice_test", []string{"Created", "Memo"}); got != want {
+		t.Errorf("\n got: %s\nwant: %s", got, want)
+	}
+	err := (&DbMap{Dialect: MySQLDialect{"InnoDB", "UTF8"}}).CopyIn(Invoice{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("CopyIn on MySQL = %v", err)
+	}
+
+	// this test only applies to PostgreSQL
+	if os.Getenv("GORP_TEST_DIALECT") != "postgres" {
+		return
+	}
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	rows := []interface{}{&Invoice{Created: 1, Memo: "a"}, Invoice{Created: 2, Memo: "b"}}
+	if err = dbmap.CopyIn(Invoice{}, rows); err != nil {
+		t.Fatal(err)
+	}
+	if n := selectInt(dbmap, "select sum(Created) from invoice_test"); n != 3 {
+		t.Errorf("sum = %d", n)
+	}
+	if err = dbmap.CopyIn(Invoice{}, []interface{}{&Person{}}); err == nil {
+		t.Errorf("CopyIn of a Person into invoice_test should fail")
+	}
+}
+
 func TestMysqlPanicIfDialectNotInitialized(t *testing.T) {
 	_, driver := dialectAndDriver()
 	// this test only applies to MySQL
@@ -2127,6 +7809,127 @@ func BenchmarkGorpCrud(b *testing.B) {
 	}
 }
 
+func BenchmarkGorpUpdate(b *testing.B) {
+	dbmap, invs, list := initDbMapBenchUpdate()
+	defer dropAndClose(dbmap)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, inv := range invs {
+			inv.Updated = int64(i)
+		}
+		_, err := dbmap.Update(list...)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkGorpUpdateBatch(b *testing.B) {
+	dbmap, invs, list := initDbMapBenchUpdate()
+	defer dropAndClose(dbmap)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, inv := range invs {
+			inv.Updated = int64(i)
+		}
+		_, err := dbmap.UpdateBatch(list...)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkGorpCopyIn(b *testing.B) {
+	if os.Getenv("GORP_TEST_DIALECT") != "postgres" {
+		b.Skip("CopyIn needs PostgreSQL")
+	}
+	dbmap := initDbMapBench()
+	defer dropAndClose(dbmap)
+	dbmap.TraceOff()
+	var list []interface{}
+	for i := 0; i < 1000; i++ {
+		list = append(list, &Invoice{0, 100, 200, "my memo", 0, true})
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := dbmap.CopyIn(Invoice{}, list)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func initDbMapBenchUpdate() (*DbMap, []*Invoice, []interface{}) {
+	dbmap := initDbMapBench()
+	dbmap.TraceOff()
+	var invs []*Invoice
+	var list []interface{}
+	for i := 0; i < 100; i++ {
+		inv := &Invoice{0, 100, 200, "my memo", 0, true}
+		_insert(dbmap, inv)
+		invs = append(invs, inv)
+		list = append(list, inv)
+	}
+	return dbmap, invs, list
+}
+
+func BenchmarkGorpSelect(b *testing.B) {
+	dbmap := initDbMapBenchSelect()
+	defer dropAndClose(dbmap)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var list []*Invoice
+		_, err := dbmap.Select(&list, "select * from invoice_test")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkGorpSelectInto(b *testing.B) {
+	dbmap := initDbMapBenchSelect()
+	defer dropAndClose(dbmap)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var list []*Invoice
+	for i := 0; i < b.N; i++ {
+		err := dbmap.SelectInto(&list, "select * from invoice_test")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkGorpSelectStmt(b *testing.B) {
+	dbmap := initDbMapBenchSelect()
+	defer dropAndClose(dbmap)
+	stmt := dbmap.PrepareSelect(Invoice{}, "select * from invoice_test")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := stmt.Select()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func initDbMapBenchSelect() *DbMap {
+	dbmap := initDbMapBench()
+	dbmap.TraceOff()
+	for i := 0; i < 100; i++ {
+		_insert(dbmap, &Invoice{0, 100, 200, "my memo", 0, true})
+	}
+	return dbmap
+}
+
 func initDbMapBench() *DbMap {
 	dbmap := newDbMap()
 	dbmap.Db.Exec("drop table if exists invoice_test")
@@ -2141,12 +7944,15 @@ func initDbMapBench() *DbMap {
 func initDbMap() *DbMap {
 	dbmap := newDbMap()
 	dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
-	dbmap.AddTableWithName(InvoiceTag{}, "invoice_tag_test")    //key is set via primarykey attribute
+	dbmap.AddTableWithName(InvoiceTag{}, "invoice_tag_test") //key is set via primarykey attribute
 	dbmap.AddTableWithName(AliasTransientField{}, "alias_trans_field_test").SetKeys(true, "id")
 	dbmap.AddTableWithName(OverriddenInvoice{}, "invoice_override_test").SetKeys(false, "Id")
 	dbmap.AddTableWithName(Person{}, "person_test").SetKeys(true, "Id").SetVersionCol("Version")
 	dbmap.AddTableWithName(WithIgnoredColumn{}, "ignored_column_test").SetKeys(true, "Id")
 	dbmap.AddTableWithName(IdCreated{}, "id_created_test").SetKeys(true, "Id")
+	// See #146 and TestSelectAlias - this type is mapped to the same
+	// table as IdCreated, but includes an extra field that isn't in the table
+	dbmap.AddPartialTable(IdCreatedExternal{}, IdCreated{})
 	dbmap.AddTableWithName(TypeConversionExample{}, "type_conv_test").SetKeys(true, "Id")
 	dbmap.AddTableWithName(WithEmbeddedStruct{}, "embedded_struct_test").SetKeys(true, "Id")
 	//dbmap.AddTableWithName(WithEmbeddedStructConflictingEmbeddedMemberNames{}, "embedded_struct_conflict_name_test").SetKeys(true, "Id")
@@ -2164,11 +7970,6 @@ func initDbMap() *DbMap {
 	if err != nil {
 		panic(err)
 	}
-
-	// See #146 and TestSelectAlias - this type is mapped to the same
-	// table as IdCreated, but includes an extra field that isn't in the table
-	dbmap.AddTableWithName(IdCreatedExternal{}, "id_created_test").SetKeys(true, "Id")
-
 	return dbmap
 }
 
@@ -2209,6 +8010,11 @@ func connect(driver string) *sql.DB {
 	return db
 }
 
+func dialectFromEnv() Dialect {
+	dialect, _ := dialectAndDriver()
+	return dialect
+}
+
 func dialectAndDriver() (Dialect, string) {
 	switch os.Getenv("GORP_TEST_DIALECT") {
 	case "mysql":